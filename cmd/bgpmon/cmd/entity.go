@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the entity search flags.
+var (
+	entityOrigin   uint32
+	entityPrefixes []string
+	entityDomain   string
+	entityFamily   int
+)
+
+// entityCmd is a wrapper for the commands that inspect and manage the
+// entities of an open session. Entities are written with write entity.
+var entityCmd = &cobra.Command{
+	Use:   "entity",
+	Short: "Lists, shows, searches or deletes the entities of an open session.",
+	Long:  "Lists, shows, searches or deletes the entities of an open session.",
+}
+
+var entityListCmd = &cobra.Command{
+	Use:   "list SESS_ID",
+	Short: "Lists all entities on an open session.",
+	Long:  "Prints every entity stored in the session SESS_ID.",
+	Args:  cobra.ExactArgs(1),
+	Run:   listEntities,
+}
+
+// The cobra command is required, but not used.
+func listEntities(_ *cobra.Command, args []string) {
+	queryEntities(&rpc.EntityQuery{SessionID: args[0]})
+}
+
+var entitySearchCmd = &cobra.Command{
+	Use:   "search SESS_ID",
+	Short: "Searches the entities on an open session.",
+	Long: `Prints the entities in the session SESS_ID which match all of the provided flags.
+An entity matches a prefix if it owns that prefix or one covering it.`,
+	Args: cobra.ExactArgs(1),
+	Run:  searchEntities,
+}
+
+func searchEntities(_ *cobra.Command, args []string) {
+	queryEntities(&rpc.EntityQuery{
+		SessionID:   args[0],
+		OwnedOrigin: entityOrigin,
+		Prefixes:    entityPrefixes,
+		EmailDomain: entityDomain,
+		Family:      entityFamily,
+	})
+}
+
+// queryEntities runs a ListEntities call and prints the results.
+func queryEntities(query *rpc.EntityQuery) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.ext.ListEntities(ctx, query)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Entities: %d\n", len(reply.Entities))
+	for i, e := range reply.Entities {
+		fmt.Printf("[%d]\n", i)
+		printEntity(e)
+	}
+}
+
+func printEntity(e *rpc.EntityInfo) {
+	fmt.Printf("Name:     %s\n", e.Name)
+	fmt.Printf("Email:    %s\n", e.Email)
+	fmt.Printf("Origins:  %v\n", e.OwnedOrigins)
+	fmt.Printf("Prefixes: %s\n", strings.Join(e.OwnedPrefixes, ", "))
+}
+
+var entityShowCmd = &cobra.Command{
+	Use:   "show SESS_ID NAME",
+	Short: "Shows an entity and its history.",
+	Long:  "Prints the entity named NAME on the session SESS_ID, followed by every recorded change to it.",
+	Args:  cobra.ExactArgs(2),
+	Run:   showEntity,
+}
+
+func showEntity(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	query := &rpc.EntityQuery{SessionID: args[0], Name: args[1]}
+	reply, err := bc.ext.ListEntities(ctx, query)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	if len(reply.Entities) == 0 {
+		fmt.Printf("Entity %s does not currently exist\n", args[1])
+	} else {
+		printEntity(reply.Entities[0])
+	}
+
+	history, err := bc.ext.EntityHistory(ctx, query)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("History: %d changes\n", len(history.Changes))
+	for _, c := range history.Changes {
+		ts := time.Unix(c.Timestamp, 0).UTC().Format(time.RFC3339)
+		fmt.Printf("%s %-6s email:%s origins:%v prefixes:[%s]\n", ts, c.Action, c.Entity.Email,
+			c.Entity.OwnedOrigins, strings.Join(c.Entity.OwnedPrefixes, ", "))
+	}
+}
+
+var entityDeleteCmd = &cobra.Command{
+	Use:   "delete SESS_ID NAME",
+	Short: "Deletes an entity from an open session.",
+	Long:  "Deletes the entity named NAME from the session SESS_ID. The deletion is kept in the entity history.",
+	Args:  cobra.ExactArgs(2),
+	Run:   deleteEntity,
+}
+
+func deleteEntity(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	if _, err := bc.ext.DeleteEntity(ctx, &rpc.DeleteEntityRequest{SessionID: args[0], Name: args[1]}); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	fmt.Printf("Deleted entity: %s\n", args[1])
+}
+
+func init() {
+	entitySearchCmd.Flags().Uint32VarP(&entityOrigin, "origin", "a", 0, "only entities owning this origin AS")
+	entitySearchCmd.Flags().StringSliceVarP(&entityPrefixes, "prefix", "p", nil, "only entities owning one of these prefixes")
+	entitySearchCmd.Flags().StringVarP(&entityDomain, "domain", "d", "", "only entities with an email in this domain")
+	entitySearchCmd.Flags().IntVarP(&entityFamily, "family", "f", 0, "only entities owning a prefix of this IP version (4 or 6)")
+
+	entityCmd.AddCommand(entityListCmd)
+	entityCmd.AddCommand(entitySearchCmd)
+	entityCmd.AddCommand(entityShowCmd)
+	entityCmd.AddCommand(entityDeleteCmd)
+	rootCmd.AddCommand(entityCmd)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the events flags. The collector and time span are
+// shared with read.
+var (
+	eventPeer  string
+	eventTypes []string
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events SESS_ID",
+	Short: "Reads peer events from an open session.",
+	Long: `Prints the BGP session events of the session SESS_ID between start and end. Events
+are written from the state changes, opens, notifications and keepalive gaps found when
+writing MRT files. Types may be any of state_change, open, notification and keepalive_gap.`,
+	Args: cobra.ExactArgs(1),
+	Run:  readPeerEvents,
+}
+
+// The cobra command is required, but not used.
+func readPeerEvents(_ *cobra.Command, args []string) {
+	start, end, err := getTimeSpan()
+	if err != nil {
+		fmt.Printf("Error parsing time span: %s\n", err)
+		return
+	}
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	stream, err := bc.ext.ReadPeerEvents(ctx, &rpc.PeerEventQuery{
+		SessionID: args[0],
+		Collector: collector,
+		Peer:      eventPeer,
+		Types:     eventTypes,
+		Start:     start.Unix(),
+		End:       end.Unix(),
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	ct := 0
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		ts := time.Unix(0, ev.Timestamp*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+		fmt.Printf("%s %s %s AS%d %-13s %s\n", ts, ev.Collector, ev.Peer, ev.PeerAS, ev.Type, describePeerEvent(ev))
+		ct++
+	}
+	fmt.Printf("Events: %d\n", ct)
+}
+
+// describePeerEvent returns the details of an event of its type.
+func describePeerEvent(ev *rpc.PeerEventInfo) string {
+	switch ev.Type {
+	case "state_change":
+		return fmt.Sprintf("%s -> %s", ev.OldState, ev.NewState)
+	case "open":
+		return fmt.Sprintf("hold time:%ds", ev.HoldTime)
+	case "notification":
+		return fmt.Sprintf("code:%d subcode:%d", ev.ErrorCode, ev.ErrorSubcode)
+	case "keepalive_gap":
+		return fmt.Sprintf("gap:%.1fs hold time:%ds", ev.Gap, ev.HoldTime)
+	}
+	return ""
+}
+
+func init() {
+	eventsCmd.Flags().StringVarP(&collector, "collector", "c", "", "only events of this collector")
+	eventsCmd.Flags().StringVarP(&eventPeer, "peer", "p", "", "only events of this peer")
+	eventsCmd.Flags().StringSliceVarP(&eventTypes, "types", "t", nil, "only events of these types")
+	eventsCmd.Flags().StringVarP(&startStr, "start", "s", "", "beginning time of the read (required)")
+	eventsCmd.MarkFlagRequired("start")
+	eventsCmd.Flags().StringVarP(&endStr, "end", "e", "", "end time of the read (required)")
+	eventsCmd.MarkFlagRequired("end")
+
+	rootCmd.AddCommand(eventsCmd)
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// fsckRepair stores the repair flag.
+var fsckRepair bool
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck SESS_ID",
+	Short: "Checks the capture table catalog of an open session.",
+	Long: `Compares the main table, the node table and the capture tables of the session SESS_ID,
+and prints every table that is registered but missing, present but unregistered, misaligned
+with the dump duration of its collector, or whose collector is unknown. With --repair, missing
+tables are unregistered, unregistered tables are registered, misaligned tables are rebucketed,
+and the rest are quarantined to the bgpmon_quarantine schema, or to the NAMESPACE_quarantine
+schema if the session has a namespace.`,
+	Args: cobra.ExactArgs(1),
+	Run:  fsck,
+}
+
+// The cobra command is required, but not used.
+func fsck(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	// Rebucketing copies whole tables, so repairs aren't bound by the RPC timeout.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if fsckRepair {
+		ctx, cancel = getBackgroundCtxWithCancel()
+	} else {
+		ctx, cancel = getCtxWithCancel()
+	}
+	defer cancel()
+
+	reply, err := bc.ext.Fsck(ctx, &rpc.FsckRequest{SessionID: args[0], Repair: fsckRepair})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	repaired := 0
+	for _, p := range reply.Problems {
+		span := ""
+		if p.Start != 0 || p.End != 0 {
+			span = fmt.Sprintf(" %s - %s", time.Unix(p.Start, 0).UTC().Format(time.RFC3339), time.Unix(p.End, 0).UTC().Format(time.RFC3339))
+		}
+
+		status := "repair: " + p.Repair
+		switch {
+		case p.Error != "":
+			status = fmt.Sprintf("%s failed: %s", p.Repair, p.Error)
+		case p.Repaired:
+			status = "repaired with " + p.Repair
+			repaired++
+		}
+		fmt.Printf("%-17s %s%s: %s (%s)\n", p.Kind, p.Table, span, p.Detail, status)
+	}
+
+	fmt.Printf("Problems: %d", len(reply.Problems))
+	if fsckRepair {
+		fmt.Printf(" Repaired: %d", repaired)
+	}
+	fmt.Printf("\n")
+}
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "repair the problems found")
+
+	rootCmd.AddCommand(fsckCmd)
+}
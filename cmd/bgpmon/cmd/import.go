@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/CSUNetSec/bgpmon/util"
+
+	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import SESS_ID FILES...",
+	Short: "Writes BGP captures from capture archives to a session.",
+	Long:  "Writes the captures in archives exported by the retention module back to a session. Each file is written on its own write stream, and a report is generated upon completion. The captures keep their timestamps, so a retention module running on the session drops their tables again on its next run, unless it's stopped or its age is raised first.",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   importFunc,
+}
+
+func importFunc(_ *cobra.Command, args []string) {
+	sessID := args[0]
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	results := make(chan writeMRTResult)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go summarizeResults(results, &wg)
+
+	for _, fileName := range args[1:] {
+		fmt.Printf("Importing %s\n", fileName)
+		ct, stored, err := importArchive(bc, fileName, sessID)
+		results <- writeMRTResult{fileName: fileName, msgCt: ct, stored: stored, err: err}
+	}
+
+	close(results)
+	wg.Wait()
+}
+
+// importArchive returns how many captures were sent, and how many of those
+// the server stored.
+func importArchive(bc *bgpmonCli, fileName, sessID string) (int, int, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+
+	ar, err := util.NewCaptureArchiveReader(fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer ar.Close()
+
+	ctx, cancel := getBackgroundCtxWithCancel()
+	defer cancel()
+
+	stream, err := bc.cli.Write(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	written := 0
+	for ar.Scan() {
+		writeRequest := &pb.WriteRequest{
+			Type:       pb.WriteRequest_BGP_CAPTURE,
+			SessionId:  sessID,
+			BgpCapture: ar.GetCapture(),
+		}
+
+		if err := stream.Send(writeRequest); err != nil {
+			return written, 0, err
+		}
+		written++
+	}
+
+	if err := ar.Err(); err != nil {
+		return written, 0, fmt.Errorf("archive reader error: %s", err)
+	}
+
+	rep, err := stream.CloseAndRecv()
+	if rep != nil && rep.Error != "" {
+		return written, 0, fmt.Errorf("write stream server error: %s", rep.Error)
+	} else if err != nil && err != io.EOF {
+		return written, 0, fmt.Errorf("write stream server error: %s", err)
+	}
+
+	if rep == nil {
+		return written, 0, nil
+	}
+	return written, int(rep.TotalMessages), nil
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the index flags. The collector is shared with read.
+var (
+	indexOpen bool
+)
+
+var indexCmd = &cobra.Command{
+	Use:   "index SESS_ID",
+	Short: "Creates the configured indexes on existing capture tables.",
+	Long: `Runs the index_tables module on the session SESS_ID, which creates the indexes
+configured for that session on its capture tables. By default only tables whose period
+has closed are indexed. Indexing runs in the background, and its result is logged by
+the daemon.`,
+	Args: cobra.ExactArgs(1),
+	Run:  indexTables,
+}
+
+// The cobra command is required, but not used.
+func indexTables(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	modOpts := fmt.Sprintf("-session %s -open %t", args[0], indexOpen)
+	if collector != "" {
+		modOpts += fmt.Sprintf(" -collector %s", collector)
+	}
+
+	emsg := &pb.RunModuleRequest{
+		Type: "index_tables",
+		Id:   "index-" + genUUID(),
+		Args: modOpts,
+	}
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.cli.RunModule(ctx, emsg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	fmt.Printf("Indexing capture tables with module: %s\n", reply.Id)
+}
+
+func init() {
+	indexCmd.Flags().StringVarP(&collector, "collector", "c", "", "only index tables of this collector")
+	indexCmd.Flags().BoolVar(&indexOpen, "open", false, "also index tables that are still being written to")
+
+	rootCmd.AddCommand(indexCmd)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the keepalive flags.
+var (
+	keepaliveOwner string
+	keepaliveEvery time.Duration
+)
+
+var keepaliveCmd = &cobra.Command{
+	Use:   "keepalive SESS_ID",
+	Short: "Renews the lease of an open session.",
+	Long: `Renews the lease of the session SESS_ID, held by the owner provided, so it isn't closed
+while idle. With --every, it keeps renewing it until interrupted.`,
+	Args: cobra.ExactArgs(1),
+	Run:  keepAlive,
+}
+
+// The cobra command is required, but not used.
+func keepAlive(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	req := &rpc.KeepAliveRequest{SessionID: args[0], Owner: keepaliveOwner}
+	renew := func() bool {
+		ctx, cancel := getCtxWithCancel()
+		defer cancel()
+
+		if _, err := bc.ext.KeepAlive(ctx, req); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return false
+		}
+		fmt.Printf("Renewed session: %s\n", req.SessionID)
+		return true
+	}
+
+	if !renew() || keepaliveEvery <= 0 {
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	tick := time.NewTicker(keepaliveEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-sig:
+			return
+		case <-tick.C:
+			if !renew() {
+				return
+			}
+		}
+	}
+}
+
+func init() {
+	keepaliveCmd.Flags().StringVar(&keepaliveOwner, "owner", "", "owner of the session lease")
+	keepaliveCmd.Flags().DurationVar(&keepaliveEvery, "every", 0, "keep renewing the lease at this interval until interrupted")
+
+	rootCmd.AddCommand(keepaliveCmd)
+}
@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
 
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
 	"github.com/spf13/cobra"
@@ -19,7 +22,7 @@ var listOpenCmd = &cobra.Command{
 var listOpenSessionsCmd = &cobra.Command{
 	Use:   "sessions",
 	Short: "Lists the open session IDs on the bgpmond server.",
-	Long: `Lists the open session IDs on the bgpmond server. 
+	Long: `Lists the open session IDs on the bgpmond server, with their lease and last activity.
 These can be used as arguments bgpmon queries like get or write, or close them.`,
 	Args: cobra.NoArgs,
 	Run:  listOpenSessions,
@@ -33,17 +36,23 @@ func listOpenSessions(_ *cobra.Command, args []string) {
 		return
 	}
 	defer bc.close()
-	emsg := &pb.Empty{}
 	ctx, cancel := getCtxWithCancel()
 	defer cancel()
-	reply, err := bc.cli.ListOpenSessions(ctx, emsg)
+	reply, err := bc.ext.ListSessions(ctx, &rpc.Empty{})
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 		return
 	}
-	fmt.Printf("Open Sessions: %d\n", len(reply.SessionId))
-	for i, openSess := range reply.SessionId {
-		fmt.Printf("[%d]\nID: %s\n", i, openSess)
+	fmt.Printf("Open Sessions: %d\n", len(reply.Sessions))
+	for i, openSess := range reply.Sessions {
+		fmt.Printf("[%d]\n", i)
+		fmt.Printf("ID:            %s\n", openSess.SessionID)
+		fmt.Printf("Type:          %s\n", openSess.Type)
+		fmt.Printf("Open streams:  %d\n", openSess.OpenStreams)
+		fmt.Printf("Last activity: %s\n", time.Unix(openSess.LastActivity, 0).UTC().Format(time.RFC3339))
+		if openSess.TTLSecs > 0 {
+			fmt.Printf("Lease:         %q for %s\n", openSess.Owner, time.Duration(openSess.TTLSecs)*time.Second)
+		}
 	}
 }
 
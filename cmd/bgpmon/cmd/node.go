@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the node flags. They are shared by the add and update
+// commands.
+var (
+	nodeName        string
+	nodeCollector   bool
+	nodeDuration    int
+	nodeDescription string
+	nodeCoords      string
+	nodeAddress     string
+)
+
+// nodeCmd is a wrapper for the commands that manage the node table of
+// an open session.
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Lists, adds, updates or deletes the nodes of an open session.",
+	Long: `Manages the nodes known to an open session. Nodes describe BGP vantage points, and
+collectors among them determine how capture tables are named and bucketed.`,
+}
+
+var nodeListCmd = &cobra.Command{
+	Use:   "list SESS_ID",
+	Short: "Lists the nodes known to an open session.",
+	Long:  "Prints every node in the node table of the session SESS_ID.",
+	Args:  cobra.ExactArgs(1),
+	Run:   listNodes,
+}
+
+// The cobra command is required, but not used.
+func listNodes(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.ext.ListNodes(ctx, &rpc.ListNodesRequest{SessionID: args[0]})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Nodes: %d\n", len(reply.Nodes))
+	for i, n := range reply.Nodes {
+		fmt.Printf("[%d]\n", i)
+		printNode(n)
+	}
+}
+
+func printNode(n *rpc.NodeInfo) {
+	fmt.Printf("Name:        %s\n", n.Name)
+	fmt.Printf("IP:          %s\n", n.IP)
+	fmt.Printf("Collector:   %t\n", n.IsCollector)
+	fmt.Printf("Duration:    %d\n", n.DumpDurationMinutes)
+	fmt.Printf("Description: %s\n", n.Description)
+	fmt.Printf("Coords:      %s\n", n.Coords)
+	fmt.Printf("Address:     %s\n", n.Address)
+}
+
+var nodeAddCmd = &cobra.Command{
+	Use:   "add SESS_ID NAME IP",
+	Short: "Adds a node to an open session.",
+	Long:  "Adds a node named NAME with address IP to the node table of the session SESS_ID.",
+	Args:  cobra.ExactArgs(3),
+	Run:   addNode,
+}
+
+func addNode(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	n := &rpc.NodeInfo{
+		Name:                args[1],
+		IP:                  args[2],
+		IsCollector:         nodeCollector,
+		DumpDurationMinutes: nodeDuration,
+		Description:         nodeDescription,
+		Coords:              nodeCoords,
+		Address:             nodeAddress,
+	}
+
+	if _, err := bc.ext.AddNode(ctx, &rpc.NodeRequest{SessionID: args[0], Node: n}); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	fmt.Printf("Added node: %s\n", n.Name)
+}
+
+var nodeUpdateCmd = &cobra.Command{
+	Use:   "update SESS_ID IP",
+	Short: "Updates a node on an open session.",
+	Long: `Updates the node with address IP in the node table of the session SESS_ID. Only
+the fields provided as flags are changed.`,
+	Args: cobra.ExactArgs(2),
+	Run:  updateNode,
+}
+
+func updateNode(cmd *cobra.Command, args []string) {
+	sessID, ip := args[0], args[1]
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.ext.ListNodes(ctx, &rpc.ListNodesRequest{SessionID: sessID})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	var n *rpc.NodeInfo
+	for _, v := range reply.Nodes {
+		if v.IP == ip {
+			n = v
+			break
+		}
+	}
+
+	if n == nil {
+		fmt.Printf("Error: no node with IP: %s\n", ip)
+		return
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		n.Name = nodeName
+	}
+	if flags.Changed("collector") {
+		n.IsCollector = nodeCollector
+	}
+	if flags.Changed("duration") {
+		n.DumpDurationMinutes = nodeDuration
+	}
+	if flags.Changed("description") {
+		n.Description = nodeDescription
+	}
+	if flags.Changed("coords") {
+		n.Coords = nodeCoords
+	}
+	if flags.Changed("address") {
+		n.Address = nodeAddress
+	}
+
+	if _, err := bc.ext.UpdateNode(ctx, &rpc.NodeRequest{SessionID: sessID, Node: n}); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	fmt.Printf("Updated node:\n")
+	printNode(n)
+}
+
+var nodeDeleteCmd = &cobra.Command{
+	Use:   "delete SESS_ID NAME|IP",
+	Short: "Deletes a node from an open session.",
+	Long: `Deletes the node matching NAME or IP from the node table of the session SESS_ID.
+Capture tables that were created for that node are not removed.`,
+	Args: cobra.ExactArgs(2),
+	Run:  deleteNode,
+}
+
+func deleteNode(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	// The server matches on either field, so the argument is sent as both.
+	req := &rpc.DeleteNodeRequest{SessionID: args[0], Name: args[1], IP: args[1]}
+	if _, err := bc.ext.DeleteNode(ctx, req); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	fmt.Printf("Deleted node: %s\n", args[1])
+}
+
+func addNodeFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&nodeCollector, "collector", "c", false, "the node is a collector")
+	cmd.Flags().IntVarP(&nodeDuration, "duration", "d", 0, "minutes of captures stored in each table of a collector")
+	cmd.Flags().StringVar(&nodeDescription, "description", "", "description of the node")
+	cmd.Flags().StringVar(&nodeCoords, "coords", "", "coordinates of the node")
+	cmd.Flags().StringVar(&nodeAddress, "address", "", "physical address of the node")
+}
+
+func init() {
+	addNodeFlags(nodeAddCmd)
+	addNodeFlags(nodeUpdateCmd)
+	nodeUpdateCmd.Flags().StringVarP(&nodeName, "name", "n", "", "new name of the node")
+
+	nodeCmd.AddCommand(nodeListCmd)
+	nodeCmd.AddCommand(nodeAddCmd)
+	nodeCmd.AddCommand(nodeUpdateCmd)
+	nodeCmd.AddCommand(nodeDeleteCmd)
+	rootCmd.AddCommand(nodeCmd)
+}
@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
 
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
 	"github.com/google/uuid"
@@ -9,8 +12,10 @@ import (
 )
 
 var (
-	sID string // sID is the ID  for the open session request.
-	nw  uint32 // nw is the number of maximum database workers.
+	sID        string        // sID is the ID  for the open session request.
+	nw         uint32        // nw is the number of maximum database workers.
+	leaseOwner string        // leaseOwner holds the lease of the session.
+	leaseTTL   time.Duration // leaseTTL is how long the session can be idle, 0 for no lease.
 )
 
 var openCmd = &cobra.Command{
@@ -21,7 +26,9 @@ var openCmd = &cobra.Command{
 
 // openCmd issues an OpenSession request to the bgpmond RPC server. That sessions
 // should be of an available type that the server supports and it can be named however
-// the client wishes. Once a session is opened it should be closed by the client.
+// the client wishes. Once a session is opened it should be closed by the client,
+// unless it's leased, in which case the server closes it once it's idle for longer
+// than the lease TTL.
 var openSessionCmd = &cobra.Command{
 	Use:   "session TYPE",
 	Short: "Opens a new database session from the bgpmond to an available database and returns its ID.",
@@ -56,6 +63,19 @@ func openSession(_ *cobra.Command, args []string) {
 		return
 	}
 	fmt.Printf("Opened session: %s\n", reply.SessionId)
+
+	if leaseTTL > 0 {
+		lreq := &rpc.LeaseSessionRequest{
+			SessionID: reply.SessionId,
+			Owner:     leaseOwner,
+			TTLSecs:   int64(leaseTTL / time.Second),
+		}
+		if _, err := bc.ext.LeaseSession(ctx, lreq); err != nil {
+			fmt.Printf("Error leasing session: %s\n", err)
+			return
+		}
+		fmt.Printf("Leased session to %q for %s\n", leaseOwner, leaseTTL)
+	}
 }
 
 // These will be the options for the openModule command
@@ -98,6 +118,8 @@ func openModule(_ *cobra.Command, args []string) {
 func init() {
 	openSessionCmd.Flags().StringVarP(&sID, "sessionId", "s", genUUID(), "UUID for the session")
 	openSessionCmd.Flags().Uint32VarP(&nw, "workers", "w", 0, "Number of maximum concurrent workers (default uses the server provided value)")
+	openSessionCmd.Flags().StringVar(&leaseOwner, "owner", "", "owner of the session lease")
+	openSessionCmd.Flags().DurationVar(&leaseTTL, "ttl", 0, "close the session once it's idle for this long, 0 for no lease")
 
 	openModuleCmd.Flags().StringVarP(&opts, "opts", "o", "", "options for the module")
 	openCmd.AddCommand(openSessionCmd)
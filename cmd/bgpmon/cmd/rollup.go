@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the rollup flags. The collector and time span are
+// shared with read.
+var (
+	rollupGranularity string
+	rollupKind        string
+	rollupKey         string
+)
+
+var rollupCmd = &cobra.Command{
+	Use:   "rollup SESS_ID",
+	Short: "Reads capture summaries from an open session.",
+	Long: `Prints the hourly or daily capture summaries of the session SESS_ID whose bucket
+starts between start and end. Summaries are written by the rollup module. Kind may be
+total, peer, origin or prefix, and key is the peer IP, origin AS or prefix to show.`,
+	Args: cobra.ExactArgs(1),
+	Run:  readRollups,
+}
+
+// The cobra command is required, but not used.
+func readRollups(_ *cobra.Command, args []string) {
+	start, end, err := getTimeSpan()
+	if err != nil {
+		fmt.Printf("Error parsing time span: %s\n", err)
+		return
+	}
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.ext.ReadRollups(ctx, &rpc.RollupQuery{
+		SessionID:   args[0],
+		Collector:   collector,
+		Granularity: rollupGranularity,
+		Kind:        rollupKind,
+		Key:         rollupKey,
+		Start:       start.Unix(),
+		End:         end.Unix(),
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Rollups: %d\n", len(reply.Rollups))
+	for _, r := range reply.Rollups {
+		bucket := time.Unix(r.Bucket, 0).UTC().Format(time.RFC3339)
+		fmt.Printf("%s %s %-6s %-20s updates:%d announced:%d withdrawn:%d ratio:%.3f paths:%d\n", bucket, r.Collector,
+			r.Kind, r.Key, r.Updates, r.Announcements, r.Withdrawals, r.AnnouncementRatio, r.UniquePaths)
+	}
+}
+
+func init() {
+	rollupCmd.Flags().StringVarP(&collector, "collector", "c", "", "only summaries of this collector")
+	rollupCmd.Flags().StringVarP(&startStr, "start", "s", "", "beginning time of the read (required)")
+	rollupCmd.MarkFlagRequired("start")
+	rollupCmd.Flags().StringVarP(&endStr, "end", "e", "", "end time of the read (required)")
+	rollupCmd.MarkFlagRequired("end")
+	rollupCmd.Flags().StringVarP(&rollupGranularity, "granularity", "g", "hour", "length of the summarized buckets, hour or day")
+	rollupCmd.Flags().StringVarP(&rollupKind, "kind", "k", "total", "kind of summaries to show, empty for all")
+	rollupCmd.Flags().StringVar(&rollupKey, "key", "", "only summaries with this key")
+
+	rootCmd.AddCommand(rollupCmd)
+}
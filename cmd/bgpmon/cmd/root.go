@@ -10,6 +10,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/CSUNetSec/bgpmon/rpc"
+
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -25,10 +27,12 @@ var (
 )
 
 // bgpmonCli is a structure that unifies a grpc connection with the bgpmond
-// client protobuf specification from the netsec-protobufs repository.
+// client protobuf specification from the netsec-protobufs repository, and
+// the supplementary bgpmond service defined in the rpc package.
 type bgpmonCli struct {
 	conn *grpc.ClientConn
 	cli  pb.BgpmondClient
+	ext  rpc.BgpmondExtClient
 }
 
 func newBgpmonCli(host string, port uint32) (*bgpmonCli, error) {
@@ -39,6 +43,7 @@ func newBgpmonCli(host string, port uint32) (*bgpmonCli, error) {
 	}
 	ret.conn = conn
 	ret.cli = pb.NewBgpmondClient(conn)
+	ret.ext = rpc.NewBgpmondExtClient(conn)
 	return ret, nil
 }
 
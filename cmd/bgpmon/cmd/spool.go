@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+var spoolCmd = &cobra.Command{
+	Use:   "spool SESS_ID",
+	Short: "Prints the state of the capture spool of an open session.",
+	Long: `Prints how many captures the session SESS_ID has spooled to disk while its database
+was unavailable, and are waiting to be replayed. A session only has a spool if SpoolDir is
+set in its configuration.`,
+	Args: cobra.ExactArgs(1),
+	Run:  spoolStatus,
+}
+
+// The cobra command is required, but not used.
+func spoolStatus(_ *cobra.Command, args []string) {
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	reply, err := bc.ext.SpoolStatus(ctx, &rpc.SpoolStatusRequest{SessionID: args[0]})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	if !reply.Enabled {
+		fmt.Printf("Session %s has no spool\n", args[0])
+		return
+	}
+
+	fmt.Printf("Dir: %s\n", reply.Dir)
+	fmt.Printf("Waiting: %d captures in %d segments\n", reply.Captures, reply.Segments)
+	fmt.Printf("Size: %s of %s\n", formatSize(reply.Bytes), formatSize(reply.MaxBytes))
+	fmt.Printf("Streams: %d open, %d spooling\n", reply.OpenSegments, reply.Spooling)
+	if reply.Failed != 0 {
+		fmt.Printf("Failed: %d segments couldn't be read\n", reply.Failed)
+	}
+
+	last := "never"
+	if reply.LastReplay != 0 {
+		last = time.Unix(reply.LastReplay, 0).UTC().Format(time.RFC3339)
+	}
+	if reply.Replaying {
+		last += " (replaying)"
+	}
+	fmt.Printf("Last replay: %s\n", last)
+	if reply.LastError != "" {
+		fmt.Printf("Last error: %s\n", reply.LastError)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(spoolCmd)
+}
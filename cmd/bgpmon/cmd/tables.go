@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/araddon/dateparse"
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the tables flags. The collector and time span are
+// shared with read.
+var (
+	tablesCalendar    bool
+	tablesGranularity string
+)
+
+// These are the default lengths of a coverage calendar, when no start is
+// provided.
+const (
+	defaultHourCalendar = 7 * 24 * time.Hour
+	defaultDayCalendar  = 90 * 24 * time.Hour
+)
+
+var tablesCmd = &cobra.Command{
+	Use:   "tables SESS_ID",
+	Short: "Lists the capture tables of an open session.",
+	Long: `Lists the capture tables of the session SESS_ID holding captures between start and end,
+with their number of captures, size on disk, and whether any hour of them is missing captures.
+With --calendar, the coverage of each collector is printed instead, with a row per day and a
+column per hour, or a row per month and a column per day, depending on granularity. End
+defaults to now, and start to the oldest table, or to 7 or 90 days before end for a calendar.`,
+	Args: cobra.ExactArgs(1),
+	Run:  listTables,
+}
+
+// getTablesTimeSpan returns the time span of the tables command, filling in
+// the defaults of start and end.
+func getTablesTimeSpan() (start time.Time, end time.Time, err error) {
+	end = time.Now().UTC()
+	if endStr != "" {
+		if end, err = dateparse.ParseAny(endStr); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case startStr != "":
+		start, err = dateparse.ParseAny(startStr)
+	case !tablesCalendar:
+		start = time.Unix(0, 0)
+	case tablesGranularity == "day":
+		start = end.Add(-defaultDayCalendar)
+	default:
+		start = end.Add(-defaultHourCalendar)
+	}
+	return
+}
+
+// The cobra command is required, but not used.
+func listTables(_ *cobra.Command, args []string) {
+	start, end, err := getTablesTimeSpan()
+	if err != nil {
+		fmt.Printf("Error parsing time span: %s\n", err)
+		return
+	}
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getCtxWithCancel()
+	defer cancel()
+
+	if tablesCalendar {
+		reply, err := bc.ext.GetCoverage(ctx, &rpc.CoverageQuery{
+			SessionID:   args[0],
+			Collector:   collector,
+			Granularity: tablesGranularity,
+			Start:       start.Unix(),
+			End:         end.Unix(),
+		})
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		printCoverageCalendar(reply.Buckets, tablesGranularity == "day", start, end)
+		return
+	}
+
+	reply, err := bc.ext.ListTables(ctx, &rpc.TablesQuery{
+		SessionID: args[0],
+		Collector: collector,
+		Start:     start.Unix(),
+		End:       end.Unix(),
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Tables: %d\n", len(reply.Tables))
+	for _, t := range reply.Tables {
+		tStart := time.Unix(t.Start, 0).UTC().Format(time.RFC3339)
+		tEnd := time.Unix(t.End, 0).UTC().Format(time.RFC3339)
+		gaps := "no gaps"
+		if t.HasGaps {
+			gaps = "has gaps"
+		}
+		fmt.Printf("%s %s %s - %s captures:%d size:%s hours with data:%d/%d %s\n", t.Name, t.Collector, tStart, tEnd,
+			t.Captures, formatSize(t.SizeBytes), t.HoursWithData, t.Hours, gaps)
+	}
+}
+
+// formatSize returns a number of bytes in the largest unit that keeps it
+// above 1.
+func formatSize(b int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(b)
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
+
+// printCoverageCalendar prints a calendar of the buckets of each collector.
+// Each hour or day of [start, end) with captures is marked with a #, and one
+// without with a dot.
+func printCoverageCalendar(buckets []*rpc.CoverageInfo, days bool, start, end time.Time) {
+	if len(buckets) == 0 {
+		fmt.Printf("No captures between %s and %s\n", start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+		return
+	}
+
+	// Collectors are printed in the order their buckets were returned.
+	var collectors []string
+	covered := make(map[string]map[int64]bool)
+	for _, b := range buckets {
+		if _, ok := covered[b.Collector]; !ok {
+			collectors = append(collectors, b.Collector)
+			covered[b.Collector] = make(map[int64]bool)
+		}
+		covered[b.Collector][b.Bucket] = true
+	}
+
+	start, end = start.UTC(), end.UTC()
+	for _, col := range collectors {
+		fmt.Printf("%s\n", col)
+		if days {
+			printDayCalendar(covered[col], start, end)
+		} else {
+			printHourCalendar(covered[col], start, end)
+		}
+	}
+}
+
+// printHourCalendar prints a row per day, and a column per hour.
+func printHourCalendar(covered map[int64]bool, start, end time.Time) {
+	fmt.Printf("%-10s %s\n", "", "000000000011111111112222")
+	fmt.Printf("%-10s %s\n", "", "012345678901234567890123")
+
+	first := start.Truncate(time.Hour)
+	for day := start.Truncate(24 * time.Hour); day.Before(end); day = day.Add(24 * time.Hour) {
+		var row strings.Builder
+		for h := 0; h < 24; h++ {
+			row.WriteByte(calendarCell(covered, day.Add(time.Duration(h)*time.Hour), first, end))
+		}
+		fmt.Printf("%s %s\n", day.Format("2006-01-02"), row.String())
+	}
+}
+
+// printDayCalendar prints a row per month, and a column per day.
+func printDayCalendar(covered map[int64]bool, start, end time.Time) {
+	fmt.Printf("%-7s %s\n", "", "0000000001111111111222222222233")
+	fmt.Printf("%-7s %s\n", "", "1234567890123456789012345678901")
+
+	first := start.Truncate(24 * time.Hour)
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for ; month.Before(end); month = month.AddDate(0, 1, 0) {
+		var row strings.Builder
+		for d := 0; d < 31; d++ {
+			day := month.AddDate(0, 0, d)
+			if day.Month() != month.Month() {
+				row.WriteByte(' ')
+				continue
+			}
+			row.WriteByte(calendarCell(covered, day, first, end))
+		}
+		fmt.Printf("%s %s\n", month.Format("2006-01"), row.String())
+	}
+}
+
+// calendarCell returns the mark of the bucket starting at t.
+func calendarCell(covered map[int64]bool, t, first, end time.Time) byte {
+	switch {
+	case t.Before(first) || !t.Before(end):
+		return ' '
+	case covered[t.Unix()]:
+		return '#'
+	default:
+		return '.'
+	}
+}
+
+func init() {
+	tablesCmd.Flags().StringVarP(&collector, "collector", "c", "", "only tables of this collector")
+	tablesCmd.Flags().StringVarP(&startStr, "start", "s", "", "beginning time of the listing")
+	tablesCmd.Flags().StringVarP(&endStr, "end", "e", "", "end time of the listing")
+	tablesCmd.Flags().BoolVar(&tablesCalendar, "calendar", false, "print the coverage of each collector instead of the tables")
+	tablesCmd.Flags().StringVarP(&tablesGranularity, "granularity", "g", "hour", "coverage calendar buckets, hour or day")
+
+	rootCmd.AddCommand(tablesCmd)
+}
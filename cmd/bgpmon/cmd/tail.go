@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/rpc"
+
+	"github.com/spf13/cobra"
+)
+
+// Variables to store the tail flags.
+var (
+	tailRate           float64
+	tailBuffer         int
+	tailDisconnectSlow bool
+)
+
+var tailCmd = &cobra.Command{
+	Use:   "tail SESS_ID [FILTER...]",
+	Short: "Prints captures as they are written to an open session.",
+	Long: `Prints the captures committed to the session SESS_ID from now on, until interrupted.
+Each FILTER is a key=value pair, and a capture must pass all of them. The keys are:
+	collector=NAME   a collector name or IP
+	origin=AS        the origin AS
+	peer_as=AS       the AS of the peer
+	family=4|6       an advertised or withdrawn prefix of this IP version
+	prefix=CIDR      this advertised prefix, may be repeated
+	subnet=CIDR      an advertised prefix covered by this one, may be repeated`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  tailCaptures,
+}
+
+// parseTailFilter fills the filter of a TailRequest from key=value terms.
+func parseTailFilter(req *rpc.TailRequest, terms []string) error {
+	for _, term := range terms {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fmt.Errorf("malformed filter: %s", term)
+		}
+
+		key, val := strings.ToLower(kv[0]), kv[1]
+		switch key {
+		case "collector":
+			req.Collector = val
+		case "origin", "peer_as":
+			as, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(val), "AS"), 10, 32)
+			if err != nil {
+				return fmt.Errorf("malformed AS in filter: %s", term)
+			}
+			if key == "origin" {
+				req.Origin = uint32(as)
+			} else {
+				req.PeerAS = uint32(as)
+			}
+		case "family":
+			fam, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("malformed family in filter: %s", term)
+			}
+			req.Family = fam
+		case "prefix":
+			req.Prefixes = append(req.Prefixes, val)
+		case "subnet":
+			req.Subnets = append(req.Subnets, val)
+		default:
+			return fmt.Errorf("unknown filter key: %s", kv[0])
+		}
+	}
+	return nil
+}
+
+// The cobra command is required, but not used.
+func tailCaptures(_ *cobra.Command, args []string) {
+	req := &rpc.TailRequest{
+		SessionID:      args[0],
+		MaxRate:        tailRate,
+		Buffer:         tailBuffer,
+		DisconnectSlow: tailDisconnectSlow,
+	}
+	if err := parseTailFilter(req, args[1:]); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	bc, clierr := newBgpmonCli(bgpmondHost, bgpmondPort)
+	if clierr != nil {
+		fmt.Printf("Error: %s\n", clierr)
+		return
+	}
+	defer bc.close()
+
+	ctx, cancel := getBackgroundCtxWithCancel()
+	defer cancel()
+
+	// An interrupt ends the tail, and the summary is still printed.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := bc.ext.Tail(ctx, req)
+	if err != nil {
+		fmt.Printf("Error opening tail stream: %s\n", err)
+		return
+	}
+
+	var received, dropped uint64
+	for {
+		rep, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				fmt.Printf("Error reading from stream: %s\n", err)
+			}
+			break
+		}
+
+		received++
+		dropped = rep.Dropped
+		c := rep.Capture
+		ts := time.Unix(0, c.Timestamp*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+		fmt.Printf("%s %s %s AS%d origin:%d path:%v adv:%v wdr:%v\n", ts, c.Collector, c.Peer, c.PeerAS,
+			c.Origin, c.ASPath, c.Advertised, c.Withdrawn)
+	}
+
+	fmt.Printf("Total captures: %d received, %d dropped\n", received, dropped)
+}
+
+func init() {
+	tailCmd.Flags().Float64VarP(&tailRate, "rate", "r", 0, "most captures to receive each second, 0 for no limit")
+	tailCmd.Flags().IntVarP(&tailBuffer, "buffer", "b", 0, "captures the server holds while the client catches up, 0 for the server default")
+	tailCmd.Flags().BoolVar(&tailDisconnectSlow, "disconnect-slow", false, "end the tail instead of dropping captures if the client falls behind")
+
+	rootCmd.AddCommand(tailCmd)
+}
@@ -1,16 +1,23 @@
 package cmd
 
 import (
+	"bufio"
+	"compress/bzip2"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/CSUNetSec/bgpmon/config"
 	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/rpc"
+	"github.com/CSUNetSec/bgpmon/util"
 
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
 	"github.com/CSUNetSec/protoparse/fileutil"
 	"github.com/CSUNetSec/protoparse/filter"
+	"github.com/CSUNetSec/protoparse/protocol/mrt"
 	swg "github.com/remeh/sizedwaitgroup"
 	"github.com/spf13/cobra"
 )
@@ -25,7 +32,7 @@ var writeCmd = &cobra.Command{
 var writeCapCmd = &cobra.Command{
 	Use:   "capture SESS_ID FILES...",
 	Short: "Writes BGP captures from a file(s) to a session.",
-	Long:  "Opens a write stream(s) on the provided session and writes <workers> files concurrently. Write generates a report upon completion of the success or failure of individual files.",
+	Long:  "Opens a write stream(s) on the provided session and writes <workers> files concurrently. State changes, opens, notifications and keepalive gaps in the files are written as peer events, skipping the ones already stored. The filters apply to peer events too, so records they can't be matched against, like state changes, are skipped when there are any. Write generates a report upon completion of the success or failure of individual files.",
 	Args:  cobra.MinimumNArgs(2),
 	Run:   writeCapFunc,
 }
@@ -39,11 +46,17 @@ var (
 // summary goroutine. It contains the file name, the number of messages
 // written from that file, and any error generated by writing it.
 type writeMRTResult struct {
-	fileName string
-	msgCt    int
-	err      error
+	fileName  string
+	msgCt     int
+	stored    int // captures stored by the server, less than msgCt if some were duplicates
+	events    int // peer events stored from the file
+	dupEvents int // peer events skipped because they were already stored
+	err       error
 }
 
+// peerEventBatch is how many peer events are written in a single call.
+const peerEventBatch = 1000
+
 // The cobra.Command is necessary for cobra, but it isn't used.
 func writeCapFunc(_ *cobra.Command, args []string) {
 	sessID := args[0]
@@ -97,8 +110,9 @@ func writeCapFunc(_ *cobra.Command, args []string) {
 		fmt.Printf("Writing %s\n", fileName)
 
 		go func(f string, wp *swg.SizedWaitGroup) {
-			ct, err := writeMRTFile(bc, f, sessID, filts)
-			results <- writeMRTResult{fileName: f, msgCt: ct, err: err}
+			res := writeMRTFile(bc, f, sessID, filts)
+			res.fileName = f
+			results <- res
 			wp.Done()
 		}(fileName, &workerPool)
 	}
@@ -112,6 +126,7 @@ func summarizeResults(in chan writeMRTResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	numWritten := 0
+	sent, stored, events, dupEvents := 0, 0, 0, 0
 
 	var failed []writeMRTResult
 
@@ -120,40 +135,189 @@ func summarizeResults(in chan writeMRTResult, wg *sync.WaitGroup) {
 
 		if result.err != nil {
 			failed = append(failed, result)
+		} else {
+			sent += result.msgCt
+			stored += result.stored
+			events += result.events
+			dupEvents += result.dupEvents
 		}
 	}
 
 	fmt.Printf("Total completed: %d\n", numWritten)
 	fmt.Printf("Total failures:  %d\n", len(failed))
+	fmt.Printf("Total captures:  %d sent, %d stored, %d skipped as duplicates or unparsable\n", sent, stored, sent-stored)
+	if events > 0 || dupEvents > 0 {
+		fmt.Printf("Total peer events: %d stored, %d skipped as duplicates\n", events, dupEvents)
+	}
 	for _, res := range failed {
 		fmt.Printf("%s : %s\n", res.fileName, res.err)
 	}
 }
 
-func writeMRTFile(bc *bgpmonCli, fileName, sessID string, filts []filter.Filter) (int, error) {
+// newMRTScanner opens an MRT file, decompressing it if it ends in .bz2, and
+// returns a scanner over its records. The returned file must be closed by
+// the caller.
+func newMRTScanner(fileName string) (*bufio.Scanner, io.Closer, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var in io.Reader = fd
+	if filepath.Ext(fileName) == ".bz2" {
+		in = bzip2.NewReader(fd)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Split(mrt.SplitMrt)
+	// MRT records can be up to 1MB, larger than the default token size.
+	buf := make([]byte, 2<<20)
+	scanner.Buffer(buf, cap(buf))
+	return scanner, fd, nil
+}
+
+// passesFilters returns whether an MRT record passes every filter. When
+// there are filters, a record they can't be matched against doesn't pass.
+func passesFilters(rec []byte, filts []filter.Filter) bool {
+	if len(filts) == 0 {
+		return true
+	}
+
+	mbs, err := mrt.ParseHeaders(rec, false)
+	if err != nil {
+		return false
+	}
+	return filter.FilterAll(filts, mbs)
+}
+
+// peerEventInfo converts a BGP4MP record to the peer event it describes.
+// Ok is false if the record doesn't describe one.
+func peerEventInfo(pr *util.MRTPeerRecord) (info *rpc.PeerEventInfo, ok bool) {
+	info = &rpc.PeerEventInfo{
+		Timestamp: pr.Timestamp.UnixNano() / 1000,
+		Collector: util.IPString(pr.LocalIP),
+		Peer:      util.IPString(pr.PeerIP),
+		PeerAS:    pr.PeerAS,
+	}
+
+	switch pr.Kind {
+	case util.MRTStateChange:
+		info.Type = string(db.PeerStateChange)
+		info.OldState = util.BGPStateName(pr.OldState)
+		info.NewState = util.BGPStateName(pr.NewState)
+	case util.MRTOpen:
+		info.Type = string(db.PeerOpen)
+		info.HoldTime = int64(pr.HoldTime.Seconds())
+	case util.MRTNotification:
+		info.Type = string(db.PeerNotification)
+		info.ErrorCode = pr.ErrorCode
+		info.ErrorSubcode = pr.ErrorSubcode
+	default:
+		return nil, false
+	}
+	return info, true
+}
+
+// writeMRTFile writes the updates of an MRT file as captures, and its
+// other BGP4MP records as peer events. Both only pass if the record passes
+// filts. The result counts how many captures were sent, how many of those
+// the server stored, and how many peer events were stored or skipped as
+// duplicates.
+func writeMRTFile(bc *bgpmonCli, fileName, sessID string, filts []filter.Filter) writeMRTResult {
 	ctx, cancel := getBackgroundCtxWithCancel()
 	defer cancel()
 
 	stream, err := bc.cli.Write(ctx)
 	if err != nil {
-		return 0, err
+		return writeMRTResult{err: err}
 	}
 
-	mf, err := fileutil.NewMrtFileReader(fileName, filts)
+	scanner, fd, err := newMRTScanner(fileName)
 	if err != nil {
-		return 0, err
+		return writeMRTResult{err: err}
 	}
-	defer mf.Close()
+	defer fd.Close()
 
-	parsed := 0
-	for mf.Scan() {
-		cap, err := mf.GetCapture()
+	res := writeMRTResult{}
+	hold := util.NewHoldTimer()
+	var events []*rpc.PeerEventInfo
+
+	flushEvents := func() error {
+		if len(events) == 0 {
+			return nil
+		}
+		rep, err := bc.ext.WritePeerEvents(ctx, &rpc.WritePeerEventsRequest{SessionID: sessID, Events: events})
+		if err != nil {
+			return fmt.Errorf("peer event write error: %s", err)
+		}
+		res.events += int(rep.Written)
+		res.dupEvents += int(rep.Skipped)
+		events = events[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		rec := scanner.Bytes()
+
+		pr, err := util.ParseMRTPeerRecord(rec)
+		if err != nil && err != util.ErrNotBGP4MP {
+			fmt.Printf("Parse error: %s\n", err)
+			continue
+		}
+
+		if pr != nil {
+			// Every record is observed, so gaps are measured even if the
+			// records around them are filtered out.
+			gap, ht, isGap := hold.Observe(pr)
+			if !passesFilters(rec, filts) {
+				continue
+			}
+
+			if isGap {
+				events = append(events, &rpc.PeerEventInfo{
+					Timestamp: pr.Timestamp.UnixNano() / 1000,
+					Type:      string(db.PeerKeepaliveGap),
+					Collector: util.IPString(pr.LocalIP),
+					Peer:      util.IPString(pr.PeerIP),
+					PeerAS:    pr.PeerAS,
+					HoldTime:  int64(ht.Seconds()),
+					Gap:       gap.Seconds(),
+				})
+			}
+
+			if info, ok := peerEventInfo(pr); ok {
+				events = append(events, info)
+			}
+
+			if len(events) >= peerEventBatch {
+				if err := flushEvents(); err != nil {
+					res.err = err
+					return res
+				}
+			}
+
+			// Only updates are written as captures.
+			if pr.Kind != util.MRTUpdate {
+				continue
+			}
+		}
+
+		mbs, err := mrt.ParseHeaders(rec, false)
+		if err != nil {
+			fmt.Printf("Parse error: %s\n", err)
+			continue
+		}
+		if !filter.FilterAll(filts, mbs) {
+			continue
+		}
+
+		cap, err := mrt.MrtToBGPCapturev2(rec)
 		if err != nil || cap == nil {
 			fmt.Printf("Parse error: %s\n", err)
 			continue
 		}
 
-		parsed++
+		res.msgCt++
 
 		writeRequest := &pb.WriteRequest{
 			Type:       pb.WriteRequest_BGP_CAPTURE,
@@ -162,22 +326,30 @@ func writeMRTFile(bc *bgpmonCli, fileName, sessID string, filts []filter.Filter)
 		}
 
 		if err := stream.Send(writeRequest); err != nil {
-			return 0, err
+			res.err = err
+			return res
 		}
 	}
 
-	if err := mf.Err(); err != nil {
-		return parsed, fmt.Errorf("MRT file reader error: %s", err)
+	if err := scanner.Err(); err != nil {
+		res.err = fmt.Errorf("MRT file reader error: %s", err)
+		return res
+	}
+
+	if err := flushEvents(); err != nil {
+		res.err = err
+		return res
 	}
 
 	rep, err := stream.CloseAndRecv()
 	if rep != nil && rep.Error != "" {
-		return parsed, fmt.Errorf("write stream server error: %s", rep.Error)
+		res.err = fmt.Errorf("write stream server error: %s", rep.Error)
 	} else if err != nil && err != io.EOF {
-		return parsed, fmt.Errorf("write stream server error: %s", err)
+		res.err = fmt.Errorf("write stream server error: %s", err)
+	} else if rep != nil {
+		res.stored = int(rep.TotalMessages)
 	}
-
-	return parsed, nil
+	return res
 }
 
 var writeEntityCmd = &cobra.Command{
@@ -3,12 +3,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	core "github.com/CSUNetSec/bgpmon"
 	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/db"
 	_ "github.com/CSUNetSec/bgpmon/modules"
 	"github.com/CSUNetSec/bgpmon/util"
 )
@@ -19,14 +22,28 @@ var mainLogger = util.NewLogger("system", "main")
 
 // Launches the BgpmondServer with a configuration file provided on the command
 // line. If the config file provided doesn't contain an RPC module, this launches
-// a default RPC. This command will only halt on ctrl-C, at which point it will
-// shut down the server.
+// a default RPC. This command will only halt on ctrl-C or SIGTERM, at which
+// point it will shut down the server. On SIGTERM, the server is drained first,
+// so the write streams in progress can commit. On SIGHUP, the configuration
+// file is read again and applied to the running server. With -migrate, the
+// offline migrations are applied to the database of every configured session
+// type instead, and the command exits.
 func main() {
-	if len(os.Args) != 2 {
+	migrate := flag.Bool("migrate", false, "apply the offline migrations to every session type's database, then exit")
+	flag.Parse()
+	if flag.NArg() != 1 {
 		mainLogger.Fatalf("No configuration file provided")
 	}
+	confFile := flag.Arg(0)
 
-	server, err := core.NewServerFromFile(os.Args[1])
+	if *migrate {
+		if err := migrateSessions(confFile); err != nil {
+			mainLogger.Fatalf("Error migrating: %s", err)
+		}
+		return
+	}
+
+	server, err := core.NewServerFromFile(confFile)
 	if err != nil {
 		mainLogger.Fatalf("Error creating server: %s", err)
 	}
@@ -51,17 +68,70 @@ func main() {
 		}
 	}
 
-	waitOnInterrupt()
-	mainLogger.Infof("Received SIGINT, shutting down")
+	if sig := waitOnInterrupt(server, confFile); sig == syscall.SIGTERM {
+		mainLogger.Infof("Received SIGTERM, draining before shutting down")
+		rep := server.Drain()
+		for id, lost := range rep.Lost {
+			mainLogger.Errorf("Session %s: cancelling %d write streams, losing %d uncommitted objects", id, lost.Streams, lost.Written)
+		}
+	} else {
+		mainLogger.Infof("Received SIGINT, shutting down")
+	}
 
 	if err := server.Close(); err != nil {
 		mainLogger.Fatalf("Error shutting down server: %s", err)
 	}
 }
 
-func waitOnInterrupt() {
-	close := make(chan os.Signal, 1)
-	signal.Notify(close, os.Interrupt)
-	<-close
-	return
+// migrateSessions opens a session of every session type configured in
+// confFile, and applies the offline migrations to its capture tables. The
+// daemon shouldn't be running meanwhile, since every table is locked while
+// it's rewritten.
+func migrateSessions(confFile string) error {
+	fd, err := os.Open(confFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	conf, err := config.NewConfig(fd)
+	if err != nil {
+		return err
+	}
+
+	for _, sc := range conf.GetSessionConfigs() {
+		session, err := db.NewSession(sc, "migrate-"+sc.GetName(), sc.GetWorkerCt())
+		if err != nil {
+			return fmt.Errorf("session type %s: %s", sc.GetName(), err)
+		}
+
+		n, err := session.MigrateCaptureTables()
+		mainLogger.Infof("Session type %s: migrated %d capture tables", sc.GetName(), n)
+		if cerr := session.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("session type %s: %s", sc.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// waitOnInterrupt returns the signal received on SIGINT or SIGTERM, and
+// reloads the configuration of server from confFile on every SIGHUP until
+// then.
+func waitOnInterrupt(server core.BgpmondServer, confFile string) os.Signal {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		sig := <-sigs
+		if sig != syscall.SIGHUP {
+			return sig
+		}
+
+		mainLogger.Infof("Received SIGHUP, reloading configuration from %s", confFile)
+		if err := core.ReloadFromFile(server, confFile); err != nil {
+			mainLogger.Errorf("Error reloading configuration: %s", err)
+		}
+	}
 }
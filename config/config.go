@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"reflect"
 
 	"github.com/CSUNetSec/bgpmon/util"
 
@@ -22,6 +23,7 @@ var (
 	sessionTypeNames = [...]string{
 		"cockroachdb",
 		"postgres",
+		"sharded",
 	}
 )
 
@@ -29,6 +31,7 @@ var (
 const (
 	CochroachSession = sessionType(iota)
 	PostgresSession
+	ShardedSession
 )
 
 const (
@@ -38,6 +41,12 @@ const (
 	DefaultRPCAddress = ":12289"
 	// DefaultDBTimeoutSecs is the maximum lifetime for a DB operation defaults to 4 minutes
 	DefaultDBTimeoutSecs = 240
+	// DefaultSpoolMaxMB is the maximum size of a session's capture spool
+	DefaultSpoolMaxMB = 1024
+	// DefaultMaxReplicaLagSecs is how far a read replica can fall behind before reads avoid it
+	DefaultMaxReplicaLagSecs = 30
+	// DefaultDrainTimeoutSecs is how long a draining server waits for write streams to commit
+	DefaultDrainTimeoutSecs = 30
 	// DefaultSuggestedNodeFile is the file created by PutConfiguredNodes
 	DefaultSuggestedNodeFile = "suggested_nodes.toml"
 )
@@ -52,6 +61,8 @@ type Configer interface {
 	GetSessionConfigWithName(string) (SessionConfiger, error)
 	GetConfiguredNodes() map[string]NodeConfig
 	GetModules() []ModuleConfig
+	GetStateFile() string
+	GetDrainTimeoutSecs() int
 }
 
 // SessionConfiger describes the configuration for a bgpmond session
@@ -60,20 +71,31 @@ type SessionConfiger interface {
 	GetHostNames() []string
 	GetName() string
 	GetDatabaseName() string
+	GetNamespace() string
 	GetTypeName() string
 	GetUser() string
 	GetPassword() string
 	GetCertDir() string
 	GetWorkerCt() int
 	GetDBTimeoutSecs() int
+	GetIndexes() []string
+	GetDeferIndexes() bool
+	GetDedup() bool
+	GetSpoolDir() string
+	GetSpoolMaxMB() int
+	GetMaxReplicaLagSecs() int
+	GetShards() []string
+	GetShardOf() map[string]string
 }
 
 type bgpmondConfig struct {
-	DebugOut string
-	ErrorOut string
-	Sessions map[string]sessionConfig //configured sessions
-	Nodes    map[string]NodeConfig    //known nodes. all collectors must be present here
-	Modules  map[string]ModuleConfig
+	DebugOut         string
+	ErrorOut         string
+	StateFile        string                   //file recording the sessions and modules opened at runtime, disabled if empty
+	DrainTimeoutSecs int                      //max seconds to wait for write streams to commit when draining
+	Sessions         map[string]sessionConfig //configured sessions
+	Nodes            map[string]NodeConfig    //known nodes. all collectors must be present here
+	Modules          map[string]ModuleConfig
 }
 
 func (b *bgpmondConfig) GetSessionConfigs() []SessionConfiger {
@@ -109,6 +131,14 @@ func (b *bgpmondConfig) GetModules() []ModuleConfig {
 	return ret
 }
 
+func (b *bgpmondConfig) GetStateFile() string {
+	return b.StateFile
+}
+
+func (b *bgpmondConfig) GetDrainTimeoutSecs() int {
+	return b.DrainTimeoutSecs
+}
+
 // PutConfiguredNodes writes a node configuration in the TOML format to w
 func PutConfiguredNodes(a map[string]NodeConfig) error {
 	fd, err := os.Create(DefaultSuggestedNodeFile)
@@ -127,10 +157,21 @@ type sessionConfig struct {
 	CertDir       string   // directory on the bgpmond host containing the certs
 	User          string   // user in the DB to run bgpmond as
 	Password      string   // user's password
-	Hosts         []string // list of hosts for that cluster
+	Hosts         []string // list of hosts for that cluster. For postgres, the primary followed by read replicas
 	Database      string   // the database under which the bgpmond relations live
+	Namespace     string   // postgres schema holding the tables of this session, the default schema if empty
 	WorkerCt      int      // The default worker count for this kind of session
 	DBTimeoutSecs int      // Max number of seconds that a DB operation (TX or Exec) should run
+	Indexes       []string // capture table columns to index, like timestamp or adv_prefixes
+	DeferIndexes  bool     // only index capture tables once their period has closed
+	Dedup         bool     // skip captures already written to their table
+	SpoolDir      string   // directory to spool captures to while the DB is unavailable, disabled if empty
+	SpoolMaxMB    int      // max size of the spool in megabytes
+	MaxReplicaLag int      // max seconds a read replica can lag behind the primary and still be read from
+
+	// Sharded sessions spread the collectors over the sessions named in Shards.
+	Shards  []string          // names of the sessions holding the shards
+	ShardOf map[string]string // collector IP to the name of its shard, others are placed by consistent hashing
 }
 
 // NodeConfig describes a BGP node, either a collector or a peer.
@@ -183,6 +224,10 @@ func (s sessionConfig) GetDatabaseName() string {
 	return s.Database
 }
 
+func (s sessionConfig) GetNamespace() string {
+	return s.Namespace
+}
+
 func (s sessionConfig) GetUser() string {
 	return s.User
 }
@@ -203,12 +248,44 @@ func (s sessionConfig) GetDBTimeoutSecs() int {
 	return s.DBTimeoutSecs
 }
 
+func (s sessionConfig) GetIndexes() []string {
+	return s.Indexes
+}
+
+func (s sessionConfig) GetDeferIndexes() bool {
+	return s.DeferIndexes
+}
+
+func (s sessionConfig) GetDedup() bool {
+	return s.Dedup
+}
+
+func (s sessionConfig) GetSpoolDir() string {
+	return s.SpoolDir
+}
+
+func (s sessionConfig) GetSpoolMaxMB() int {
+	return s.SpoolMaxMB
+}
+
+func (s sessionConfig) GetMaxReplicaLagSecs() int {
+	return s.MaxReplicaLag
+}
+
+func (s sessionConfig) GetShards() []string {
+	return s.Shards
+}
+
+func (s sessionConfig) GetShardOf() map[string]string {
+	return s.ShardOf
+}
+
 // EntityConfig contains an entity that was specified in a configuration
 // file.
 type EntityConfig struct {
 	Name          string
 	Email         string
-	OwnedOrigins  []int
+	OwnedOrigins  []uint32
 	OwnedPrefixes []string
 }
 
@@ -231,6 +308,40 @@ func NewEntityConfigFromJSONFile(fName string) (*EntityConfig, error) {
 	return &ec, nil
 }
 
+// checkShards makes sure a sharded session only names sessions which exist
+// and aren't sharded themselves, and that every pinned collector is placed
+// on one of them.
+func (b *bgpmondConfig) checkShards(name string, s sessionConfig) error {
+	if s.Type != sessionTypeNames[ShardedSession] {
+		return nil
+	}
+	if len(s.Shards) == 0 {
+		return fmt.Errorf("sharded session %s has no shards", name)
+	}
+
+	isShard := make(map[string]bool)
+	for _, sh := range s.Shards {
+		shConf, ok := b.Sessions[sh]
+		if !ok {
+			return fmt.Errorf("shard %s of session %s does not exist", sh, name)
+		}
+		if shConf.Type == sessionTypeNames[ShardedSession] {
+			return fmt.Errorf("shard %s of session %s is sharded itself", sh, name)
+		}
+		isShard[sh] = true
+	}
+
+	for ip, sh := range s.ShardOf {
+		if util.ParseIP(ip) == nil {
+			return fmt.Errorf("malformed collector ip in the shards of session %s:%s", name, ip)
+		}
+		if !isShard[sh] {
+			return fmt.Errorf("collector %s of session %s is placed on unknown shard %s", ip, name, sh)
+		}
+	}
+	return nil
+}
+
 // helper function to sanity check the config file.
 func (b *bgpmondConfig) checkConfig() error {
 	inSlice := false
@@ -248,6 +359,11 @@ func (b *bgpmondConfig) checkConfig() error {
 	if !inSlice {
 		return fmt.Errorf("unknown session type name. Known session types are: %v", sessionTypeNames)
 	}
+	for sn, s := range b.Sessions {
+		if err := b.checkShards(sn, s); err != nil {
+			return err
+		}
+	}
 	//make sure the args optstring parses well
 	for k, v := range b.Modules {
 		opts, err := util.StringToOptMap(v.Args)
@@ -257,13 +373,20 @@ func (b *bgpmondConfig) checkConfig() error {
 		v.optmap = opts
 		b.Modules[k] = v
 	}
+	//nodes are keyed by the canonical form of their IP, which is how
+	//they are stored and looked up by the db package
+	nodes := make(map[string]NodeConfig, len(b.Nodes))
 	for k, v := range b.Nodes {
-		if nip = net.ParseIP(k); nip == nil {
+		if nip = util.ParseIP(k); nip == nil {
 			return fmt.Errorf("malformed ip in config:%s", k)
 		}
-		v.IP = k
-		b.Nodes[k] = v
+		v.IP = util.IPString(nip)
+		if _, ok := nodes[v.IP]; ok {
+			return fmt.Errorf("duplicate node ip in config:%s", k)
+		}
+		nodes[v.IP] = v
 	}
+	b.Nodes = nodes
 
 	return nil
 }
@@ -271,11 +394,22 @@ func (b *bgpmondConfig) checkConfig() error {
 // helper function that can visit the config and replace needed values that might have not
 // been provided by the user to sane defaults
 func (b *bgpmondConfig) populateDefaults() {
+	if b.DrainTimeoutSecs == 0 {
+		b.DrainTimeoutSecs = DefaultDrainTimeoutSecs
+	}
 	for si, s := range b.Sessions {
 		if s.DBTimeoutSecs == 0 {
 			s.DBTimeoutSecs = DefaultDBTimeoutSecs
 			b.Sessions[si] = s
 		}
+		if s.SpoolMaxMB == 0 {
+			s.SpoolMaxMB = DefaultSpoolMaxMB
+			b.Sessions[si] = s
+		}
+		if s.MaxReplicaLag == 0 {
+			s.MaxReplicaLag = DefaultMaxReplicaLagSecs
+			b.Sessions[si] = s
+		}
 	}
 	for mi, m := range b.Modules {
 		if m.Type == "rpc" {
@@ -293,9 +427,9 @@ func (b *bgpmondConfig) populateDefaults() {
 	}
 }
 
-//NewConfig reads a TOML file with the bgpmon configuration, sanity checks it
-//and returns a bgpmondConfig struct which should satisfy the Configer interface,
-//or an error
+// NewConfig reads a TOML file with the bgpmon configuration, sanity checks it
+// and returns a bgpmondConfig struct which should satisfy the Configer interface,
+// or an error
 func NewConfig(creader io.Reader) (Configer, error) {
 	bc := bgpmondConfig{}
 	if _, err := toml.DecodeReader(creader, &bc); err != nil {
@@ -319,6 +453,19 @@ func NewConfig(creader io.Reader) (Configer, error) {
 	return &bc, nil
 }
 
+// SameSessionConfig returns whether two session configurations, usually of
+// the same name in two loaded configurations, have the same settings.
+func SameSessionConfig(a, b SessionConfiger) bool {
+	sa, okA := a.(sessionConfig)
+	sb, okB := b.(sessionConfig)
+	if !okA || !okB {
+		return false
+	}
+	// The parent configurations are expected to differ.
+	sa.Configer, sb.Configer = nil, nil
+	return reflect.DeepEqual(sa, sb)
+}
+
 // SumNodeConfs combines two maps of NodeConfigs, preferring the first in case of overlap
 func SumNodeConfs(confnodes, dbnodes map[string]NodeConfig) map[string]NodeConfig {
 	ret := make(map[string]NodeConfig)
@@ -0,0 +1,389 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+const (
+	// defaultSubscriptionBuffer is how many captures a subscription holds
+	// for its reader if SubscribeOptions doesn't say.
+	defaultSubscriptionBuffer = 1024
+
+	// defaultBlockTimeout is how long a Block subscription holds up the
+	// writer if SubscribeOptions doesn't say.
+	defaultBlockTimeout = time.Second
+
+	// maxPendingPublish is how many captures a write stream holds until
+	// they're committed and published. The ones it writes past that are
+	// dropped for every subscription they match.
+	maxPendingPublish = 64 * defaultSubscriptionBuffer
+)
+
+var (
+	// ErrSlowSubscriber is the error of a Disconnect subscription which was
+	// closed because its buffer filled up.
+	ErrSlowSubscriber = errors.New("subscription closed: reader too slow")
+
+	// ErrSessionClosed is the error of a subscription whose session was
+	// closed.
+	ErrSessionClosed = errors.New("subscription closed: session closed")
+)
+
+// DropPolicy decides what a subscription does with a capture published
+// while its buffer is full.
+type DropPolicy int
+
+// These are the policies a subscription can use when its reader falls behind.
+const (
+	// DropNewest discards the capture being published.
+	DropNewest DropPolicy = iota
+
+	// DropOldest discards the oldest buffered capture to make room.
+	DropOldest
+
+	// Block makes the writer wait for room, for at most the subscription's
+	// BlockTimeout for every committed batch, before discarding the
+	// captures that don't fit. This slows down every write stream of the
+	// session, and should only be used by readers that can keep up.
+	Block
+
+	// Disconnect closes the subscription with ErrSlowSubscriber.
+	Disconnect
+)
+
+// SubscribeOptions configures a subscription to the captures written to a
+// session. The zero value receives every capture, buffers
+// defaultSubscriptionBuffer of them, and drops the newest when full.
+type SubscribeOptions struct {
+	// Filter selects the captures delivered. The collector, origin, peer
+	// AS, family and prefix options are applied as they are to a read. The
+	// time span is only applied if it isn't empty, so live subscriptions can
+	// use a filter made with zero times. A nil filter passes every capture.
+	Filter *CaptureFilterOptions
+
+	Buffer       int
+	Policy       DropPolicy
+	BlockTimeout time.Duration
+}
+
+// Subscription is a ReadStream of the captures committed to a session after
+// it was opened. Read blocks until a capture is published or the
+// subscription is closed, so another goroutine may need to call Close to
+// stop a reader. Data returns a *Capture, which must not be modified, since
+// it's shared with every other subscription. On dedup sessions, captures
+// skipped as duplicates are published too, since a batched insert doesn't
+// report which of its rows were skipped.
+type Subscription struct {
+	dropped uint64 // first, so it's aligned for atomic operations
+
+	bus     *captureBus
+	filter  *CaptureFilterOptions
+	colIPs  map[string]bool // the collector IPs the filter allows, nil for any
+	policy  DropPolicy
+	timeout time.Duration
+
+	ch   chan *Capture
+	done chan struct{}
+	once sync.Once
+	err  error
+	cur  *Capture
+}
+
+// Read waits for the next capture, and returns false once the subscription
+// is closed.
+func (sub *Subscription) Read() bool {
+	// A closed subscription stops at once, even with captures buffered.
+	select {
+	case <-sub.done:
+		return false
+	default:
+	}
+
+	select {
+	case cap := <-sub.ch:
+		sub.cur = cap
+		return true
+	case <-sub.done:
+		return false
+	}
+}
+
+// Data returns the last capture read.
+func (sub *Subscription) Data() interface{} {
+	return sub.cur
+}
+
+// Bytes is unused on a subscription.
+func (sub *Subscription) Bytes() []byte {
+	return nil
+}
+
+// Err returns why the subscription was closed, or nil if it was closed by
+// its reader.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.done:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+// Dropped returns how many captures matching the filter weren't delivered
+// because the subscription's buffer was full, or because a write stream
+// held too many captures to publish them all.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Close stops the subscription. It's safe to call from any goroutine, and
+// more than once.
+func (sub *Subscription) Close() {
+	sub.stop(nil)
+	sub.bus.unsubscribe(sub)
+}
+
+// stop closes the subscription with err, unless it was already closed. It
+// doesn't remove it from the bus, which publish may be iterating over.
+func (sub *Subscription) stop(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+// matches returns whether a capture passes the subscription's filter.
+func (sub *Subscription) matches(cap *Capture) bool {
+	if sub.colIPs != nil && !sub.colIPs[util.IPString(cap.ColIP)] {
+		return false
+	}
+
+	f := sub.filter
+	if f == nil {
+		return true
+	}
+
+	if !f.span.Start.Equal(f.span.End) && (cap.Timestamp.Before(f.span.Start) || !cap.Timestamp.Before(f.span.End)) {
+		return false
+	}
+	if f.origin != -1 && int64(cap.Origin) != f.origin {
+		return false
+	}
+	if f.peerAS != -1 && int64(cap.PeerAS) != f.peerAS {
+		return false
+	}
+	if f.family != AnyFamily && !hasFamily(f.family, cap.Advertised, cap.Withdrawn) {
+		return false
+	}
+	if f.advPrefs != nil && !anyPrefix(cap.Advertised, func(p *net.IPNet) bool { return containsNet(f.advPrefs, p, false) }) {
+		return false
+	}
+	if f.advSubnets != nil && !anyPrefix(cap.Advertised, func(p *net.IPNet) bool { return containsNet(f.advSubnets, p, true) }) {
+		return false
+	}
+	return true
+}
+
+// publish delivers the captures which match the subscription. A Block
+// subscription holds it up for at most its timeout in total.
+func (sub *Subscription) publish(caps []*Capture) {
+	var expired <-chan struct{}
+	if sub.policy == Block {
+		ctx, cancel := context.WithTimeout(context.Background(), sub.timeout)
+		defer cancel()
+		expired = ctx.Done()
+	}
+
+	for _, cap := range caps {
+		if sub.matches(cap) {
+			sub.deliver(cap, expired)
+		}
+	}
+}
+
+// deliver passes a capture to the reader according to the policy. A Block
+// subscription waits for room until expired is closed.
+func (sub *Subscription) deliver(cap *Capture, expired <-chan struct{}) {
+	select {
+	case <-sub.done:
+		return
+	case sub.ch <- cap:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case DropOldest:
+		for {
+			select {
+			case sub.ch <- cap:
+				return
+			default:
+			}
+			select {
+			case <-sub.ch:
+				atomic.AddUint64(&sub.dropped, 1)
+			default:
+			}
+		}
+	case Block:
+		select {
+		case sub.ch <- cap:
+		case <-sub.done:
+		case <-expired:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	default:
+		sub.overflow()
+	}
+}
+
+// overflow records a capture that couldn't be delivered, and closes a
+// Disconnect subscription.
+func (sub *Subscription) overflow() {
+	atomic.AddUint64(&sub.dropped, 1)
+	if sub.policy == Disconnect {
+		sub.stop(ErrSlowSubscriber)
+	}
+}
+
+// hasFamily returns whether any of the prefixes belongs to family f.
+func hasFamily(f AddressFamily, lists ...[]*net.IPNet) bool {
+	for _, l := range lists {
+		for _, p := range l {
+			if util.IsIPv4Net(p) == (f == IPv4Family) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyPrefix returns whether fn is true for any of prefs.
+func anyPrefix(prefs []*net.IPNet, fn func(*net.IPNet) bool) bool {
+	for _, p := range prefs {
+		if fn(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsNet returns whether p is one of nets, or, if subnets is set, is
+// covered by one of them.
+func containsNet(nets []*net.IPNet, p *net.IPNet, subnets bool) bool {
+	pOnes, pBits := p.Mask.Size()
+	for _, n := range nets {
+		nOnes, nBits := n.Mask.Size()
+		if nBits != pBits {
+			continue
+		}
+		if nOnes == pOnes && n.IP.Mask(n.Mask).Equal(p.IP.Mask(p.Mask)) {
+			return true
+		}
+		if subnets && nOnes < pOnes && n.Contains(p.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureBus publishes the captures committed to a session to its
+// subscriptions. The captures of a sharded session are published on a
+// single bus shared by its shards.
+type captureBus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]bool
+	active int32 // the number of subscriptions, read without the lock by writers
+}
+
+func newCaptureBus() *captureBus {
+	return &captureBus{subs: make(map[*Subscription]bool)}
+}
+
+// subscribe adds a subscription with the provided options. colIPs are the
+// collector IPs the filter's collector resolved to, or nil for any.
+func (b *captureBus) subscribe(opts SubscribeOptions, colIPs map[string]bool) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultSubscriptionBuffer
+	}
+	if opts.BlockTimeout <= 0 {
+		opts.BlockTimeout = defaultBlockTimeout
+	}
+
+	sub := &Subscription{
+		bus:     b,
+		filter:  opts.Filter,
+		colIPs:  colIPs,
+		policy:  opts.Policy,
+		timeout: opts.BlockTimeout,
+		ch:      make(chan *Capture, opts.Buffer),
+		done:    make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = true
+	atomic.StoreInt32(&b.active, int32(len(b.subs)))
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *captureBus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	atomic.StoreInt32(&b.active, int32(len(b.subs)))
+	b.mu.Unlock()
+}
+
+// hasSubscribers returns whether anything would be published to, so
+// writers only hold on to their captures when they have to.
+func (b *captureBus) hasSubscribers() bool {
+	return atomic.LoadInt32(&b.active) != 0
+}
+
+// subscribers returns how many subscriptions the bus has.
+func (b *captureBus) subscribers() int {
+	return int(atomic.LoadInt32(&b.active))
+}
+
+// publish delivers committed captures to every subscription they match.
+func (b *captureBus) publish(caps []*Capture) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		sub.publish(caps)
+	}
+}
+
+// drop records a capture which won't be published as dropped by every
+// subscription it matches, whatever their policy.
+func (b *captureBus) drop(cap *Capture) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if sub.matches(cap) {
+			sub.overflow()
+		}
+	}
+}
+
+// close stops every subscription with ErrSessionClosed.
+func (b *captureBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		sub.stop(ErrSessionClosed)
+		delete(b.subs, sub)
+	}
+	atomic.StoreInt32(&b.active, 0)
+}
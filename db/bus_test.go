@@ -0,0 +1,154 @@
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+func busTestCapture(origin uint32, prefix string) *Capture {
+	_, pref, _ := net.ParseCIDR(prefix)
+	return &Capture{
+		Timestamp:  time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC),
+		Origin:     origin,
+		Advertised: []*net.IPNet{pref},
+		ColIP:      util.ParseIP("192.0.2.254"),
+		PeerIP:     util.ParseIP("192.0.2.1"),
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	bus := newCaptureBus()
+
+	fo := NewCaptureFilterOptions(AnyCollector, time.Time{}, time.Time{})
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	fo.AllowSubnets(subnet)
+	fo.SetOrigin(65000)
+	sub := bus.subscribe(SubscribeOptions{Filter: fo}, nil)
+	defer sub.Close()
+
+	tests := []struct {
+		cap     *Capture
+		matches bool
+	}{
+		{busTestCapture(65000, "10.1.0.0/16"), true},
+		{busTestCapture(65000, "10.0.0.0/8"), true},
+		{busTestCapture(65000, "192.168.0.0/16"), false},
+		{busTestCapture(65001, "10.1.0.0/16"), false},
+	}
+	for _, v := range tests {
+		if sub.matches(v.cap) != v.matches {
+			t.Errorf("Capture: %v, Expected match: %t", v.cap.Advertised, v.matches)
+		}
+	}
+
+	// A span which isn't empty is applied.
+	start := time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+	spanned := bus.subscribe(SubscribeOptions{Filter: NewCaptureFilterOptions(AnyCollector, start, start.Add(time.Hour))}, nil)
+	defer spanned.Close()
+	if spanned.matches(busTestCapture(65000, "10.1.0.0/16")) {
+		t.Errorf("Expected a capture outside of the span not to match")
+	}
+
+	other := bus.subscribe(SubscribeOptions{}, map[string]bool{"192.0.2.253": true})
+	defer other.Close()
+	if other.matches(busTestCapture(65000, "10.1.0.0/16")) {
+		t.Errorf("Expected a capture of another collector not to match")
+	}
+}
+
+func TestSubscriptionPolicies(t *testing.T) {
+	bus := newCaptureBus()
+	caps := []*Capture{
+		busTestCapture(1, "10.0.0.0/8"),
+		busTestCapture(2, "10.0.0.0/8"),
+		busTestCapture(3, "10.0.0.0/8"),
+	}
+
+	newest := bus.subscribe(SubscribeOptions{Buffer: 2, Policy: DropNewest}, nil)
+	oldest := bus.subscribe(SubscribeOptions{Buffer: 2, Policy: DropOldest}, nil)
+	slow := bus.subscribe(SubscribeOptions{Buffer: 2, Policy: Disconnect}, nil)
+	if !bus.hasSubscribers() {
+		t.Fatalf("Expected the bus to have subscribers")
+	}
+	bus.publish(caps)
+
+	for _, v := range []struct {
+		name    string
+		sub     *Subscription
+		origins []uint32
+	}{
+		{"DropNewest", newest, []uint32{1, 2}},
+		{"DropOldest", oldest, []uint32{2, 3}},
+	} {
+		for _, o := range v.origins {
+			if !v.sub.Read() || v.sub.Data().(*Capture).Origin != o {
+				t.Errorf("%s: Expected origin %d", v.name, o)
+			}
+		}
+		if v.sub.Dropped() != 1 {
+			t.Errorf("%s: Expected 1 dropped capture, Got: %d", v.name, v.sub.Dropped())
+		}
+	}
+
+	if slow.Read() || slow.Err() != ErrSlowSubscriber {
+		t.Errorf("Expected: %s, Got: %v", ErrSlowSubscriber, slow.Err())
+	}
+	slow.Close()
+
+	newest.Close()
+	if newest.Read() || newest.Err() != nil {
+		t.Errorf("Expected a closed subscription to stop without an error, Got: %v", newest.Err())
+	}
+
+	bus.close()
+	if oldest.Read() || oldest.Err() != ErrSessionClosed {
+		t.Errorf("Expected: %s, Got: %v", ErrSessionClosed, oldest.Err())
+	}
+	if bus.hasSubscribers() {
+		t.Errorf("Expected no subscribers after the bus closed")
+	}
+}
+
+func TestSubscriptionBlockTimeout(t *testing.T) {
+	bus := newCaptureBus()
+	sub := bus.subscribe(SubscribeOptions{Buffer: 1, Policy: Block, BlockTimeout: 50 * time.Millisecond}, nil)
+	defer sub.Close()
+
+	var caps []*Capture
+	for i := 0; i < 5; i++ {
+		caps = append(caps, busTestCapture(uint32(i), "10.0.0.0/8"))
+	}
+
+	// The timeout applies to the whole batch, not to each capture.
+	start := time.Now()
+	bus.publish(caps)
+	if took := time.Since(start); took > 150*time.Millisecond {
+		t.Errorf("Expected publish to be held up for about 50ms, Got: %s", took)
+	}
+	if sub.Dropped() != 4 {
+		t.Errorf("Expected 4 dropped captures, Got: %d", sub.Dropped())
+	}
+}
+
+func TestCaptureBusDrop(t *testing.T) {
+	bus := newCaptureBus()
+	fo := NewCaptureFilterOptions(AnyCollector, time.Time{}, time.Time{})
+	fo.SetOrigin(65000)
+	newest := bus.subscribe(SubscribeOptions{Policy: DropNewest}, nil)
+	defer newest.Close()
+	slow := bus.subscribe(SubscribeOptions{Policy: Disconnect}, nil)
+	defer slow.Close()
+	other := bus.subscribe(SubscribeOptions{Filter: fo}, nil)
+	defer other.Close()
+
+	bus.drop(busTestCapture(1, "10.0.0.0/8"))
+	if newest.Dropped() != 1 || other.Dropped() != 0 {
+		t.Errorf("Expected 1 and 0 dropped captures, Got: %d and %d", newest.Dropped(), other.Dropped())
+	}
+	if slow.Read() || slow.Err() != ErrSlowSubscriber {
+		t.Errorf("Expected: %s, Got: %v", ErrSlowSubscriber, slow.Err())
+	}
+}
@@ -0,0 +1,327 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+// quarantineSchema is the postgres schema quarantined tables of sessions
+// without a namespace are moved to. They are kept there, out of the way of
+// the session, until an operator inspects or drops them.
+const quarantineSchema = "bgpmon_quarantine"
+
+// rebucketSuffix is appended to the name of a table while its captures are
+// moved to tables of a new duration.
+const rebucketSuffix = "_rebucket"
+
+// CatalogProblemKind names an inconsistency between the main table, the node
+// table and the capture tables of a session.
+type CatalogProblemKind string
+
+// These are the kinds of problems CheckCatalog finds.
+const (
+	// ProblemMissingTable is a table registered in the main table which
+	// doesn't exist.
+	ProblemMissingTable = CatalogProblemKind("missing")
+	// ProblemUnregisteredTable is a capture table which isn't registered in
+	// the main table, so it's never read.
+	ProblemUnregisteredTable = CatalogProblemKind("unregistered")
+	// ProblemUnknownCollector is a registered table whose collector isn't
+	// in the node table.
+	ProblemUnknownCollector = CatalogProblemKind("unknown_collector")
+	// ProblemMisaligned is a registered table whose name or window doesn't
+	// match the dump duration of its collector, usually because the
+	// duration was changed.
+	ProblemMisaligned = CatalogProblemKind("misaligned")
+)
+
+// CatalogRepair names the way a catalog problem is repaired.
+type CatalogRepair string
+
+// These are the repairs of RepairCatalogProblem.
+const (
+	// RepairNone means the problem can't be repaired automatically.
+	RepairNone = CatalogRepair("none")
+	// RepairUnregister removes a missing table from the main table.
+	RepairUnregister = CatalogRepair("unregister")
+	// RepairRegister adds an unregistered table to the main table.
+	RepairRegister = CatalogRepair("register")
+	// RepairRebucket moves the captures of a misaligned table to tables
+	// matching the dump duration of its collector, and drops it.
+	RepairRebucket = CatalogRepair("rebucket")
+	// RepairQuarantine moves a table out of the session, and removes it from
+	// the main table.
+	RepairQuarantine = CatalogRepair("quarantine")
+)
+
+// CatalogProblem is a single inconsistency found by CheckCatalog, and the
+// repair that would fix it.
+type CatalogProblem struct {
+	Kind      CatalogProblemKind
+	Repair    CatalogRepair
+	Table     string
+	Collector string
+	// Span is the window the table is, or would be, registered for. It's
+	// empty if that isn't known.
+	Span util.Timespan
+	// Duration is the dump duration of the collector, if it's known.
+	Duration time.Duration
+	Detail   string
+
+	// shard is the shard of a sharded session the problem was found in.
+	shard *Session
+}
+
+// String returns a one line description of the problem.
+func (cp *CatalogProblem) String() string {
+	return fmt.Sprintf("%s table %s: %s (repair: %s)", cp.Kind, cp.Table, cp.Detail, cp.Repair)
+}
+
+// parseTableName splits the name of a capture table, as generated by
+// genTableName, into the name of its collector and the start of its window.
+func parseTableName(name string) (string, time.Time, error) {
+	sep := len(name) - len(tableDateFormat) - 1
+	if sep <= 0 || name[sep] != '_' {
+		return "", time.Time{}, fmt.Errorf("table name %s doesn't end with a date", name)
+	}
+
+	start, err := time.Parse(tableDateFormat, name[sep+1:])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("table name %s doesn't end with a date: %s", name, err)
+	}
+	return name[:sep], start.UTC(), nil
+}
+
+// checkCatalog compares the registered capture tables with the capture
+// tables that exist, and with the dump durations of the nodes, which are
+// keyed by IP. Postgres folds table names to lower case, so names are
+// compared without case. Misaligned tables can only be rebucketed once their
+// window has ended, before now.
+func checkCatalog(registered []*CaptureTable, relations []string, nodes map[string]config.NodeConfig, now time.Time) []*CatalogProblem {
+	var problems []*CatalogProblem
+
+	nodesByName := make(map[string]config.NodeConfig)
+	for _, nc := range nodes {
+		nodesByName[strings.ToLower(nc.Name)] = nc
+	}
+
+	exists := make(map[string]bool)
+	for _, rel := range relations {
+		exists[strings.ToLower(rel)] = true
+	}
+
+	isRegistered := make(map[string]bool)
+	colSpans := make(map[string][]util.Timespan)
+	for _, ct := range registered {
+		isRegistered[strings.ToLower(ct.Name())] = true
+		col := strings.ToLower(ct.Collector())
+		colSpans[col] = append(colSpans[col], ct.Span())
+		prob := &CatalogProblem{Table: ct.Name(), Collector: ct.Collector(), Span: ct.Span()}
+
+		nc, known := nodesByName[strings.ToLower(ct.Collector())]
+		if known {
+			prob.Duration = time.Duration(nc.DumpDurationMinutes) * time.Minute
+		}
+
+		switch {
+		case !exists[strings.ToLower(ct.Name())]:
+			prob.Kind, prob.Repair = ProblemMissingTable, RepairUnregister
+			prob.Detail = "registered, but the table doesn't exist"
+		case !known:
+			prob.Kind, prob.Repair = ProblemUnknownCollector, RepairQuarantine
+			prob.Detail = fmt.Sprintf("collector %s isn't a known node", ct.Collector())
+		case nc.DumpDurationMinutes > 0 && !isAligned(ct, nc):
+			prob.Kind, prob.Repair = ProblemMisaligned, RepairRebucket
+			prob.Detail = fmt.Sprintf("window from %s to %s doesn't match the %s dump duration of %s",
+				ct.Span().Start.Format(time.RFC3339), ct.Span().End.Format(time.RFC3339), prob.Duration, nc.Name)
+			if now.Before(ct.Span().End) {
+				prob.Repair = RepairNone
+				prob.Detail += ", and it can't be rebucketed until its window ends"
+			}
+		default:
+			continue
+		}
+		problems = append(problems, prob)
+	}
+
+	sort.Strings(relations)
+	for _, rel := range relations {
+		if isRegistered[strings.ToLower(rel)] {
+			continue
+		}
+		prob := &CatalogProblem{Kind: ProblemUnregisteredTable, Repair: RepairQuarantine, Table: rel}
+
+		colName, start, err := parseTableName(rel)
+		nc, known := nodesByName[strings.ToLower(colName)]
+		switch {
+		case err != nil:
+			prob.Detail = err.Error()
+		case !known:
+			prob.Collector = colName
+			prob.Detail = fmt.Sprintf("not registered, and collector %s isn't a known node", colName)
+		case nc.DumpDurationMinutes <= 0:
+			prob.Collector = nc.Name
+			prob.Detail = fmt.Sprintf("not registered, and collector %s has no dump duration", nc.Name)
+		default:
+			prob.Collector = nc.Name
+			prob.Duration = time.Duration(nc.DumpDurationMinutes) * time.Minute
+			prob.Span = util.Timespan{Start: start, End: start.Add(prob.Duration)}
+			prob.Detail = "not registered"
+			// Registering a table overlapping another one of the same
+			// collector would make table lookups ambiguous.
+			if overlapsAny(prob.Span, colSpans[strings.ToLower(colName)]) {
+				prob.Detail += ", and it overlaps a registered table"
+			} else {
+				prob.Repair = RepairRegister
+			}
+		}
+		problems = append(problems, prob)
+	}
+
+	return problems
+}
+
+// isAligned returns true if a table's name and window are the ones
+// genTableName and the schema manager give it for its collector.
+func isAligned(ct *CaptureTable, nc config.NodeConfig) bool {
+	dur := time.Duration(nc.DumpDurationMinutes) * time.Minute
+	span := ct.Span()
+
+	return span.Start.Equal(span.Start.Truncate(dur)) && span.End.Equal(span.Start.Add(dur)) &&
+		strings.EqualFold(ct.Name(), genTableName(nc.Name, span.Start, nc.DumpDurationMinutes))
+}
+
+// overlapsAny returns true if span shares any time with one of spans.
+func overlapsAny(span util.Timespan, spans []util.Timespan) bool {
+	for _, other := range spans {
+		if span.Start.Before(other.End) && other.Start.Before(span.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// listCaptureRelations returns the names of every capture table in the
+// database, registered or not.
+func listCaptureRelations(ex SessionExecutor) ([]string, error) {
+	rows, err := ex.Query(ex.getQuery(listCaptureRelationsOp))
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	var names []string
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// listRegisteredTables returns every table registered in the main table,
+// ordered by collector and time.
+func listRegisteredTables(ex SessionExecutor, mainTable string) ([]*CaptureTable, error) {
+	rows, err := ex.Query(fmt.Sprintf(ex.getQuery(listRegisteredTablesOp), mainTable))
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	var tables []*CaptureTable
+	for rows.Next() {
+		ct := &CaptureTable{}
+		if err := ct.Scan(rows); err != nil {
+			return nil, err
+		}
+		tables = append(tables, ct)
+	}
+	return tables, rows.Err()
+}
+
+// rebucketCaptureTable moves the captures of a table to tables of the
+// provided duration, registering and indexing any it creates, then drops it.
+// It should be run in a transaction, so a failure leaves the table as it
+// was. Captures outside of the table's window are moved as well.
+func rebucketCaptureTable(ex SessionExecutor, mainTable string, prob *CatalogProblem, indexes []string) error {
+	if prob.Duration <= 0 {
+		return fmt.Errorf("no dump duration to rebucket table %s to", prob.Table)
+	}
+	durMins := int(prob.Duration / time.Minute)
+
+	name := util.SanitizeDBString(prob.Table)
+	tmpName := name + rebucketSuffix
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(unregisterTableOp), mainTable), name); err != nil {
+		return err
+	}
+	// The table is renamed first, in case one of the new tables has its name.
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(renameTableOp), name, tmpName)); err != nil {
+		return err
+	}
+
+	buckets, err := captureBuckets(ex, tmpName, prob.Duration)
+	if err != nil {
+		return err
+	}
+
+	for _, start := range buckets {
+		end := start.Add(prob.Duration)
+		newName := util.SanitizeDBString(genTableName(prob.Collector, start, durMins))
+
+		if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(makeCaptureTableOp), newName)); err != nil {
+			return err
+		}
+		if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(registerTableOp), mainTable), newName, prob.Collector, start, end); err != nil {
+			return err
+		}
+		if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(copyCapturesOp), newName, tmpName), start, end); err != nil {
+			return err
+		}
+		if err := createCaptureIndexes(ex, newName, indexes); err != nil {
+			return err
+		}
+	}
+
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(dropCaptureTableOp), mainTable, tmpName)); err != nil {
+		return err
+	}
+	dbLogger.Infof("rebucketed table %s into %d tables of %s", name, len(buckets), prob.Duration)
+	return nil
+}
+
+// captureBuckets returns the start of every window of duration dur, aligned
+// like genTableName aligns them, that holds a capture of table. Durations
+// are whole minutes, so the distinct minutes of the captures are enough.
+func captureBuckets(ex SessionExecutor, table string, dur time.Duration) ([]time.Time, error) {
+	rows, err := ex.Query(fmt.Sprintf(ex.getQuery(captureMinutesOp), table))
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	seen := make(map[time.Time]bool)
+	var buckets []time.Time
+	for rows.Next() {
+		var minute time.Time
+		if err := rows.Scan(&minute); err != nil {
+			return nil, err
+		}
+
+		// Timestamps are stored without a time zone, in UTC.
+		start := minute.UTC().Truncate(dur)
+		if !seen[start] {
+			seen[start] = true
+			buckets = append(buckets, start)
+		}
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+	return buckets, rows.Err()
+}
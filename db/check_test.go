@@ -0,0 +1,234 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+func TestParseTableName(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 30, 0, 0, time.UTC)
+
+	col, date, err := parseTableName(genTableName("route_views2", start, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col != "route_views2" || !date.Equal(start) {
+		t.Fatalf("Expected: route_views2 %s, Got: %s %s", start, col, date)
+	}
+
+	for _, name := range []string{"dbs", "col_2019_06_01", "col_2019_13_01_00_00_00"} {
+		if _, _, err := parseTableName(name); err == nil {
+			t.Errorf("Expected error parsing table name %s", name)
+		}
+	}
+}
+
+func TestCheckCatalog(t *testing.T) {
+	day := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(36 * time.Hour)
+
+	nodes := map[string]config.NodeConfig{
+		"10.0.0.1": {Name: "Hourly", IP: "10.0.0.1", IsCollector: true, DumpDurationMinutes: 60},
+		"10.0.0.2": {Name: "daily", IP: "10.0.0.2", IsCollector: true, DumpDurationMinutes: 1440},
+	}
+
+	table := func(col string, start time.Time, durMins int) *CaptureTable {
+		dur := time.Duration(durMins) * time.Minute
+		return &CaptureTable{
+			name:      genTableName(col, start, durMins),
+			collector: col,
+			span:      util.Timespan{Start: start, End: start.Add(dur)},
+		}
+	}
+
+	registered := []*CaptureTable{
+		// Fine, even though postgres folded the name of the table.
+		table("Hourly", day, 60),
+		// Registered with a day, but the collector now dumps every hour.
+		table("Hourly", day.Add(-24*time.Hour), 1440),
+		// Still open, so it can't be rebucketed yet.
+		table("Hourly", day.Add(24*time.Hour), 1440),
+		table("daily", day, 1440),
+		// The node of this collector was removed.
+		table("retired", day, 1440),
+		// The table was dropped, but not removed from the main table.
+		table("daily", day.Add(24*time.Hour), 1440),
+	}
+	relations := []string{
+		"hourly_2019_06_01_00_00_00",
+		"hourly_2019_05_31_00_00_00",
+		"hourly_2019_06_02_00_00_00",
+		"daily_2019_06_01_00_00_00",
+		"retired_2019_06_01_00_00_00",
+		// Unregistered, with a known collector and a free window.
+		"daily_2019_05_31_00_00_00",
+		// Unregistered, overlapping a registered table.
+		"hourly_2019_06_01_00_30_00",
+		"unknown_2019_06_01_00_00_00",
+		"leftovers",
+	}
+
+	expected := map[string]struct {
+		kind   CatalogProblemKind
+		repair CatalogRepair
+	}{
+		"Hourly_2019_05_31_00_00_00":  {ProblemMisaligned, RepairRebucket},
+		"Hourly_2019_06_02_00_00_00":  {ProblemMisaligned, RepairNone},
+		"retired_2019_06_01_00_00_00": {ProblemUnknownCollector, RepairQuarantine},
+		"daily_2019_06_02_00_00_00":   {ProblemMissingTable, RepairUnregister},
+		"daily_2019_05_31_00_00_00":   {ProblemUnregisteredTable, RepairRegister},
+		"hourly_2019_06_01_00_30_00":  {ProblemUnregisteredTable, RepairQuarantine},
+		"unknown_2019_06_01_00_00_00": {ProblemUnregisteredTable, RepairQuarantine},
+		"leftovers":                   {ProblemUnregisteredTable, RepairQuarantine},
+	}
+
+	problems := checkCatalog(registered, relations, nodes, now)
+	if len(problems) != len(expected) {
+		t.Errorf("Expected %d problems, Got: %d", len(expected), len(problems))
+	}
+
+	for _, p := range problems {
+		exp, ok := expected[p.Table]
+		if !ok {
+			t.Errorf("Unexpected problem: %s", p)
+			continue
+		}
+		if p.Kind != exp.kind || p.Repair != exp.repair {
+			t.Errorf("Table: %s, Expected: %s %s, Got: %s %s", p.Table, exp.kind, exp.repair, p.Kind, p.Repair)
+		}
+	}
+
+	for _, p := range problems {
+		if p.Table == "daily_2019_05_31_00_00_00" {
+			if p.Collector != "daily" || !p.Span.Start.Equal(day.Add(-24*time.Hour)) || !p.Span.End.Equal(day) {
+				t.Errorf("Expected the table to be registered to daily for 2019-05-31, Got: %s %+v", p.Collector, p.Span)
+			}
+		}
+	}
+}
+
+func TestRepairUnregisteredTable(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := genTableName("testcollector6", time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC), 1440)
+	if _, err := session.db.Exec("DELETE FROM dbs WHERE dbname = $1", name); err != nil {
+		t.Fatal(err)
+	}
+
+	findProblem := func() *CatalogProblem {
+		problems, err := session.CheckCatalog()
+		if err != nil {
+			t.Fatalf("Error checking catalog: %s", err)
+		}
+		for _, p := range problems {
+			if p.Table == name {
+				return p
+			}
+		}
+		return nil
+	}
+
+	prob := findProblem()
+	if prob == nil || prob.Kind != ProblemUnregisteredTable || prob.Repair != RepairRegister {
+		t.Fatalf("Expected table %s to be unregistered, Got: %v", name, prob)
+	}
+
+	if err := session.RepairCatalogProblem(prob); err != nil {
+		t.Fatalf("Error repairing catalog: %s", err)
+	}
+	if prob := findProblem(); prob != nil {
+		t.Fatalf("Expected the table to be registered, Got: %s", prob)
+	}
+}
+
+func TestRebucketCaptureTable(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := session.ListNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, ok := nodes["2001:db8::1"]
+	if !ok {
+		t.Fatalf("Expected the test collector to be a node")
+	}
+
+	// Every capture of the sample file is in the first hour of the day, so
+	// the day table is moved to an hourly table with the same name, and back.
+	day := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	before, err := session.CaptureTableStats(nc.Name, day, day.Add(24*time.Hour))
+	if err != nil || len(before) != 1 {
+		t.Fatalf("Expected one table, Got: %d %v", len(before), err)
+	}
+
+	for _, durMins := range []int{60, 1440} {
+		nc.DumpDurationMinutes = durMins
+		if err := session.UpdateNode(nc); err != nil {
+			t.Fatal(err)
+		}
+
+		problems, err := session.CheckCatalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			if p.Kind == ProblemMisaligned && p.Collector == nc.Name && p.Repair == RepairRebucket {
+				if err := session.RepairCatalogProblem(p); err != nil {
+					t.Fatalf("Error rebucketing %s: %s", p.Table, err)
+				}
+			}
+		}
+
+		after, err := session.CaptureTableStats(nc.Name, day, day.Add(24*time.Hour))
+		if err != nil || len(after) != 1 {
+			t.Fatalf("Expected one table, Got: %d %v", len(after), err)
+		}
+
+		dur := time.Duration(durMins) * time.Minute
+		if span := after[0].Span(); !span.Start.Equal(day) || !span.End.Equal(day.Add(dur)) {
+			t.Errorf("Expected a table of %s, Got: %+v", dur, span)
+		}
+		if after[0].Captures != before[0].Captures {
+			t.Errorf("Expected %d captures, Got: %d", before[0].Captures, after[0].Captures)
+		}
+	}
+}
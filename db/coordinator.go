@@ -0,0 +1,74 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// catalogChannel is the postgres notification channel sessions announce
+	// catalog changes on. Namespaced sessions use their own channel.
+	catalogChannel = "bgpmon_catalog"
+
+	// catalogPingInterval is how often an idle catalog listener checks its
+	// connection, so a dropped connection is noticed before a change is
+	// missed for long.
+	catalogPingInterval = time.Minute
+)
+
+// catalogCoordinator keeps the catalog cache of a session coherent with the
+// other sessions, in this process or another, writing to the same database.
+// Capture tables are created under an advisory lock on their collector, and
+// changes which invalidate cached tables or nodes are announced with NOTIFY.
+type catalogCoordinator struct {
+	tdb     TimeoutDBer
+	dbo     queryProvider
+	uuid    string
+	channel string
+}
+
+// newCatalogCoordinator returns a coordinator for the session uuid, which
+// announces changes on the channel of its namespace.
+func newCatalogCoordinator(tdb TimeoutDBer, dbo queryProvider, uuid, namespace string) *catalogCoordinator {
+	return &catalogCoordinator{tdb: tdb, dbo: dbo, uuid: uuid, channel: catalogChannelOf(namespace)}
+}
+
+// catalogChannelOf returns the notification channel of a namespace.
+func catalogChannelOf(namespace string) string {
+	if namespace == "" {
+		return catalogChannel
+	}
+	return catalogChannel + "_" + namespace
+}
+
+// notify tells every other listening session to drop its cached tables and
+// nodes. Failing to notify isn't fatal to the change itself, so it's only
+// logged.
+func (c *catalogCoordinator) notify() {
+	if _, err := c.tdb.DB().Exec(c.dbo.getQuery(notifyCatalogOp), c.channel, c.uuid); err != nil {
+		dbLogger.Errorf("Error notifying catalog change on %s: %s", c.channel, err)
+	}
+}
+
+// listen opens a listener on the coordinator's channel, using its own
+// connection to the database.
+func (c *catalogCoordinator) listen(constr string) (*pq.Listener, error) {
+	l := pq.NewListener(constr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			dbLogger.Errorf("Catalog listener on %s: %s", c.channel, err)
+		}
+	})
+	if err := l.Listen(c.channel); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// isRemote returns whether a notification was sent by another session. A
+// nil notification is sent after the listener reconnects, when changes may
+// have been missed, so it counts as remote too.
+func (c *catalogCoordinator) isRemote(n *pq.Notification) bool {
+	return n == nil || n.Extra != c.uuid
+}
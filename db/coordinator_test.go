@@ -0,0 +1,27 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestCatalogCoordinator(t *testing.T) {
+	if c := catalogChannelOf(""); c != catalogChannel {
+		t.Errorf("Expected: %s, Got: %s", catalogChannel, c)
+	}
+	if c := catalogChannelOf("lab"); c != "bgpmon_catalog_lab" {
+		t.Errorf("Expected: bgpmon_catalog_lab, Got: %s", c)
+	}
+
+	coord := newCatalogCoordinator(nil, newPostgressQueryProvider(), "sess1", "")
+	if coord.isRemote(&pq.Notification{Channel: coord.channel, Extra: "sess1"}) {
+		t.Errorf("Expected a session's own notification not to be remote")
+	}
+	if !coord.isRemote(&pq.Notification{Channel: coord.channel, Extra: "sess2"}) {
+		t.Errorf("Expected another session's notification to be remote")
+	}
+	if !coord.isRemote(nil) {
+		t.Errorf("Expected a reconnection to be treated as a remote change")
+	}
+}
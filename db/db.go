@@ -6,9 +6,12 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"net"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/CSUNetSec/bgpmon/util"
@@ -22,6 +25,39 @@ const (
 	defaultEntityTable = "entities"
 )
 
+// entityHistorySuffix is appended to the name of an entity table to get the
+// name of the table recording every change made to it.
+const entityHistorySuffix = "_history"
+
+// rollupSuffix is appended to the name of the main table to get the name of
+// the table holding capture summaries.
+const rollupSuffix = "_rollups"
+
+// peerEventSuffix is appended to the name of the main table to get the name
+// of the table holding peer events.
+const peerEventSuffix = "_peer_events"
+
+// migrationSuffix is appended to the name of the main table to get the name
+// of the table recording which migrations were applied to which tables.
+const migrationSuffix = "_migrations"
+
+// tableDateFormat is the format of the start of a capture table's window
+// in its name.
+const tableDateFormat = "2006_01_02_15_04_05"
+
+// captureIndexMethods holds the capture table columns that can be indexed,
+// and the index method used for each. The array columns use GIN, so they
+// can be searched by their elements.
+var captureIndexMethods = map[string]string{
+	"timestamp":    "btree",
+	"peer_ip":      "btree",
+	"peer_as":      "btree",
+	"origin_as":    "btree",
+	"as_path":      "gin",
+	"adv_prefixes": "gin",
+	"wdr_prefixes": "gin",
+}
+
 // This block holds the currently supported database backends.
 const (
 	postgres = iota
@@ -36,6 +72,9 @@ const (
 	checkSchemaOp
 	selectNodeOp
 	insertNodeOp
+	addNodeOp
+	updateNodeOp
+	deleteNodeOp
 	insertMainTableOp
 	makeMainTableOp
 	selectTableOp
@@ -43,11 +82,58 @@ const (
 	makeCaptureTableOp
 	insertCaptureTableOp
 	getCaptureTablesOp
+	getCaptureTableByNameOp
 	getCaptureBinaryOp
+	getOrderedCapturesOp
 	getPrefixOp
 	makeEntityTableOp
 	insertEntityOp
 	getEntityOp
+	deleteEntityOp
+	makeEntityHistoryTableOp
+	insertEntityHistoryOp
+	getEntityHistoryOp
+	listCaptureTablesOp
+	addPeerASColumnOp
+	widenCaptureASNOp
+	widenEntityASNOp
+	listCaptureTableInfoOp
+	dropCaptureTableOp
+	getCaptureTableInfoOp
+	makeRollupTableOp
+	rollupSourceOp
+	rollupCapturesOp
+	getRollupOp
+	makeCaptureIndexOp
+	captureTableStatsOp
+	coverageSourceOp
+	captureCoverageOp
+	listRegisteredTablesOp
+	listCaptureRelationsOp
+	registerTableOp
+	unregisterTableOp
+	quarantineTableOp
+	captureMinutesOp
+	renameTableOp
+	copyCapturesOp
+	replicaLagOp
+	makeNamespaceOp
+	lockCollectorOp
+	notifyCatalogOp
+	insertDedupCaptureOp
+	skipDuplicateCapturesOp
+	addContentHashColumnOp
+	indexContentHashOp
+	invalidIndexOp
+	dropIndexOp
+	makePeerEventTableOp
+	makePeerEventIndexOp
+	insertPeerEventOp
+	uniquePeerEventsOp
+	getPeerEventsOp
+	makeMigrationTableOp
+	listMigrationsOp
+	recordMigrationOp
 )
 
 // dbOps associates every generic database operation with an array that holds the correct SQL statements
@@ -66,7 +152,7 @@ var dbOps = map[dbOp][]string{
 		`SELECT EXISTS (
 		   SELECT *
 		   FROM   information_schema.tables
-		   WHERE  table_name = $1
+		   WHERE  table_name = $1 AND table_schema = current_schema()
 		 );`,
 	},
 	selectNodeOp: {
@@ -81,6 +167,20 @@ var dbOps = map[dbOp][]string{
 		     tableDumpDurationMinutes=EXCLUDED.tableDumpDurationMinutes,
 		     description=EXCLUDED.description, coords=EXCLUDED.coords, address=EXCLUDED.address;`,
 	},
+	addNodeOp: {
+		// postgres
+		`INSERT INTO %s (name, ip, isCollector, tableDumpDurationMinutes, description, coords, address)
+		   VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+	},
+	updateNodeOp: {
+		// postgres
+		`UPDATE %s SET name=$1, isCollector=$3, tableDumpDurationMinutes=$4, description=$5, coords=$6, address=$7
+		   WHERE ip=$2;`,
+	},
+	deleteNodeOp: {
+		// postgres
+		`DELETE FROM %s WHERE ($1 <> '' AND name=$1) OR ($2 <> '' AND ip=$2);`,
+	},
 	makeMainTableOp: {
 		// postgres
 		`CREATE TABLE IF NOT EXISTS %s (
@@ -92,7 +192,8 @@ var dbOps = map[dbOp][]string{
 	},
 	insertMainTableOp: {
 		// postgres
-		`INSERT INTO %s (dbname, collector, dateFrom, dateTo) VALUES ($1, $2, $3, $4);`,
+		// Another session may have registered the same table first.
+		`INSERT INTO %s (dbname, collector, dateFrom, dateTo) VALUES ($1, $2, $3, $4) ON CONFLICT (dbname) DO NOTHING;`,
 	},
 	makeCaptureTableOp: {
 		// postgres
@@ -101,22 +202,37 @@ var dbOps = map[dbOp][]string{
 		   timestamp timestamp NOT NULL,
 		   collector_ip inet NOT NULL, 
 		   peer_ip inet NOT NULL, 
-		   as_path integer[] DEFAULT '{}'::integer[],
+		   peer_as bigint DEFAULT '0'::bigint,
+		   as_path bigint[] DEFAULT '{}'::bigint[],
 		   next_hop inet DEFAULT '0.0.0.0'::inet,
-		   origin_as integer DEFAULT '0'::integer,
+		   origin_as bigint DEFAULT '0'::bigint,
 		   adv_prefixes cidr[] DEFAULT '{}'::cidr[],
-		   wdr_prefixes cidr[] DEFAULT '{}'::cidr[]
+		   wdr_prefixes cidr[] DEFAULT '{}'::cidr[],
+		   content_hash bytea UNIQUE
 		   );`,
 	},
 	// This template shouldn't need VALUES, because those will be provided by the buffer
 	insertCaptureTableOp: {
 		// postgres
-		`INSERT INTO %s (timestamp, collector_ip, peer_ip, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes) VALUES `,
+		`INSERT INTO %s (timestamp, collector_ip, peer_ip, peer_as, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes) VALUES `,
+	},
+	// Like insertCaptureTableOp, followed by skipDuplicateCapturesOp once the
+	// buffer added the VALUES.
+	insertDedupCaptureOp: {
+		// postgres
+		`INSERT INTO %s (timestamp, collector_ip, peer_ip, peer_as, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes, content_hash) VALUES `,
 	},
+	skipDuplicateCapturesOp: {
+		// postgres
+		`ON CONFLICT (content_hash) DO NOTHING`,
+	},
+	// While tables of an old and a new dump duration overlap, the one matching
+	// the current duration of the node is preferred.
 	selectTableOp: {
 		// postgres
 		`SELECT d.dbname, d.collector, d.dateFrom, d.dateTo, n.tableDumpDurationMinutes FROM %s d,%s n
-                WHERE d.dateFrom <= $1 AND d.dateTo > $1 AND n.ip = $2 AND n.name = d.collector;`,
+                WHERE d.dateFrom <= $1 AND d.dateTo > $1 AND n.ip = $2 AND n.name = d.collector
+                ORDER BY d.dateTo - d.dateFrom = n.tableDumpDurationMinutes * interval '1 minute' DESC, d.dateFrom DESC;`,
 	},
 	makeNodeTableOp: {
 		// postgres
@@ -132,11 +248,21 @@ var dbOps = map[dbOp][]string{
 	},
 	getCaptureTablesOp: {
 		// postgres
-		`SELECT dbname FROM %s WHERE collector LIKE '%s' AND datefrom>='%s' AND dateto<'%s';`,
+		`SELECT dbname FROM %s WHERE collector LIKE $1 AND datefrom < $2 AND dateto > $3 ORDER BY datefrom;`,
+	},
+	getCaptureTableByNameOp: {
+		// postgres
+		`SELECT dbname FROM %s WHERE dbname = $1;`,
 	},
 	getCaptureBinaryOp: {
 		// postgres
-		`SELECT DISTINCT(update_id), timestamp, collector_ip, peer_ip, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes FROM %s %s;`,
+		`SELECT DISTINCT(update_id), timestamp, collector_ip, peer_ip, peer_as, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes FROM %s %s`,
+	},
+	// The captures of every table, selected by getCaptureBinaryOp and joined
+	// with UNION ALL, in time order.
+	getOrderedCapturesOp: {
+		// postgres
+		`SELECT * FROM (%s) AS caps ORDER BY timestamp;`,
 	},
 	getPrefixOp: {
 		// postgres
@@ -147,7 +273,7 @@ var dbOps = map[dbOp][]string{
 		`CREATE TABLE IF NOT EXISTS %s (
 			name varchar PRIMARY KEY,
 			email varchar,
-			knownOrigins integer[] DEFAULT '{}'::integer[],
+			knownOrigins bigint[] DEFAULT '{}'::bigint[],
 			ownedPrefixes cidr[] DEFAULT '{}'::cidr[]
 		);`,
 	},
@@ -161,6 +287,296 @@ var dbOps = map[dbOp][]string{
 		// postgres
 		`SELECT name, email, knownorigins, ownedprefixes FROM %s %s;`,
 	},
+	deleteEntityOp: {
+		// postgres
+		`DELETE FROM %s WHERE name=$1 RETURNING name, email, knownorigins, ownedprefixes;`,
+	},
+	makeEntityHistoryTableOp: {
+		// postgres
+		`CREATE TABLE IF NOT EXISTS %s (
+			change_id BIGSERIAL PRIMARY KEY NOT NULL,
+			name varchar NOT NULL,
+			action varchar NOT NULL,
+			changed timestamp NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+			email varchar,
+			knownOrigins bigint[] DEFAULT '{}'::bigint[],
+			ownedPrefixes cidr[] DEFAULT '{}'::cidr[]
+		);`,
+	},
+	insertEntityHistoryOp: {
+		// postgres
+		`INSERT INTO %s (name, email, knownorigins, ownedprefixes, action) VALUES ($1, $2, $3, $4, $5);`,
+	},
+	getEntityHistoryOp: {
+		// postgres
+		`SELECT name, email, knownorigins, ownedprefixes, action, changed FROM %s %s ORDER BY change_id;`,
+	},
+	listCaptureTablesOp: {
+		// postgres
+		`SELECT dbname FROM %s;`,
+	},
+	addPeerASColumnOp: {
+		// postgres
+		`ALTER TABLE %s ADD COLUMN IF NOT EXISTS peer_as bigint DEFAULT '0'::bigint;`,
+	},
+	// Captures written without deduplication have no hash, and NULLs never
+	// conflict, so existing rows don't need one. Adding a column without a
+	// default doesn't rewrite the table, and its unique index is built by
+	// indexContentHashOp.
+	addContentHashColumnOp: {
+		// postgres
+		`ALTER TABLE %s ADD COLUMN IF NOT EXISTS content_hash bytea;`,
+	},
+	// The index is named like the constraint makeCaptureTableOp creates, so
+	// new tables are skipped. It's built without blocking writes, which can't
+	// be done in a transaction.
+	indexContentHashOp: {
+		// postgres
+		`CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS %[1]s_content_hash_key ON %[1]s (content_hash);`,
+	},
+	// A concurrent index build that fails leaves an invalid index behind.
+	invalidIndexOp: {
+		// postgres
+		`SELECT EXISTS (SELECT 1 FROM pg_index WHERE indexrelid = to_regclass($1) AND NOT indisvalid);`,
+	},
+	dropIndexOp: {
+		// postgres
+		`DROP INDEX CONCURRENTLY IF EXISTS %s;`,
+	},
+	widenCaptureASNOp: {
+		// postgres
+		`ALTER TABLE %s ALTER COLUMN peer_as TYPE bigint, ALTER COLUMN as_path TYPE bigint[],
+		ALTER COLUMN origin_as TYPE bigint;`,
+	},
+	widenEntityASNOp: {
+		// postgres
+		`ALTER TABLE %s ALTER COLUMN knownOrigins TYPE bigint[];`,
+	},
+	listCaptureTableInfoOp: {
+		// postgres
+		`SELECT dbname, collector, dateFrom, dateTo FROM %s WHERE collector LIKE $1 AND dateTo <= $2 ORDER BY dateFrom;`,
+	},
+	// The first argument is the main table, the second the capture table. Both
+	// statements are sent as one query, which postgres runs in a single transaction.
+	dropCaptureTableOp: {
+		// postgres
+		`DROP TABLE IF EXISTS %[2]s; DELETE FROM %[1]s WHERE dbname = '%[2]s';`,
+	},
+	getCaptureTableInfoOp: {
+		// postgres
+		`SELECT dbname, collector, dateFrom, dateTo FROM %s WHERE collector LIKE $1 AND dateFrom < $2 AND dateTo > $3 ORDER BY dateFrom;`,
+	},
+	makeRollupTableOp: {
+		// postgres
+		`CREATE TABLE IF NOT EXISTS %s (
+		   collector varchar NOT NULL,
+		   granularity varchar NOT NULL,
+		   bucket timestamp NOT NULL,
+		   kind varchar NOT NULL,
+		   key varchar NOT NULL,
+		   updates bigint NOT NULL,
+		   announcements bigint NOT NULL,
+		   withdrawals bigint NOT NULL,
+		   unique_paths bigint NOT NULL,
+		   PRIMARY KEY (collector, granularity, bucket, kind, key)
+		   );`,
+	},
+	makePeerEventTableOp: {
+		// postgres
+		`CREATE TABLE IF NOT EXISTS %s (
+		   event_id BIGSERIAL PRIMARY KEY NOT NULL,
+		   timestamp timestamp NOT NULL,
+		   type varchar NOT NULL,
+		   collector_ip inet NOT NULL,
+		   peer_ip inet NOT NULL,
+		   peer_as bigint DEFAULT '0'::bigint,
+		   old_state varchar DEFAULT '',
+		   new_state varchar DEFAULT '',
+		   error_code smallint DEFAULT 0,
+		   error_subcode smallint DEFAULT 0,
+		   hold_time integer DEFAULT 0,
+		   gap_secs double precision DEFAULT 0
+		   );`,
+	},
+	makePeerEventIndexOp: {
+		// postgres
+		`CREATE INDEX IF NOT EXISTS %[1]s_collector_time_idx ON %[1]s (collector_ip, timestamp);`,
+	},
+	// Events are only unique once uniquePeerEventsOp was applied, so no
+	// conflict target is given.
+	insertPeerEventOp: {
+		// postgres
+		`INSERT INTO %s (timestamp, type, collector_ip, peer_ip, peer_as, old_state, new_state, error_code, error_subcode, hold_time, gap_secs)
+		   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT DO NOTHING;`,
+	},
+	// Writing the same MRT file again writes the same events, which are
+	// skipped once this index exists.
+	uniquePeerEventsOp: {
+		// postgres
+		`CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_event_key ON %[1]s
+		   (collector_ip, peer_ip, timestamp, type, old_state, new_state, error_code, error_subcode, hold_time);`,
+	},
+	getPeerEventsOp: {
+		// postgres
+		`SELECT timestamp, type, collector_ip, peer_ip, peer_as, old_state, new_state, error_code, error_subcode, hold_time, gap_secs
+		   FROM %s %s ORDER BY timestamp, event_id;`,
+	},
+	makeMigrationTableOp: {
+		// postgres
+		`CREATE TABLE IF NOT EXISTS %s (
+		   tablename varchar NOT NULL,
+		   migration varchar NOT NULL,
+		   applied timestamp NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+		   PRIMARY KEY (tablename, migration)
+		 );`,
+	},
+	listMigrationsOp: {
+		// postgres
+		`SELECT tablename, migration FROM %s;`,
+	},
+	// Another session may have applied the same migration first.
+	recordMigrationOp: {
+		// postgres
+		`INSERT INTO %s (tablename, migration) VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+	},
+	// The rows of one capture table that rollupCapturesOp summarizes. The
+	// rows of every table overlapping the window are joined with UNION ALL.
+	rollupSourceOp: {
+		// postgres
+		`SELECT timestamp, peer_ip, origin_as, as_path, adv_prefixes, wdr_prefixes FROM %s
+		   WHERE timestamp >= $3 AND timestamp < $4`,
+	},
+	// The first argument is the rollup table, the second the rows to summarize.
+	// $1 is the collector, $2 the granularity, as accepted by date_trunc, and
+	// $3 and $4 the window. Withdrawals don't carry a path or an origin, so
+	// they aren't counted as unique paths or under any origin.
+	rollupCapturesOp: {
+		// postgres
+		`WITH caps AS (%[2]s),
+		 prefs AS (
+		   SELECT timestamp, as_path, unnest(adv_prefixes) AS prefix, true AS adv FROM caps
+		   UNION ALL
+		   SELECT timestamp, as_path, unnest(wdr_prefixes) AS prefix, false AS adv FROM caps
+		 )
+		 INSERT INTO %[1]s (collector, granularity, bucket, kind, key, updates, announcements, withdrawals, unique_paths)
+		   SELECT $1::varchar, $2::varchar, date_trunc($2::text, timestamp), 'total', '', COUNT(*),
+		     COALESCE(SUM(cardinality(adv_prefixes)), 0), COALESCE(SUM(cardinality(wdr_prefixes)), 0),
+		     COUNT(DISTINCT as_path) FILTER (WHERE cardinality(adv_prefixes) > 0)
+		   FROM caps GROUP BY 3
+		 UNION ALL
+		   SELECT $1::varchar, $2::varchar, date_trunc($2::text, timestamp), 'peer', host(peer_ip), COUNT(*),
+		     COALESCE(SUM(cardinality(adv_prefixes)), 0), COALESCE(SUM(cardinality(wdr_prefixes)), 0),
+		     COUNT(DISTINCT as_path) FILTER (WHERE cardinality(adv_prefixes) > 0)
+		   FROM caps GROUP BY 3, 5
+		 UNION ALL
+		   SELECT $1::varchar, $2::varchar, date_trunc($2::text, timestamp), 'origin', origin_as::text, COUNT(*),
+		     COALESCE(SUM(cardinality(adv_prefixes)), 0), 0, COUNT(DISTINCT as_path)
+		   FROM caps WHERE cardinality(adv_prefixes) > 0 GROUP BY 3, 5
+		 UNION ALL
+		   SELECT $1::varchar, $2::varchar, date_trunc($2::text, timestamp), 'prefix', prefix::text, COUNT(*),
+		     COUNT(*) FILTER (WHERE adv), COUNT(*) FILTER (WHERE NOT adv),
+		     COUNT(DISTINCT as_path) FILTER (WHERE adv)
+		   FROM prefs GROUP BY 3, 5
+		 ON CONFLICT (collector, granularity, bucket, kind, key) DO UPDATE SET updates=EXCLUDED.updates,
+		   announcements=EXCLUDED.announcements, withdrawals=EXCLUDED.withdrawals, unique_paths=EXCLUDED.unique_paths;`,
+	},
+	getRollupOp: {
+		// postgres
+		`SELECT collector, granularity, bucket, kind, key, updates, announcements, withdrawals, unique_paths
+		   FROM %s %s ORDER BY bucket, kind, key;`,
+	},
+	// The first argument is the capture table, the second the column, the
+	// third the index method, from captureIndexMethods, and the fourth the
+	// index name, from captureIndexName.
+	makeCaptureIndexOp: {
+		// postgres
+		`CREATE INDEX IF NOT EXISTS %[4]s ON %[1]s USING %[3]s (%[2]s);`,
+	},
+	// The argument is the capture table. Only the hours before $1 are counted,
+	// so an hour that hasn't ended isn't reported as a gap.
+	captureTableStatsOp: {
+		// postgres
+		`SELECT COUNT(*), COUNT(DISTINCT date_trunc('hour', timestamp)) FILTER (WHERE timestamp < $1),
+		   pg_total_relation_size('%[1]s') FROM %[1]s;`,
+	},
+	// The rows of one capture table that captureCoverageOp counts. The rows of
+	// every table overlapping the window are joined with UNION ALL.
+	coverageSourceOp: {
+		// postgres
+		`SELECT timestamp FROM %s WHERE timestamp >= $2 AND timestamp < $3`,
+	},
+	// The argument is the rows to count. $1 is the length of the buckets, as
+	// accepted by date_trunc, and $2 and $3 the window.
+	captureCoverageOp: {
+		// postgres
+		`SELECT date_trunc($1::text, timestamp) AS bucket, COUNT(*) FROM (%s) AS caps
+		   GROUP BY bucket ORDER BY bucket;`,
+	},
+	listRegisteredTablesOp: {
+		// postgres
+		`SELECT dbname, collector, dateFrom, dateTo FROM %s ORDER BY collector, dateFrom;`,
+	},
+	// Every capture table has an adv_prefixes column, and no other table
+	// does. Tables in other schemas, like the quarantine, are ignored.
+	listCaptureRelationsOp: {
+		// postgres
+		`SELECT table_name FROM information_schema.columns
+		   WHERE table_schema = current_schema() AND column_name = 'adv_prefixes';`,
+	},
+	registerTableOp: {
+		// postgres
+		`INSERT INTO %s (dbname, collector, dateFrom, dateTo) VALUES ($1, $2, $3, $4)
+		   ON CONFLICT (dbname) DO NOTHING;`,
+	},
+	unregisterTableOp: {
+		// postgres
+		`DELETE FROM %s WHERE dbname = $1;`,
+	},
+	// The first argument is the main table, the second the capture table and
+	// the third the quarantine schema. The statements are sent as one query,
+	// which postgres runs in a single transaction.
+	quarantineTableOp: {
+		// postgres
+		`CREATE SCHEMA IF NOT EXISTS %[3]s; ALTER TABLE %[2]s SET SCHEMA %[3]s; DELETE FROM %[1]s WHERE dbname = '%[2]s';`,
+	},
+	captureMinutesOp: {
+		// postgres
+		`SELECT DISTINCT date_trunc('minute', timestamp) FROM %s;`,
+	},
+	renameTableOp: {
+		// postgres
+		`ALTER TABLE %s RENAME TO %s;`,
+	},
+	// The first argument is the table to copy to, the second the table to
+	// copy from. Only captures in [$1, $2) are copied.
+	copyCapturesOp: {
+		// postgres
+		`INSERT INTO %[1]s (timestamp, collector_ip, peer_ip, peer_as, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes, content_hash)
+		   SELECT timestamp, collector_ip, peer_ip, peer_as, as_path, next_hop, origin_as, adv_prefixes, wdr_prefixes, content_hash
+		   FROM %[2]s WHERE timestamp >= $1 AND timestamp < $2;`,
+	},
+	makeNamespaceOp: {
+		// postgres
+		`CREATE SCHEMA IF NOT EXISTS %s;`,
+	},
+	lockCollectorOp: {
+		// postgres
+		// Held until the end of the transaction. The key includes the schema
+		// so namespaces don't wait on each other.
+		`SELECT pg_advisory_xact_lock(hashtext(current_schema() || '.' || $1));`,
+	},
+	notifyCatalogOp: {
+		// postgres
+		`SELECT pg_notify($1, $2);`,
+	},
+	// The first column is whether the host is a replica at all. A replica
+	// which has replayed everything it received isn't behind, even if the
+	// primary hasn't committed anything in a while.
+	replicaLagOp: {
+		// postgres
+		`SELECT pg_is_in_recovery(), CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		   ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END;`,
+	},
 }
 
 // dbLogger is the logger for the database subsystem.
@@ -283,48 +699,63 @@ func (c *ctxExecutor) Rollback() error {
 }
 
 // tableCache provides functions to lookup caches for existing table
-// names and nodes.
+// names and nodes. Tables are returned with the window of time they hold.
 type tableCache interface {
-	LookupTable(net.IP, time.Time) (string, error)
+	LookupTable(net.IP, time.Time) (string, util.Timespan, error)
 	LookupNode(net.IP) (*node, error)
 }
 
+// cachedTable is a capture table known to a cache, and its window. Tables
+// are found by their window rather than by the name genTableName would give
+// them, because a table created before the dump duration of its node changed
+// has a different name.
+type cachedTable struct {
+	name string
+	span util.Timespan
+}
+
+// findCachedTable returns the table in tables whose window holds t.
+func findCachedTable(tables []cachedTable, t time.Time) (cachedTable, bool) {
+	for _, ct := range tables {
+		if ct.span.Contains(t) {
+			return ct, true
+		}
+	}
+	return cachedTable{}, false
+}
+
 // dbCache implements the tableCache interface. This is meant to be a top level cache.
 // If it doesn't contain an entry, it just returns an error.
 type dbCache struct {
 	nodes  map[string]*node
-	tables map[string][]string
+	tables map[string][]cachedTable
 }
 
 func newDBCache() *dbCache {
 	n := make(map[string]*node)
-	t := make(map[string][]string)
+	t := make(map[string][]cachedTable)
 	return &dbCache{nodes: n, tables: t}
 }
 
-// LookupTable provides the name of a table in the db, given the IP of a machine,
-// and a time or an error if it doesn't exist.
-func (dc *dbCache) LookupTable(nodeIP net.IP, t time.Time) (string, error) {
-	ipStr := nodeIP.String()
+// LookupTable provides the name and window of a table in the db, given the IP
+// of a machine, and a time or an error if it doesn't exist.
+func (dc *dbCache) LookupTable(nodeIP net.IP, t time.Time) (string, util.Timespan, error) {
+	ipStr := util.IPString(nodeIP)
 	node, ok := dc.nodes[ipStr]
 	if !ok {
-		return "", errNoNode
+		return "", util.Timespan{}, errNoNode
 	}
 
-	tName := genTableName(node.name, t, node.duration)
-	nodeTables := dc.tables[node.name]
-	for _, name := range nodeTables {
-		if name == tName {
-			return name, nil
-		}
+	ct, ok := findCachedTable(dc.tables[node.name], t)
+	if !ok {
+		return "", util.Timespan{}, errNoTable
 	}
-
-	return "", errNoTable
+	return ct.name, ct.span, nil
 }
 
 // LookupNode returns the node information given an IP or an error if it doesn't exist.
 func (dc *dbCache) LookupNode(nodeIP net.IP) (*node, error) {
-	ipStr := nodeIP.String()
+	ipStr := util.IPString(nodeIP)
 	n, ok := dc.nodes[ipStr]
 	if !ok {
 		return nil, errNoNode
@@ -332,20 +763,25 @@ func (dc *dbCache) LookupNode(nodeIP net.IP) (*node, error) {
 	return n, nil
 }
 
-func (dc *dbCache) addTable(nodeIP net.IP, t time.Time) {
+func (dc *dbCache) addTable(nodeIP net.IP, name string, span util.Timespan) {
 	n, err := dc.LookupNode(nodeIP)
 	if err != nil {
 		dbLogger.Errorf("error in addTable:%s. Can't add a table for a node isn't known.", err)
 		return
 	}
-	tName := genTableName(n.name, t, n.duration)
-	nodeTables := dc.tables[n.name]
-	nodeTables = append(nodeTables, tName)
-	dc.tables[n.name] = nodeTables
+	dc.tables[n.name] = append(dc.tables[n.name], cachedTable{name: name, span: span})
 }
 
 func (dc *dbCache) addNode(n *node) {
-	dc.nodes[n.ip] = n
+	dc.nodes[canonicalIP(n.ip)] = n
+}
+
+// clear drops every cached node and table. It's used when the node table
+// changes underneath the cache, so that stale names and durations are
+// looked up again.
+func (dc *dbCache) clear() {
+	dc.nodes = make(map[string]*node)
+	dc.tables = make(map[string][]cachedTable)
 }
 
 // nestedTableCache is a second level cache. If it doesn't find an entry, it checks the
@@ -354,66 +790,118 @@ func (dc *dbCache) addNode(n *node) {
 type nestedTableCache struct {
 	par    tableCache
 	nodes  map[string]*node
-	tables map[string][]string
+	tables map[string][]cachedTable
 }
 
 func newNestedTableCache(par tableCache) *nestedTableCache {
 	n := make(map[string]*node)
-	t := make(map[string][]string)
+	t := make(map[string][]cachedTable)
 	return &nestedTableCache{par: par, nodes: n, tables: t}
 }
 
-func (ntc *nestedTableCache) LookupTable(nodeIP net.IP, t time.Time) (string, error) {
+func (ntc *nestedTableCache) LookupTable(nodeIP net.IP, t time.Time) (string, util.Timespan, error) {
 	var node *node
 	var err error
-	node, ok := ntc.nodes[nodeIP.String()]
+	node, ok := ntc.nodes[util.IPString(nodeIP)]
 
 	if !ok {
 		node, err = ntc.par.LookupNode(nodeIP)
 		if err != nil {
-			return "", err
+			return "", util.Timespan{}, err
 		}
 		ntc.addNode(node)
 	}
 
-	tName := genTableName(node.name, t, node.duration)
-	nodeTables := ntc.tables[node.name]
-	ok = false
-	for _, name := range nodeTables {
-		if name == tName {
-			ok = true
-			break
-		}
-	}
-
-	if ok {
-		return tName, nil
+	if ct, ok := findCachedTable(ntc.tables[node.name], t); ok {
+		return ct.name, ct.span, nil
 	}
 
-	tName, err = ntc.par.LookupTable(nodeIP, t)
+	tName, span, err := ntc.par.LookupTable(nodeIP, t)
 	if err != nil {
-		return "", err
+		return "", util.Timespan{}, err
 	}
-	ntc.addTable(node.name, tName)
-	return tName, nil
+	ntc.addTable(node.name, tName, span)
+	return tName, span, nil
 }
 
 func (ntc *nestedTableCache) LookupNode(nodeIP net.IP) (*node, error) {
-	n, ok := ntc.nodes[nodeIP.String()]
+	n, ok := ntc.nodes[util.IPString(nodeIP)]
 	if !ok {
 		return nil, errNoNode
 	}
 	return n, nil
 }
 
-func (ntc *nestedTableCache) addTable(nodeName, table string) {
-	nodeTables := ntc.tables[nodeName]
-	nodeTables = append(nodeTables, table)
-	ntc.tables[nodeName] = nodeTables
+func (ntc *nestedTableCache) addTable(nodeName, table string, span util.Timespan) {
+	ntc.tables[nodeName] = append(ntc.tables[nodeName], cachedTable{name: table, span: span})
 }
 
 func (ntc *nestedTableCache) addNode(n *node) {
-	ntc.nodes[n.ip] = n
+	ntc.nodes[canonicalIP(n.ip)] = n
+}
+
+// canonicalIP returns the form of an IP address string that is stored in the
+// node table and used as a cache key, so that different ways of writing one
+// address find the same node. Strings that aren't addresses are returned as is.
+func canonicalIP(ip string) string {
+	if parsed := util.ParseIP(ip); parsed != nil {
+		return util.IPString(parsed)
+	}
+	return ip
+}
+
+// checkCaptureIndexes returns the columns in cols without duplicates, or an
+// error if any of them can't be indexed.
+func checkCaptureIndexes(cols []string) ([]string, error) {
+	var checked []string
+	seen := make(map[string]bool)
+	for _, col := range cols {
+		if _, ok := captureIndexMethods[col]; !ok {
+			return nil, fmt.Errorf("capture table column %s can't be indexed", col)
+		}
+		if !seen[col] {
+			seen[col] = true
+			checked = append(checked, col)
+		}
+	}
+	return checked, nil
+}
+
+// maxIdentifierLen is the length postgres truncates longer identifiers to.
+const maxIdentifierLen = 63
+
+// captureIndexName returns the name of the index on col of a capture table.
+// A name that would be truncated gets a hash of the table and column instead
+// of its end, so indexes of different tables or columns can't share one.
+func captureIndexName(table, col string) string {
+	name := fmt.Sprintf("%s_%s_idx", table, col)
+	if len(name) <= maxIdentifierLen {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(table + "." + col))
+	suffix := fmt.Sprintf("_%x_idx", sum[:4])
+	return name[:maxIdentifierLen-len(suffix)] + suffix
+}
+
+// namespaceRegexp matches the postgres schema names a session namespace can
+// use. They are used unquoted, so they must be lower case, and they leave
+// room for the suffix of the namespace's quarantine schema.
+var namespaceRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,51}$`)
+
+// checkNamespace returns an error if ns can't be used as the namespace of a
+// session. An empty namespace uses the default schema.
+func checkNamespace(ns string) error {
+	if ns == "" {
+		return nil
+	}
+	if !namespaceRegexp.MatchString(ns) || strings.HasPrefix(ns, "pg_") {
+		return fmt.Errorf("namespace %s must be a lower case postgres schema name not starting with pg_", ns)
+	}
+	if ns == quarantineSchema {
+		return fmt.Errorf("namespace %s is used for quarantined tables", ns)
+	}
+	return nil
 }
 
 // genTableName takes a name of a collector, a time and a duration, and
@@ -422,5 +910,5 @@ func (ntc *nestedTableCache) addNode(n *node) {
 func genTableName(colName string, date time.Time, durMins int) string {
 	dur := time.Duration(durMins) * time.Minute
 	truncTime := date.Truncate(dur).UTC()
-	return fmt.Sprintf("%s_%s", colName, truncTime.Format("2006_01_02_15_04_05"))
+	return fmt.Sprintf("%s_%s", colName, truncTime.Format(tableDateFormat))
 }
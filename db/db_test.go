@@ -5,9 +5,14 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
 )
 
 const (
@@ -117,3 +122,119 @@ func TestSyncNodes(t *testing.T) {
 		t.Fatal(rep.Error())
 	}
 }
+
+func TestGenTableNameBuckets(t *testing.T) {
+	denver, err := time.LoadLocation("America/Denver")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %s", err)
+	}
+
+	tests := []struct {
+		date     time.Time
+		durMins  int
+		expected string
+	}{
+		{time.Date(2013, time.January, 1, 0, 0, 0, 0, time.UTC), 1440, "col_2013_01_01_00_00_00"},
+		{time.Date(2013, time.January, 1, 23, 59, 59, 999999999, time.UTC), 1440, "col_2013_01_01_00_00_00"},
+		{time.Date(2013, time.January, 2, 0, 0, 0, 0, time.UTC), 1440, "col_2013_01_02_00_00_00"},
+		{time.Date(2013, time.January, 1, 10, 44, 59, 0, time.UTC), 30, "col_2013_01_01_10_30_00"},
+		// Buckets are aligned to UTC, not to the time zone of the date.
+		{time.Date(2013, time.January, 1, 20, 0, 0, 0, denver), 1440, "col_2013_01_02_00_00_00"},
+		{time.Date(2019, time.March, 10, 3, 30, 0, 0, denver), 60, "col_2019_03_10_09_00_00"},
+		{time.Date(2019, time.November, 3, 1, 30, 0, 0, denver), 60, "col_2019_11_03_07_00_00"},
+		{time.Date(2019, time.November, 3, 1, 30, 0, 0, denver).Add(time.Hour), 60, "col_2019_11_03_08_00_00"},
+	}
+
+	for _, v := range tests {
+		if name := genTableName("col", v.date, v.durMins); name != v.expected {
+			t.Errorf("Date: %s, Expected: %s, Got: %s", v.date, v.expected, name)
+		}
+	}
+}
+
+func TestCheckCaptureIndexes(t *testing.T) {
+	cols, err := checkCaptureIndexes([]string{"timestamp", "adv_prefixes", "timestamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cols, []string{"timestamp", "adv_prefixes"}) {
+		t.Fatalf("Expected duplicates to be removed, Got: %v", cols)
+	}
+
+	if _, err := checkCaptureIndexes([]string{"origin_as", "next_hop"}); err == nil {
+		t.Fatalf("Expected error indexing an unknown column")
+	}
+
+	table := "col_2013_01_01_00_00_00"
+	stmt := fmt.Sprintf(dbOps[makeCaptureIndexOp][postgres], table, "adv_prefixes", captureIndexMethods["adv_prefixes"],
+		captureIndexName(table, "adv_prefixes"))
+	expected := "CREATE INDEX IF NOT EXISTS col_2013_01_01_00_00_00_adv_prefixes_idx ON col_2013_01_01_00_00_00 USING gin (adv_prefixes);"
+	if stmt != expected {
+		t.Fatalf("Expected: %s, Got: %s", expected, stmt)
+	}
+}
+
+func TestCaptureIndexNameLong(t *testing.T) {
+	// With the separator, the table takes all 63 bytes postgres keeps.
+	table := "collector_" + strings.Repeat("x", 32) + "_2013_01_01_00_00_00"
+	names := make(map[string]bool)
+	for _, col := range []string{"adv_prefixes", "wdr_prefixes"} {
+		name := captureIndexName(table, col)
+		if len(name) > maxIdentifierLen {
+			t.Errorf("Column %s: Expected at most %d bytes, Got: %s (%d)", col, maxIdentifierLen, name, len(name))
+		}
+		if !strings.HasSuffix(name, "_idx") {
+			t.Errorf("Column %s: Expected an _idx suffix, Got: %s", col, name)
+		}
+		if name != captureIndexName(table, col) {
+			t.Errorf("Column %s: Expected the same name every time", col)
+		}
+		names[name] = true
+	}
+
+	// Both names share their first 63 bytes, so postgres alone would
+	// truncate them to the same one.
+	if len(names) != 2 {
+		t.Errorf("Expected distinct index names, Got: %v", names)
+	}
+}
+
+func TestCheckNamespace(t *testing.T) {
+	for _, ns := range []string{"", "team_a", "_exp2"} {
+		if err := checkNamespace(ns); err != nil {
+			t.Errorf("Expected namespace %q to be valid, Got: %s", ns, err)
+		}
+	}
+
+	for _, ns := range []string{"Team", "2exp", "a-b", "a;drop", "pg_temp", quarantineSchema, strings.Repeat("a", 53)} {
+		if err := checkNamespace(ns); err == nil {
+			t.Errorf("Expected namespace %q to be invalid", ns)
+		}
+	}
+}
+
+func TestTableCacheAfterDurationChange(t *testing.T) {
+	day := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	ip := util.ParseIP("10.0.0.1")
+
+	// The node now dumps every hour, but the table holding this day was
+	// created when it dumped every day.
+	dc := newDBCache()
+	dc.addNode(&node{name: "col", ip: "10.0.0.1", duration: 60})
+	dc.addTable(ip, genTableName("col", day, 1440), util.Timespan{Start: day, End: day.Add(24 * time.Hour)})
+
+	ntc := newNestedTableCache(dc)
+	for _, c := range []tableCache{dc, ntc} {
+		name, span, err := c.LookupTable(ip, day.Add(90*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "col_2019_06_01_00_00_00" || !span.End.Equal(day.Add(24*time.Hour)) {
+			t.Errorf("Expected the day table, Got: %s %+v", name, span)
+		}
+	}
+
+	if _, _, err := dc.LookupTable(ip, day.Add(24*time.Hour)); err != errNoTable {
+		t.Errorf("Expected no table for the next day, Got: %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/CSUNetSec/bgpmon/config"
@@ -15,6 +17,8 @@ import (
 var (
 	errNoNode  = errors.New("no such node in DB")
 	errNoTable = errors.New("no such table in DB")
+
+	errNoEntity = errors.New("no such entity in DB")
 )
 
 // This is a utility function that can be deferred while
@@ -32,7 +36,9 @@ func closeRowsAndLog(rows *sql.Rows) {
 func checkSchema(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	csQuery := ex.getQuery(checkSchemaOp)
 
-	toCheck := []string{msg.GetMainTable(), msg.GetNodeTable(), msg.GetEntityTable()}
+	toCheck := []string{msg.GetMainTable(), msg.GetNodeTable(), msg.GetEntityTable(),
+		entityHistoryTable(msg.GetEntityTable()), rollupTable(msg.GetMainTable()), peerEventTable(msg.GetMainTable()),
+		migrationTable(msg.GetMainTable())}
 	allGood := true
 	for _, tName := range toCheck {
 		res := false
@@ -82,14 +88,7 @@ func syncNodes(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 
 	allNodes := config.SumNodeConfs(nodesMsg.getNodes(), dbNodes)
 	for _, v := range allNodes {
-		_, err := ex.Exec(fmt.Sprintf(insertNodeTmpl, nodesMsg.GetNodeTable()),
-			util.SanitizeDBString(v.Name),
-			util.SanitizeDBString(v.IP),
-			v.IsCollector,
-			v.DumpDurationMinutes,
-			util.SanitizeDBString(v.Description),
-			util.SanitizeDBString(v.Coords),
-			util.SanitizeDBString(v.Location))
+		_, err := ex.Exec(fmt.Sprintf(insertNodeTmpl, nodesMsg.GetNodeTable()), nodeConfValues(v)...)
 		if err != nil {
 			dbLogger.Errorf("failed to insert node config. %s", err)
 		} else {
@@ -99,6 +98,126 @@ func syncNodes(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	return newNodesReply(allNodes, nil)
 }
 
+// nodeConfValues returns the values of a node configuration in the order expected
+// by the insertNodeOp, addNodeOp and updateNodeOp statements.
+func nodeConfValues(nc config.NodeConfig) []interface{} {
+	return []interface{}{
+		util.SanitizeDBString(nc.Name),
+		util.SanitizeDBString(canonicalIP(nc.IP)),
+		nc.IsCollector,
+		nc.DumpDurationMinutes,
+		util.SanitizeDBString(nc.Description),
+		util.SanitizeDBString(nc.Coords),
+		util.SanitizeDBString(nc.Location),
+	}
+}
+
+// listNodes returns every node in the node table, keyed by IP.
+func listNodes(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	selectNodeTmpl := ex.getQuery(selectNodeOp)
+
+	rows, err := ex.Query(fmt.Sprintf(selectNodeTmpl, msg.GetNodeTable()))
+	if err != nil {
+		return newNodesReply(nil, dbLogger.Errorf("listNodes query: %s", err))
+	}
+	defer closeRowsAndLog(rows)
+
+	nodes := make(map[string]config.NodeConfig)
+	for rows.Next() {
+		cn := newNode()
+		err := rows.Scan(&cn.name, &cn.ip, &cn.isCollector, &cn.duration, &cn.description, &cn.coords, &cn.address)
+		if err != nil {
+			return newNodesReply(nil, dbLogger.Errorf("listNodes fetch node row: %s", err))
+		}
+		nodes[cn.ip] = cn.nodeConfigFromNode()
+	}
+
+	if err := rows.Err(); err != nil {
+		return newNodesReply(nil, dbLogger.Errorf("listNodes rows: %s", err))
+	}
+	return newNodesReply(nodes, nil)
+}
+
+// addNode inserts a new node into the node table. It fails if a node with the
+// same IP already exists.
+func addNode(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	nMsg := msg.(nodeConfMessage)
+	nc := nMsg.getNodeConf()
+
+	if err := checkNodeConf(nc); err != nil {
+		return newReply(err)
+	}
+
+	stmt := fmt.Sprintf(ex.getQuery(addNodeOp), nMsg.GetNodeTable())
+	if _, err := ex.Exec(stmt, nodeConfValues(nc)...); err != nil {
+		return newReply(dbLogger.Errorf("addNode error: %s", err))
+	}
+	dbLogger.Infof("added node: %v", nc)
+
+	return newReply(nil)
+}
+
+// updateNode replaces the fields of the node with a matching IP. It returns
+// errNoNode if there is no such node.
+func updateNode(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	nMsg := msg.(nodeConfMessage)
+	nc := nMsg.getNodeConf()
+
+	if err := checkNodeConf(nc); err != nil {
+		return newReply(err)
+	}
+
+	stmt := fmt.Sprintf(ex.getQuery(updateNodeOp), nMsg.GetNodeTable())
+	res, err := ex.Exec(stmt, nodeConfValues(nc)...)
+	if err != nil {
+		return newReply(dbLogger.Errorf("updateNode error: %s", err))
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return newReply(errNoNode)
+	}
+	dbLogger.Infof("updated node: %v", nc)
+
+	return newReply(nil)
+}
+
+// deleteNode removes every node matching the name or IP in the message. It returns
+// errNoNode if nothing was removed.
+func deleteNode(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	nMsg := msg.(nodeMessage)
+	name, ip := util.SanitizeDBString(nMsg.getNodeName()), util.SanitizeDBString(canonicalIP(nMsg.getNodeIP()))
+
+	stmt := fmt.Sprintf(ex.getQuery(deleteNodeOp), nMsg.GetNodeTable())
+	res, err := ex.Exec(stmt, name, ip)
+	if err != nil {
+		return newReply(dbLogger.Errorf("deleteNode error: %s", err))
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return newReply(errNoNode)
+	}
+	dbLogger.Infof("deleted node name:%s ip:%s", name, ip)
+
+	return newReply(nil)
+}
+
+// checkNodeConf makes sure a node configuration provided by a client can
+// be stored.
+func checkNodeConf(nc config.NodeConfig) error {
+	if nc.Name == "" {
+		return fmt.Errorf("node name can't be empty")
+	}
+
+	if net.ParseIP(nc.IP) == nil {
+		return fmt.Errorf("malformed node IP: %s", nc.IP)
+	}
+
+	if nc.IsCollector && nc.DumpDurationMinutes <= 0 {
+		return fmt.Errorf("collectors require a positive dump duration")
+	}
+	return nil
+}
+
 // getNode returns the first matching node from the db table based on IP or name.
 func getNode(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	nodeMsg := msg.(nodeMessage)
@@ -137,17 +256,31 @@ func createCaptureTable(ex SessionExecutor, msg CommonMessage) (rep CommonReply)
 	cMsg := msg.(capTableMessage)
 	name := util.SanitizeDBString(cMsg.getTableName())
 
+	// A table left behind without being registered may predate the current
+	// schema, so only a table created here is known not to need migrations.
+	existed := false
+	if err := ex.QueryRow(ex.getQuery(checkSchemaOp), name).Scan(&existed); err != nil {
+		return newCapTableReply("", "", time.Now(), time.Now(), dbLogger.Errorf("createCaptureTable error: %s", err))
+	}
+
 	stmt := fmt.Sprintf(createCapTmpl, name)
 	_, err := ex.Exec(stmt)
 	if err != nil {
 		return newCapTableReply("", "", time.Now(), time.Now(), dbLogger.Errorf("createCaptureTable error: %s", err))
 	}
+	if !existed {
+		if err := recordNewCaptureTable(ex, cMsg.GetMainTable(), name); err != nil {
+			// The migrations are harmless on a new table, so they'll just
+			// be run when the next session opens.
+			dbLogger.Errorf("createCaptureTable error recording migrations of %s: %s", name, err)
+		}
+	}
 
 	insertCapTmpl := ex.getQuery(insertMainTableOp)
 	// This returns the collector IP.
 	ip := cMsg.getTableCol()
 	start, end := cMsg.getDates()
-	_, err = ex.Exec(fmt.Sprintf(insertCapTmpl, cMsg.GetMainTable()), name, ip, start, end)
+	_, err = ex.Exec(fmt.Sprintf(insertCapTmpl, cMsg.GetMainTable()), name, ip, start.UTC(), end.UTC())
 
 	if err != nil {
 		return newCapTableReply("", "", time.Now(), time.Now(), dbLogger.Errorf("createCaptureTable insertnode error:%s", err))
@@ -157,6 +290,20 @@ func createCaptureTable(ex SessionExecutor, msg CommonMessage) (rep CommonReply)
 	return newCapTableReply(name, ip, start, end, nil)
 }
 
+// createCaptureIndexes creates an index on each of the columns of a capture
+// table. Columns that are already indexed are skipped. The columns must have
+// been checked with checkCaptureIndexes.
+func createCaptureIndexes(ex SessionExecutor, table string, cols []string) error {
+	table = util.SanitizeDBString(table)
+	for _, col := range cols {
+		stmt := fmt.Sprintf(ex.getQuery(makeCaptureIndexOp), table, col, captureIndexMethods[col], captureIndexName(table, col))
+		if _, err := ex.Exec(stmt); err != nil {
+			return dbLogger.Errorf("createCaptureIndexes error on %s(%s): %s", table, col, err)
+		}
+	}
+	return nil
+}
+
 // getTable returns the collector table from the main dbs table.
 func getTable(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	tMsg := msg.(tableMessage)
@@ -195,6 +342,7 @@ func makeSchema(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	mainTableTmpl := ex.getQuery(makeMainTableOp)
 	nodeTableTmpl := ex.getQuery(makeNodeTableOp)
 	entityTableTmpl := ex.getQuery(makeEntityTableOp)
+	entityHistoryTmpl := ex.getQuery(makeEntityHistoryTableOp)
 
 	if _, err := ex.Exec(fmt.Sprintf(mainTableTmpl, msg.GetMainTable())); err != nil {
 		return newReply(errors.Wrap(err, "makeSchema maintable"))
@@ -211,6 +359,33 @@ func makeSchema(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
 	}
 	dbLogger.Infof("created table:%s", msg.GetEntityTable())
 
+	histTable := entityHistoryTable(msg.GetEntityTable())
+	if _, err := ex.Exec(fmt.Sprintf(entityHistoryTmpl, histTable)); err != nil {
+		return newReply(errors.Wrap(err, "makeSchema entityHistoryTable"))
+	}
+	dbLogger.Infof("created table:%s", histTable)
+
+	rollTable := rollupTable(msg.GetMainTable())
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(makeRollupTableOp), rollTable)); err != nil {
+		return newReply(errors.Wrap(err, "makeSchema rollupTable"))
+	}
+	dbLogger.Infof("created table:%s", rollTable)
+
+	evTable := peerEventTable(msg.GetMainTable())
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(makePeerEventTableOp), evTable)); err != nil {
+		return newReply(errors.Wrap(err, "makeSchema peerEventTable"))
+	}
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(makePeerEventIndexOp), evTable)); err != nil {
+		return newReply(errors.Wrap(err, "makeSchema peerEventTable index"))
+	}
+	dbLogger.Infof("created table:%s", evTable)
+
+	migTable := migrationTable(msg.GetMainTable())
+	if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(makeMigrationTableOp), migTable)); err != nil {
+		return newReply(errors.Wrap(err, "makeSchema migrationTable"))
+	}
+	dbLogger.Infof("created table:%s", migTable)
+
 	return newReply(nil)
 }
 
@@ -230,7 +405,22 @@ func insertCapture(ex SessionExecutor, msg CommonMessage) CommonReply {
 	return newReply(err)
 }
 
-// getCaptureBinaryStream returns a stream of Captures
+// insertDedupCapture is insertCapture for streams which skip duplicate
+// captures. The executor must append skipDuplicateCapturesOp to the VALUES.
+func insertDedupCapture(ex SessionExecutor, msg CommonMessage) CommonReply {
+	stmtTmpl := ex.getQuery(insertDedupCaptureOp)
+
+	capMsg := (msg).(*captureMessage)
+	stmt := fmt.Sprintf(stmtTmpl, capMsg.getTableName())
+
+	_, err := ex.Exec(stmt, capMsg.getCapture().DedupValues()...)
+
+	return newReply(err)
+}
+
+// getCaptureBinaryStream returns a stream of Captures. They are read table
+// by table, unless the filter is ordered, in which case every table is read
+// in a single query, in time order.
 func getCaptureBinaryStream(ctx context.Context, ex SessionExecutor, msg CommonMessage) chan CommonReply {
 
 	// This has a buffer length of 1 so it can be cancelled and not block while
@@ -245,15 +435,31 @@ func getCaptureBinaryStream(ctx context.Context, ex SessionExecutor, msg CommonM
 
 		start, end := capFilt.span.Start, capFilt.span.End
 
-		tables, err := getCaptureTables(ex, fMsg.GetMainTable(), capFilt.collector, start, end)
+		var tables []string
+		var err error
+		if capFilt.table != "" {
+			tables, err = getCaptureTableByName(ex, fMsg.GetMainTable(), capFilt.table)
+		} else {
+			tables, err = getCaptureTables(ex, fMsg.GetMainTable(), capFilt.collector, start, end)
+		}
 		if err != nil {
 			repStream <- newReply(err)
 			return
 		}
 
 		selectCapTmpl := ex.getQuery(getCaptureBinaryOp)
-		for _, tName := range tables {
-			stmt := fmt.Sprintf(selectCapTmpl, tName, capFilt.getWhereClause())
+		stmts := make([]string, len(tables))
+		for i, tName := range tables {
+			stmts[i] = fmt.Sprintf(selectCapTmpl, tName, capFilt.getWhereClause())
+		}
+		if capFilt.ordered && len(stmts) != 0 {
+			// The rows no longer come from a single table.
+			stmts = []string{fmt.Sprintf(ex.getQuery(getOrderedCapturesOp), strings.Join(stmts, " UNION ALL "))}
+			tables = []string{""}
+		}
+
+		for i, tName := range tables {
+			stmt := stmts[i]
 			//fmt.Printf("----QUERY----\n\n%s\n\n", stmt)
 			rows, err := ex.Query(stmt)
 			if err != nil {
@@ -329,17 +535,20 @@ func getPrefixStream(ctx context.Context, ex SessionExecutor, msg CommonMessage)
 	return retC
 }
 
+// getCaptureTables returns the names of every capture table of the collector
+// that holds part of the window [start, end), ordered by time. A table only
+// has to overlap the window, so the rows read from it still have to be
+// filtered by timestamp.
 func getCaptureTables(ex SessionExecutor, dbTable, colName string, start, end time.Time) ([]string, error) {
 	stmtTmpl := ex.getQuery(getCaptureTablesOp)
-	timeFormat := "2006-01-02 15:04:05"
 
 	colName = util.SanitizeDBString(colName)
 
-	stmt := fmt.Sprintf(stmtTmpl, dbTable, colName, start.Local().Format(timeFormat), end.Local().Format(timeFormat))
-	//fmt.Printf("----GET TABLES QUERY----\n\n%s\n\n", stmt)
+	stmt := fmt.Sprintf(stmtTmpl, dbTable)
 
 	var tableNames []string
-	rows, err := ex.Query(stmt)
+	// The table dates are stored without a time zone, in UTC.
+	rows, err := ex.Query(stmt, colName, end.UTC(), start.UTC())
 	if err != nil {
 		return nil, err
 	}
@@ -356,6 +565,360 @@ func getCaptureTables(ex SessionExecutor, dbTable, colName string, start, end ti
 	return tableNames, nil
 }
 
+// getCaptureTableByName returns name if it's a capture table of the main
+// table, or no tables otherwise, like on a shard which doesn't hold it.
+func getCaptureTableByName(ex SessionExecutor, dbTable, name string) ([]string, error) {
+	stmt := fmt.Sprintf(ex.getQuery(getCaptureTableByNameOp), dbTable)
+	rows, err := ex.Query(stmt, name)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	var tableNames []string
+	for rows.Next() {
+		tName := ""
+		if err := rows.Scan(&tName); err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, tName)
+	}
+	return tableNames, rows.Err()
+}
+
+// listCaptureTableInfo returns the capture tables of a collector which only
+// hold captures from before a date. The collector is the table column of the
+// message, and the date is its end date.
+func listCaptureTableInfo(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	cMsg := msg.(capTableMessage)
+	_, before := cMsg.getDates()
+
+	stmt := fmt.Sprintf(ex.getQuery(listCaptureTableInfoOp), cMsg.GetMainTable())
+	rows, err := ex.Query(stmt, util.SanitizeDBString(cMsg.getTableCol()), before.UTC())
+	if err != nil {
+		return newCapTablesReply(nil, dbLogger.Errorf("listCaptureTableInfo query error: %s", err))
+	}
+	defer closeRowsAndLog(rows)
+
+	var tables []*CaptureTable
+	for rows.Next() {
+		ct := &CaptureTable{}
+		if err := ct.Scan(rows); err != nil {
+			return newCapTablesReply(nil, dbLogger.Errorf("listCaptureTableInfo scan error: %s", err))
+		}
+		tables = append(tables, ct)
+	}
+
+	return newCapTablesReply(tables, rows.Err())
+}
+
+// dropCaptureTable drops the capture table named in the message and removes
+// it from the main table, in one transaction.
+func dropCaptureTable(ex SessionExecutor, msg CommonMessage) (rep CommonReply) {
+	cMsg := msg.(capTableMessage)
+	name := util.SanitizeDBString(cMsg.getTableName())
+	if name == "" {
+		return newReply(fmt.Errorf("no capture table name provided"))
+	}
+
+	stmt := fmt.Sprintf(ex.getQuery(dropCaptureTableOp), cMsg.GetMainTable(), name)
+	if _, err := ex.Exec(stmt); err != nil {
+		return newReply(dbLogger.Errorf("dropCaptureTable error: %s", err))
+	}
+	dbLogger.Infof("dropped capture table: %s", name)
+
+	return newReply(nil)
+}
+
+// getCaptureTableInfo returns every capture table of the collector that
+// holds part of the window [start, end), ordered by time. Unlike
+// getCaptureTables, the collector of each table is returned as well.
+func getCaptureTableInfo(ex SessionExecutor, dbTable, colName string, start, end time.Time) ([]*CaptureTable, error) {
+	stmt := fmt.Sprintf(ex.getQuery(getCaptureTableInfoOp), dbTable)
+
+	rows, err := ex.Query(stmt, util.SanitizeDBString(colName), end.UTC(), start.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	var tables []*CaptureTable
+	for rows.Next() {
+		ct := &CaptureTable{}
+		if err := ct.Scan(rows); err != nil {
+			return nil, err
+		}
+		tables = append(tables, ct)
+	}
+	return tables, rows.Err()
+}
+
+// rollupTable returns the name of the table that holds the capture
+// summaries of the tables listed in mainTable.
+func rollupTable(mainTable string) string {
+	return mainTable + rollupSuffix
+}
+
+// rollupCaptures summarizes the captures of every collector matching the
+// message into the rollup table. The window is widened to whole buckets, and
+// each bucket it touches is recomputed from all of its captures, replacing
+// any earlier rollup. Each collector is summarized by a single statement, so
+// a bucket is never left half written.
+func rollupCaptures(ex SessionExecutor, msg CommonMessage) CommonReply {
+	rMsg := msg.(*rollupMessage)
+	g := rMsg.getGranularity()
+	span := rMsg.getSpan()
+
+	dur := g.Duration()
+	start := span.Start.UTC().Truncate(dur)
+	end := span.End.UTC()
+	if trunc := end.Truncate(dur); !trunc.Equal(end) {
+		end = trunc.Add(dur)
+	}
+
+	tables, err := getCaptureTableInfo(ex, rMsg.GetMainTable(), rMsg.getCollector(), start, end)
+	if err != nil {
+		return newReply(dbLogger.Errorf("rollupCaptures table lookup error: %s", err))
+	}
+
+	collectors, colTables := groupTablesByCollector(tables)
+
+	sourceTmpl := ex.getQuery(rollupSourceOp)
+	rollupTmpl := ex.getQuery(rollupCapturesOp)
+	for _, col := range collectors {
+		var sources []string
+		for _, tName := range colTables[col] {
+			sources = append(sources, fmt.Sprintf(sourceTmpl, tName))
+		}
+
+		stmt := fmt.Sprintf(rollupTmpl, rollupTable(rMsg.GetMainTable()), strings.Join(sources, " UNION ALL "))
+		if _, err := ex.Exec(stmt, col, string(g), start, end); err != nil {
+			return newReply(dbLogger.Errorf("rollupCaptures error for collector %s: %s", col, err))
+		}
+		dbLogger.Infof("rolled up %d tables of collector %s from %s to %s", len(colTables[col]), col, start, end)
+	}
+
+	return newReply(nil)
+}
+
+// groupTablesByCollector returns the names of the tables of each collector,
+// and the collectors in the order they first appear.
+func groupTablesByCollector(tables []*CaptureTable) ([]string, map[string][]string) {
+	var collectors []string
+	colTables := make(map[string][]string)
+	for _, t := range tables {
+		if _, ok := colTables[t.Collector()]; !ok {
+			collectors = append(collectors, t.Collector())
+		}
+		colTables[t.Collector()] = append(colTables[t.Collector()], t.Name())
+	}
+	return collectors, colTables
+}
+
+// getCaptureTableStats returns the stats of every capture table of the
+// collector in the message that holds part of its window, ordered by time.
+// Every table is scanned to count its rows, so this is slow on large tables.
+func getCaptureTableStats(ex SessionExecutor, msg CommonMessage) CommonReply {
+	cMsg := msg.(capTableMessage)
+	start, end := cMsg.getDates()
+
+	tables, err := getCaptureTableInfo(ex, cMsg.GetMainTable(), cMsg.getTableCol(), start, end)
+	if err != nil {
+		return newCaptureStatsReply(nil, dbLogger.Errorf("getCaptureTableStats table lookup error: %s", err))
+	}
+
+	lastHour := time.Now().UTC().Truncate(time.Hour)
+	statsTmpl := ex.getQuery(captureTableStatsOp)
+
+	var stats []*CaptureTableStats
+	for _, t := range tables {
+		checkedUntil := t.Span().End
+		if lastHour.Before(checkedUntil) {
+			checkedUntil = lastHour
+		}
+
+		ts := &CaptureTableStats{CaptureTable: t, Hours: countHours(t.Span().Start, checkedUntil)}
+		row := ex.QueryRow(fmt.Sprintf(statsTmpl, t.Name()), checkedUntil)
+		if err := row.Scan(&ts.Captures, &ts.HoursWithData, &ts.SizeBytes); err != nil {
+			return newCaptureStatsReply(nil, dbLogger.Errorf("getCaptureTableStats error on %s: %s", t.Name(), err))
+		}
+		stats = append(stats, ts)
+	}
+
+	return newCaptureStatsReply(stats, nil)
+}
+
+// getCaptureCoverage counts the captures of every collector matching the
+// message in each bucket of its window. The message is a rollupMessage, and
+// its granularity is the length of the buckets. Buckets are ordered by
+// collector, in the order of their first table, then by time.
+func getCaptureCoverage(ex SessionExecutor, msg CommonMessage) CommonReply {
+	rMsg := msg.(*rollupMessage)
+	span := rMsg.getSpan()
+	start, end := span.Start.UTC(), span.End.UTC()
+
+	tables, err := getCaptureTableInfo(ex, rMsg.GetMainTable(), rMsg.getCollector(), start, end)
+	if err != nil {
+		return newCoverageReply(nil, dbLogger.Errorf("getCaptureCoverage table lookup error: %s", err))
+	}
+
+	collectors, colTables := groupTablesByCollector(tables)
+	sourceTmpl := ex.getQuery(coverageSourceOp)
+	coverageTmpl := ex.getQuery(captureCoverageOp)
+
+	var buckets []*CoverageBucket
+	for _, col := range collectors {
+		var sources []string
+		for _, tName := range colTables[col] {
+			sources = append(sources, fmt.Sprintf(sourceTmpl, tName))
+		}
+
+		stmt := fmt.Sprintf(coverageTmpl, strings.Join(sources, " UNION ALL "))
+		rows, err := ex.Query(stmt, string(rMsg.getGranularity()), start, end)
+		if err != nil {
+			return newCoverageReply(nil, dbLogger.Errorf("getCaptureCoverage error for collector %s: %s", col, err))
+		}
+
+		for rows.Next() {
+			b := &CoverageBucket{Collector: col}
+			if err := rows.Scan(&b.Start, &b.Captures); err != nil {
+				closeRowsAndLog(rows)
+				return newCoverageReply(nil, dbLogger.Errorf("getCaptureCoverage scan error: %s", err))
+			}
+			// Buckets are computed without a time zone, in UTC.
+			b.Start = b.Start.UTC()
+			buckets = append(buckets, b)
+		}
+		err = rows.Err()
+		closeRowsAndLog(rows)
+		if err != nil {
+			return newCoverageReply(nil, dbLogger.Errorf("getCaptureCoverage error for collector %s: %s", col, err))
+		}
+	}
+
+	return newCoverageReply(buckets, nil)
+}
+
+// getRollupStream returns a stream of Rollups, ordered by bucket.
+func getRollupStream(ctx context.Context, ex SessionExecutor, msg CommonMessage) chan CommonReply {
+	retC := make(chan CommonReply, 1)
+
+	go func(ctx context.Context, ex SessionExecutor, msg CommonMessage, rep chan CommonReply) {
+		defer close(rep)
+		filtMsg := msg.(*filterMessage)
+		filter := filtMsg.getFilter()
+
+		stmt := fmt.Sprintf(ex.getQuery(getRollupOp), rollupTable(filtMsg.GetMainTable()), filter.getWhereClause())
+		rows, err := ex.Query(stmt)
+		if err != nil {
+			rep <- newReply(err)
+			return
+		}
+		defer closeRowsAndLog(rows)
+
+		for rows.Next() {
+			r := &Rollup{}
+			err = r.Scan(rows)
+
+			select {
+			case <-ctx.Done():
+				rep <- newReply(fmt.Errorf("context closed"))
+				return
+			case rep <- newRollupReply(r, err):
+				break
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			rep <- newReply(err)
+		}
+	}(ctx, ex, msg, retC)
+	return retC
+}
+
+// peerEventTable returns the name of the table that holds the peer events
+// of the collectors in mainTable.
+func peerEventTable(mainTable string) string {
+	return mainTable + peerEventSuffix
+}
+
+// insertPeerEvent adds a peer event to the peer event table.
+func insertPeerEvent(ex SessionExecutor, msg CommonMessage) CommonReply {
+	stmt := fmt.Sprintf(ex.getQuery(insertPeerEventOp), peerEventTable(msg.GetMainTable()))
+	ev := msg.(*peerEventMessage).getEvent()
+
+	res, err := ex.Exec(stmt, ev.Values()...)
+	if err != nil {
+		return newReply(err)
+	}
+	// A duplicate of a stored event isn't inserted.
+	n, err := res.RowsAffected()
+	return newInsertReply(n == 0, err)
+}
+
+// getPeerEventStream returns a stream of the peer events passing the filter
+// in msg, in the order they happened.
+func getPeerEventStream(ctx context.Context, ex SessionExecutor, msg CommonMessage) chan CommonReply {
+	retC := make(chan CommonReply, 1)
+
+	go func(ctx context.Context, ex SessionExecutor, msg CommonMessage, rep chan CommonReply) {
+		defer close(rep)
+		filtMsg := msg.(*filterMessage)
+		filter := filtMsg.getFilter()
+
+		stmt := fmt.Sprintf(ex.getQuery(getPeerEventsOp), peerEventTable(filtMsg.GetMainTable()), filter.getWhereClause())
+		rows, err := ex.Query(stmt)
+		if err != nil {
+			rep <- newReply(err)
+			return
+		}
+		defer closeRowsAndLog(rows)
+
+		for rows.Next() {
+			ev := &PeerEvent{}
+			err = ev.Scan(rows)
+
+			select {
+			case <-ctx.Done():
+				rep <- newReply(fmt.Errorf("context closed"))
+				return
+			case rep <- newPeerEventReply(ev, err):
+				break
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			rep <- newReply(err)
+		}
+	}(ctx, ex, msg, retC)
+	return retC
+}
+
+// entityHistoryTable returns the name of the table that records changes
+// to entityTable.
+func entityHistoryTable(entityTable string) string {
+	return entityTable + entityHistorySuffix
+}
+
+// Actions recorded in the entity history table.
+const (
+	entityActionUpsert = "upsert"
+	entityActionDelete = "delete"
+)
+
+// recordEntityChange adds a row describing a change to an entity in the
+// entity history table. It should be run on the same executor as the change
+// itself, so both are committed or rolled back together.
+func recordEntityChange(ex SessionExecutor, entityTable string, e *Entity, action string) error {
+	stmt := fmt.Sprintf(ex.getQuery(insertEntityHistoryOp), entityHistoryTable(entityTable))
+	vals := append(e.Values(), action)
+
+	_, err := ex.Exec(stmt, vals...)
+	return err
+}
+
+// insertEntity adds or replaces an entity, and records the change in the
+// entity history table.
 func insertEntity(ex SessionExecutor, msg CommonMessage) CommonReply {
 	stmtTmpl := ex.getQuery(insertEntityOp)
 	stmt := fmt.Sprintf(stmtTmpl, msg.GetEntityTable())
@@ -363,10 +926,90 @@ func insertEntity(ex SessionExecutor, msg CommonMessage) CommonReply {
 	entMsg := msg.(*entityMessage)
 	entity := entMsg.getEntity()
 
-	_, err := ex.Exec(stmt, entity.Values()...)
+	if _, err := ex.Exec(stmt, entity.Values()...); err != nil {
+		return newReply(err)
+	}
+
+	err := recordEntityChange(ex, msg.GetEntityTable(), entity, entityActionUpsert)
 	return newReply(err)
 }
 
+// deleteEntity removes the entity named in the message, and records the
+// removal in the entity history table. It returns errNoEntity if there was
+// no entity with that name.
+func deleteEntity(ex SessionExecutor, msg CommonMessage) CommonReply {
+	stmtTmpl := ex.getQuery(deleteEntityOp)
+	stmt := fmt.Sprintf(stmtTmpl, msg.GetEntityTable())
+
+	entMsg := msg.(*entityMessage)
+	name := util.SanitizeDBString(entMsg.getEntity().Name)
+
+	rows, err := ex.Query(stmt, name)
+	if err != nil {
+		return newReply(dbLogger.Errorf("deleteEntity error: %s", err))
+	}
+
+	var deleted []*Entity
+	for rows.Next() {
+		ent := &Entity{}
+		if err := ent.Scan(rows); err != nil {
+			closeRowsAndLog(rows)
+			return newReply(err)
+		}
+		deleted = append(deleted, ent)
+	}
+	closeRowsAndLog(rows)
+
+	if len(deleted) == 0 {
+		return newReply(errNoEntity)
+	}
+
+	for _, ent := range deleted {
+		if err := recordEntityChange(ex, msg.GetEntityTable(), ent, entityActionDelete); err != nil {
+			return newReply(err)
+		}
+	}
+	dbLogger.Infof("deleted entity: %s", name)
+
+	return newReply(nil)
+}
+
+// getEntityHistoryStream returns a stream of EntityChanges, oldest first.
+func getEntityHistoryStream(ctx context.Context, ex SessionExecutor, msg CommonMessage) chan CommonReply {
+	retC := make(chan CommonReply, 1)
+
+	go func(ctx context.Context, ex SessionExecutor, msg CommonMessage, rep chan CommonReply) {
+		defer close(rep)
+		stmtTmpl := ex.getQuery(getEntityHistoryOp)
+		filtMsg := msg.(*filterMessage)
+		filter := filtMsg.getFilter()
+
+		histTable := entityHistoryTable(filtMsg.GetEntityTable())
+		stmt := fmt.Sprintf(stmtTmpl, histTable, filter.getWhereClause())
+		rows, err := ex.Query(stmt)
+		if err != nil {
+			rep <- newReply(err)
+			return
+		}
+		defer closeRowsAndLog(rows)
+
+		for rows.Next() {
+			change := &EntityChange{Entity: &Entity{}}
+			err = change.Scan(rows)
+
+			select {
+			case <-ctx.Done():
+				rep <- newReply(fmt.Errorf("context closed"))
+				return
+			case rep <- newEntityChangeReply(change, err):
+				break
+			}
+		}
+
+	}(ctx, ex, msg, retC)
+	return retC
+}
+
 func getEntityStream(ctx context.Context, ex SessionExecutor, msg CommonMessage) chan CommonReply {
 	retC := make(chan CommonReply, 1)
 
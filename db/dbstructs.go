@@ -1,6 +1,7 @@
 package db
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"github.com/CSUNetSec/bgpmon/util"
 
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
+	pbbgp "github.com/CSUNetSec/netsec-protobufs/protocol/bgp"
 	"github.com/lib/pq"
 )
 
@@ -53,12 +55,13 @@ type Capture struct {
 	fromTable  string // mostly debug
 	ID         string // the capture_id that together with the table makes it unique
 	Timestamp  time.Time
-	Origin     int // origin as
+	Origin     uint32 // origin as
 	Advertised []*net.IPNet
 	Withdrawn  []*net.IPNet
-	ASPath     []int
+	ASPath     []uint32
 	ColIP      net.IP
 	PeerIP     net.IP
+	PeerAS     uint32 // the AS of the peer the collector received this from, 0 if unknown
 	NextHop    net.IP
 }
 
@@ -69,37 +72,42 @@ func (c *Capture) Scan(rows *sql.Rows) error {
 	var (
 		colIP      sql.NullString
 		peerIP     sql.NullString
+		peerAS     sql.NullInt64
 		asPath     sql.NullString
 		nextHop    sql.NullString
 		advertized sql.NullString
 		withdrawn  sql.NullString
 	)
 
-	err := rows.Scan(&c.ID, &c.Timestamp, &colIP, &peerIP, &asPath, &nextHop, &c.Origin, &advertized, &withdrawn)
+	err := rows.Scan(&c.ID, &c.Timestamp, &colIP, &peerIP, &peerAS, &asPath, &nextHop, &c.Origin, &advertized, &withdrawn)
 	if err != nil {
 		return err
 	}
 
 	if colIP.Valid {
-		c.ColIP = net.ParseIP(colIP.String)
+		c.ColIP = util.ParseIP(colIP.String)
 	} else {
 		c.ColIP = nil
 	}
 
 	if peerIP.Valid {
-		c.PeerIP = net.ParseIP(peerIP.String)
+		c.PeerIP = util.ParseIP(peerIP.String)
 	} else {
 		c.PeerIP = nil
 	}
 
+	// Tables created before the peer_as column existed are migrated with a
+	// default, but a NULL could still be inserted by hand.
+	c.PeerAS = uint32(peerAS.Int64)
+
 	if nextHop.Valid {
-		c.NextHop = net.ParseIP(nextHop.String)
+		c.NextHop = util.ParseIP(nextHop.String)
 	} else {
 		c.NextHop = nil
 	}
 
 	if asPath.Valid {
-		c.ASPath, err = parseIntArray(asPath.String)
+		c.ASPath, err = parseASArray(asPath.String)
 		if err != nil {
 			return err
 		}
@@ -130,23 +138,43 @@ func (c *Capture) Scan(rows *sql.Rows) error {
 
 // Values supplies values to a SQLExecutor
 func (c *Capture) Values() []interface{} {
-	ret := make([]interface{}, 8)
+	ret := make([]interface{}, 9)
 
-	ret[0] = c.Timestamp
-	ret[1] = c.ColIP.String()
-	ret[2] = c.PeerIP.String()
-	ret[3] = pq.Array(c.ASPath)
-	ret[4] = c.NextHop.String()
-	ret[5] = c.Origin
+	// The timestamp column has no time zone, so it must be written in UTC.
+	ret[0] = c.Timestamp.UTC()
+	ret[1] = util.IPString(c.ColIP)
+	ret[2] = util.IPString(c.PeerIP)
+	ret[3] = int64(c.PeerAS)
+	ret[4] = asArray(c.ASPath)
+	ret[5] = util.IPString(c.NextHop)
+	ret[6] = int64(c.Origin)
 
 	advArr := util.PrefixesToPQArray(c.Advertised)
 	wdrArr := util.PrefixesToPQArray(c.Withdrawn)
-	ret[6] = advArr
-	ret[7] = wdrArr
+	ret[7] = advArr
+	ret[8] = wdrArr
 
 	return ret
 }
 
+// DedupValues returns the Values of the capture followed by its content hash,
+// which is the same for every capture of the same message.
+func (c *Capture) DedupValues() []interface{} {
+	vals := c.Values()
+
+	h := sha256.New()
+	for i, v := range vals {
+		// Timestamps are stored with microsecond precision, so a capture read
+		// back from a table hashes the same.
+		if i == 0 {
+			v = c.Timestamp.UTC().Round(time.Microsecond).Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%v\x00", v)
+	}
+
+	return append(vals, h.Sum(nil))
+}
+
 // NewCaptureFromPB returns a *Capture populated from a pb.BGPCapture
 func NewCaptureFromPB(pbCap *pb.BGPCapture) (*Capture, error) {
 	cap := &Capture{fromTable: "", ID: ""}
@@ -162,6 +190,11 @@ func NewCaptureFromPB(pbCap *pb.BGPCapture) (*Capture, error) {
 		return nil, dbLogger.Errorf("unable to parse peer IP: %s", err)
 	}
 
+	cap.PeerAS, err = util.GetPeerAS(pbCap)
+	if err != nil {
+		return nil, dbLogger.Errorf("unable to parse peer AS: %s", err)
+	}
+
 	// Ignoring the error here as this message could only have withdraws.
 	cap.ASPath, _ = util.GetASPath(pbCap)
 
@@ -170,9 +203,15 @@ func NewCaptureFromPB(pbCap *pb.BGPCapture) (*Capture, error) {
 		cap.Origin = cap.ASPath[len(cap.ASPath)-1]
 	}
 
+	// Withdraw only messages have no next hop, so they get the unspecified
+	// address of the same family as the peer.
 	cap.NextHop, err = util.GetNextHop(pbCap)
 	if err != nil {
-		cap.NextHop = net.IPv4(0, 0, 0, 0)
+		if util.IsIPv4(cap.PeerIP) {
+			cap.NextHop = net.IPv4zero.To4()
+		} else {
+			cap.NextHop = net.IPv6unspecified
+		}
 	}
 
 	// Here if it errors and the return is nil, PrefixToPQArray should leave it and the schema should insert the default
@@ -182,6 +221,38 @@ func NewCaptureFromPB(pbCap *pb.BGPCapture) (*Capture, error) {
 	return cap, nil
 }
 
+// ToProtobuf returns a protobuf BGPCapture with the same values as this
+// capture. The AS path is returned as a single sequence segment, since
+// sets and sequences aren't distinguished once stored.
+func (c *Capture) ToProtobuf() *pb.BGPCapture {
+	pbCap := &pb.BGPCapture{}
+	pbCap.Timestamp = uint32(c.Timestamp.Unix())
+	pbCap.Peer_AS = c.PeerAS
+	pbCap.Local_IP = util.GetIPAsIPWrapper(c.ColIP)
+	pbCap.Peer_IP = util.GetIPAsIPWrapper(c.PeerIP)
+
+	update := &pbbgp.BGPUpdate{}
+	attrs := &pbbgp.BGPUpdate_Attributes{}
+	if len(c.ASPath) != 0 {
+		seq := make([]uint32, len(c.ASPath))
+		copy(seq, c.ASPath)
+		attrs.ASPath = []*pbbgp.BGPUpdate_ASPathSegment{{ASSeq: seq}}
+	}
+	attrs.NextHop = util.GetIPAsIPWrapper(c.NextHop)
+	update.Attrs = attrs
+
+	if len(c.Advertised) != 0 {
+		update.AdvertisedRoutes = &pbbgp.BGPUpdate_AdvertisedRoutes{Prefixes: util.GetIPNetsAsPrefixList(c.Advertised)}
+	}
+
+	if len(c.Withdrawn) != 0 {
+		update.WithdrawnRoutes = &pbbgp.BGPUpdate_WithdrawnRoutes{Prefixes: util.GetIPNetsAsPrefixList(c.Withdrawn)}
+	}
+	pbCap.Update = update
+
+	return pbCap
+}
+
 // CaptureTable represents a row in the main table. It describes
 // an existing table populated with BGPCaptures
 type CaptureTable struct {
@@ -190,12 +261,263 @@ type CaptureTable struct {
 	span      util.Timespan
 }
 
+// Scan populates this capture table from a row of the main table.
+func (ct *CaptureTable) Scan(rows *sql.Rows) error {
+	if err := rows.Scan(&ct.name, &ct.collector, &ct.span.Start, &ct.span.End); err != nil {
+		return err
+	}
+
+	// The dates are stored without a time zone, in UTC.
+	ct.span.Start = ct.span.Start.UTC()
+	ct.span.End = ct.span.End.UTC()
+	return nil
+}
+
+// Name returns the name of this table in the database.
+func (ct *CaptureTable) Name() string {
+	return ct.name
+}
+
+// Collector returns the name of the collector whose captures this table holds.
+func (ct *CaptureTable) Collector() string {
+	return ct.collector
+}
+
+// Span returns the window of time, in UTC, this table holds captures for.
+func (ct *CaptureTable) Span() util.Timespan {
+	return ct.span
+}
+
+// CaptureTableStats describes the contents of a capture table.
+type CaptureTableStats struct {
+	*CaptureTable
+
+	// Captures is the number of rows in the table, and SizeBytes its size
+	// on disk, including indexes.
+	Captures  int64
+	SizeBytes int64
+	// Hours is the number of hours of the table's span that have ended, and
+	// HoursWithData the number of those holding at least one capture.
+	Hours         int64
+	HoursWithData int64
+}
+
+// HasGaps returns true if an hour of the table's span that has ended holds
+// no captures.
+func (cs *CaptureTableStats) HasGaps() bool {
+	return cs.HoursWithData < cs.Hours
+}
+
+// countHours returns the number of hours in [start, end), counting the hour
+// start is in as a whole.
+func countHours(start, end time.Time) int64 {
+	start = start.Truncate(time.Hour)
+	if !start.Before(end) {
+		return 0
+	}
+	return int64((end.Sub(start) + time.Hour - 1) / time.Hour)
+}
+
+// CoverageBucket counts the captures of a collector in a single hour or day.
+// Buckets without captures aren't returned.
+type CoverageBucket struct {
+	Collector string
+	Start     time.Time
+	Captures  int64
+}
+
+// RollupGranularity is the length of the buckets captures are summarized
+// in. The values are the names postgres uses to truncate timestamps.
+type RollupGranularity string
+
+// These are the supported rollup granularities.
+const (
+	RollupHour = RollupGranularity("hour")
+	RollupDay  = RollupGranularity("day")
+)
+
+// ParseRollupGranularity returns the granularity named by s, or an error if
+// it isn't supported.
+func ParseRollupGranularity(s string) (RollupGranularity, error) {
+	switch g := RollupGranularity(s); g {
+	case RollupHour, RollupDay:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown rollup granularity: %s", s)
+	}
+}
+
+// Duration returns the length of a bucket of this granularity.
+func (g RollupGranularity) Duration() time.Duration {
+	if g == RollupDay {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// RollupKind describes what the captures of a rollup are grouped by.
+type RollupKind string
+
+// These are the kinds of rollups computed for each bucket. The key of a
+// total rollup is empty, the key of the others is the peer IP, the origin AS
+// or the prefix as text.
+const (
+	RollupTotal  = RollupKind("total")
+	RollupPeer   = RollupKind("peer")
+	RollupOrigin = RollupKind("origin")
+	RollupPrefix = RollupKind("prefix")
+)
+
+// Rollup represents a row in the rollup table. It summarizes the captures
+// of a collector in one bucket of time that share a key.
+type Rollup struct {
+	Collector   string
+	Granularity RollupGranularity
+	Bucket      time.Time
+	Kind        RollupKind
+	Key         string
+
+	// Updates is the number of captures. For prefix rollups, it's the
+	// number of captures announcing or withdrawing the prefix.
+	Updates int64
+	// Announcements and Withdrawals count prefixes, so a single capture
+	// can add more than one to each.
+	Announcements int64
+	Withdrawals   int64
+	// UniquePaths is the number of distinct AS paths announced.
+	UniquePaths int64
+}
+
+// Scan populates this rollup from a sql.Rows
+func (r *Rollup) Scan(rows *sql.Rows) error {
+	var granularity, kind string
+
+	err := rows.Scan(&r.Collector, &granularity, &r.Bucket, &kind, &r.Key, &r.Updates, &r.Announcements, &r.Withdrawals, &r.UniquePaths)
+	if err != nil {
+		return err
+	}
+	r.Granularity = RollupGranularity(granularity)
+	r.Kind = RollupKind(kind)
+	// Buckets are stored without a time zone, in UTC.
+	r.Bucket = r.Bucket.UTC()
+
+	return nil
+}
+
+// AnnouncementRatio returns the fraction of announced and withdrawn prefixes
+// that were announcements, or 0 if there were neither.
+func (r *Rollup) AnnouncementRatio() float64 {
+	total := r.Announcements + r.Withdrawals
+	if total == 0 {
+		return 0
+	}
+	return float64(r.Announcements) / float64(total)
+}
+
+// PeerEventType describes what happened to the BGP session between a
+// collector and a peer.
+type PeerEventType string
+
+// These are the types of peer events.
+const (
+	// PeerStateChange is a transition of the BGP state machine of the
+	// session, like from Established to Idle when the peer resets it.
+	PeerStateChange = PeerEventType("state_change")
+	// PeerOpen is an OPEN message from the peer.
+	PeerOpen = PeerEventType("open")
+	// PeerNotification is a NOTIFICATION message, closing the session with
+	// an error.
+	PeerNotification = PeerEventType("notification")
+	// PeerKeepaliveGap is a period without any message from the peer longer
+	// than its hold time, which should have closed the session.
+	PeerKeepaliveGap = PeerEventType("keepalive_gap")
+)
+
+// ParsePeerEventType returns the peer event type named by s, or an error if
+// there is none.
+func ParsePeerEventType(s string) (PeerEventType, error) {
+	switch t := PeerEventType(s); t {
+	case PeerStateChange, PeerOpen, PeerNotification, PeerKeepaliveGap:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown peer event type: %s", s)
+	}
+}
+
+// PeerEvent represents a row in the peer event table. Only the fields of its
+// type are set.
+type PeerEvent struct {
+	Timestamp time.Time
+	Type      PeerEventType
+	ColIP     net.IP
+	PeerIP    net.IP
+	PeerAS    uint32
+
+	// OldState and NewState are the names of the states of a state change,
+	// like Established.
+	OldState string
+	NewState string
+	// ErrorCode and ErrorSubcode are the error of a notification.
+	ErrorCode    uint8
+	ErrorSubcode uint8
+	// HoldTime is the hold time proposed by an open, or the one a keepalive
+	// gap exceeded.
+	HoldTime time.Duration
+	// Gap is how long a keepalive gap lasted.
+	Gap time.Duration
+}
+
+// Values returns an array of interfaces that can be passed to a SQLExecutor
+// to insert this PeerEvent.
+func (e *PeerEvent) Values() []interface{} {
+	return []interface{}{
+		e.Timestamp.UTC(),
+		string(e.Type),
+		util.IPString(e.ColIP),
+		util.IPString(e.PeerIP),
+		int64(e.PeerAS),
+		e.OldState,
+		e.NewState,
+		int(e.ErrorCode),
+		int(e.ErrorSubcode),
+		int(e.HoldTime / time.Second),
+		e.Gap.Seconds(),
+	}
+}
+
+// Scan populates this peer event from a sql.Rows
+func (e *PeerEvent) Scan(rows *sql.Rows) error {
+	var (
+		evType, colIP, peerIP string
+		peerAS                int64
+		code, subcode, hold   int
+		gap                   float64
+	)
+
+	err := rows.Scan(&e.Timestamp, &evType, &colIP, &peerIP, &peerAS, &e.OldState, &e.NewState, &code, &subcode, &hold, &gap)
+	if err != nil {
+		return err
+	}
+
+	// Timestamps are stored without a time zone, in UTC.
+	e.Timestamp = e.Timestamp.UTC()
+	e.Type = PeerEventType(evType)
+	e.ColIP = util.ParseIP(colIP)
+	e.PeerIP = util.ParseIP(peerIP)
+	e.PeerAS = uint32(peerAS)
+	e.ErrorCode, e.ErrorSubcode = uint8(code), uint8(subcode)
+	e.HoldTime = time.Duration(hold) * time.Second
+	e.Gap = time.Duration(gap * float64(time.Second))
+
+	return nil
+}
+
 // Entity represents a row in the entities table. It describes a party interested
 // in particular BGP data, like the owner of a prefix.
 type Entity struct {
 	Name          string
 	Email         string
-	OwnedOrigins  []int
+	OwnedOrigins  []uint32
 	OwnedPrefixes []*net.IPNet
 }
 
@@ -206,7 +528,7 @@ func (e *Entity) Values() []interface{} {
 	vals := make([]interface{}, 4)
 	vals[0] = util.SanitizeDBString(e.Name)
 	vals[1] = util.SanitizeDBString(e.Email)
-	vals[2] = pq.Array(e.OwnedOrigins)
+	vals[2] = asArray(e.OwnedOrigins)
 	vals[3] = pqPrefs
 
 	return vals
@@ -222,8 +544,14 @@ func (e *Entity) Scan(rows *sql.Rows) error {
 		return err
 	}
 
+	return e.scanEntityFields(originsStr, prefixStr)
+}
+
+// scanEntityFields fills the owned origins and prefixes of this entity from
+// the raw array columns of a row.
+func (e *Entity) scanEntityFields(originsStr, prefixStr sql.NullString) (err error) {
 	if originsStr.Valid {
-		e.OwnedOrigins, err = parseIntArray(originsStr.String)
+		e.OwnedOrigins, err = parseASArray(originsStr.String)
 		if err != nil {
 			return err
 		}
@@ -243,8 +571,36 @@ func (e *Entity) Scan(rows *sql.Rows) error {
 	return nil
 }
 
+// EntityChange represents a row in the entity history table. It describes the
+// state of an entity after an insert or update, or right before it was deleted.
+type EntityChange struct {
+	*Entity
+	Action string
+	Time   time.Time
+}
+
+// Scan populates this entity change from a sql.Rows
+func (ec *EntityChange) Scan(rows *sql.Rows) error {
+	var originsStr sql.NullString
+	var prefixStr sql.NullString
+	var email sql.NullString
+
+	if ec.Entity == nil {
+		ec.Entity = &Entity{}
+	}
+
+	err := rows.Scan(&ec.Name, &email, &originsStr, &prefixStr, &ec.Action, &ec.Time)
+	if err != nil {
+		return err
+	}
+	ec.Email = email.String
+
+	return ec.scanEntityFields(originsStr, prefixStr)
+}
+
 // ToProtobuf returns a protobuf Entity with the same
-// values as this entity
+// values as this entity. The protobuf stores origins as int32, so ASNs
+// above 2147483647 are carried as negative numbers with the same bits.
 func (e *Entity) ToProtobuf() *pb.Entity {
 	pbEnt := &pb.Entity{}
 	pbEnt.Name = e.Name
@@ -278,7 +634,9 @@ func NewEntityFromConfig(ec *config.EntityConfig) (e *Entity, err error) {
 	return
 }
 
-// NewEntityFromPB returns an Entity populated from a protobuf.
+// NewEntityFromPB returns an Entity populated from a protobuf. Negative
+// origins are read back as the uint32 ASN with the same bits, undoing the
+// conversion in ToProtobuf.
 func NewEntityFromPB(pbEnt *pb.Entity) (e *Entity, err error) {
 	if pbEnt == nil {
 		return nil, fmt.Errorf("nil pb.Entity")
@@ -287,9 +645,9 @@ func NewEntityFromPB(pbEnt *pb.Entity) (e *Entity, err error) {
 	e.Name = pbEnt.Name
 	e.Email = pbEnt.Email
 
-	e.OwnedOrigins = make([]int, len(pbEnt.OwnedOrigins))
+	e.OwnedOrigins = make([]uint32, len(pbEnt.OwnedOrigins))
 	for i, v := range pbEnt.OwnedOrigins {
-		e.OwnedOrigins[i] = int(v)
+		e.OwnedOrigins[i] = uint32(v)
 	}
 
 	e.OwnedPrefixes, err = util.GetPrefixListAsIPNet(pbEnt.OwnedPrefixes)
@@ -300,11 +658,11 @@ func NewEntityFromPB(pbEnt *pb.Entity) (e *Entity, err error) {
 	return e, nil
 }
 
-// parseIntArray takes a DB array string and returns an int array from that.
-// This is convenient since multiple structs might need an AS path or array
-// that needs to be parsed from the DB.
-func parseIntArray(arr string) ([]int, error) {
-	var ret []int
+// parseASArray takes a DB array string and returns an array of AS numbers
+// from that. This is convenient since multiple structs might need an AS path
+// or array that needs to be parsed from the DB.
+func parseASArray(arr string) ([]uint32, error) {
+	var ret []uint32
 
 	asArr := parseDBArray(arr)
 	if asArr == nil {
@@ -312,17 +670,27 @@ func parseIntArray(arr string) ([]int, error) {
 	}
 
 	for _, v := range asArr {
-		as, err := strconv.ParseInt(v, 10, 32)
+		as, err := strconv.ParseUint(v, 10, 32)
 		if err != nil {
 			return nil, err
 		}
 
-		ret = append(ret, int(as))
+		ret = append(ret, uint32(as))
 	}
 
 	return ret, nil
 }
 
+// asArray returns an array of AS numbers in a form that can be inserted in a
+// bigint[] column.
+func asArray(asns []uint32) interface{} {
+	ret := make(pq.Int64Array, len(asns))
+	for i, v := range asns {
+		ret[i] = int64(v)
+	}
+	return ret
+}
+
 func parsePrefixArray(arr string) ([]*net.IPNet, error) {
 	if arr == "" {
 		return nil, nil
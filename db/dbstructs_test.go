@@ -0,0 +1,214 @@
+package db
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/util"
+
+	"github.com/CSUNetSec/protoparse/fileutil"
+)
+
+func TestCaptureProtobufRoundTrip(t *testing.T) {
+	_, adv, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cap := &Capture{
+		Timestamp:  time.Date(2013, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Origin:     4200000000,
+		Advertised: []*net.IPNet{adv},
+		ASPath:     []uint32{3356, 174, 4200000000},
+		ColIP:      net.ParseIP("128.223.51.102").To4(),
+		PeerIP:     net.ParseIP("4.69.184.193").To4(),
+		PeerAS:     3356,
+		NextHop:    net.ParseIP("4.69.184.193").To4(),
+	}
+
+	parsed, err := NewCaptureFromPB(cap.ToProtobuf())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !parsed.Timestamp.Equal(cap.Timestamp) {
+		t.Fatalf("Expected timestamp: %s, Got: %s", cap.Timestamp, parsed.Timestamp)
+	}
+	parsed.Timestamp = cap.Timestamp
+
+	if !reflect.DeepEqual(cap, parsed) {
+		t.Fatalf("Expected: %+v, Got: %+v", cap, parsed)
+	}
+}
+
+func TestEntityProtobufRoundTrip(t *testing.T) {
+	ent := &Entity{
+		Name:          "test",
+		Email:         "test@example.com",
+		OwnedOrigins:  []uint32{65000, 2147483648, 4294967295},
+		OwnedPrefixes: []*net.IPNet{},
+	}
+
+	parsed, err := NewEntityFromPB(ent.ToProtobuf())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(ent.OwnedOrigins, parsed.OwnedOrigins) {
+		t.Fatalf("Expected: %v, Got: %v", ent.OwnedOrigins, parsed.OwnedOrigins)
+	}
+}
+
+func TestParseASArray(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   []uint32
+		isErr bool
+	}{
+		{"{}", nil, false},
+		{"{3356,174,65000}", []uint32{3356, 174, 65000}, false},
+		{"{4200000000,4294967295}", []uint32{4200000000, 4294967295}, false},
+		{"{4294967296}", nil, true},
+		{"{-1}", nil, true},
+	}
+
+	for _, v := range tests {
+		got, err := parseASArray(v.in)
+		if (err != nil) != v.isErr {
+			t.Errorf("Input: %s, Expected error: %t, Got: %v", v.in, v.isErr, err)
+			continue
+		}
+		if !v.isErr && !reflect.DeepEqual(got, v.out) {
+			t.Errorf("Input: %s, Expected: %v, Got: %v", v.in, v.out, got)
+		}
+	}
+}
+
+// TestIPv6CaptureRoundTrip reads the IPv6 sample MRT file, and makes sure the
+// address family of every address and prefix survives conversion to a Capture,
+// to the values stored in the DB, and back to a protobuf.
+func TestIPv6CaptureRoundTrip(t *testing.T) {
+	mf, err := fileutil.NewMrtFileReader("../docs/sample_mrt_v6", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.Close()
+
+	capCt := 0
+	for mf.Scan() {
+		pbCap, err := mf.GetCapture()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cap, err := NewCaptureFromPB(pbCap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		capCt++
+
+		for _, ip := range []net.IP{cap.ColIP, cap.PeerIP, cap.NextHop} {
+			if util.IsIPv4(ip) {
+				t.Errorf("[%d] Expected an IPv6 address, Got: %s", capCt, ip)
+			}
+		}
+
+		for _, pref := range append(cap.Advertised, cap.Withdrawn...) {
+			if util.IsIPv4Net(pref) {
+				t.Errorf("[%d] Expected an IPv6 prefix, Got: %s", capCt, pref)
+			}
+		}
+
+		vals := cap.Values()
+		if vals[2] != util.IPString(cap.PeerIP) || strings.Count(vals[2].(string), ":") == 0 {
+			t.Errorf("[%d] Peer IP stored as: %v", capCt, vals[2])
+		}
+
+		parsed, err := NewCaptureFromPB(cap.ToProtobuf())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cap, parsed) {
+			t.Errorf("[%d] Expected: %+v, Got: %+v", capCt, cap, parsed)
+		}
+	}
+
+	if capCt != 6 {
+		t.Fatalf("Expected 6 captures, Got: %d", capCt)
+	}
+}
+
+func TestCountHours(t *testing.T) {
+	base := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		start    time.Time
+		end      time.Time
+		expected int64
+	}{
+		{base, base.Add(24 * time.Hour), 24},
+		{base, base.Add(90 * time.Minute), 2},
+		{base.Add(30 * time.Minute), base.Add(time.Hour), 1},
+		{base, base, 0},
+		{base.Add(time.Hour), base, 0},
+	}
+
+	for _, v := range tests {
+		if ct := countHours(v.start, v.end); ct != v.expected {
+			t.Errorf("Span: %s - %s, Expected: %d, Got: %d", v.start, v.end, v.expected, ct)
+		}
+	}
+}
+
+func TestCaptureDedupValues(t *testing.T) {
+	_, adv, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCap := func() *Capture {
+		return &Capture{
+			Timestamp:  time.Date(2013, time.January, 1, 0, 0, 0, 1200, time.UTC),
+			Origin:     65002,
+			Advertised: []*net.IPNet{adv},
+			ASPath:     []uint32{65001, 65002},
+			ColIP:      net.ParseIP("128.223.51.102").To4(),
+			PeerIP:     net.ParseIP("4.69.184.193").To4(),
+			PeerAS:     65001,
+		}
+	}
+	hash := func(c *Capture) []byte {
+		vals := c.DedupValues()
+		if len(vals) != len(c.Values())+1 {
+			t.Fatalf("Expected the hash after every value, Got: %d values", len(vals))
+		}
+		return vals[len(vals)-1].([]byte)
+	}
+
+	orig := hash(newCap())
+	if h := hash(newCap()); !reflect.DeepEqual(orig, h) {
+		t.Errorf("Expected equal captures to have the same hash")
+	}
+
+	// A capture read back from its table has lost the nanoseconds.
+	read := newCap()
+	read.Timestamp = read.Timestamp.Truncate(time.Microsecond)
+	if h := hash(read); !reflect.DeepEqual(orig, h) {
+		t.Errorf("Expected a capture read back from the database to have the same hash")
+	}
+
+	other := newCap()
+	other.PeerAS = 65003
+	if h := hash(other); reflect.DeepEqual(orig, h) {
+		t.Errorf("Expected captures from different peers to have different hashes")
+	}
+
+	wdr := newCap()
+	wdr.Withdrawn, wdr.Advertised = wdr.Advertised, nil
+	if h := hash(wdr); reflect.DeepEqual(orig, h) {
+		t.Errorf("Expected a withdrawal and an advertisement to have different hashes")
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionDraining is returned when opening a stream on a session
+	// which is being drained.
+	ErrSessionDraining = errors.New("session is draining")
+
+	// ErrSessionExpired is returned when opening a stream on a session which
+	// expired, and is being closed.
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// DrainReport describes the write streams of a session which were still
+// open when its drain ran out of time. They are cancelled when the session
+// is closed, so Written is how many objects are lost.
+type DrainReport struct {
+	Streams int
+	Written int64
+}
+
+// streamTracker keeps track of the streams open on a session, so it can be
+// drained, and of when the session was last active.
+type streamTracker struct {
+	mu       sync.Mutex
+	draining bool
+	expired  bool
+	open     map[*trackedWriteStream]bool
+	reads    int
+	idle     chan struct{} // closed once a drain has no streams left to wait for
+	last     time.Time     // when a stream was last opened or closed, or the session touched
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{open: make(map[*trackedWriteStream]bool), last: time.Now()}
+}
+
+// touch records activity on the session.
+func (t *streamTracker) touch() {
+	t.mu.Lock()
+	t.last = time.Now()
+	t.mu.Unlock()
+}
+
+// activity returns how many streams are open, and when the session was
+// last active.
+func (t *streamTracker) activity() (int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.open) + t.reads, t.last
+}
+
+// refusal returns why new streams are refused, or nil if they aren't. The
+// mutex must be held.
+func (t *streamTracker) refusal() error {
+	if t.draining {
+		return ErrSessionDraining
+	}
+	if t.expired {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// expire refuses new streams if expired returns true for the activity of
+// the session, and returns whether it did. subs is how many subscriptions
+// the session has, which count as open streams. Since it's checked under
+// the mutex, no stream can be opened between the check and the refusal.
+func (t *streamTracker) expire(subs func() int, expired func(open int, last time.Time) bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.expired && expired(len(t.open)+t.reads+subs(), t.last) {
+		t.expired = true
+	}
+	return t.expired
+}
+
+// trackRead wraps a newly opened read stream, so it counts as activity
+// until it's closed. It returns why new streams are refused instead, if
+// they are, and the stream must be closed by the caller.
+func (t *streamTracker) trackRead(rs ReadStream) (ReadStream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.refusal(); err != nil {
+		return nil, err
+	}
+	t.reads++
+	t.last = time.Now()
+	return &trackedReadStream{ReadStream: rs, tracker: t}, nil
+}
+
+// track wraps a newly opened write stream, so it's waited for by a drain
+// until it's closed. It returns why new streams are refused instead, if
+// they are, and the stream must be cancelled by the caller.
+func (t *streamTracker) track(ws WriteStream) (WriteStream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.refusal(); err != nil {
+		return nil, err
+	}
+	tws := &trackedWriteStream{WriteStream: ws, tracker: t}
+	t.open[tws] = true
+	t.last = time.Now()
+
+	if wc, ok := ws.(WriteCounter); ok {
+		return countedWriteStream{trackedWriteStream: tws, WriteCounter: wc}, nil
+	}
+	return tws, nil
+}
+
+func (t *streamTracker) untrack(tws *trackedWriteStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.open, tws)
+	t.last = time.Now()
+	if len(t.open) == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// drain refuses new streams, and waits for the open ones to be closed
+// until ctx is done. It reports the streams still open at that point.
+func (t *streamTracker) drain(ctx context.Context) DrainReport {
+	t.mu.Lock()
+	t.draining = true
+	if len(t.open) == 0 {
+		t.mu.Unlock()
+		return DrainReport{}
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return DrainReport{}
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rep DrainReport
+	for tws := range t.open {
+		rep.Streams++
+		rep.Written += atomic.LoadInt64(&tws.pending)
+	}
+	return rep
+}
+
+// trackedWriteStream is a WriteStream which is tracked by its session until
+// it's closed. It counts the objects written since it was last flushed,
+// which would be lost if it was cancelled.
+type trackedWriteStream struct {
+	pending int64 // first, so it's aligned for atomic operations
+
+	WriteStream
+	tracker *streamTracker
+}
+
+func (tws *trackedWriteStream) Write(arg interface{}) error {
+	err := tws.WriteStream.Write(arg)
+	if err == nil {
+		atomic.AddInt64(&tws.pending, 1)
+	}
+	return err
+}
+
+func (tws *trackedWriteStream) Flush() error {
+	err := tws.WriteStream.Flush()
+	if err == nil {
+		atomic.StoreInt64(&tws.pending, 0)
+	}
+	return err
+}
+
+func (tws *trackedWriteStream) Cancel() {
+	tws.WriteStream.Cancel()
+	atomic.StoreInt64(&tws.pending, 0)
+}
+
+func (tws *trackedWriteStream) Close() {
+	tws.WriteStream.Close()
+	tws.tracker.untrack(tws)
+}
+
+// trackedReadStream is a ReadStream which counts as activity on its session
+// until it's closed.
+type trackedReadStream struct {
+	ReadStream
+	tracker *streamTracker
+	once    sync.Once
+}
+
+func (trs *trackedReadStream) Close() {
+	trs.ReadStream.Close()
+	trs.once.Do(func() {
+		trs.tracker.mu.Lock()
+		trs.tracker.reads--
+		trs.tracker.last = time.Now()
+		trs.tracker.mu.Unlock()
+	})
+}
+
+// countedWriteStream is a trackedWriteStream which is still a WriteCounter.
+type countedWriteStream struct {
+	*trackedWriteStream
+	WriteCounter
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// drainTestStream is a WriteStream which only counts calls.
+type drainTestStream struct {
+	written, flushed int
+	closed           bool
+}
+
+func (ws *drainTestStream) Write(interface{}) error { ws.written++; return nil }
+func (ws *drainTestStream) Flush() error            { ws.flushed++; return nil }
+func (ws *drainTestStream) Cancel()                 {}
+func (ws *drainTestStream) Close()                  { ws.closed = true }
+
+func TestStreamTrackerDrain(t *testing.T) {
+	tracker := newStreamTracker()
+	if rep := tracker.drain(context.Background()); rep.Streams != 0 {
+		t.Fatalf("Expected an idle tracker to drain at once, Got: %+v", rep)
+	}
+
+	tracker = newStreamTracker()
+	committed, _ := tracker.track(&drainTestStream{})
+	stuck, _ := tracker.track(&drainTestStream{})
+	if _, ok := committed.(WriteCounter); ok {
+		t.Errorf("Expected a tracked stream to only be a WriteCounter if it was one")
+	}
+	for i := 0; i < 3; i++ {
+		committed.Write(nil)
+		stuck.Write(nil)
+	}
+	committed.Flush()
+	stuck.Flush()
+	stuck.Write(nil)
+	stuck.Write(nil)
+
+	// The committed stream is closed while draining, the stuck one isn't.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		committed.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rep := tracker.drain(ctx)
+	if rep.Streams != 1 || rep.Written != 2 {
+		t.Errorf("Expected 1 stream with 2 uncommitted writes, Got: %+v", rep)
+	}
+	if _, err := tracker.track(&drainTestStream{}); err != ErrSessionDraining {
+		t.Errorf("Expected: %s, Got: %v", ErrSessionDraining, err)
+	}
+	if _, err := tracker.trackRead(&activityTestStream{}); err != ErrSessionDraining {
+		t.Errorf("Expected: %s, Got: %v", ErrSessionDraining, err)
+	}
+
+	// Once the last stream closes, a new drain returns at once.
+	stuck.Close()
+	if rep := tracker.drain(context.Background()); rep.Streams != 0 {
+		t.Errorf("Expected no streams left, Got: %+v", rep)
+	}
+}
+
+// activityTestStream is a ReadStream which returns nothing.
+type activityTestStream struct{}
+
+func (rs *activityTestStream) Read() bool        { return false }
+func (rs *activityTestStream) Data() interface{} { return nil }
+func (rs *activityTestStream) Bytes() []byte     { return nil }
+func (rs *activityTestStream) Err() error        { return nil }
+func (rs *activityTestStream) Close()            {}
+
+func TestStreamTrackerActivity(t *testing.T) {
+	tracker := newStreamTracker()
+	open, opened := tracker.activity()
+	if open != 0 || opened.IsZero() {
+		t.Fatalf("Expected a new tracker to be active now with no streams, Got: %d, %s", open, opened)
+	}
+
+	rs, _ := tracker.trackRead(&activityTestStream{})
+	ws, _ := tracker.track(&drainTestStream{})
+	if open, _ := tracker.activity(); open != 2 {
+		t.Errorf("Expected 2 open streams, Got: %d", open)
+	}
+
+	rs.Close()
+	rs.Close()
+	ws.Close()
+	open, last := tracker.activity()
+	if open != 0 {
+		t.Errorf("Expected no open streams, Got: %d", open)
+	}
+	if last.Before(opened) {
+		t.Errorf("Expected closing streams to be activity, Got: %s before %s", last, opened)
+	}
+}
+
+func TestStreamTrackerExpire(t *testing.T) {
+	tracker := newStreamTracker()
+	noSubs := func() int { return 0 }
+	idle := func(open int, last time.Time) bool { return open == 0 }
+
+	ws, _ := tracker.track(&drainTestStream{})
+	if tracker.expire(noSubs, idle) {
+		t.Fatalf("Expected a tracker with an open stream not to expire")
+	}
+	ws.Close()
+	if tracker.expire(func() int { return 1 }, idle) {
+		t.Fatalf("Expected a tracker with a subscription not to expire")
+	}
+
+	if !tracker.expire(noSubs, idle) {
+		t.Fatalf("Expected an idle tracker to expire")
+	}
+	if _, err := tracker.track(&drainTestStream{}); err != ErrSessionExpired {
+		t.Errorf("Expected: %s, Got: %v", ErrSessionExpired, err)
+	}
+	if _, err := tracker.trackRead(&activityTestStream{}); err != ErrSessionExpired {
+		t.Errorf("Expected: %s, Got: %v", ErrSessionExpired, err)
+	}
+}
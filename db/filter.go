@@ -14,6 +14,23 @@ const (
 	AnyCollector = "%"
 )
 
+// AddressFamily restricts a filter to prefixes of one IP version. The values
+// match those returned by the postgres family() function.
+type AddressFamily int
+
+// These are the address families a filter can be restricted to.
+const (
+	AnyFamily  = AddressFamily(0)
+	IPv4Family = AddressFamily(4)
+	IPv6Family = AddressFamily(6)
+)
+
+// familyCondition returns a condition that is true if any element of the
+// prefix array expression arr belongs to family f.
+func familyCondition(arr string, f AddressFamily) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM UNNEST(%s) AS famPrefix WHERE family(famPrefix) = %d)", arr, f)
+}
+
 type readFilter interface {
 	getWhereClause() string
 }
@@ -24,19 +41,49 @@ type FilterOptions interface{}
 
 // CaptureFilterOptions contains the options to filter by capture messages.
 type CaptureFilterOptions struct {
-	collector      string
-	span           util.Timespan
-	hasExtraFilter bool
+	collector string
+	span      util.Timespan
 
-	origin     int
+	origin     int64 // -1 if unset, otherwise a uint32 ASN
+	peerAS     int64
+	family     AddressFamily
 	advPrefs   []*net.IPNet
 	advSubnets []*net.IPNet
+
+	table       string // the only table read if set, whatever the collector and span
+	fromPrimary bool
+	ordered     bool // set by sharded sessions, which merge the captures of their shards by time
+}
+
+// SetTable restricts the stream to the capture table with this name, like
+// one returned by ListCaptureTables, and reads every capture in it
+// regardless of the time span. The collector is still used to find the
+// shard of the table on sharded sessions.
+func (cfo *CaptureFilterOptions) SetTable(name string) {
+	cfo.table = name
+}
+
+// SetFromPrimary makes the stream read from the primary database of the
+// session, even if it has read replicas, so no committed capture is missed.
+func (cfo *CaptureFilterOptions) SetFromPrimary() {
+	cfo.fromPrimary = true
 }
 
 // SetOrigin filters by the provided origin autonomous system (AS).
-func (cfo *CaptureFilterOptions) SetOrigin(as int) {
-	cfo.hasExtraFilter = true
-	cfo.origin = as
+func (cfo *CaptureFilterOptions) SetOrigin(as uint32) {
+	cfo.origin = int64(as)
+}
+
+// SetPeerAS filters by the AS of the peer the collector received the
+// capture from.
+func (cfo *CaptureFilterOptions) SetPeerAS(as uint32) {
+	cfo.peerAS = int64(as)
+}
+
+// SetFamily only allows captures that advertise or withdraw a prefix of the
+// provided address family. AnyFamily removes this restriction.
+func (cfo *CaptureFilterOptions) SetFamily(f AddressFamily) {
+	cfo.family = f
 }
 
 // AllowAdvPrefixes adds the provided prefixes to a list of prefixes to filter
@@ -46,7 +93,6 @@ func (cfo *CaptureFilterOptions) AllowAdvPrefixes(prefs ...*net.IPNet) {
 		return
 	}
 
-	cfo.hasExtraFilter = true
 	cfo.advPrefs = append(cfo.advPrefs, prefs...)
 }
 
@@ -54,26 +100,27 @@ func (cfo *CaptureFilterOptions) AllowAdvPrefixes(prefs ...*net.IPNet) {
 // prefix that falls under one of the provided prefixes.
 func (cfo *CaptureFilterOptions) AllowSubnets(prefs ...*net.IPNet) {
 	if len(prefs) != 0 {
-		cfo.hasExtraFilter = true
 		cfo.advSubnets = append(cfo.advSubnets, prefs...)
 	}
 }
 
 // NewCaptureFilterOptions returns a FilterOptions interface for filtering
-// captures.
+// captures. Only captures with a timestamp in [start, end) pass the filter,
+// regardless of the time zone of start and end.
 func NewCaptureFilterOptions(collector string, start time.Time, end time.Time) *CaptureFilterOptions {
 	cfo := &CaptureFilterOptions{
-		collector:      collector,
-		span:           util.Timespan{Start: start, End: end},
-		hasExtraFilter: false,
-		origin:         -1,
-		advPrefs:       nil,
+		collector: collector,
+		span:      util.Timespan{Start: start.UTC(), End: end.UTC()},
+		origin:    -1,
+		peerAS:    -1,
+		advPrefs:  nil,
 	}
 	return cfo
 }
 
 // DefaultCaptureFilterOptions returns a CaptureFilterOptions with any collector, and
-// time.Now() as the start and end date. This is unlikely to return any captures.
+// time.Now() as the start and end date. Since the window is empty, this doesn't
+// return any captures.
 func DefaultCaptureFilterOptions() *CaptureFilterOptions {
 	now := time.Now()
 	return NewCaptureFilterOptions(AnyCollector, now, now)
@@ -83,22 +130,32 @@ type captureFilter struct {
 	*CaptureFilterOptions
 }
 
-func (cf *captureFilter) getWhereClause() string {
-	if !cf.hasExtraFilter {
-		return ""
-	}
+// captureTimeFormat is the format of the timestamp literals in a capture
+// where clause. Captures are stored with microsecond precision.
+const captureTimeFormat = "2006-01-02 15:04:05.999999"
 
+// getWhereClause returns the clause to select captures from a single table.
+// Tables can hold captures outside of the filter's time span, so the
+// timestamp condition is present unless the filter reads a whole table.
+func (cf *captureFilter) getWhereClause() string {
 	doCrossJoin := false
 	var conditions []string
+	if cf.table == "" {
+		conditions = append(conditions, fmt.Sprintf("timestamp >= '%s' AND timestamp < '%s'",
+			cf.span.Start.UTC().Format(captureTimeFormat), cf.span.End.UTC().Format(captureTimeFormat)))
+	}
 
 	if cf.advPrefs != nil {
 		doCrossJoin = true
 		var prefStr []string
 		for _, v := range cf.advPrefs {
-			prefStr = append(prefStr, fmt.Sprintf("'%s'", v.String()))
+			prefStr = append(prefStr, fmt.Sprintf("'%s'", util.IPNetString(v)))
 		}
-		advCond := fmt.Sprintf("advPrefix IN (%s)", strings.Join(prefStr, ","))
-		conditions = append(conditions, advCond)
+		// The overlap condition matches the same rows, but it can use a GIN
+		// index on adv_prefixes.
+		prefList := strings.Join(prefStr, ",")
+		conditions = append(conditions, fmt.Sprintf("adv_prefixes && ARRAY[%s]::cidr[]", prefList))
+		conditions = append(conditions, fmt.Sprintf("advPrefix IN (%s)", prefList))
 	}
 
 	if cf.advSubnets != nil {
@@ -106,7 +163,7 @@ func (cf *captureFilter) getWhereClause() string {
 		var orConds []string
 
 		for _, v := range cf.advSubnets {
-			orConds = append(orConds, fmt.Sprintf("advPrefix <<= '%s'", v.String()))
+			orConds = append(orConds, fmt.Sprintf("advPrefix <<= '%s'", util.IPNetString(v)))
 		}
 		cond := fmt.Sprintf("(%s)", strings.Join(orConds, " OR "))
 		conditions = append(conditions, cond)
@@ -116,11 +173,22 @@ func (cf *captureFilter) getWhereClause() string {
 		conditions = append(conditions, fmt.Sprintf("origin_as = %d", cf.origin))
 	}
 
+	if cf.peerAS != -1 {
+		conditions = append(conditions, fmt.Sprintf("peer_as = %d", cf.peerAS))
+	}
+
+	if cf.family != AnyFamily {
+		conditions = append(conditions, familyCondition("adv_prefixes || wdr_prefixes", cf.family))
+	}
+
 	crossJoin := ""
 	if doCrossJoin {
 		crossJoin = "CROSS JOIN UNNEST(adv_prefixes) as advPrefix"
 	}
 
+	if len(conditions) == 0 {
+		return crossJoin
+	}
 	return fmt.Sprintf("%s WHERE %s", crossJoin, strings.Join(conditions, " AND "))
 }
 
@@ -144,9 +212,38 @@ func newCaptureFilter(opts FilterOptions) (*captureFilter, error) {
 	return &captureFilter{CaptureFilterOptions: capOpts}, nil
 }
 
-// EntityFilterOptions holds all the fields to filter entities.
+// EntityFilterOptions holds all the fields to filter entities. Every option
+// that is set must match for an entity to pass the filter.
 type EntityFilterOptions struct {
-	name string
+	name        string
+	origin      int64 // -1 if unset, otherwise a uint32 ASN
+	family      AddressFamily
+	ownedPrefs  []*net.IPNet
+	emailDomain string
+}
+
+// SetOwnedOrigin will only allow entities which own the provided origin
+// autonomous system (AS).
+func (efo *EntityFilterOptions) SetOwnedOrigin(as uint32) {
+	efo.origin = int64(as)
+}
+
+// SetFamily will only allow entities which own a prefix of the provided
+// address family. AnyFamily removes this restriction.
+func (efo *EntityFilterOptions) SetFamily(f AddressFamily) {
+	efo.family = f
+}
+
+// AllowOwnersOf will allow entities that own one of the provided prefixes,
+// or a prefix that covers one of them.
+func (efo *EntityFilterOptions) AllowOwnersOf(prefs ...*net.IPNet) {
+	efo.ownedPrefs = append(efo.ownedPrefs, prefs...)
+}
+
+// SetEmailDomain will only allow entities whose email belongs to the provided
+// domain, such as "example.com".
+func (efo *EntityFilterOptions) SetEmailDomain(domain string) {
+	efo.emailDomain = strings.TrimPrefix(domain, "@")
 }
 
 type entityFilter struct {
@@ -158,7 +255,37 @@ func (e *entityFilter) getWhereClause() string {
 		return ""
 	}
 
-	return fmt.Sprintf("WHERE name='%s'", e.name)
+	var conditions []string
+	if e.name != "" {
+		conditions = append(conditions, fmt.Sprintf("name='%s'", util.SanitizeDBString(e.name)))
+	}
+
+	if e.origin != -1 {
+		conditions = append(conditions, fmt.Sprintf("%d = ANY(knownorigins)", e.origin))
+	}
+
+	if e.ownedPrefs != nil {
+		var orConds []string
+		for _, v := range e.ownedPrefs {
+			orConds = append(orConds, fmt.Sprintf("ownedPrefix >>= '%s'", util.IPNetString(v)))
+		}
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM UNNEST(ownedprefixes) AS ownedPrefix WHERE %s)", strings.Join(orConds, " OR "))
+		conditions = append(conditions, cond)
+	}
+
+	if e.family != AnyFamily {
+		conditions = append(conditions, familyCondition("ownedprefixes", e.family))
+	}
+
+	if e.emailDomain != "" {
+		conditions = append(conditions, fmt.Sprintf("email LIKE '%%@%s'", util.SanitizeDBString(e.emailDomain)))
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("WHERE %s", strings.Join(conditions, " AND "))
 }
 
 func newEntityFilter(fo FilterOptions) (*entityFilter, error) {
@@ -172,13 +299,143 @@ func newEntityFilter(fo FilterOptions) (*entityFilter, error) {
 		entOpts = fo.(*EntityFilterOptions)
 		break
 	default:
-		return nil, fmt.Errorf("Need CaptureFilterOptions")
+		return nil, fmt.Errorf("Need EntityFilterOptions")
 	}
 
 	return &entityFilter{EntityFilterOptions: entOpts}, nil
 }
 
-// NewEntityFilterOptions returns FilterOptions for an Entity
+// NewEntityFilterOptions returns FilterOptions for an Entity. If name is
+// empty, entities are not filtered by name.
 func NewEntityFilterOptions(name string) *EntityFilterOptions {
-	return &EntityFilterOptions{name: name}
+	return &EntityFilterOptions{name: name, origin: -1}
+}
+
+// RollupFilterOptions holds the fields to filter rollups. Only rollups of
+// one granularity with a bucket starting in [start, end) pass the filter.
+type RollupFilterOptions struct {
+	collector   string
+	granularity RollupGranularity
+	span        util.Timespan
+	kind        RollupKind
+	key         string
+}
+
+// SetKind will only allow rollups of the provided kind. An empty kind
+// removes this restriction.
+func (rfo *RollupFilterOptions) SetKind(k RollupKind) {
+	rfo.kind = k
+}
+
+// SetKey will only allow rollups with the provided key, like a peer IP,
+// origin AS or prefix. An empty key removes this restriction.
+func (rfo *RollupFilterOptions) SetKey(key string) {
+	rfo.key = key
+}
+
+// NewRollupFilterOptions returns FilterOptions for rollups of a collector,
+// which may be AnyCollector.
+func NewRollupFilterOptions(collector string, g RollupGranularity, start, end time.Time) *RollupFilterOptions {
+	return &RollupFilterOptions{
+		collector:   collector,
+		granularity: g,
+		span:        util.Timespan{Start: start.UTC(), End: end.UTC()},
+	}
+}
+
+type rollupFilter struct {
+	*RollupFilterOptions
+}
+
+func (rf *rollupFilter) getWhereClause() string {
+	conditions := []string{
+		fmt.Sprintf("collector LIKE '%s'", util.SanitizeDBString(rf.collector)),
+		fmt.Sprintf("granularity = '%s'", util.SanitizeDBString(string(rf.granularity))),
+		fmt.Sprintf("bucket >= '%s' AND bucket < '%s'",
+			rf.span.Start.Format(captureTimeFormat), rf.span.End.Format(captureTimeFormat)),
+	}
+
+	if rf.kind != "" {
+		conditions = append(conditions, fmt.Sprintf("kind = '%s'", util.SanitizeDBString(string(rf.kind))))
+	}
+
+	if rf.key != "" {
+		conditions = append(conditions, fmt.Sprintf("key = '%s'", util.SanitizeDBString(rf.key)))
+	}
+
+	return fmt.Sprintf("WHERE %s", strings.Join(conditions, " AND "))
+}
+
+func newRollupFilter(fo FilterOptions) (*rollupFilter, error) {
+	rollOpts, ok := fo.(*RollupFilterOptions)
+	if !ok || rollOpts == nil {
+		return nil, fmt.Errorf("Need RollupFilterOptions")
+	}
+
+	return &rollupFilter{RollupFilterOptions: rollOpts}, nil
+}
+
+// PeerEventFilterOptions holds the fields to filter peer events. Only events
+// in [start, end) pass the filter.
+type PeerEventFilterOptions struct {
+	collector net.IP
+	peer      net.IP
+	span      util.Timespan
+	types     []PeerEventType
+}
+
+// SetPeer will only allow events of the session with the provided peer. A
+// nil IP removes this restriction.
+func (pfo *PeerEventFilterOptions) SetPeer(ip net.IP) {
+	pfo.peer = ip
+}
+
+// AllowTypes will only allow events of the provided types. Calling it with
+// no types removes this restriction.
+func (pfo *PeerEventFilterOptions) AllowTypes(types ...PeerEventType) {
+	pfo.types = types
+}
+
+// NewPeerEventFilterOptions returns FilterOptions for the peer events of a
+// collector. If collector is nil, events of every collector pass.
+func NewPeerEventFilterOptions(collector net.IP, start, end time.Time) *PeerEventFilterOptions {
+	return &PeerEventFilterOptions{collector: collector, span: util.Timespan{Start: start.UTC(), End: end.UTC()}}
+}
+
+type peerEventFilter struct {
+	*PeerEventFilterOptions
+}
+
+func (pf *peerEventFilter) getWhereClause() string {
+	conditions := []string{
+		fmt.Sprintf("timestamp >= '%s' AND timestamp < '%s'",
+			pf.span.Start.Format(captureTimeFormat), pf.span.End.Format(captureTimeFormat)),
+	}
+
+	if pf.collector != nil {
+		conditions = append(conditions, fmt.Sprintf("collector_ip = '%s'", util.IPString(pf.collector)))
+	}
+
+	if pf.peer != nil {
+		conditions = append(conditions, fmt.Sprintf("peer_ip = '%s'", util.IPString(pf.peer)))
+	}
+
+	if len(pf.types) != 0 {
+		var types []string
+		for _, t := range pf.types {
+			types = append(types, fmt.Sprintf("'%s'", util.SanitizeDBString(string(t))))
+		}
+		conditions = append(conditions, fmt.Sprintf("type IN (%s)", strings.Join(types, ", ")))
+	}
+
+	return fmt.Sprintf("WHERE %s", strings.Join(conditions, " AND "))
+}
+
+func newPeerEventFilter(fo FilterOptions) (*peerEventFilter, error) {
+	evOpts, ok := fo.(*PeerEventFilterOptions)
+	if !ok || evOpts == nil {
+		return nil, fmt.Errorf("Need PeerEventFilterOptions")
+	}
+
+	return &peerEventFilter{PeerEventFilterOptions: evOpts}, nil
 }
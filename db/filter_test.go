@@ -0,0 +1,242 @@
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+func TestEntityFilterWhereClause(t *testing.T) {
+	_, pref, err := net.ParseCIDR("10.1.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameOnly := NewEntityFilterOptions("test1")
+
+	all := NewEntityFilterOptions("")
+	all.SetOwnedOrigin(65000)
+	all.AllowOwnersOf(pref)
+	all.SetEmailDomain("@example.com")
+
+	tests := []struct {
+		opts     FilterOptions
+		expected string
+	}{
+		{nil, ""},
+		{NewEntityFilterOptions(""), ""},
+		{nameOnly, "WHERE name='test1'"},
+		{all, "WHERE 65000 = ANY(knownorigins) AND EXISTS (SELECT 1 FROM UNNEST(ownedprefixes) AS ownedPrefix " +
+			"WHERE ownedPrefix >>= '10.1.2.0/24') AND email LIKE '%@example.com'"},
+	}
+
+	for _, v := range tests {
+		filt, err := newEntityFilter(v.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if clause := filt.getWhereClause(); clause != v.expected {
+			t.Fatalf("Expected: %s, Got: %s", v.expected, clause)
+		}
+	}
+
+	if _, err := newEntityFilter(DefaultCaptureFilterOptions()); err == nil {
+		t.Fatalf("Expected error creating an entity filter from capture options")
+	}
+}
+
+func TestCaptureFilterWhereClause(t *testing.T) {
+	_, pref, err := net.ParseCIDR("10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2013, time.January, 1, 10, 15, 0, 0, time.UTC)
+	end := time.Date(2013, time.January, 1, 10, 45, 0, 0, time.UTC)
+	window := "timestamp >= '2013-01-01 10:15:00' AND timestamp < '2013-01-01 10:45:00'"
+
+	peerOnly := NewCaptureFilterOptions(AnyCollector, start, end)
+	peerOnly.SetPeerAS(3356)
+
+	combined := NewCaptureFilterOptions(AnyCollector, start, end)
+	combined.SetOrigin(65000)
+	combined.SetPeerAS(3356)
+	combined.AllowSubnets(pref)
+
+	exact := NewCaptureFilterOptions(AnyCollector, start, end)
+	exact.AllowAdvPrefixes(pref)
+
+	table := NewCaptureFilterOptions("routeviews2", start, end)
+	table.SetTable("routeviews2_20130101_100000")
+
+	tablePeer := NewCaptureFilterOptions("routeviews2", start, end)
+	tablePeer.SetTable("routeviews2_20130101_100000")
+	tablePeer.SetPeerAS(3356)
+
+	tests := []struct {
+		opts     *CaptureFilterOptions
+		expected string
+	}{
+		{NewCaptureFilterOptions(AnyCollector, start, end), " WHERE " + window},
+		{peerOnly, " WHERE " + window + " AND peer_as = 3356"},
+		{combined, "CROSS JOIN UNNEST(adv_prefixes) as advPrefix WHERE " + window +
+			" AND (advPrefix <<= '10.1.0.0/16') AND origin_as = 65000 AND peer_as = 3356"},
+		{exact, "CROSS JOIN UNNEST(adv_prefixes) as advPrefix WHERE " + window +
+			" AND adv_prefixes && ARRAY['10.1.0.0/16']::cidr[] AND advPrefix IN ('10.1.0.0/16')"},
+		{table, ""},
+		{tablePeer, " WHERE peer_as = 3356"},
+	}
+
+	for _, v := range tests {
+		filt, err := newCaptureFilter(v.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if clause := filt.getWhereClause(); clause != v.expected {
+			t.Fatalf("Expected: %s, Got: %s", v.expected, clause)
+		}
+	}
+}
+
+func TestFamilyFilterWhereClause(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	capOpts := NewCaptureFilterOptions(AnyCollector, start, start.Add(time.Hour))
+	capOpts.SetFamily(IPv6Family)
+
+	capFilt, err := newCaptureFilter(capOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := " WHERE timestamp >= '2019-06-01 00:00:00' AND timestamp < '2019-06-01 01:00:00' AND EXISTS (SELECT 1 FROM UNNEST(adv_prefixes || wdr_prefixes) AS famPrefix WHERE family(famPrefix) = 6)"
+	if clause := capFilt.getWhereClause(); clause != expected {
+		t.Fatalf("Expected: %s, Got: %s", expected, clause)
+	}
+
+	_, pref, err := net.ParseCIDR("2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entOpts := NewEntityFilterOptions("")
+	entOpts.SetFamily(IPv4Family)
+	entOpts.AllowOwnersOf(pref)
+
+	entFilt, err := newEntityFilter(entOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "WHERE EXISTS (SELECT 1 FROM UNNEST(ownedprefixes) AS ownedPrefix WHERE ownedPrefix >>= '2001:db8::/32') AND " +
+		"EXISTS (SELECT 1 FROM UNNEST(ownedprefixes) AS famPrefix WHERE family(famPrefix) = 4)"
+	if clause := entFilt.getWhereClause(); clause != expected {
+		t.Fatalf("Expected: %s, Got: %s", expected, clause)
+	}
+}
+
+func TestCaptureFilterTimeZones(t *testing.T) {
+	denver, err := time.LoadLocation("America/Denver")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %s", err)
+	}
+
+	tests := []struct {
+		start, end time.Time
+		expected   string
+	}{
+		// Sub-second precision is kept.
+		{time.Date(2013, time.January, 1, 23, 59, 59, 500000000, time.UTC), time.Date(2013, time.January, 2, 0, 0, 0, 0, time.UTC),
+			" WHERE timestamp >= '2013-01-01 23:59:59.5' AND timestamp < '2013-01-02 00:00:00'"},
+		// Local times are converted to UTC.
+		{time.Date(2019, time.June, 1, 10, 15, 0, 0, denver), time.Date(2019, time.June, 1, 10, 45, 0, 0, denver),
+			" WHERE timestamp >= '2019-06-01 16:15:00' AND timestamp < '2019-06-01 16:45:00'"},
+		// A window spanning the start of DST is an hour long, not two.
+		{time.Date(2019, time.March, 10, 1, 30, 0, 0, denver), time.Date(2019, time.March, 10, 3, 30, 0, 0, denver),
+			" WHERE timestamp >= '2019-03-10 08:30:00' AND timestamp < '2019-03-10 09:30:00'"},
+		// A window spanning the end of DST is three hours long, not two.
+		{time.Date(2019, time.November, 3, 0, 30, 0, 0, denver), time.Date(2019, time.November, 3, 2, 30, 0, 0, denver),
+			" WHERE timestamp >= '2019-11-03 06:30:00' AND timestamp < '2019-11-03 09:30:00'"},
+	}
+
+	for _, v := range tests {
+		filt, err := newCaptureFilter(NewCaptureFilterOptions(AnyCollector, v.start, v.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if clause := filt.getWhereClause(); clause != v.expected {
+			t.Errorf("Expected: %s, Got: %s", v.expected, clause)
+		}
+	}
+}
+
+func TestRollupFilterWhereClause(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	window := "bucket >= '2019-06-01 00:00:00' AND bucket < '2019-06-02 00:00:00'"
+
+	peerOpts := NewRollupFilterOptions("testcollector6", RollupHour, start, start.Add(24*time.Hour))
+	peerOpts.SetKind(RollupPeer)
+	peerOpts.SetKey("2001:db8::2")
+
+	tests := []struct {
+		opts     *RollupFilterOptions
+		expected string
+	}{
+		{NewRollupFilterOptions(AnyCollector, RollupDay, start, start.Add(24*time.Hour)),
+			"WHERE collector LIKE '%' AND granularity = 'day' AND " + window},
+		{peerOpts, "WHERE collector LIKE 'testcollector6' AND granularity = 'hour' AND " + window +
+			" AND kind = 'peer' AND key = '2001:db8::2'"},
+	}
+
+	for _, v := range tests {
+		filt, err := newRollupFilter(v.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if clause := filt.getWhereClause(); clause != v.expected {
+			t.Fatalf("Expected: %s, Got: %s", v.expected, clause)
+		}
+	}
+
+	if _, err := newRollupFilter(DefaultCaptureFilterOptions()); err == nil {
+		t.Fatalf("Expected error creating a rollup filter from capture options")
+	}
+}
+
+func TestPeerEventFilterWhereClause(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	window := "timestamp >= '2019-06-01 00:00:00' AND timestamp < '2019-06-02 00:00:00'"
+
+	peerOpts := NewPeerEventFilterOptions(util.ParseIP("2001:db8::1"), start, start.Add(24*time.Hour))
+	peerOpts.SetPeer(util.ParseIP("192.0.2.1"))
+	peerOpts.AllowTypes(PeerStateChange, PeerNotification)
+
+	tests := []struct {
+		opts     *PeerEventFilterOptions
+		expected string
+	}{
+		{NewPeerEventFilterOptions(nil, start, start.Add(24*time.Hour)), "WHERE " + window},
+		{peerOpts, "WHERE " + window + " AND collector_ip = '2001:db8::1' AND peer_ip = '192.0.2.1'" +
+			" AND type IN ('state_change', 'notification')"},
+	}
+
+	for _, v := range tests {
+		filt, err := newPeerEventFilter(v.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if clause := filt.getWhereClause(); clause != v.expected {
+			t.Fatalf("Expected: %s, Got: %s", v.expected, clause)
+		}
+	}
+
+	if _, err := newPeerEventFilter(DefaultCaptureFilterOptions()); err == nil {
+		t.Fatalf("Expected error creating a peer event filter from capture options")
+	}
+}
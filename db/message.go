@@ -86,6 +86,21 @@ func (n nodeMessage) getNodeIP() string {
 	return n.nodeIP
 }
 
+type nodeConfMessage struct {
+	CommonMessage
+	conf config.NodeConfig
+}
+
+// newNodeConfMessage creates a message carrying a single node configuration
+// to be added to, or updated in, the node table.
+func newNodeConfMessage(nc config.NodeConfig) nodeConfMessage {
+	return nodeConfMessage{CommonMessage: newMessage(), conf: nc}
+}
+
+func (n nodeConfMessage) getNodeConf() config.NodeConfig {
+	return n.conf
+}
+
 type capTableMessage struct {
 	CommonMessage
 	tableName string
@@ -227,6 +242,19 @@ func (c capTableReply) getDates() (start time.Time, end time.Time) {
 	return c.start, c.end
 }
 
+type capTablesReply struct {
+	CommonReply
+	tables []*CaptureTable
+}
+
+func newCapTablesReply(tables []*CaptureTable, err error) capTablesReply {
+	return capTablesReply{CommonReply: newReply(err), tables: tables}
+}
+
+func (c capTablesReply) getTables() []*CaptureTable {
+	return c.tables
+}
+
 type tableReply struct {
 	CommonReply
 	name  string
@@ -329,6 +357,19 @@ func newEntityReply(e *Entity, err error) *entityReply {
 	return &entityReply{CommonReply: newReply(err), entity: e}
 }
 
+type entityChangeReply struct {
+	CommonReply
+	change *EntityChange
+}
+
+func (ecr *entityChangeReply) getChange() *EntityChange {
+	return ecr.change
+}
+
+func newEntityChangeReply(ec *EntityChange, err error) *entityChangeReply {
+	return &entityChangeReply{CommonReply: newReply(err), change: ec}
+}
+
 type filterMessage struct {
 	CommonMessage
 
@@ -342,3 +383,113 @@ func (fm *filterMessage) getFilter() readFilter {
 func newFilterMessage(rf readFilter) *filterMessage {
 	return &filterMessage{CommonMessage: newMessage(), rf: rf}
 }
+
+type rollupMessage struct {
+	CommonMessage
+	collector   string
+	granularity RollupGranularity
+	span        util.Timespan
+}
+
+// newRollupMessage creates a message requesting the rollup of a collector's
+// captures in [start, end) into buckets of granularity g.
+func newRollupMessage(collector string, g RollupGranularity, start, end time.Time) *rollupMessage {
+	return &rollupMessage{
+		CommonMessage: newMessage(),
+		collector:     collector,
+		granularity:   g,
+		span:          util.Timespan{Start: start, End: end},
+	}
+}
+
+func (rm *rollupMessage) getCollector() string {
+	return rm.collector
+}
+
+func (rm *rollupMessage) getGranularity() RollupGranularity {
+	return rm.granularity
+}
+
+func (rm *rollupMessage) getSpan() util.Timespan {
+	return rm.span
+}
+
+type rollupReply struct {
+	CommonReply
+	rollup *Rollup
+}
+
+func (rr *rollupReply) getRollup() *Rollup {
+	return rr.rollup
+}
+
+func newRollupReply(r *Rollup, err error) *rollupReply {
+	return &rollupReply{CommonReply: newReply(err), rollup: r}
+}
+
+type captureStatsReply struct {
+	CommonReply
+	stats []*CaptureTableStats
+}
+
+func newCaptureStatsReply(stats []*CaptureTableStats, err error) captureStatsReply {
+	return captureStatsReply{CommonReply: newReply(err), stats: stats}
+}
+
+func (c captureStatsReply) getStats() []*CaptureTableStats {
+	return c.stats
+}
+
+type coverageReply struct {
+	CommonReply
+	buckets []*CoverageBucket
+}
+
+func newCoverageReply(buckets []*CoverageBucket, err error) coverageReply {
+	return coverageReply{CommonReply: newReply(err), buckets: buckets}
+}
+
+func (c coverageReply) getBuckets() []*CoverageBucket {
+	return c.buckets
+}
+
+type peerEventMessage struct {
+	CommonMessage
+	ev *PeerEvent
+}
+
+func newPeerEventMessage(ev *PeerEvent) *peerEventMessage {
+	return &peerEventMessage{CommonMessage: newMessage(), ev: ev}
+}
+
+func (pm *peerEventMessage) getEvent() *PeerEvent {
+	return pm.ev
+}
+
+// insertReply reports whether an insert skipped its row, because it was a
+// duplicate of a stored one.
+type insertReply struct {
+	CommonReply
+	skipped bool
+}
+
+func newInsertReply(skipped bool, err error) *insertReply {
+	return &insertReply{CommonReply: newReply(err), skipped: skipped}
+}
+
+func (ir *insertReply) isSkipped() bool {
+	return ir.skipped
+}
+
+type peerEventReply struct {
+	CommonReply
+	ev *PeerEvent
+}
+
+func (pr *peerEventReply) getEvent() *PeerEvent {
+	return pr.ev
+}
+
+func newPeerEventReply(ev *PeerEvent, err error) *peerEventReply {
+	return &peerEventReply{CommonReply: newReply(err), ev: ev}
+}
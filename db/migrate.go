@@ -0,0 +1,268 @@
+package db
+
+import (
+	"fmt"
+)
+
+// migration is a schema change applied to a table at most once. Once it's
+// applied, its name is recorded for that table in the migration table, so
+// opening a session doesn't run it again. Its statement takes the table name
+// as its only argument, and must still be safe to run more than once, since
+// two sessions may apply it at the same time.
+type migration struct {
+	name string
+	op   dbOp
+
+	// retry, if it's set, is called before op, to clean up after an
+	// attempt which failed part way.
+	retry func(ex SessionExecutor, table string) error
+}
+
+// captureMigrations are applied to every registered capture table when a
+// session opens, to bring tables created by older versions of bgpmon up to
+// date with makeCaptureTableOp.
+var captureMigrations = []migration{
+	{name: "add_peer_as", op: addPeerASColumnOp},
+	{name: "add_content_hash", op: addContentHashColumnOp},
+	{name: "index_content_hash", op: indexContentHashOp, retry: dropInvalidContentHashIndex},
+}
+
+// offlineCaptureMigrations rewrite every row of the capture tables they're
+// applied to, locking each table until it's done, so they aren't applied
+// when a session opens. They're applied by Session.MigrateCaptureTables,
+// which bgpmond -migrate runs while the daemon is stopped. Until then,
+// opening a session logs how many tables still need them.
+var offlineCaptureMigrations = []migration{
+	{name: "widen_asn", op: widenCaptureASNOp},
+}
+
+// entityMigrations are applied to the entity table and its history table
+// when a session opens, under the same rules as captureMigrations.
+var entityMigrations = []migration{
+	{name: "widen_asn", op: widenEntityASNOp},
+}
+
+// dropInvalidContentHashIndex drops the content hash index of table if a
+// concurrent build of it failed, since indexContentHashOp would skip it.
+func dropInvalidContentHashIndex(ex SessionExecutor, table string) error {
+	idx := table + "_content_hash_key"
+
+	invalid := false
+	if err := ex.QueryRow(ex.getQuery(invalidIndexOp), idx).Scan(&invalid); err != nil {
+		return err
+	}
+	if !invalid {
+		return nil
+	}
+
+	_, err := ex.Exec(fmt.Sprintf(ex.getQuery(dropIndexOp), idx))
+	return err
+}
+
+// peerEventMigrations are applied to the peer event table when a session
+// opens, under the same rules as captureMigrations.
+var peerEventMigrations = []migration{
+	{name: "unique_peer_events", op: uniquePeerEventsOp},
+}
+
+// migrationTable returns the name of the table recording the migrations
+// applied to the tables of a main table.
+func migrationTable(mainTable string) string {
+	return mainTable + migrationSuffix
+}
+
+// appliedMigrations maps a table name to the names of the migrations which
+// were applied to it.
+type appliedMigrations map[string]map[string]bool
+
+// pending returns the migrations of migs which weren't applied to table yet.
+func (am appliedMigrations) pending(table string, migs []migration) []migration {
+	var ret []migration
+	for _, m := range migs {
+		if !am[table][m.name] {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// listAppliedMigrations reads the migration table of mainTable.
+func listAppliedMigrations(ex SessionExecutor, mainTable string) (appliedMigrations, error) {
+	rows, err := ex.Query(fmt.Sprintf(ex.getQuery(listMigrationsOp), migrationTable(mainTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	am := make(appliedMigrations)
+	for rows.Next() {
+		tName, name := "", ""
+		if err := rows.Scan(&tName, &name); err != nil {
+			return nil, err
+		}
+		if am[tName] == nil {
+			am[tName] = make(map[string]bool)
+		}
+		am[tName][name] = true
+	}
+	return am, rows.Err()
+}
+
+// applyMigrations runs migs on table, recording each one once it succeeds.
+func applyMigrations(ex SessionExecutor, mainTable, table string, migs []migration) error {
+	for _, m := range migs {
+		if m.retry != nil {
+			if err := m.retry(ex, table); err != nil {
+				return fmt.Errorf("%s: %s", m.name, err)
+			}
+		}
+		if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(m.op), table)); err != nil {
+			return fmt.Errorf("%s: %s", m.name, err)
+		}
+		if _, err := ex.Exec(fmt.Sprintf(ex.getQuery(recordMigrationOp), migrationTable(mainTable)), table, m.name); err != nil {
+			return fmt.Errorf("recording %s: %s", m.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateCaptureTables applies the pending captureMigrations to all capture
+// tables listed in the main table. A table that fails to migrate is logged
+// and skipped, so one broken table doesn't prevent a session from opening.
+func migrateCaptureTables(ex SessionExecutor, msg CommonMessage) CommonReply {
+	tables, err := listCaptureTables(ex, msg.GetMainTable())
+	if err != nil {
+		return newReply(dbLogger.Errorf("migrateCaptureTables: %s", err))
+	}
+	applied, err := listAppliedMigrations(ex, msg.GetMainTable())
+	if err != nil {
+		return newReply(dbLogger.Errorf("migrateCaptureTables: %s", err))
+	}
+
+	migrated := 0
+	for _, tName := range tables {
+		migs := applied.pending(tName, captureMigrations)
+		if len(migs) == 0 {
+			continue
+		}
+		if err := applyMigrations(ex, msg.GetMainTable(), tName, migs); err != nil {
+			dbLogger.Errorf("Error migrating capture table %s: %s", tName, err)
+			continue
+		}
+		migrated++
+	}
+	dbLogger.Infof("migrated %d of %d capture tables", migrated, len(tables))
+
+	offline := 0
+	for _, tName := range tables {
+		if len(applied.pending(tName, offlineCaptureMigrations)) != 0 {
+			offline++
+		}
+	}
+	if offline != 0 {
+		dbLogger.Errorf("%d capture tables need offline migrations, and can't store ASNs of 2^31 or more until bgpmond -migrate is run", offline)
+	}
+
+	return newReply(nil)
+}
+
+// recordNewCaptureTable records every capture migration as applied to a
+// capture table which was just created, since makeCaptureTableOp is already
+// up to date.
+func recordNewCaptureTable(ex SessionExecutor, mainTable, table string) error {
+	stmt := fmt.Sprintf(ex.getQuery(recordMigrationOp), migrationTable(mainTable))
+	for _, m := range append(captureMigrations, offlineCaptureMigrations...) {
+		if _, err := ex.Exec(stmt, table, m.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCaptureTablesOffline applies the pending offlineCaptureMigrations
+// to all capture tables listed in the main table, and returns how many
+// tables were migrated. Unlike when a session opens, it stops at the first
+// table which fails to migrate.
+func migrateCaptureTablesOffline(ex SessionExecutor, mainTable string) (int, error) {
+	tables, err := listCaptureTables(ex, mainTable)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := listAppliedMigrations(ex, mainTable)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, tName := range tables {
+		migs := applied.pending(tName, offlineCaptureMigrations)
+		if len(migs) == 0 {
+			continue
+		}
+		dbLogger.Infof("migrating capture table %s", tName)
+		if err := applyMigrations(ex, mainTable, tName, migs); err != nil {
+			return migrated, fmt.Errorf("capture table %s: %s", tName, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// migrateEntityTables applies the pending entityMigrations to the entity
+// table and its history table. Unlike capture tables, a failure here is
+// returned, since every entity operation depends on them.
+func migrateEntityTables(ex SessionExecutor, msg CommonMessage) CommonReply {
+	applied, err := listAppliedMigrations(ex, msg.GetMainTable())
+	if err != nil {
+		return newReply(dbLogger.Errorf("migrateEntityTables: %s", err))
+	}
+
+	tables := []string{msg.GetEntityTable(), entityHistoryTable(msg.GetEntityTable())}
+	for _, tName := range tables {
+		migs := applied.pending(tName, entityMigrations)
+		if err := applyMigrations(ex, msg.GetMainTable(), tName, migs); err != nil {
+			return newReply(dbLogger.Errorf("Error migrating entity table %s: %s", tName, err))
+		}
+	}
+
+	return newReply(nil)
+}
+
+// migratePeerEventTable applies the pending peerEventMigrations to the peer
+// event table. A failure is logged, since peer events can still be written
+// and read without them. The unique index can't be built while the table
+// holds duplicate events, which then have to be removed by hand.
+func migratePeerEventTable(ex SessionExecutor, msg CommonMessage) CommonReply {
+	applied, err := listAppliedMigrations(ex, msg.GetMainTable())
+	if err != nil {
+		return newReply(dbLogger.Errorf("migratePeerEventTable: %s", err))
+	}
+
+	tName := peerEventTable(msg.GetMainTable())
+	migs := applied.pending(tName, peerEventMigrations)
+	if err := applyMigrations(ex, msg.GetMainTable(), tName, migs); err != nil {
+		dbLogger.Errorf("Error migrating peer event table %s: %s", tName, err)
+	}
+
+	return newReply(nil)
+}
+
+// listCaptureTables returns the names of all capture tables registered in
+// the main table.
+func listCaptureTables(ex SessionExecutor, mainTable string) ([]string, error) {
+	rows, err := ex.Query(fmt.Sprintf(ex.getQuery(listCaptureTablesOp), mainTable))
+	if err != nil {
+		return nil, err
+	}
+	defer closeRowsAndLog(rows)
+
+	var tables []string
+	for rows.Next() {
+		tName := ""
+		if err := rows.Scan(&tName); err != nil {
+			return nil, err
+		}
+		tables = append(tables, tName)
+	}
+	return tables, rows.Err()
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppliedMigrationsPending(t *testing.T) {
+	migs := []migration{{name: "first", op: addPeerASColumnOp}, {name: "second", op: widenCaptureASNOp}}
+	applied := appliedMigrations{
+		"done":    {"first": true, "second": true},
+		"partial": {"first": true},
+		"other":   {"unknown": true},
+	}
+
+	tests := []struct {
+		table string
+		want  []migration
+	}{
+		{"done", nil},
+		{"partial", migs[1:]},
+		{"other", migs},
+		{"new", migs},
+	}
+	for _, v := range tests {
+		if got := applied.pending(v.table, migs); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("Table %s: Expected: %v, Got: %v", v.table, v.want, got)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package db
 
 import (
 	"context"
+
+	"github.com/CSUNetSec/bgpmon/util"
+
+	"github.com/golang/protobuf/proto"
 )
 
 type readCapStream struct {
@@ -41,12 +45,19 @@ func (rcs *readCapStream) Data() interface{} {
 	return capMsg.getCapture()
 }
 
+// Bytes returns the last capture serialized as a protobuf BGPCapture.
 func (rcs *readCapStream) Bytes() []byte {
 	if rcs.lastRep == nil {
 		return nil
 	}
 
-	return []byte{}
+	capMsg := rcs.lastRep.(*getCapReply)
+	data, err := proto.Marshal(capMsg.getCapture().ToProtobuf())
+	if err != nil {
+		dbLogger.Errorf("Error marshalling capture: %s", err)
+		return nil
+	}
+	return data
 }
 
 func (rcs *readCapStream) Err() error {
@@ -81,7 +92,7 @@ func newReadCapStream(parStream *sessionStream, pCancel chan bool, fo FilterOpti
 		return nil, err
 	}
 
-	ex := newSessionExecutor(r.db.DB(), r.oper)
+	ex := r.executor()
 	filtMsg := newFilterMessage(filt)
 	// Make sure this message uses the same tables as the schema
 	r.schema.setMessageTables(filtMsg)
@@ -134,7 +145,7 @@ func (rps *readPrefixStream) Bytes() []byte {
 	}
 
 	prefRep := rps.lastRep.(*getPrefixReply)
-	return []byte(prefRep.getPrefix().String())
+	return []byte(util.IPNetString(prefRep.getPrefix()))
 }
 
 func (rps *readPrefixStream) Err() error {
@@ -164,7 +175,7 @@ func newReadPrefixStream(parStream *sessionStream, pCancel chan bool, fo FilterO
 		cf()
 	}(pCancel, r.cancel, cf)
 
-	ex := newSessionExecutor(r.db.DB(), r.oper)
+	ex := r.executor()
 
 	filt, err := newCaptureFilter(fo)
 	if err != nil {
@@ -181,7 +192,7 @@ func newReadPrefixStream(parStream *sessionStream, pCancel chan bool, fo FilterO
 type readEntityStream struct {
 	*sessionStream
 
-	lastRep *Entity
+	lastRep interface{}
 	lastErr error
 
 	cancel chan bool
@@ -200,11 +211,17 @@ func (es *readEntityStream) Read() bool {
 		return false
 	}
 
-	entRep := rep.(*entityReply)
-	es.lastRep = entRep.getEntity()
+	switch entRep := rep.(type) {
+	case *entityReply:
+		es.lastRep = entRep.getEntity()
+	case *entityChangeReply:
+		es.lastRep = entRep.getChange()
+	}
 	return true
 }
 
+// Data returns an *Entity for entity streams, and an *EntityChange for
+// entity history streams.
 func (es *readEntityStream) Data() interface{} {
 	return es.lastRep
 }
@@ -222,7 +239,11 @@ func (es *readEntityStream) Close() {
 	es.wp.Done()
 }
 
-func newReadEntityStream(baseStream *sessionStream, pCancel chan bool, fo FilterOptions) (*readEntityStream, error) {
+// entityStreamFunc is the signature of the db operations that can back a
+// readEntityStream.
+type entityStreamFunc func(context.Context, SessionExecutor, CommonMessage) chan CommonReply
+
+func newReadEntityStream(baseStream *sessionStream, pCancel chan bool, fo FilterOptions, streamFunc entityStreamFunc) (*readEntityStream, error) {
 	es := &readEntityStream{sessionStream: baseStream}
 	es.cancel = make(chan bool)
 	es.lastRep = nil
@@ -239,7 +260,7 @@ func newReadEntityStream(baseStream *sessionStream, pCancel chan bool, fo Filter
 		cf()
 	}(pCancel, es.cancel, cf)
 
-	ex := newSessionExecutor(es.db.DB(), es.oper)
+	ex := es.executor()
 
 	filt, err := newEntityFilter(fo)
 	if err != nil {
@@ -250,6 +271,160 @@ func newReadEntityStream(baseStream *sessionStream, pCancel chan bool, fo Filter
 	// Make sure this message uses the same tables as the schema
 	es.schema.setMessageTables(filtMsg)
 
-	es.dbResp = getEntityStream(ctx, ex, filtMsg)
+	es.dbResp = streamFunc(ctx, ex, filtMsg)
 	return es, nil
 }
+
+type readRollupStream struct {
+	*sessionStream
+
+	lastRep *Rollup
+	lastErr error
+
+	cancel chan bool
+	dbResp chan CommonReply
+}
+
+func (rs *readRollupStream) Read() bool {
+	rep, ok := <-rs.dbResp
+	if !ok {
+		rs.lastErr = nil
+		return false
+	}
+
+	if rep.Error() != nil {
+		rs.lastErr = rep.Error()
+		return false
+	}
+
+	rs.lastRep = rep.(*rollupReply).getRollup()
+	return true
+}
+
+// Data returns a *Rollup.
+func (rs *readRollupStream) Data() interface{} {
+	if rs.lastRep == nil {
+		return nil
+	}
+	return rs.lastRep
+}
+
+func (rs *readRollupStream) Bytes() []byte {
+	return []byte{}
+}
+
+func (rs *readRollupStream) Err() error {
+	return rs.lastErr
+}
+
+func (rs *readRollupStream) Close() {
+	close(rs.cancel)
+	rs.wp.Done()
+}
+
+func newReadRollupStream(baseStream *sessionStream, pCancel chan bool, fo FilterOptions) (*readRollupStream, error) {
+	rs := &readRollupStream{sessionStream: baseStream}
+	rs.cancel = make(chan bool)
+
+	filt, err := newRollupFilter(fo)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cf := context.WithCancel(context.Background())
+	go func(par chan bool, child chan bool, cf context.CancelFunc) {
+		select {
+		case <-par:
+			break
+		case <-child:
+			break
+		}
+		cf()
+	}(pCancel, rs.cancel, cf)
+
+	ex := rs.executor()
+
+	filtMsg := newFilterMessage(filt)
+	// Make sure this message uses the same tables as the schema
+	rs.schema.setMessageTables(filtMsg)
+
+	rs.dbResp = getRollupStream(ctx, ex, filtMsg)
+	return rs, nil
+}
+
+type readPeerEventStream struct {
+	*sessionStream
+
+	lastRep *PeerEvent
+	lastErr error
+
+	cancel chan bool
+	dbResp chan CommonReply
+}
+
+func (ps *readPeerEventStream) Read() bool {
+	rep, ok := <-ps.dbResp
+	if !ok {
+		ps.lastErr = nil
+		return false
+	}
+
+	if rep.Error() != nil {
+		ps.lastErr = rep.Error()
+		return false
+	}
+
+	ps.lastRep = rep.(*peerEventReply).getEvent()
+	return true
+}
+
+// Data returns a *PeerEvent.
+func (ps *readPeerEventStream) Data() interface{} {
+	if ps.lastRep == nil {
+		return nil
+	}
+	return ps.lastRep
+}
+
+func (ps *readPeerEventStream) Bytes() []byte {
+	return []byte{}
+}
+
+func (ps *readPeerEventStream) Err() error {
+	return ps.lastErr
+}
+
+func (ps *readPeerEventStream) Close() {
+	close(ps.cancel)
+	ps.wp.Done()
+}
+
+func newReadPeerEventStream(baseStream *sessionStream, pCancel chan bool, fo FilterOptions) (*readPeerEventStream, error) {
+	ps := &readPeerEventStream{sessionStream: baseStream}
+	ps.cancel = make(chan bool)
+
+	filt, err := newPeerEventFilter(fo)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cf := context.WithCancel(context.Background())
+	go func(par chan bool, child chan bool, cf context.CancelFunc) {
+		select {
+		case <-par:
+			break
+		case <-child:
+			break
+		}
+		cf()
+	}(pCancel, ps.cancel, cf)
+
+	ex := ps.executor()
+
+	filtMsg := newFilterMessage(filt)
+	// Make sure this message uses the same tables as the schema
+	ps.schema.setMessageTables(filtMsg)
+
+	ps.dbResp = getPeerEventStream(ctx, ex, filtMsg)
+	return ps, nil
+}
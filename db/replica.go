@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// replicaCheckInterval is how often the health and lag of read replicas is
+// checked.
+const replicaCheckInterval = 10 * time.Second
+
+// replica is a read-only copy of a session's database.
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy bool
+	lag     time.Duration
+}
+
+// replicaSet spreads the read streams of a session over its replicas, round
+// robin, skipping the ones which are unreachable or lag too far behind the
+// primary.
+type replicaSet struct {
+	dbo    queryProvider
+	maxLag time.Duration
+
+	mu       sync.Mutex
+	replicas []*replica
+	next     int
+}
+
+// newReplicaSet returns a replicaSet of the provided databases, keyed by
+// host. None of them are read from until they have been checked.
+func newReplicaSet(dbo queryProvider, maxLag time.Duration, hosts []string, dbs []*sql.DB) *replicaSet {
+	rs := &replicaSet{dbo: dbo, maxLag: maxLag}
+	for i := range hosts {
+		rs.replicas = append(rs.replicas, &replica{host: hosts[i], db: dbs[i]})
+	}
+	return rs
+}
+
+// pick returns the next healthy replica, or nil if there are none.
+func (rs *replicaSet) pick() *replica {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for i := 0; i < len(rs.replicas); i++ {
+		r := rs.replicas[rs.next]
+		rs.next = (rs.next + 1) % len(rs.replicas)
+		if r.healthy {
+			return r
+		}
+	}
+	return nil
+}
+
+// check measures the lag of every replica, and updates which ones can be
+// read from.
+func (rs *replicaSet) check() {
+	for _, r := range rs.replicas {
+		lag, err := replicaLag(r.db, rs.dbo)
+		healthy := err == nil && lag <= rs.maxLag
+
+		rs.mu.Lock()
+		changed := healthy != r.healthy
+		r.healthy, r.lag = healthy, lag
+		rs.mu.Unlock()
+
+		switch {
+		case !changed:
+		case err != nil:
+			dbLogger.Errorf("Read replica %s is unavailable: %s", r.host, err)
+		case !healthy:
+			dbLogger.Infof("Read replica %s is %s behind, which is more than %s", r.host, lag, rs.maxLag)
+		default:
+			dbLogger.Infof("Read replica %s is available, %s behind", r.host, lag)
+		}
+	}
+}
+
+// close closes the connections to every replica.
+func (rs *replicaSet) close() {
+	for _, r := range rs.replicas {
+		if err := r.db.Close(); err != nil {
+			dbLogger.Errorf("Error closing read replica %s: %s", r.host, err)
+		}
+	}
+}
+
+// replicaLag returns how far behind its primary a replica is.
+func replicaLag(db *sql.DB, dbo queryProvider) (time.Duration, error) {
+	ctx, cf := context.WithTimeout(context.Background(), replicaCheckInterval)
+	defer cf()
+
+	var (
+		inRecovery bool
+		lag        sql.NullFloat64
+	)
+	if err := db.QueryRowContext(ctx, dbo.getQuery(replicaLagOp)).Scan(&inRecovery, &lag); err != nil {
+		return 0, err
+	}
+	return checkReplicaLag(inRecovery, lag)
+}
+
+// checkReplicaLag returns the lag of a host from the result of replicaLagOp,
+// or an error if it can't be read from as a replica.
+func checkReplicaLag(inRecovery bool, lag sql.NullFloat64) (time.Duration, error) {
+	// A host which isn't in recovery is a primary, possibly another one
+	// than the session writes to, so reads from it could miss writes.
+	if !inRecovery {
+		return 0, fmt.Errorf("host isn't a replica")
+	}
+	if !lag.Valid {
+		return 0, fmt.Errorf("replica hasn't replayed any transactions")
+	}
+	return time.Duration(lag.Float64 * float64(time.Second)), nil
+}
+
+// isUndefinedTable returns whether err is the postgres error for a table
+// which doesn't exist.
+func isUndefinedTable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "42P01"
+}
+
+// replicaDB is the TimeoutDBer of a read stream on a replica. It's also the
+// SQLExecutor the stream queries with, which queries the primary instead when
+// a table doesn't exist on the replica yet.
+type replicaDB struct {
+	db      *sql.DB
+	host    string
+	primary *sql.DB
+	timeout time.Duration
+}
+
+// Exec satisfies the SQLExecutor interface on a replicaDB. Read streams
+// don't modify the database, so it's run on the replica.
+func (r *replicaDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.db.Exec(query, args...)
+}
+
+// Query satisfies the SQLExecutor interface on a replicaDB.
+func (r *replicaDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := r.db.Query(query, args...)
+	if isUndefinedTable(err) {
+		dbLogger.Infof("Read replica %s is missing a table, reading it from the primary: %s", r.host, err)
+		return r.primary.Query(query, args...)
+	}
+	return rows, err
+}
+
+// QueryRow satisfies the SQLExecutor interface on a replicaDB. Its error
+// isn't known until the row is scanned, so it's only run on the replica.
+func (r *replicaDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRow(query, args...)
+}
+
+// DB satisfies the DBer interface on a replicaDB.
+func (r *replicaDB) DB() *sql.DB {
+	return r.db
+}
+
+// GetTimeout satisfies the GetTimeouter interface on a replicaDB.
+func (r *replicaDB) GetTimeout() time.Duration {
+	return r.timeout
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestReplicaSetPick(t *testing.T) {
+	rs := newReplicaSet(newPostgressQueryProvider(), time.Minute, []string{"r1", "r2", "r3"}, make([]*sql.DB, 3))
+	if r := rs.pick(); r != nil {
+		t.Fatalf("Expected no replica before the first check, Got: %s", r.host)
+	}
+
+	rs.replicas[0].healthy = true
+	rs.replicas[2].healthy = true
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, rs.pick().host)
+	}
+	expected := []string{"r1", "r3", "r1", "r3"}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected: %v, Got: %v", expected, got)
+		}
+	}
+}
+
+func TestCheckReplicaLag(t *testing.T) {
+	tests := []struct {
+		inRecovery bool
+		lag        sql.NullFloat64
+		expected   time.Duration
+		fail       bool
+	}{
+		{true, sql.NullFloat64{Float64: 1.5, Valid: true}, 1500 * time.Millisecond, false},
+		{true, sql.NullFloat64{Float64: 0, Valid: true}, 0, false},
+		{true, sql.NullFloat64{}, 0, true},
+		// A primary is never read from as a replica, however its lag reads.
+		{false, sql.NullFloat64{Float64: 0, Valid: true}, 0, true},
+		{false, sql.NullFloat64{}, 0, true},
+	}
+
+	for _, v := range tests {
+		lag, err := checkReplicaLag(v.inRecovery, v.lag)
+		if (err != nil) != v.fail {
+			t.Errorf("In recovery: %v, lag: %v, Expected failure: %v, Got: %v", v.inRecovery, v.lag, v.fail, err)
+		} else if lag != v.expected {
+			t.Errorf("In recovery: %v, lag: %v, Expected: %s, Got: %s", v.inRecovery, v.lag, v.expected, lag)
+		}
+	}
+}
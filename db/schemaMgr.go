@@ -20,6 +20,15 @@ const (
 	mgrGetNodeOp
 	mgrSyncNodesOp
 	mgrGetTableOp
+	mgrListNodesOp
+	mgrAddNodeOp
+	mgrUpdateNodeOp
+	mgrDeleteNodeOp
+	mgrMigrateOp
+	mgrListCaptureTablesOp
+	mgrDropCaptureTableOp
+	mgrClearCacheOp
+	mgrRemoteChangeOp
 )
 
 type schemaMgr struct {
@@ -32,6 +41,13 @@ type schemaMgr struct {
 	mainTable   string
 	nodeTable   string
 	entityTable string
+
+	// indexes are the columns indexed on every new capture table.
+	indexes []string
+
+	// coord is nil if the schema manager doesn't share its database with
+	// other sessions.
+	coord *catalogCoordinator
 }
 
 func (s *schemaMgr) getCommonMessage() CommonMessage {
@@ -44,8 +60,9 @@ func (s *schemaMgr) setMessageTables(cm CommonMessage) {
 	cm.SetEntityTable(s.entityTable)
 }
 
-// This function launches the run method in a separate goroutine
-func newSchemaMgr(sEx SessionExecutor, main, node, entity string) *schemaMgr {
+// This function launches the run method in a separate goroutine. indexes
+// must have been checked with checkCaptureIndexes. coord may be nil.
+func newSchemaMgr(sEx SessionExecutor, main, node, entity string, indexes []string, coord *catalogCoordinator) *schemaMgr {
 	sm := &schemaMgr{
 		req:         make(chan schemaMessage),
 		resp:        make(chan CommonReply),
@@ -55,6 +72,8 @@ func newSchemaMgr(sEx SessionExecutor, main, node, entity string) *schemaMgr {
 		mainTable:   main,
 		nodeTable:   node,
 		entityTable: entity,
+		indexes:     indexes,
+		coord:       coord,
 	}
 	sm.daemonWG.Add(1)
 	go sm.run()
@@ -85,10 +104,12 @@ func (s *schemaMgr) run() {
 			case mgrSyncNodesOp:
 				sLogger.Infof("syncing node configs")
 				ret = syncNodes(s.sEx, cmd.getMessage())
+				s.cache.clear()
+				s.notifyChange()
 			case mgrGetNodeOp:
 				sLogger.Infof("getting node name")
 				nMsg := cmd.getMessage().(nodeMessage)
-				nodeIP := net.ParseIP(nMsg.getNodeIP())
+				nodeIP := util.ParseIP(nMsg.getNodeIP())
 				node, err := s.cache.LookupNode(nodeIP)
 				if err == errNoNode {
 					sLogger.Infof("Node cache miss. Looking up node for IP: %s", nMsg.getNodeIP())
@@ -105,7 +126,7 @@ func (s *schemaMgr) run() {
 				tMsg := cmd.getMessage().(tableMessage)
 				colIP := tMsg.getColIP()
 				date := tMsg.getDate()
-				tName, err := s.cache.LookupTable(net.ParseIP(colIP), date)
+				tName, span, err := s.cache.LookupTable(util.ParseIP(colIP), date)
 				if err == errNoNode {
 					ret = newReply(err)
 				} else if err == errNoTable {
@@ -114,11 +135,58 @@ func (s *schemaMgr) run() {
 					if ret.Error() != nil {
 						sLogger.Errorf("schemaMgr: %s", ret.Error())
 					} else {
-						s.cache.addTable(net.ParseIP(colIP), date)
+						// This may be an older table than the node's duration
+						// would create, so it's cached under its own window.
+						tRep := ret.(tableReply)
+						start, end := tRep.getDates()
+						s.cache.addTable(util.ParseIP(colIP), tRep.getName(), util.Timespan{Start: start, End: end})
 					}
 				} else {
-					ret = newTableReply(tName, time.Now(), time.Now(), nil, nil)
+					ret = newTableReply(tName, span.Start, span.End, nil, nil)
+				}
+			case mgrMigrateOp:
+				sLogger.Infof("migrating entity, peer event and capture tables")
+				ret = migrateEntityTables(s.sEx, cmd.getMessage())
+				if ret.Error() == nil {
+					ret = migratePeerEventTable(s.sEx, cmd.getMessage())
 				}
+				if ret.Error() == nil {
+					ret = migrateCaptureTables(s.sEx, cmd.getMessage())
+				}
+			case mgrListCaptureTablesOp:
+				ret = listCaptureTableInfo(s.sEx, cmd.getMessage())
+			case mgrDropCaptureTableOp:
+				sLogger.Infof("dropping capture table")
+				ret = dropCaptureTable(s.sEx, cmd.getMessage())
+				// Even on failure the table may be gone, so it's safest
+				// to look every table up again.
+				s.cache.clear()
+				s.notifyChange()
+			case mgrClearCacheOp:
+				s.cache.clear()
+				s.notifyChange()
+				ret = newReply(nil)
+			case mgrRemoteChangeOp:
+				sLogger.Infof("catalog changed by another session, clearing cache")
+				s.cache.clear()
+				ret = newReply(nil)
+			case mgrListNodesOp:
+				ret = listNodes(s.sEx, cmd.getMessage())
+			case mgrAddNodeOp:
+				sLogger.Infof("adding node")
+				ret = addNode(s.sEx, cmd.getMessage())
+				s.cache.clear()
+				s.notifyChange()
+			case mgrUpdateNodeOp:
+				sLogger.Infof("updating node")
+				ret = updateNode(s.sEx, cmd.getMessage())
+				s.cache.clear()
+				s.notifyChange()
+			case mgrDeleteNodeOp:
+				sLogger.Infof("deleting node")
+				ret = deleteNode(s.sEx, cmd.getMessage())
+				s.cache.clear()
+				s.notifyChange()
 			default:
 				ret = newReply(fmt.Errorf("unhandled schema manager command:%+v", cmd))
 			}
@@ -138,25 +206,44 @@ func (s *schemaMgr) makeCapTable(msg CommonMessage) CommonReply {
 		nodeIP := tMsg.getColIP()
 		date := tMsg.getDate()
 
-		node, err := s.cache.LookupNode(net.ParseIP(nodeIP))
+		node, err := s.cache.LookupNode(util.ParseIP(nodeIP))
 		if err != nil {
 			return newReply(err)
 		}
 		dur := time.Duration(node.duration) * time.Minute
-		start := date.Truncate(dur)
+		start := date.Truncate(dur).UTC()
 
 		tName := genTableName(node.name, date, node.duration)
 		cMsg := newCapTableMessage(tName, node.name, start, start.Add(dur))
 		// Make sure this message uses the same tables as the schema
 		s.setMessageTables(cMsg)
 
-		capRep := createCaptureTable(s.sEx, cMsg).(capTableReply)
+		var capRep CommonReply
+		if s.coord != nil {
+			capRep = s.createCapTableLocked(msg, cMsg)
+		} else {
+			capRep = createCaptureTable(s.sEx, cMsg)
+		}
 		if err := capRep.Error(); err != nil {
 			return newReply(fmt.Errorf("makeCapTable: %s", err))
 		}
 
-		start, end := capRep.getDates()
-		res = newTableReply(capRep.getName(), start, end, node, nil)
+		// Another session registered a table first, which may have a
+		// different window.
+		if tRep, ok := capRep.(tableReply); ok {
+			start, end := tRep.getDates()
+			return newTableReply(tRep.getName(), start, end, node, nil)
+		}
+		cRep := capRep.(capTableReply)
+
+		// The table is usable without its indexes, and they can be created
+		// later with IndexCaptureTables, so this isn't fatal.
+		if err := createCaptureIndexes(s.sEx, cRep.getName(), s.indexes); err != nil {
+			sLogger.Errorf("makeCapTable: %s", err)
+		}
+
+		start, end := cRep.getDates()
+		res = newTableReply(cRep.getName(), start, end, node, nil)
 	} else if err != nil {
 		return newReply(fmt.Errorf("makeCapTable: %s", err))
 	} else {
@@ -167,6 +254,56 @@ func (s *schemaMgr) makeCapTable(msg CommonMessage) CommonReply {
 	return res
 }
 
+// createCapTableLocked creates and registers the capture table in cMsg while
+// holding an advisory lock on its collector, so only one session creates a
+// table for a collector at a time. If a table for msg was registered while
+// waiting on the lock, it returns that table's tableReply instead of a
+// capTableReply.
+func (s *schemaMgr) createCapTableLocked(msg CommonMessage, cMsg capTableMessage) CommonReply {
+	ctxEx, err := newCtxExecutor(s.coord.tdb)
+	if err != nil {
+		return newReply(dbLogger.Errorf("createCapTableLocked begin: %s", err))
+	}
+	ex := newSessionExecutor(ctxEx, s.sEx.queryProvider)
+
+	if _, err := ex.Exec(ex.getQuery(lockCollectorOp), cMsg.getTableCol()); err != nil {
+		rollbackAndLog(ctxEx, "createCapTableLocked")
+		return newReply(dbLogger.Errorf("createCapTableLocked lock: %s", err))
+	}
+
+	var rep CommonReply
+	if tRep := getTable(ex, msg); tRep.Error() != errNoTable {
+		rep = tRep
+	} else {
+		rep = createCaptureTable(ex, cMsg)
+	}
+	if rep.Error() != nil {
+		rollbackAndLog(ctxEx, "createCapTableLocked")
+		return rep
+	}
+
+	if err := ctxEx.Commit(); err != nil {
+		return newReply(dbLogger.Errorf("createCapTableLocked commit: %s", err))
+	}
+	return rep
+}
+
+// rollbackAndLog rolls back a transaction which failed in the function
+// named by op.
+func rollbackAndLog(ctxEx *ctxExecutor, op string) {
+	if err := ctxEx.Rollback(); err != nil {
+		dbLogger.Errorf("%s rollback error: %s", op, err)
+	}
+}
+
+// notifyChange tells other sessions sharing the database that their cached
+// tables and nodes may be stale.
+func (s *schemaMgr) notifyChange() {
+	if s.coord != nil {
+		s.coord.notify()
+	}
+}
+
 // Below this are the schema manager client functions, called by the session streams
 
 // This doesn't need a dedicated close channel. With the way we use it,
@@ -190,6 +327,13 @@ func (s *schemaMgr) makeSchema() error {
 	return sreply.Error()
 }
 
+func (s *schemaMgr) migrate() error {
+	cmdin := newSchemaMessage(s.getCommonMessage(), mgrMigrateOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	return sreply.Error()
+}
+
 func (s *schemaMgr) syncNodes(knownNodes map[string]config.NodeConfig) (map[string]config.NodeConfig, error) {
 	nMsg := newNodesMessage(knownNodes)
 	s.setMessageTables(nMsg)
@@ -233,14 +377,94 @@ func (s *schemaMgr) getNode(nodeName string, nodeIP string) (*node, error) {
 	return nRep.getNode(), nRep.Error()
 }
 
+func (s *schemaMgr) listNodes() (map[string]config.NodeConfig, error) {
+	cmdin := newSchemaMessage(s.getCommonMessage(), mgrListNodesOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	nRep := sreply.(nodesReply)
+
+	return nRep.getNodes(), nRep.Error()
+}
+
+func (s *schemaMgr) addNode(nc config.NodeConfig) error {
+	nMsg := newNodeConfMessage(nc)
+	s.setMessageTables(nMsg)
+
+	cmdin := newSchemaMessage(nMsg, mgrAddNodeOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	return sreply.Error()
+}
+
+func (s *schemaMgr) updateNode(nc config.NodeConfig) error {
+	nMsg := newNodeConfMessage(nc)
+	s.setMessageTables(nMsg)
+
+	cmdin := newSchemaMessage(nMsg, mgrUpdateNodeOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	return sreply.Error()
+}
+
+func (s *schemaMgr) deleteNode(nodeName, nodeIP string) error {
+	nMsg := newNodeMessage(nodeName, nodeIP)
+	s.setMessageTables(nMsg)
+
+	cmdin := newSchemaMessage(nMsg, mgrDeleteNodeOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	return sreply.Error()
+}
+
+// listCaptureTables returns the capture tables of the collector with only
+// captures from before the provided date.
+func (s *schemaMgr) listCaptureTables(collector string, before time.Time) ([]*CaptureTable, error) {
+	cMsg := newCapTableMessage("", collector, time.Time{}, before)
+	s.setMessageTables(cMsg)
+
+	cmdin := newSchemaMessage(cMsg, mgrListCaptureTablesOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	if sreply.Error() != nil {
+		return nil, sreply.Error()
+	}
+	return sreply.(capTablesReply).getTables(), nil
+}
+
+func (s *schemaMgr) dropCaptureTable(name string) error {
+	cMsg := newCapTableMessage(name, "", time.Time{}, time.Time{})
+	s.setMessageTables(cMsg)
+
+	cmdin := newSchemaMessage(cMsg, mgrDropCaptureTableOp)
+	s.req <- cmdin
+	sreply := <-s.resp
+	return sreply.Error()
+}
+
+// clearCache makes the schema manager look up every node and table again,
+// after the catalog was changed outside of it.
+func (s *schemaMgr) clearCache() {
+	cmdin := newSchemaMessage(s.getCommonMessage(), mgrClearCacheOp)
+	s.req <- cmdin
+	<-s.resp
+}
+
+// remoteChange clears the cache of the schema manager after another session
+// changed the catalog. Unlike clearCache, it doesn't notify anyone.
+func (s *schemaMgr) remoteChange() {
+	cmdin := newSchemaMessage(s.getCommonMessage(), mgrRemoteChangeOp)
+	s.req <- cmdin
+	<-s.resp
+}
+
 // LookupTable allows schemaMgr to adhere to the tableCache interface
-func (s *schemaMgr) LookupTable(nodeIP net.IP, t time.Time) (string, error) {
-	tName, _, _, err := s.getTable(nodeIP.String(), t)
-	return tName, err
+func (s *schemaMgr) LookupTable(nodeIP net.IP, t time.Time) (string, util.Timespan, error) {
+	tName, start, end, err := s.getTable(util.IPString(nodeIP), t)
+	return tName, util.Timespan{Start: start, End: end}, err
 }
 
 // LookupNode allows schemaMgr to adhere to the tableCache interface
 func (s *schemaMgr) LookupNode(nodeIP net.IP) (*node, error) {
-	n, err := s.getNode("", nodeIP.String())
+	n, err := s.getNode("", util.IPString(nodeIP))
 	return n, err
 }
@@ -32,7 +32,7 @@ func TestSchemaMgrStartStop(t *testing.T) {
 		t.Skipf("Skipping TestSchemaMgr for short tests")
 	}
 	sx, _ := getEx()
-	sm := newSchemaMgr(sx, "dbs", "nodes", "entities")
+	sm := newSchemaMgr(sx, "dbs", "nodes", "entities", nil, nil)
 	sm.stop()
 	t.Log("schema mgr started and closed")
 }
@@ -42,7 +42,7 @@ func TestSchemaCheckSchema(t *testing.T) {
 		t.Skipf("Skipping TestSchemaCheckSchema for short tests")
 	}
 	sx, _ := getEx()
-	sm := newSchemaMgr(sx, "dbs", "nodes", "entities")
+	sm := newSchemaMgr(sx, "dbs", "nodes", "entities", nil, nil)
 
 	err := sm.checkSchema()
 	t.Logf("schema mgr checkSchema: [err:%v]", err)
@@ -1,12 +1,18 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	swg "github.com/remeh/sizedwaitgroup"
 )
@@ -32,6 +38,22 @@ const (
 	// SessionReadEntity is provided to a Sessions OpenReadStream to open
 	// an entity read stream.
 	SessionReadEntity
+
+	// SessionReadEntityHistory is provided to a Sessions OpenReadStream to
+	// open a stream of changes made to entities.
+	SessionReadEntityHistory
+
+	// SessionReadRollup is provided to a Sessions OpenReadStream to open a
+	// stream of capture summaries. It requires RollupFilterOptions.
+	SessionReadRollup
+
+	// SessionWritePeerEvent is provided to a Sessions OpenWriteStream to open
+	// a peer event write stream.
+	SessionWritePeerEvent
+
+	// SessionReadPeerEvent is provided to a Sessions OpenReadStream to open a
+	// stream of peer events. It requires PeerEventFilterOptions.
+	SessionReadPeerEvent
 )
 
 type sessionStream struct {
@@ -45,6 +67,14 @@ func newSessionStream(db TimeoutDBer, oper queryProvider, s *schemaMgr, wp *swg.
 	return &sessionStream{db: db, oper: oper, schema: s, wp: wp}
 }
 
+// executor returns the executor a read stream queries its database with.
+func (ss *sessionStream) executor() SessionExecutor {
+	if r, ok := ss.db.(*replicaDB); ok {
+		return newSessionExecutor(r, ss.oper)
+	}
+	return newSessionExecutor(ss.db.DB(), ss.oper)
+}
+
 // ReadStream represents the different kinds of read streams that can be done on a session
 type ReadStream interface {
 	Read() bool
@@ -65,6 +95,14 @@ type WriteStream interface {
 	Close()
 }
 
+// WriteCounter is implemented by write streams which can report how many of
+// the objects written to them were stored.
+type WriteCounter interface {
+	// Counts returns how many objects were written to the stream, and how
+	// many of them were skipped as duplicates when it was flushed.
+	Counts() (written int64, skipped int64)
+}
+
 // Session represents a session to the underlying db. It holds references to the schema manager and workerpool.
 type Session struct {
 	uuid          string
@@ -75,17 +113,40 @@ type Session struct {
 	schema        *schemaMgr
 	maxWC         int
 	dbTimeoutSecs int
+	indexes       []string
+	namespace     string
+	dedup         bool
+	spool         *captureSpool
+	replicas      *replicaSet
+	shards        *shardSet      // only set on sharded sessions, which have no database of their own
+	bus           *captureBus    // shared by the shards of a sharded session
+	writes        *streamTracker // the open streams, waited for by Drain, and the last activity
+	bgWG          sync.WaitGroup // background routines, like the spool replay
 }
 
 // NewSession returns a newly allocated Session
-func NewSession(conf config.SessionConfiger, id string, workers int) (*Session, error) {
+func NewSession(conf config.SessionConfiger, id string, workers int) (_ *Session, err error) {
 	var (
-		err    error
-		constr string
-		db     *sql.DB
+		constr  string
+		primary string
+		db      *sql.DB
 	)
 
+	if conf.GetTypeName() == "sharded" {
+		return newShardedSession(conf, id, workers)
+	}
+
+	var replicaDBs []*sql.DB
 	cancel := make(chan bool)
+	// Once opened, the replicas are only closed with the session.
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, rdb := range replicaDBs {
+			rdb.Close()
+		}
+	}()
 
 	var wc int
 	// The configuration will default to 0 if not specified,
@@ -104,7 +165,18 @@ func NewSession(conf config.SessionConfiger, id string, workers int) (*Session,
 
 	wp := swg.New(wc)
 	dt := conf.GetDBTimeoutSecs()
-	s := &Session{uuid: id, cancel: cancel, wp: &wp, maxWC: wc, dbTimeoutSecs: dt}
+	indexes, err := checkCaptureIndexes(conf.GetIndexes())
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := conf.GetNamespace()
+	if err := checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	s := &Session{uuid: id, cancel: cancel, wp: &wp, maxWC: wc, dbTimeoutSecs: dt, indexes: indexes, namespace: namespace,
+		dedup: conf.GetDedup(), bus: newCaptureBus(), writes: newStreamTracker()}
 	username := conf.GetUser()
 	password := conf.GetPassword()
 	dbName := conf.GetDatabaseName()
@@ -115,8 +187,8 @@ func NewSession(conf config.SessionConfiger, id string, workers int) (*Session,
 	// The DB will need to be a field within session
 	switch st := conf.GetTypeName(); st {
 	case "postgres":
-		if len(hostNames) != 1 {
-			return nil, fmt.Errorf("postgres sessions require exactly one hostname")
+		if len(hostNames) == 0 {
+			return nil, fmt.Errorf("postgres sessions require at least one hostname")
 		}
 
 		s.dbo = newPostgressQueryProvider()
@@ -128,13 +200,28 @@ func NewSession(conf config.SessionConfiger, id string, workers int) (*Session,
 			// Otherwise, use SSL
 			constr = s.dbo.getQuery(connectSSLOp)
 		} else {
-			return nil, errors.New("postgres sessions require a password and exactly one hostname")
+			return nil, errors.New("postgres sessions require a password or a cert dir")
+		}
+		// Every table of a namespaced session, including the capture tables,
+		// is created in and read from its schema.
+		if namespace != "" {
+			constr += " search_path=" + namespace
 		}
 
-		db, err = sql.Open("postgres", fmt.Sprintf(constr, username, password, dbName, hostNames[0]))
+		primary = fmt.Sprintf(constr, username, password, dbName, hostNames[0])
+		db, err = sql.Open("postgres", primary)
 		if err != nil {
 			return nil, errors.Wrap(err, "sql open")
 		}
+
+		// Every host after the first is a read replica.
+		for _, host := range hostNames[1:] {
+			rdb, err := sql.Open("postgres", fmt.Sprintf(constr, username, password, dbName, host))
+			if err != nil {
+				return nil, errors.Wrap(err, "sql open replica")
+			}
+			replicaDBs = append(replicaDBs, rdb)
+		}
 	case "cockroachdb":
 		return nil, errors.New("cockroachdb not yet supported")
 	default:
@@ -142,20 +229,146 @@ func NewSession(conf config.SessionConfiger, id string, workers int) (*Session,
 	}
 	s.db = db
 	sEx := newSessionExecutor(s.db, s.dbo)
-	s.schema = newSchemaMgr(sEx, defaultMainTable, defaultNodeTable, defaultEntityTable)
+	// Deferred indexes are left to IndexCaptureTables, once a table's period
+	// has closed.
+	var newTableIndexes []string
+	if !conf.GetDeferIndexes() {
+		newTableIndexes = indexes
+	}
+	coord := newCatalogCoordinator(s, s.dbo, s.uuid, namespace)
+	s.schema = newSchemaMgr(sEx, defaultMainTable, defaultNodeTable, defaultEntityTable, newTableIndexes, coord)
 
 	if err := s.initDB(cn); err != nil {
 		return nil, err
 	}
 
+	listener, err := coord.listen(primary)
+	if err != nil {
+		return nil, errors.Wrap(err, "catalog listen")
+	}
+	s.bgWG.Add(1)
+	go s.listenCatalog(coord, listener)
+
+	if len(replicaDBs) != 0 {
+		maxLag := time.Duration(conf.GetMaxReplicaLagSecs()) * time.Second
+		s.replicas = newReplicaSet(s.dbo, maxLag, hostNames[1:], replicaDBs)
+		s.replicas.check()
+
+		s.bgWG.Add(1)
+		go s.checkReplicas()
+	}
+
+	if dir := conf.GetSpoolDir(); dir != "" {
+		maxBytes := int64(conf.GetSpoolMaxMB()) << 20
+		s.spool, err = openCaptureSpool(filepath.Join(dir, conf.GetName()), maxBytes)
+		if err != nil {
+			// The replica checks must be stopped before the replicas are
+			// closed.
+			close(cancel)
+			s.bgWG.Wait()
+			return nil, errors.Wrap(err, "spool open")
+		}
+
+		s.bgWG.Add(1)
+		go s.replaySpool()
+	}
+
 	return s, nil
 }
 
+// checkReplicas periodically checks the read replicas of the session, until
+// the session is closed.
+func (s *Session) checkReplicas() {
+	defer s.bgWG.Done()
+
+	tick := time.NewTicker(replicaCheckInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-s.cancel:
+			return
+		case <-tick.C:
+			s.replicas.check()
+		}
+	}
+}
+
+// listenCatalog clears the catalog cache of the session whenever another
+// session changes the catalog, until the session is closed.
+func (s *Session) listenCatalog(coord *catalogCoordinator, l *pq.Listener) {
+	defer s.bgWG.Done()
+	defer l.Close()
+
+	tick := time.NewTicker(catalogPingInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-s.cancel:
+			return
+		case n := <-l.Notify:
+			if coord.isRemote(n) {
+				s.schema.remoteChange()
+			}
+		case <-tick.C:
+			if err := l.Ping(); err != nil {
+				dbLogger.Errorf("Catalog listener ping: %s", err)
+			}
+		}
+	}
+}
+
+// readDB returns the database a read stream should query, which is the next
+// healthy replica, or the primary if there are none.
+func (s *Session) readDB() TimeoutDBer {
+	if s.replicas == nil {
+		return s
+	}
+
+	if r := s.replicas.pick(); r != nil {
+		return &replicaDB{db: r.db, host: r.host, primary: s.db, timeout: s.GetTimeout()}
+	}
+	dbLogger.Infof("No read replica is available, reading from the primary")
+	return s
+}
+
+// replaySpool periodically replays the sealed segments of the session's
+// spool while the database is reachable, until the session is closed.
+func (s *Session) replaySpool() {
+	defer s.bgWG.Done()
+
+	tick := time.NewTicker(spoolReplayInterval)
+	defer tick.Stop()
+
+	for {
+		if s.spool.status().Segments != 0 && pingDB(s.db) == nil {
+			s.spool.replay(s)
+		}
+
+		select {
+		case <-s.cancel:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
 func (s *Session) initDB(cn map[string]config.NodeConfig) error {
+	if s.namespace != "" {
+		if _, err := s.db.Exec(fmt.Sprintf(s.dbo.getQuery(makeNamespaceOp), s.namespace)); err != nil {
+			return dbLogger.Errorf("Error creating namespace %s: %s", s.namespace, err)
+		}
+	}
+
 	if err := s.schema.makeSchema(); err != nil {
 		return err
 	}
 
+	if err := s.schema.migrate(); err != nil {
+		return err
+	}
+
 	nodes, err := s.schema.syncNodes(cn)
 	if err != nil {
 		dbLogger.Errorf("Error syncing nodes: %s", err)
@@ -180,13 +393,33 @@ func (s *Session) GetTimeout() time.Duration {
 }
 
 // OpenWriteStream opens and returns a WriteStream with the given type, or an
-// error if no such type exists
+// error if no such type exists. It returns ErrSessionDraining once the
+// session is being drained.
 func (s *Session) OpenWriteStream(sType SessionType) (WriteStream, error) {
+	ws, err := s.openWriteStream(sType)
+	if err != nil {
+		return nil, err
+	}
+
+	tws, err := s.writes.track(ws)
+	if err != nil {
+		ws.Cancel()
+		ws.Close()
+		return nil, err
+	}
+	return tws, nil
+}
+
+func (s *Session) openWriteStream(sType SessionType) (WriteStream, error) {
+	if s.shards != nil {
+		return s.shards.openWriteStream(sType)
+	}
+
 	switch sType {
 	case SessionWriteCapture:
 		s.wp.Add()
 		parStream := newSessionStream(s, s.dbo, s.schema, s.wp)
-		ws, err := newWriteCapStream(parStream, s.cancel)
+		ws, err := newWriteCapStream(parStream, s.cancel, s.spool, s.dedup, s.bus)
 		if err != nil {
 			s.wp.Done()
 		}
@@ -199,56 +432,503 @@ func (s *Session) OpenWriteStream(sType SessionType) (WriteStream, error) {
 		}
 		s.wp.Add()
 		return ws, err
+	case SessionWritePeerEvent:
+		parStream := newSessionStream(s, s.dbo, s.schema, s.wp)
+		ws, err := newWritePeerEventStream(parStream, s.cancel)
+		if err != nil {
+			return nil, err
+		}
+		s.wp.Add()
+		return ws, err
 	default:
 		return nil, fmt.Errorf("unsupported write stream type")
 	}
 }
 
 // OpenReadStream opens and returns a ReadStream with the given type, or an
-// error if no such type exists
+// error if no such type exists. It returns ErrSessionDraining once the
+// session is being drained.
 func (s *Session) OpenReadStream(sType SessionType, fo FilterOptions) (ReadStream, error) {
+	rs, err := s.openReadStream(sType, fo)
+	if err != nil {
+		return nil, err
+	}
+
+	trs, err := s.writes.trackRead(rs)
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+	return trs, nil
+}
+
+func (s *Session) openReadStream(sType SessionType, fo FilterOptions) (ReadStream, error) {
+	if s.shards != nil {
+		return s.shards.openReadStream(sType, fo)
+	}
+
 	switch sType {
 	case SessionReadCapture:
+		var rdb TimeoutDBer = s
+		if cfo, ok := fo.(*CaptureFilterOptions); !ok || !cfo.fromPrimary {
+			rdb = s.readDB()
+		}
+
 		s.wp.Add()
-		parStream := newSessionStream(s, s.dbo, s.schema, s.wp)
+		parStream := newSessionStream(rdb, s.dbo, s.schema, s.wp)
 		rs, err := newReadCapStream(parStream, s.cancel, fo)
 		if err != nil {
 			s.wp.Done()
+			return nil, err
 		}
 		return rs, nil
 	case SessionReadPrefix:
 		s.wp.Add()
-		parStream := newSessionStream(s, s.dbo, s.schema, s.wp)
+		parStream := newSessionStream(s.readDB(), s.dbo, s.schema, s.wp)
 		rs, err := newReadPrefixStream(parStream, s.cancel, fo)
 		if err != nil {
 			s.wp.Done()
+			return nil, err
 		}
 		return rs, nil
 	case SessionReadEntity:
 		s.wp.Add()
-		parStream := newSessionStream(s, s.dbo, s.schema, s.wp)
-		es, err := newReadEntityStream(parStream, s.cancel, fo)
+		parStream := newSessionStream(s.readDB(), s.dbo, s.schema, s.wp)
+		es, err := newReadEntityStream(parStream, s.cancel, fo, getEntityStream)
 		if err != nil {
 			s.wp.Done()
+			return nil, err
+		}
+		return es, nil
+	case SessionReadEntityHistory:
+		s.wp.Add()
+		parStream := newSessionStream(s.readDB(), s.dbo, s.schema, s.wp)
+		es, err := newReadEntityStream(parStream, s.cancel, fo, getEntityHistoryStream)
+		if err != nil {
+			s.wp.Done()
+			return nil, err
 		}
 		return es, nil
+	case SessionReadRollup:
+		s.wp.Add()
+		parStream := newSessionStream(s.readDB(), s.dbo, s.schema, s.wp)
+		rs, err := newReadRollupStream(parStream, s.cancel, fo)
+		if err != nil {
+			s.wp.Done()
+			return nil, err
+		}
+		return rs, nil
+	case SessionReadPeerEvent:
+		s.wp.Add()
+		parStream := newSessionStream(s.readDB(), s.dbo, s.schema, s.wp)
+		ps, err := newReadPeerEventStream(parStream, s.cancel, fo)
+		if err != nil {
+			s.wp.Done()
+			return nil, err
+		}
+		return ps, nil
 	default:
 		return nil, fmt.Errorf("unsupported read stream type")
 	}
 }
 
+// Subscribe returns a Subscription to the captures committed to this session
+// from now on. A collector in the filter may be a node name or an IP, and it
+// is an error if no node has that name. Subscriptions are refused like
+// streams once the session is draining or expired.
+func (s *Session) Subscribe(opts SubscribeOptions) (*Subscription, error) {
+	var colIPs map[string]bool
+	if opts.Filter != nil && opts.Filter.collector != AnyCollector && opts.Filter.collector != "" {
+		var err error
+		if colIPs, err = s.collectorIPs(opts.Filter.collector); err != nil {
+			return nil, err
+		}
+	}
+
+	// The tracker is held while subscribing, so the session can't expire
+	// in between.
+	s.writes.mu.Lock()
+	defer s.writes.mu.Unlock()
+	if err := s.writes.refusal(); err != nil {
+		return nil, err
+	}
+	return s.bus.subscribe(opts, colIPs), nil
+}
+
+// collectorIPs returns the IPs of the nodes named collector, or the IP
+// itself if collector is one.
+func (s *Session) collectorIPs(collector string) (map[string]bool, error) {
+	if ip := util.ParseIP(collector); ip != nil {
+		return map[string]bool{util.IPString(ip): true}, nil
+	}
+
+	nodes, err := s.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool)
+	for _, nc := range nodes {
+		if ip := util.ParseIP(nc.IP); ip != nil && strings.EqualFold(nc.Name, collector) {
+			ret[util.IPString(ip)] = true
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no collector named %s", collector)
+	}
+	return ret, nil
+}
+
+// Touch records activity on the session without opening a stream, like a
+// keepalive from its client.
+func (s *Session) Touch() {
+	s.writes.touch()
+}
+
+// Activity returns how many streams and subscriptions are open on the
+// session, and when it was last active: when it was opened or touched, or
+// a stream was last opened or closed.
+func (s *Session) Activity() (int, time.Time) {
+	open, last := s.writes.activity()
+	return open + s.bus.subscribers(), last
+}
+
+// Expire stops the session from opening new streams and subscriptions if
+// expired returns true for its activity, like Activity returns it, and
+// returns whether it did. Streams opened afterwards are refused with
+// ErrSessionExpired, so an expired session can be closed without cutting
+// any of them off.
+func (s *Session) Expire(expired func(open int, last time.Time) bool) bool {
+	return s.writes.expire(s.bus.subscribers, expired)
+}
+
+// Drain stops the session from opening new streams, and waits for its open
+// write streams to be flushed and closed, until ctx is done. It reports the
+// write streams which were still open then, which are cancelled once the
+// session is closed. Read streams aren't waited for.
+func (s *Session) Drain(ctx context.Context) DrainReport {
+	return s.writes.drain(ctx)
+}
+
 // Close stops the schema manager and the worker pool
 func (s *Session) Close() error {
 	dbLogger.Infof("Closing session: %s", s.uuid)
+	if s.shards != nil {
+		return s.shards.close()
+	}
 
 	close(s.cancel)
+	s.bgWG.Wait()
 	s.wp.Wait()
+	s.bus.close()
 	s.schema.stop()
+	if s.spool != nil {
+		s.spool.release()
+	}
+	if s.replicas != nil {
+		s.replicas.close()
+	}
+
+	return nil
+}
+
+// ListNodes returns every node stored in this session's node table, keyed
+// by IP.
+func (s *Session) ListNodes() (map[string]config.NodeConfig, error) {
+	if s.shards != nil {
+		return s.shards.listNodes()
+	}
+
+	return s.schema.listNodes()
+}
+
+// SyncNodes stores the configured nodes in this session's node table,
+// replacing the stored fields of nodes with the same IP. Nodes which are
+// only in the table are left as they are. Like UpdateNode, changes are
+// visible to new write streams immediately.
+func (s *Session) SyncNodes(nodes map[string]config.NodeConfig) error {
+	if s.shards != nil {
+		return s.shards.each(func(sh *Session) error { return sh.SyncNodes(nodes) })
+	}
+
+	_, err := s.schema.syncNodes(nodes)
+	return err
+}
+
+// AddNode stores a new node in this session's node table. It returns an
+// error if a node with the same IP already exists.
+func (s *Session) AddNode(nc config.NodeConfig) error {
+	if s.shards != nil {
+		return s.shards.each(func(sh *Session) error { return sh.AddNode(nc) })
+	}
+
+	return s.schema.addNode(nc)
+}
+
+// UpdateNode replaces the stored fields of the node with the same IP as nc.
+// Changes are visible to new write streams immediately.
+func (s *Session) UpdateNode(nc config.NodeConfig) error {
+	if s.shards != nil {
+		return s.shards.each(func(sh *Session) error { return sh.UpdateNode(nc) })
+	}
+
+	return s.schema.updateNode(nc)
+}
+
+// DeleteNode removes the node matching either name or ip from this session's
+// node table. Capture tables belonging to that node are left untouched.
+func (s *Session) DeleteNode(name, ip string) error {
+	if s.shards != nil {
+		return s.shards.each(func(sh *Session) error { return sh.DeleteNode(name, ip) })
+	}
+
+	return s.schema.deleteNode(name, ip)
+}
+
+// ListCaptureTables returns the capture tables of collector which only hold
+// captures from before the provided date, ordered by time. collector may be
+// AnyCollector.
+func (s *Session) ListCaptureTables(collector string, before time.Time) ([]*CaptureTable, error) {
+	if s.shards != nil {
+		return s.shards.listCaptureTables(collector, before)
+	}
+
+	return s.schema.listCaptureTables(collector, before)
+}
+
+// DropCaptureTable drops a capture table and removes it from the main table.
+// The schema manager's caches are cleared, so a later write for the same
+// collector and time creates a new, empty table. Write streams that were
+// already open may still fail writing to the dropped table.
+func (s *Session) DropCaptureTable(name string) error {
+	if s.shards != nil {
+		return s.shards.dropCaptureTable(name)
+	}
+
+	return s.schema.dropCaptureTable(name)
+}
+
+// IndexCaptureTables creates the configured indexes on every capture table
+// of collector, which may be AnyCollector, that only holds captures from
+// before the provided date. Indexes that already exist are skipped. It
+// returns the number of tables that were indexed. Building indexes on large
+// tables is slow, so this doesn't go through the schema manager, and new
+// capture tables can still be created meanwhile.
+func (s *Session) IndexCaptureTables(collector string, before time.Time) (int, error) {
+	if s.shards != nil {
+		return s.shards.indexCaptureTables(collector, before)
+	}
+
+	if len(s.indexes) == 0 {
+		return 0, fmt.Errorf("no indexes configured for this session")
+	}
+
+	tables, err := s.schema.listCaptureTables(collector, before)
+	if err != nil {
+		return 0, err
+	}
+
+	sEx := newSessionExecutor(s.db, s.dbo)
+	for i, t := range tables {
+		if err := createCaptureIndexes(sEx, t.Name(), s.indexes); err != nil {
+			return i, err
+		}
+	}
+	return len(tables), nil
+}
+
+// MigrateCaptureTables applies the offline migrations to every capture
+// table which still needs them, and returns how many tables were migrated.
+// Each migration rewrites the whole table and locks it meanwhile, so this
+// should only be run while nothing else uses the database, as bgpmond
+// -migrate does.
+func (s *Session) MigrateCaptureTables() (int, error) {
+	if s.shards != nil {
+		return s.shards.migrateCaptureTables()
+	}
+
+	return migrateCaptureTablesOffline(newSessionExecutor(s.db, s.dbo), s.schema.mainTable)
+}
+
+// CaptureTableStats returns the stats of every capture table of collector,
+// which may be AnyCollector, holding captures from [start, end), ordered by
+// time. Every table is scanned, so this can be slow.
+func (s *Session) CaptureTableStats(collector string, start, end time.Time) ([]*CaptureTableStats, error) {
+	if s.shards != nil {
+		return s.shards.captureTableStats(collector, start, end)
+	}
 
+	cMsg := newCapTableMessage("", collector, start, end)
+	// Make sure this uses the same tables as the schema
+	s.schema.setMessageTables(cMsg)
+
+	rep := getCaptureTableStats(newSessionExecutor(s.db, s.dbo), cMsg).(captureStatsReply)
+	return rep.getStats(), rep.Error()
+}
+
+// CaptureCoverage counts the captures of collector, which may be
+// AnyCollector, in each hour or day of [start, end), depending on g.
+func (s *Session) CaptureCoverage(collector string, g RollupGranularity, start, end time.Time) ([]*CoverageBucket, error) {
+	if s.shards != nil {
+		return s.shards.captureCoverage(collector, g, start, end)
+	}
+
+	rMsg := newRollupMessage(collector, g, start, end)
+	// Make sure this uses the same tables as the schema
+	s.schema.setMessageTables(rMsg)
+
+	rep := getCaptureCoverage(newSessionExecutor(s.db, s.dbo), rMsg).(coverageReply)
+	return rep.getBuckets(), rep.Error()
+}
+
+// CheckCatalog compares the main table, the node table and the capture
+// tables of this session, and returns every inconsistency found between
+// them, with the repair RepairCatalogProblem would make.
+func (s *Session) CheckCatalog() ([]*CatalogProblem, error) {
+	if s.shards != nil {
+		return s.shards.checkCatalog()
+	}
+
+	sEx := newSessionExecutor(s.db, s.dbo)
+	cMsg := s.schema.getCommonMessage()
+
+	registered, err := listRegisteredTables(sEx, cMsg.GetMainTable())
+	if err != nil {
+		return nil, dbLogger.Errorf("CheckCatalog error listing registered tables: %s", err)
+	}
+
+	relations, err := listCaptureRelations(sEx)
+	if err != nil {
+		return nil, dbLogger.Errorf("CheckCatalog error listing capture tables: %s", err)
+	}
+
+	nodes, err := s.schema.listNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	return checkCatalog(registered, relations, nodes, time.Now().UTC()), nil
+}
+
+// RepairCatalogProblem applies the repair of a problem found by CheckCatalog.
+// Each repair runs in a single transaction. Rebucketing copies every capture
+// of the table, so it's bound by the DB timeout of the session. Write
+// streams that were already open may still fail writing to a table that was
+// moved.
+func (s *Session) RepairCatalogProblem(prob *CatalogProblem) error {
+	if prob.Repair == RepairNone {
+		return fmt.Errorf("%s table %s can't be repaired", prob.Kind, prob.Table)
+	}
+	if s.shards != nil {
+		return s.shards.repairCatalogProblem(prob)
+	}
+
+	ctxEx, err := newCtxExecutor(s)
+	if err != nil {
+		return err
+	}
+
+	sEx := newSessionExecutor(ctxEx, s.dbo)
+	mainTable := s.schema.getCommonMessage().GetMainTable()
+	name := util.SanitizeDBString(prob.Table)
+
+	switch prob.Repair {
+	case RepairUnregister:
+		_, err = sEx.Exec(fmt.Sprintf(sEx.getQuery(unregisterTableOp), mainTable), name)
+	case RepairRegister:
+		_, err = sEx.Exec(fmt.Sprintf(sEx.getQuery(registerTableOp), mainTable), name, prob.Collector, prob.Span.Start, prob.Span.End)
+	case RepairQuarantine:
+		_, err = sEx.Exec(fmt.Sprintf(sEx.getQuery(quarantineTableOp), mainTable, name, s.quarantineSchema()))
+	case RepairRebucket:
+		err = rebucketCaptureTable(sEx, mainTable, prob, s.indexes)
+	default:
+		err = fmt.Errorf("unknown repair: %s", prob.Repair)
+	}
+
+	if err != nil {
+		if rbErr := ctxEx.Rollback(); rbErr != nil {
+			dbLogger.Errorf("RepairCatalogProblem rollback error: %s", rbErr)
+		}
+		return dbLogger.Errorf("RepairCatalogProblem error repairing table %s: %s", prob.Table, err)
+	}
+
+	if err := ctxEx.Commit(); err != nil {
+		return err
+	}
+	dbLogger.Infof("repaired %s table %s with %s", prob.Kind, prob.Table, prob.Repair)
+
+	s.schema.clearCache()
 	return nil
 }
 
+// RollupCaptures summarizes the captures of collector, which may be
+// AnyCollector, into buckets of granularity g. Every bucket overlapping
+// [start, end) is recomputed from the capture tables, so this is safe to
+// repeat, as long as the tables of those buckets haven't been dropped.
+func (s *Session) RollupCaptures(collector string, g RollupGranularity, start, end time.Time) error {
+	if s.shards != nil {
+		return s.shards.rollupCaptures(collector, g, start, end)
+	}
+
+	rMsg := newRollupMessage(collector, g, start, end)
+	// Make sure this uses the same tables as the schema
+	s.schema.setMessageTables(rMsg)
+
+	rep := rollupCaptures(newSessionExecutor(s.db, s.dbo), rMsg)
+	return rep.Error()
+}
+
+// DeleteEntity removes the entity with the provided name, recording the
+// removal in the entity history. It returns an error if there is no such
+// entity.
+func (s *Session) DeleteEntity(name string) error {
+	if s.shards != nil {
+		return s.shards.each(func(sh *Session) error { return sh.DeleteEntity(name) })
+	}
+
+	ctxEx, err := newCtxExecutor(s)
+	if err != nil {
+		return err
+	}
+
+	entMsg := newEntityMessage(&Entity{Name: name})
+	// Make sure this uses the same tables as the schema
+	s.schema.setMessageTables(entMsg)
+
+	rep := deleteEntity(newSessionExecutor(ctxEx, s.dbo), entMsg)
+	if rep.Error() != nil {
+		if err := ctxEx.Rollback(); err != nil {
+			dbLogger.Errorf("Error rolling back entity delete: %s", err)
+		}
+		return rep.Error()
+	}
+
+	return ctxEx.Commit()
+}
+
 // GetMaxWorkers returns the maximum amount of workers that the session supports
 func (s *Session) GetMaxWorkers() int {
 	return s.maxWC
 }
+
+// SpoolStatus returns the state of the session's capture spool. Enabled is
+// false if the session has none.
+func (s *Session) SpoolStatus() SpoolStatus {
+	if s.shards != nil {
+		return s.shards.spoolStatus()
+	}
+	if s.spool == nil {
+		return SpoolStatus{}
+	}
+	return s.spool.status()
+}
+
+// quarantineSchema returns the schema the quarantined tables of this session
+// are moved to. Namespaced sessions each have their own, so tables with the
+// same name don't collide.
+func (s *Session) quarantineSchema() string {
+	if s.namespace == "" {
+		return quarantineSchema
+	}
+	return s.namespace + "_quarantine"
+}
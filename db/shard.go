@@ -0,0 +1,665 @@
+package db
+
+// shard.go contains sharded sessions, which spread their collectors over
+// several backend sessions. Every capture of a collector is kept in the same
+// shard, so writes are routed by collector IP, and reads only query the
+// shards of the collectors they ask for. Nodes and entities are kept in
+// every shard. Moving a collector to another shard doesn't move the captures
+// it already has, so they stop being read.
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
+
+	"github.com/pkg/errors"
+)
+
+// shardRingReplicas is the number of points each shard has on the hash ring.
+// More points spread the collectors more evenly.
+const shardRingReplicas = 64
+
+// shardRing places keys on shards by consistent hashing, so adding a shard
+// only moves the keys which end up on it.
+type shardRing struct {
+	points []uint32
+	owners map[uint32]int
+}
+
+func hashShardKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// newShardRing returns a ring of the shards with the provided names. A
+// shard's points only depend on its name, not its position.
+func newShardRing(names []string) *shardRing {
+	r := &shardRing{owners: make(map[uint32]int)}
+	for i, name := range names {
+		for v := 0; v < shardRingReplicas; v++ {
+			p := hashShardKey(fmt.Sprintf("%s-%d", name, v))
+			if _, taken := r.owners[p]; taken {
+				continue
+			}
+			r.owners[p] = i
+			r.points = append(r.points, p)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// lookup returns the index of the shard owning key.
+func (r *shardRing) lookup(key string) int {
+	h := hashShardKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// shardSet holds the shards of a sharded session.
+type shardSet struct {
+	names  []string
+	shards []*Session
+	pinned map[string]int // collector IP to shard index
+	ring   *shardRing
+}
+
+// newShardedSession opens a session on every shard named in the
+// configuration, and returns a session spread over them.
+func newShardedSession(conf config.SessionConfiger, id string, workers int) (*Session, error) {
+	ss := &shardSet{pinned: make(map[string]int)}
+	index := make(map[string]int)
+
+	for _, name := range conf.GetShards() {
+		sc, err := conf.GetSessionConfigWithName(name)
+		if err != nil {
+			ss.close()
+			return nil, err
+		}
+
+		shard, err := NewSession(sc, fmt.Sprintf("%s-%s", id, name), workers)
+		if err != nil {
+			ss.close()
+			return nil, errors.Wrap(err, fmt.Sprintf("shard %s", name))
+		}
+
+		index[name] = len(ss.shards)
+		ss.names = append(ss.names, name)
+		ss.shards = append(ss.shards, shard)
+	}
+
+	if len(ss.shards) == 0 {
+		return nil, fmt.Errorf("sharded sessions require at least one shard")
+	}
+
+	for ip, name := range conf.GetShardOf() {
+		nip := util.ParseIP(ip)
+		i, ok := index[name]
+		if nip == nil || !ok {
+			ss.close()
+			return nil, fmt.Errorf("can't place collector %s on shard %s", ip, name)
+		}
+		ss.pinned[nip.String()] = i
+	}
+	ss.ring = newShardRing(ss.names)
+
+	// Writes to different shards don't compete for workers, and their
+	// captures are published on a single bus.
+	wc := 0
+	bus := newCaptureBus()
+	for _, sh := range ss.shards {
+		wc += sh.GetMaxWorkers()
+		sh.bus = bus
+	}
+
+	return &Session{uuid: id, shards: ss, maxWC: wc, bus: bus, writes: newStreamTracker()}, nil
+}
+
+// shardFor returns the index of the shard holding the captures of the
+// collector with the provided IP.
+func (ss *shardSet) shardFor(colIP net.IP) int {
+	key := colIP.String()
+	if i, ok := ss.pinned[key]; ok {
+		return i
+	}
+	return ss.ring.lookup(key)
+}
+
+// shardsFor returns the shards holding the captures of collector, which is
+// a node name and may be AnyCollector. If no node has that name, every shard
+// is returned.
+func (ss *shardSet) shardsFor(collector string) ([]*Session, error) {
+	if collector == AnyCollector {
+		return ss.shards, nil
+	}
+
+	nodes, err := ss.listNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	var ret []*Session
+	for _, nc := range nodes {
+		nip := net.ParseIP(nc.IP)
+		if nip == nil || !strings.EqualFold(nc.Name, collector) {
+			continue
+		}
+
+		i := ss.shardFor(nip)
+		if !seen[i] {
+			seen[i] = true
+			ret = append(ret, ss.shards[i])
+		}
+	}
+
+	if len(ret) == 0 {
+		return ss.shards, nil
+	}
+	return ret, nil
+}
+
+// each calls fn on every shard in order, and stops at the first error.
+func (ss *shardSet) each(fn func(*Session) error) error {
+	for i, sh := range ss.shards {
+		if err := fn(sh); err != nil {
+			return fmt.Errorf("shard %s: %s", ss.names[i], err)
+		}
+	}
+	return nil
+}
+
+// close closes every shard, and returns the first error.
+func (ss *shardSet) close() error {
+	var ret error
+	for _, sh := range ss.shards {
+		if err := sh.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// listNodes returns the nodes of every shard. They should be the same, but
+// if they aren't, the first shard holding a node wins.
+func (ss *shardSet) listNodes() (map[string]config.NodeConfig, error) {
+	nodes := make(map[string]config.NodeConfig)
+	err := ss.each(func(sh *Session) error {
+		shNodes, err := sh.ListNodes()
+		if err != nil {
+			return err
+		}
+
+		for ip, nc := range shNodes {
+			if _, ok := nodes[ip]; !ok {
+				nodes[ip] = nc
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+func (ss *shardSet) listCaptureTables(collector string, before time.Time) ([]*CaptureTable, error) {
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []*CaptureTable
+	for _, sh := range shards {
+		shTables, err := sh.ListCaptureTables(collector, before)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, shTables...)
+	}
+
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].Span().Start.Before(tables[j].Span().Start) })
+	return tables, nil
+}
+
+// dropCaptureTable drops a table from the shard of the collector in its
+// name.
+func (ss *shardSet) dropCaptureTable(name string) error {
+	collector, _, err := parseTableName(name)
+	if err != nil {
+		return err
+	}
+
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return err
+	}
+	if len(shards) != 1 {
+		return fmt.Errorf("can't find the shard of table %s", name)
+	}
+	return shards[0].DropCaptureTable(name)
+}
+
+func (ss *shardSet) indexCaptureTables(collector string, before time.Time) (int, error) {
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	for _, sh := range shards {
+		n, err := sh.IndexCaptureTables(collector, before)
+		indexed += n
+		if err != nil {
+			return indexed, err
+		}
+	}
+	return indexed, nil
+}
+
+func (ss *shardSet) migrateCaptureTables() (int, error) {
+	migrated := 0
+	err := ss.each(func(sh *Session) error {
+		n, err := sh.MigrateCaptureTables()
+		migrated += n
+		return err
+	})
+	return migrated, err
+}
+
+func (ss *shardSet) captureTableStats(collector string, start, end time.Time) ([]*CaptureTableStats, error) {
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []*CaptureTableStats
+	for _, sh := range shards {
+		shStats, err := sh.CaptureTableStats(collector, start, end)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, shStats...)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Span().Start.Before(stats[j].Span().Start) })
+	return stats, nil
+}
+
+func (ss *shardSet) captureCoverage(collector string, g RollupGranularity, start, end time.Time) ([]*CoverageBucket, error) {
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []*CoverageBucket
+	for _, sh := range shards {
+		shBuckets, err := sh.CaptureCoverage(collector, g, start, end)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, shBuckets...)
+	}
+
+	sort.SliceStable(buckets, func(i, j int) bool {
+		if buckets[i].Collector != buckets[j].Collector {
+			return buckets[i].Collector < buckets[j].Collector
+		}
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets, nil
+}
+
+// checkCatalog checks the catalog of every shard. Each problem remembers its
+// shard, so it can be repaired there.
+func (ss *shardSet) checkCatalog() ([]*CatalogProblem, error) {
+	var problems []*CatalogProblem
+	for i, sh := range ss.shards {
+		shProblems, err := sh.CheckCatalog()
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %s", ss.names[i], err)
+		}
+
+		for _, p := range shProblems {
+			p.shard = sh
+			p.Detail = fmt.Sprintf("%s, in shard %s", p.Detail, ss.names[i])
+		}
+		problems = append(problems, shProblems...)
+	}
+	return problems, nil
+}
+
+func (ss *shardSet) repairCatalogProblem(prob *CatalogProblem) error {
+	if prob.shard == nil {
+		return fmt.Errorf("table %s wasn't found by CheckCatalog on this session", prob.Table)
+	}
+	return prob.shard.RepairCatalogProblem(prob)
+}
+
+func (ss *shardSet) rollupCaptures(collector string, g RollupGranularity, start, end time.Time) error {
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return err
+	}
+
+	for _, sh := range shards {
+		if err := sh.RollupCaptures(collector, g, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spoolStatus sums the spools of every shard.
+func (ss *shardSet) spoolStatus() SpoolStatus {
+	var st SpoolStatus
+	var dirs []string
+	for _, sh := range ss.shards {
+		shSt := sh.SpoolStatus()
+		if !shSt.Enabled {
+			continue
+		}
+
+		st.Enabled = true
+		dirs = append(dirs, shSt.Dir)
+		st.Segments += shSt.Segments
+		st.Captures += shSt.Captures
+		st.Bytes += shSt.Bytes
+		st.MaxBytes += shSt.MaxBytes
+		st.OpenSegments += shSt.OpenSegments
+		st.Spooling += shSt.Spooling
+		st.Failed += shSt.Failed
+		st.Replaying = st.Replaying || shSt.Replaying
+		if shSt.LastReplay.After(st.LastReplay) {
+			st.LastReplay = shSt.LastReplay
+		}
+		if st.LastError == "" {
+			st.LastError = shSt.LastError
+		}
+	}
+	st.Dir = strings.Join(dirs, ", ")
+	return st
+}
+
+// openWriteStream returns a stream which writes captures to the shard of
+// their collector, and entities to every shard.
+func (ss *shardSet) openWriteStream(sType SessionType) (WriteStream, error) {
+	switch sType {
+	case SessionWriteCapture, SessionWriteEntity, SessionWritePeerEvent:
+		return &shardedWriteStream{ss: ss, sType: sType, streams: make([]WriteStream, len(ss.shards))}, nil
+	default:
+		return nil, fmt.Errorf("unsupported write stream type")
+	}
+}
+
+// openReadStream opens a read stream on every shard which may hold results,
+// and merges them. Captures and peer events are read in time order from
+// each shard, and merged by time. Rollups are read in bucket order, and
+// merged by bucket. Prefixes are returned shard by shard. Entities are the
+// same in every shard, so they're only read from the first.
+func (ss *shardSet) openReadStream(sType SessionType, fo FilterOptions) (ReadStream, error) {
+	var (
+		collector = AnyCollector
+		less      func(a, b interface{}) bool
+	)
+
+	switch sType {
+	case SessionReadCapture, SessionReadPrefix:
+		if cfo, ok := fo.(*CaptureFilterOptions); ok && cfo != nil {
+			collector = cfo.collector
+			if sType == SessionReadCapture {
+				// The caller's options aren't changed.
+				ordered := *cfo
+				ordered.ordered = true
+				fo = &ordered
+			}
+		}
+		if sType == SessionReadCapture {
+			less = func(a, b interface{}) bool {
+				return a.(*Capture).Timestamp.Before(b.(*Capture).Timestamp)
+			}
+		}
+	case SessionReadRollup:
+		if rfo, ok := fo.(*RollupFilterOptions); ok && rfo != nil {
+			collector = rfo.collector
+		}
+		less = rollupLess
+	case SessionReadEntity, SessionReadEntityHistory:
+		return ss.shards[0].OpenReadStream(sType, fo)
+	case SessionReadPeerEvent:
+		// Peer events are filtered by the IP of their collector, so the
+		// shard is known without looking the collector up.
+		if pfo, ok := fo.(*PeerEventFilterOptions); ok && pfo != nil && pfo.collector != nil {
+			return ss.shards[ss.shardFor(pfo.collector)].OpenReadStream(sType, fo)
+		}
+		less = func(a, b interface{}) bool {
+			return a.(*PeerEvent).Timestamp.Before(b.(*PeerEvent).Timestamp)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported read stream type")
+	}
+
+	shards, err := ss.shardsFor(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []ReadStream
+	for _, sh := range shards {
+		rs, err := sh.OpenReadStream(sType, fo)
+		if err != nil {
+			for _, opened := range streams {
+				opened.Close()
+			}
+			return nil, err
+		}
+		streams = append(streams, rs)
+	}
+	return newShardedReadStream(streams, less), nil
+}
+
+// rollupLess orders rollups like getRollupOp does: by bucket, then kind
+// and key.
+func rollupLess(a, b interface{}) bool {
+	ra, rb := a.(*Rollup), b.(*Rollup)
+	if !ra.Bucket.Equal(rb.Bucket) {
+		return ra.Bucket.Before(rb.Bucket)
+	}
+	if ra.Kind != rb.Kind {
+		return ra.Kind < rb.Kind
+	}
+	return ra.Key < rb.Key
+}
+
+// shardedWriteStream opens a write stream on a shard the first time
+// something is written to it. Each shard commits separately, so a failed
+// Flush may leave some shards written.
+type shardedWriteStream struct {
+	ss      *shardSet
+	sType   SessionType
+	streams []WriteStream
+}
+
+func (w *shardedWriteStream) stream(i int) (WriteStream, error) {
+	if w.streams[i] == nil {
+		ws, err := w.ss.shards[i].OpenWriteStream(w.sType)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %s", w.ss.names[i], err)
+		}
+		w.streams[i] = ws
+	}
+	return w.streams[i], nil
+}
+
+// Write sends a capture or a peer event to the shard of its collector, or an
+// entity to every shard.
+func (w *shardedWriteStream) Write(arg interface{}) error {
+	switch w.sType {
+	case SessionWriteCapture:
+		ws, err := w.stream(w.ss.shardFor(arg.(*Capture).ColIP))
+		if err != nil {
+			return err
+		}
+		return ws.Write(arg)
+	case SessionWritePeerEvent:
+		ws, err := w.stream(w.ss.shardFor(arg.(*PeerEvent).ColIP))
+		if err != nil {
+			return err
+		}
+		return ws.Write(arg)
+	}
+
+	for i := range w.ss.shards {
+		ws, err := w.stream(i)
+		if err != nil {
+			return err
+		}
+		if err := ws.Write(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush flushes the stream of every shard that was written to, and returns
+// the first error.
+func (w *shardedWriteStream) Flush() error {
+	var ret error
+	for i, ws := range w.streams {
+		if ws == nil {
+			continue
+		}
+		if err := ws.Flush(); err != nil && ret == nil {
+			ret = fmt.Errorf("shard %s: %s", w.ss.names[i], err)
+		}
+	}
+	return ret
+}
+
+// Counts sums the counts of the stream of every shard.
+func (w *shardedWriteStream) Counts() (int64, int64) {
+	var written, skipped int64
+	for _, ws := range w.streams {
+		if wc, ok := ws.(WriteCounter); ok {
+			n, sk := wc.Counts()
+			written, skipped = written+n, skipped+sk
+		}
+	}
+	return written, skipped
+}
+
+func (w *shardedWriteStream) Cancel() {
+	for _, ws := range w.streams {
+		if ws != nil {
+			ws.Cancel()
+		}
+	}
+}
+
+func (w *shardedWriteStream) Close() {
+	for _, ws := range w.streams {
+		if ws != nil {
+			ws.Close()
+		}
+	}
+}
+
+// shardedReadStream merges the read streams of several shards. If less is
+// nil, the streams are read one after the other, otherwise the smallest
+// result of any stream is returned first.
+type shardedReadStream struct {
+	streams []ReadStream
+	less    func(a, b interface{}) bool
+	pending []bool // the stream has a result which wasn't returned yet
+	done    []bool
+	cur     int
+	err     error
+}
+
+func newShardedReadStream(streams []ReadStream, less func(a, b interface{}) bool) *shardedReadStream {
+	return &shardedReadStream{
+		streams: streams,
+		less:    less,
+		pending: make([]bool, len(streams)),
+		done:    make([]bool, len(streams)),
+		cur:     -1,
+	}
+}
+
+func (r *shardedReadStream) Read() bool {
+	if r.err != nil {
+		return false
+	}
+
+	for i, rs := range r.streams {
+		if r.done[i] || r.pending[i] {
+			continue
+		}
+
+		if rs.Read() {
+			r.pending[i] = true
+		} else if rs.Err() != nil {
+			r.err = rs.Err()
+			return false
+		} else {
+			r.done[i] = true
+		}
+	}
+
+	next := -1
+	for i := range r.streams {
+		if !r.pending[i] {
+			continue
+		}
+		if next == -1 {
+			next = i
+			if r.less == nil {
+				break
+			}
+		} else if r.less(r.streams[i].Data(), r.streams[next].Data()) {
+			next = i
+		}
+	}
+
+	if next == -1 {
+		r.cur = -1
+		return false
+	}
+
+	r.pending[next] = false
+	r.cur = next
+	return true
+}
+
+// Data returns the data of the underlying stream of the last result.
+func (r *shardedReadStream) Data() interface{} {
+	if r.cur == -1 {
+		return nil
+	}
+	return r.streams[r.cur].Data()
+}
+
+func (r *shardedReadStream) Bytes() []byte {
+	if r.cur == -1 {
+		return nil
+	}
+	return r.streams[r.cur].Bytes()
+}
+
+func (r *shardedReadStream) Err() error {
+	return r.err
+}
+
+func (r *shardedReadStream) Close() {
+	for _, rs := range r.streams {
+		rs.Close()
+	}
+}
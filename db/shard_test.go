@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShardRing(t *testing.T) {
+	three := newShardRing([]string{"a", "b", "c"})
+	four := newShardRing([]string{"a", "b", "c", "d"})
+
+	counts := make([]int, 4)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := net.IPv4(10, 0, byte(i/256), byte(i%256)).String()
+		before, after := three.lookup(key), four.lookup(key)
+		counts[after]++
+
+		if before != after {
+			moved++
+			if after != 3 {
+				t.Fatalf("Key %s moved from shard %d to %d, instead of the new shard", key, before, after)
+			}
+		}
+	}
+
+	for i, c := range counts {
+		if c == 0 {
+			t.Errorf("Expected shard %d to own some keys, Got: %v", i, counts)
+		}
+	}
+	if moved == 0 || moved == 1000 {
+		t.Errorf("Expected some keys to move to the new shard, Got: %d", moved)
+	}
+}
+
+// sliceReadStream is a ReadStream over a slice of captures.
+type sliceReadStream struct {
+	caps []*Capture
+	cur  *Capture
+}
+
+func (s *sliceReadStream) Read() bool {
+	if len(s.caps) == 0 {
+		return false
+	}
+	s.cur, s.caps = s.caps[0], s.caps[1:]
+	return true
+}
+
+func (s *sliceReadStream) Data() interface{} { return s.cur }
+func (s *sliceReadStream) Bytes() []byte     { return nil }
+func (s *sliceReadStream) Err() error        { return nil }
+func (s *sliceReadStream) Close()            {}
+
+func TestShardedReadStream(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	capsAt := func(ids string, mins ...int) []*Capture {
+		var caps []*Capture
+		for i, m := range mins {
+			caps = append(caps, &Capture{ID: fmt.Sprintf("%c", ids[i]), Timestamp: start.Add(time.Duration(m) * time.Minute)})
+		}
+		return caps
+	}
+	less := func(a, b interface{}) bool {
+		return a.(*Capture).Timestamp.Before(b.(*Capture).Timestamp)
+	}
+
+	for _, tc := range []struct {
+		less     func(a, b interface{}) bool
+		expected string
+	}{
+		{less, "adbecf"},
+		{nil, "abcdef"},
+	} {
+		rs := newShardedReadStream([]ReadStream{
+			&sliceReadStream{caps: capsAt("abc", 0, 2, 4)},
+			&sliceReadStream{},
+			&sliceReadStream{caps: capsAt("def", 1, 3, 5)},
+		}, tc.less)
+
+		got := ""
+		for rs.Read() {
+			got += rs.Data().(*Capture).ID
+		}
+		if rs.Err() != nil || got != tc.expected {
+			t.Errorf("Expected: %s, Got: %s %v", tc.expected, got, rs.Err())
+		}
+	}
+}
+
+func TestRollupLess(t *testing.T) {
+	bucket := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		a, b *Rollup
+		less bool
+	}{
+		{&Rollup{Bucket: bucket, Kind: RollupTotal}, &Rollup{Bucket: bucket.Add(time.Hour), Kind: RollupOrigin}, true},
+		{&Rollup{Bucket: bucket.Add(time.Hour), Kind: RollupOrigin}, &Rollup{Bucket: bucket, Kind: RollupTotal}, false},
+		{&Rollup{Bucket: bucket, Kind: RollupOrigin, Key: "65000"}, &Rollup{Bucket: bucket, Kind: RollupPeer}, true},
+		{&Rollup{Bucket: bucket, Kind: RollupPeer, Key: "192.0.2.1"}, &Rollup{Bucket: bucket, Kind: RollupPeer, Key: "192.0.2.2"}, true},
+		{&Rollup{Bucket: bucket, Kind: RollupPeer, Key: "192.0.2.1"}, &Rollup{Bucket: bucket, Kind: RollupPeer, Key: "192.0.2.1"}, false},
+	}
+
+	for _, v := range tests {
+		if rollupLess(v.a, v.b) != v.less {
+			t.Errorf("Rollups: %+v, %+v, Expected less: %t", v.a, v.b, v.less)
+		}
+	}
+}
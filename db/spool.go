@@ -0,0 +1,509 @@
+package db
+
+// spool.go contains the on-disk spool of capture write streams. While a
+// stream writes to the database, every capture it accepts is also appended
+// to a segment file, which is removed once the stream commits. If the
+// database becomes unavailable, the segment is renamed to show it's
+// spooling, the stream keeps appending to it instead, and seals it when it's
+// flushed. Sealed segments are replayed into the database by the session
+// once it's reachable again.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolOpenExt     = ".open"
+	spoolSpoolingExt = ".spooling"
+	spoolSealedExt   = ".spool"
+	spoolFailedExt   = ".failed"
+
+	// spoolReplayInterval is how often a session checks for sealed segments
+	// to replay.
+	spoolReplayInterval = 30 * time.Second
+	// spoolPingTimeout bounds the ping used to decide if the database is
+	// unavailable.
+	spoolPingTimeout = 5 * time.Second
+)
+
+var (
+	errSpoolFull    = errors.New("capture spool is full")
+	errSpoolCorrupt = errors.New("corrupt spool segment")
+)
+
+// spools holds every spool opened by this process, keyed by directory, so
+// sessions opened from the same configuration share one. A spool directory
+// must not be shared between processes.
+var (
+	spoolsMu sync.Mutex
+	spools   = make(map[string]*captureSpool)
+)
+
+// SpoolStatus describes the capture spool of a session.
+type SpoolStatus struct {
+	Enabled bool
+	Dir     string
+	// Segments and Captures count the sealed segments waiting to be
+	// replayed, and the captures in them.
+	Segments int
+	Captures int64
+	// Bytes is the size of every segment, including the ones of open streams
+	// and failed segments.
+	Bytes    int64
+	MaxBytes int64
+	// OpenSegments is the number of streams writing to the spool, and
+	// Spooling is how many of them can't reach the database.
+	OpenSegments int
+	Spooling     int
+	// Failed is the number of segments which couldn't be read, and were left
+	// in the directory for an operator.
+	Failed     int
+	Replaying  bool
+	LastReplay time.Time
+	LastError  string
+}
+
+// captureSpool is a directory of spool segments with a size limit.
+type captureSpool struct {
+	dir      string
+	maxBytes int64
+	refs     int // protected by spoolsMu
+
+	mu         sync.Mutex
+	seq        int
+	bytes      int64
+	sealed     []*spoolSegment
+	open       int
+	spooling   int
+	failed     int
+	replaying  bool
+	lastReplay time.Time
+	lastErr    string
+}
+
+// spoolSegment is a file of JSON encoded captures, one per line.
+type spoolSegment struct {
+	sp       *captureSpool
+	path     string
+	fd       *os.File
+	w        *bufio.Writer
+	captures int64
+	bytes    int64
+	spooling bool
+}
+
+// openCaptureSpool returns the spool in dir, creating it if necessary.
+// Segments left by a previous process are recovered like recover does.
+// Every call must be matched by a call to release.
+func openCaptureSpool(dir string, maxBytes int64) (*captureSpool, error) {
+	spoolsMu.Lock()
+	defer spoolsMu.Unlock()
+
+	if sp, ok := spools[dir]; ok {
+		sp.refs++
+		return sp, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	sp := &captureSpool{dir: dir, maxBytes: maxBytes, refs: 1}
+	if err := sp.recover(); err != nil {
+		return nil, err
+	}
+	spools[dir] = sp
+
+	return sp, nil
+}
+
+// recover loads the segments already in the spool directory. Segments
+// which were spooling are sealed, since their captures only made it to the
+// spool. Segments which weren't are removed: their captures were either
+// committed, or never flushed by their client, which has to send them again.
+func (sp *captureSpool) recover() error {
+	files, err := ioutil.ReadDir(sp.dir)
+	if err != nil {
+		return err
+	}
+
+	// Segment names start with their creation time, so this is the order
+	// they were written in.
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	for _, fi := range files {
+		path := filepath.Join(sp.dir, fi.Name())
+
+		switch filepath.Ext(path) {
+		case spoolOpenExt:
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		case spoolSpoolingExt:
+			sealedPath := strings.TrimSuffix(path, spoolSpoolingExt) + spoolSealedExt
+			if err := os.Rename(path, sealedPath); err != nil {
+				return err
+			}
+			path = sealedPath
+		case spoolSealedExt:
+		case spoolFailedExt:
+			sp.failed++
+			sp.bytes += fi.Size()
+			continue
+		default:
+			continue
+		}
+
+		captures, err := countSpoolLines(path)
+		if err != nil {
+			return err
+		}
+		sp.sealed = append(sp.sealed, &spoolSegment{sp: sp, path: path, captures: captures, bytes: fi.Size()})
+		sp.bytes += fi.Size()
+	}
+
+	if len(sp.sealed) != 0 {
+		dbLogger.Infof("Found %d spool segments to replay in %s", len(sp.sealed), sp.dir)
+	}
+	return nil
+}
+
+// release drops a reference to the spool.
+func (sp *captureSpool) release() {
+	spoolsMu.Lock()
+	defer spoolsMu.Unlock()
+
+	sp.refs--
+	if sp.refs == 0 {
+		delete(spools, sp.dir)
+	}
+}
+
+// newSegment creates an open segment for a stream.
+func (sp *captureSpool) newSegment() (*spoolSegment, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.seq++
+	name := fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), sp.seq, spoolOpenExt)
+	path := filepath.Join(sp.dir, name)
+
+	fd, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	sp.open++
+
+	return &spoolSegment{sp: sp, path: path, fd: fd, w: bufio.NewWriter(fd)}, nil
+}
+
+// reserve claims n bytes of the spool, or returns errSpoolFull.
+func (sp *captureSpool) reserve(n int64) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.bytes+n > sp.maxBytes {
+		return errSpoolFull
+	}
+	sp.bytes += n
+	return nil
+}
+
+// status returns the current state of the spool.
+func (sp *captureSpool) status() SpoolStatus {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	st := SpoolStatus{
+		Enabled:      true,
+		Dir:          sp.dir,
+		Segments:     len(sp.sealed),
+		Bytes:        sp.bytes,
+		MaxBytes:     sp.maxBytes,
+		OpenSegments: sp.open,
+		Spooling:     sp.spooling,
+		Failed:       sp.failed,
+		Replaying:    sp.replaying,
+		LastReplay:   sp.lastReplay,
+		LastError:    sp.lastErr,
+	}
+	for _, seg := range sp.sealed {
+		st.Captures += seg.captures
+	}
+	return st
+}
+
+// append adds a capture to the segment, if there is room in the spool.
+func (seg *spoolSegment) append(cap *Capture) error {
+	data, err := json.Marshal(cap)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	n := int64(len(data))
+	if err := seg.sp.reserve(n); err != nil {
+		return err
+	}
+	seg.bytes += n
+
+	if _, err := seg.w.Write(data); err != nil {
+		return err
+	}
+	seg.captures++
+	return nil
+}
+
+// setSpooling marks the stream writing this segment as unable to reach the
+// database, and renames the segment so it's replayed if the process stops
+// before sealing it.
+func (seg *spoolSegment) setSpooling() {
+	seg.sp.mu.Lock()
+	defer seg.sp.mu.Unlock()
+
+	if seg.spooling {
+		return
+	}
+	seg.spooling = true
+	seg.sp.spooling++
+
+	spoolingPath := strings.TrimSuffix(seg.path, spoolOpenExt) + spoolSpoolingExt
+	if err := os.Rename(seg.path, spoolingPath); err != nil {
+		dbLogger.Errorf("Error marking spool segment as spooling, it won't be recovered after a restart: %s", err)
+		return
+	}
+	seg.path = spoolingPath
+}
+
+// seal writes the segment to disk and queues it to be replayed. If it can't
+// be written, it's discarded.
+func (seg *spoolSegment) seal() error {
+	err := seg.w.Flush()
+	if err == nil {
+		err = seg.fd.Sync()
+	}
+	if cerr := seg.fd.Close(); err == nil {
+		err = cerr
+	}
+
+	sealedPath := strings.TrimSuffix(seg.path, filepath.Ext(seg.path)) + spoolSealedExt
+	if err == nil {
+		err = os.Rename(seg.path, sealedPath)
+	}
+	if err != nil {
+		seg.remove()
+		return err
+	}
+
+	sp := seg.sp
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.open--
+	if seg.spooling {
+		sp.spooling--
+	}
+	seg.path = sealedPath
+	sp.sealed = append(sp.sealed, seg)
+	return nil
+}
+
+// discard removes an open segment, once its captures are committed or the
+// stream is cancelled.
+func (seg *spoolSegment) discard() {
+	if err := seg.fd.Close(); err != nil {
+		dbLogger.Errorf("Error closing spool segment: %s", err)
+	}
+	seg.remove()
+}
+
+// remove deletes the file of an open segment and releases its space.
+func (seg *spoolSegment) remove() {
+	if err := os.Remove(seg.path); err != nil {
+		dbLogger.Errorf("Error removing spool segment: %s", err)
+	}
+
+	sp := seg.sp
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.open--
+	if seg.spooling {
+		sp.spooling--
+	}
+	sp.bytes -= seg.bytes
+}
+
+// nextSealed returns the oldest sealed segment and marks the spool as
+// replaying, or returns nil if there is none or a replay is running.
+func (sp *captureSpool) nextSealed() *spoolSegment {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.replaying || len(sp.sealed) == 0 {
+		return nil
+	}
+	sp.replaying = true
+	return sp.sealed[0]
+}
+
+// finishReplay records the result of replaying seg, which was returned by
+// nextSealed. A segment that was replayed is removed. A corrupt segment is
+// renamed so it isn't replayed again, and any other failure leaves it to
+// be retried.
+func (sp *captureSpool) finishReplay(seg *spoolSegment, err error) {
+	var rmErr error
+	switch {
+	case err == nil:
+		rmErr = os.Remove(seg.path)
+	case errors.Is(err, errSpoolCorrupt):
+		rmErr = os.Rename(seg.path, strings.TrimSuffix(seg.path, spoolSealedExt)+spoolFailedExt)
+	}
+	if rmErr != nil {
+		dbLogger.Errorf("Error removing replayed spool segment: %s", rmErr)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.replaying = false
+	sp.lastReplay = time.Now()
+	sp.lastErr = ""
+	if err != nil {
+		sp.lastErr = err.Error()
+	}
+
+	if err == nil || errors.Is(err, errSpoolCorrupt) {
+		sp.sealed = sp.sealed[1:]
+		if err == nil {
+			sp.bytes -= seg.bytes
+		} else {
+			sp.failed++
+		}
+	}
+}
+
+// replay writes every sealed segment into the session's database, oldest
+// first, and stops at the first failure or when the session is closed. A
+// segment is removed once its captures are committed, so if the commit
+// succeeds but the removal fails, its captures are written again by the next
+// replay.
+func (sp *captureSpool) replay(s *Session) {
+	for {
+		select {
+		case <-s.cancel:
+			return
+		default:
+		}
+
+		seg := sp.nextSealed()
+		if seg == nil {
+			return
+		}
+
+		n, err := replaySpoolSegment(s, seg.path)
+		sp.finishReplay(seg, err)
+		if err != nil {
+			dbLogger.Errorf("Error replaying spool segment %s: %s", seg.path, err)
+			return
+		}
+		dbLogger.Infof("Replayed %d spooled captures from %s", n, seg.path)
+	}
+}
+
+// replaySpoolSegment writes the captures of a segment to a new write stream
+// of the session, and commits them.
+func replaySpoolSegment(s *Session, path string) (int64, error) {
+	s.wp.Add()
+	ws, err := newWriteCapStream(newSessionStream(s, s.dbo, s.schema, s.wp), s.cancel, nil, s.dedup, s.bus)
+	if err != nil {
+		s.wp.Done()
+		return 0, err
+	}
+	defer ws.Close()
+
+	n, err := readSpoolSegment(path, ws.Write)
+	if err != nil {
+		ws.Cancel()
+		return n, err
+	}
+	return n, ws.Flush()
+}
+
+// readSpoolSegment calls fn with every capture in a segment, and returns
+// how many there were. A partial capture at the end of the segment, left by
+// a process which stopped while writing it, is skipped.
+func readSpoolSegment(path string, fn func(interface{}) error) (int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	br := bufio.NewReader(fd)
+	var n int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				dbLogger.Infof("Skipping partial capture at the end of spool segment %s", path)
+			}
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		cap := &Capture{}
+		if err := json.Unmarshal(line, cap); err != nil {
+			return n, fmt.Errorf("%w: %s", errSpoolCorrupt, err)
+		}
+		if err := fn(cap); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// countSpoolLines returns the number of complete captures in a segment,
+// without decoding them.
+func countSpoolLines(path string) (int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	var n int64
+	buf := make([]byte, 32*1024)
+	for {
+		read, err := fd.Read(buf)
+		n += int64(bytes.Count(buf[:read], []byte{'\n'}))
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// pingDB returns an error if the database can't be reached.
+func pingDB(db *sql.DB) error {
+	ctx, cf := context.WithTimeout(context.Background(), spoolPingTimeout)
+	defer cf()
+
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestSpoolCapture(t *testing.T) *Capture {
+	_, prefix, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Capture{
+		Timestamp:  time.Date(2019, time.June, 1, 0, 30, 0, 0, time.UTC),
+		Origin:     65002,
+		Advertised: []*net.IPNet{prefix},
+		ASPath:     []uint32{65001, 65002},
+		ColIP:      net.ParseIP("2001:db8::1"),
+		PeerIP:     net.ParseIP("2001:db8::2"),
+		PeerAS:     65001,
+		NextHop:    net.ParseIP("2001:db8::3"),
+	}
+}
+
+func TestSpoolSealAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgpmon-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sp, err := openCaptureSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.release()
+
+	cap := newTestSpoolCapture(t)
+	seg, err := sp.newSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := seg.append(cap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seg.setSpooling()
+
+	st := sp.status()
+	if st.OpenSegments != 1 || st.Spooling != 1 || st.Segments != 0 {
+		t.Errorf("Expected one open spooling segment, Got: %+v", st)
+	}
+
+	if err := seg.seal(); err != nil {
+		t.Fatal(err)
+	}
+	st = sp.status()
+	if st.OpenSegments != 0 || st.Spooling != 0 || st.Segments != 1 || st.Captures != 3 || st.Bytes != seg.bytes {
+		t.Errorf("Expected one sealed segment of 3 captures, Got: %+v", st)
+	}
+
+	n, err := readSpoolSegment(seg.path, func(arg interface{}) error {
+		got := arg.(*Capture)
+		if !got.Timestamp.Equal(cap.Timestamp) || !got.ColIP.Equal(cap.ColIP) || got.PeerAS != cap.PeerAS ||
+			len(got.Advertised) != 1 || got.Advertised[0].String() != cap.Advertised[0].String() {
+			t.Errorf("Expected: %+v, Got: %+v", cap, got)
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Errorf("Expected to read 3 captures, Got: %d %v", n, err)
+	}
+
+	sp.finishReplay(seg, nil)
+	if st := sp.status(); st.Segments != 0 || st.Bytes != 0 {
+		t.Errorf("Expected an empty spool after the replay, Got: %+v", st)
+	}
+	if _, err := os.Stat(seg.path); !os.IsNotExist(err) {
+		t.Errorf("Expected the replayed segment to be removed, Got: %v", err)
+	}
+}
+
+func TestSpoolLimitAndRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgpmon-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sp, err := openCaptureSpool(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg, err := sp.newSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cap := newTestSpoolCapture(t)
+	written := 0
+	for ; written < 100; written++ {
+		if err = seg.append(cap); err != nil {
+			break
+		}
+	}
+	if err != errSpoolFull || written == 0 {
+		t.Fatalf("Expected the spool to fill up, Got: %d captures, %v", written, err)
+	}
+
+	seg.setSpooling()
+
+	// A segment which wasn't spooling is removed, not replayed.
+	plain, err := sp.newSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.fd.Close()
+
+	// Leave a partial capture at the end of the segment, as if the process
+	// stopped while writing it.
+	if _, err := seg.w.WriteString(`{"Timestamp":`); err != nil {
+		t.Fatal(err)
+	}
+	if err := seg.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sp.release()
+
+	recovered, err := openCaptureSpool(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.release()
+	seg.fd.Close()
+
+	st := recovered.status()
+	if st.Segments != 1 || st.Captures != int64(written) {
+		t.Fatalf("Expected one segment of %d captures, Got: %+v", written, st)
+	}
+
+	n, err := readSpoolSegment(recovered.sealed[0].path, func(interface{}) error { return nil })
+	if err != nil || n != int64(written) {
+		t.Errorf("Expected to read %d captures, Got: %d %v", written, n, err)
+	}
+	if _, err := os.Stat(plain.path); !os.IsNotExist(err) {
+		t.Errorf("Expected the segment which wasn't spooling to be removed, Got: %v", err)
+	}
+}
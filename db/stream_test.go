@@ -25,12 +25,12 @@ var (
 		{
 			Name:         "test1",
 			Email:        "test1@test.com",
-			OwnedOrigins: []int{1, 2, 3},
+			OwnedOrigins: []uint32{1, 2, 3},
 		},
 		{
 			Name:         "test2",
 			Email:        "test2@test.com",
-			OwnedOrigins: []int{4, 5, 6},
+			OwnedOrigins: []uint32{4, 5, 6},
 			OwnedPrefixes: []*net.IPNet{
 				{
 					IP:   net.IPv4(1, 2, 3, 0),
@@ -160,6 +160,34 @@ func TestEntityReadStream(t *testing.T) {
 	t.Logf("Total entities read: %d", msgCt)
 }
 
+func TestReadStreamWrongFilter(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	tests := []struct {
+		sType SessionType
+		fo    FilterOptions
+	}{
+		{SessionReadCapture, &EntityFilterOptions{}},
+		{SessionReadPrefix, &EntityFilterOptions{}},
+		{SessionReadEntity, DefaultCaptureFilterOptions()},
+		{SessionReadEntityHistory, DefaultCaptureFilterOptions()},
+	}
+	for _, v := range tests {
+		stream, err := session.OpenReadStream(v.sType, v.fo)
+		if err == nil || stream != nil {
+			t.Errorf("Stream type %d: Expected an error and no stream, Got: %v, %v", v.sType, stream, err)
+		}
+	}
+}
+
 func TestEntityNameFilters(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -252,7 +280,7 @@ func TestCaptureOriginFilter(t *testing.T) {
 
 	cfo := NewCaptureFilterOptions(collector, start, end)
 	// This origin was manually observed in the data
-	filterOrigin := 29838
+	filterOrigin := uint32(29838)
 	cfo.SetOrigin(filterOrigin)
 
 	stream, err := session.OpenReadStream(SessionReadCapture, cfo)
@@ -346,3 +374,229 @@ func TestCapturePrefixFilter(t *testing.T) {
 
 	t.Logf("Total messages read: %d", msgCt)
 }
+
+func TestIPv6WriteReadStream(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// These dates correspond to the data in the IPv6 sample MRT file.
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2019, time.June, 2, 0, 0, 0, 0, time.UTC)
+
+	cfo := NewCaptureFilterOptions("testcollector6", start, end)
+	cfo.SetFamily(IPv6Family)
+
+	rs, err := session.OpenReadStream(SessionReadCapture, cfo)
+	if err != nil {
+		t.Fatalf("Error opening read stream: %s", err)
+	}
+	defer rs.Close()
+
+	msgCt := 0
+	for rs.Read() {
+		cap := rs.Data().(*Capture)
+		if len(cap.PeerIP) == net.IPv4len {
+			t.Fatalf("Expected an IPv6 peer, Got: %s", cap.PeerIP)
+		}
+		msgCt++
+	}
+
+	if err := rs.Err(); err != nil {
+		t.Fatalf("Stream failed: %s", err)
+	}
+
+	if msgCt < written {
+		t.Fatalf("Expected at least %d captures, Got: %d", written, msgCt)
+	}
+}
+
+func TestCaptureTimeWindow(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The IPv6 sample MRT file has a capture every 5 minutes starting at
+	// 2019-06-01 00:00 UTC, stored in daily tables.
+	tests := []struct {
+		start, end time.Time
+	}{
+		// Part of a single table.
+		{time.Date(2019, time.June, 1, 0, 5, 0, 0, time.UTC), time.Date(2019, time.June, 1, 0, 15, 0, 0, time.UTC)},
+		// Overlapping the start of a table.
+		{time.Date(2019, time.May, 31, 23, 50, 0, 0, time.UTC), time.Date(2019, time.June, 1, 0, 5, 0, 0, time.UTC)},
+		// The same window in a different time zone.
+		{time.Date(2019, time.June, 1, 0, 5, 0, 0, time.UTC).In(time.FixedZone("UTC-6", -6*3600)),
+			time.Date(2019, time.June, 1, 0, 15, 0, 0, time.UTC).In(time.FixedZone("UTC+9", 9*3600))},
+	}
+
+	for _, v := range tests {
+		rs, err := session.OpenReadStream(SessionReadCapture, NewCaptureFilterOptions("testcollector6", v.start, v.end))
+		if err != nil {
+			t.Fatalf("Error opening read stream: %s", err)
+		}
+
+		msgCt := 0
+		for rs.Read() {
+			cap := rs.Data().(*Capture)
+			if cap.Timestamp.Before(v.start) || !cap.Timestamp.Before(v.end) {
+				t.Errorf("Capture at %s is outside of [%s, %s)", cap.Timestamp, v.start, v.end)
+			}
+			msgCt++
+		}
+
+		if err := rs.Err(); err != nil {
+			t.Fatalf("Stream failed: %s", err)
+		}
+		rs.Close()
+
+		if msgCt == 0 {
+			t.Errorf("Expected captures in [%s, %s), found none", v.start, v.end)
+		}
+	}
+}
+
+func TestRollupCaptures(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every capture of the IPv6 sample MRT file falls in the first hour of
+	// 2019-06-01. Rolling up twice must give the same result.
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	var totals []*Rollup
+	for i := 0; i < 2; i++ {
+		if err := session.RollupCaptures("testcollector6", RollupHour, start, start.Add(time.Hour)); err != nil {
+			t.Fatalf("Error rolling up captures: %s", err)
+		}
+
+		fo := NewRollupFilterOptions("testcollector6", RollupHour, start, start.Add(time.Hour))
+		fo.SetKind(RollupTotal)
+		rs, err := session.OpenReadStream(SessionReadRollup, fo)
+		if err != nil {
+			t.Fatalf("Error opening read stream: %s", err)
+		}
+
+		for rs.Read() {
+			totals = append(totals, rs.Data().(*Rollup))
+		}
+		if err := rs.Err(); err != nil {
+			t.Fatalf("Stream failed: %s", err)
+		}
+		rs.Close()
+	}
+
+	if len(totals) != 2 {
+		t.Fatalf("Expected one total rollup per run, Got: %d", len(totals))
+	}
+
+	first, second := totals[0], totals[1]
+	if !first.Bucket.Equal(start) {
+		t.Errorf("Expected bucket: %s, Got: %s", start, first.Bucket)
+	}
+	if first.Updates == 0 || first.Withdrawals == 0 || first.UniquePaths == 0 {
+		t.Errorf("Expected updates, withdrawals and paths to be counted, Got: %+v", first)
+	}
+	if *first != *second {
+		t.Errorf("Rolling up again changed the rollup from %+v to %+v", first, second)
+	}
+}
+
+func TestCaptureTableStatsAndCoverage(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	session, err := openTestSession(1)
+	if err != nil {
+		t.Fatalf("Error opening test session: %s", err)
+	}
+	defer RunAndLog(session.Close)
+
+	ws, err := session.OpenWriteStream(SessionWriteCapture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeFileToStream("../docs/sample_mrt_v6", ws)
+	ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The collector has one table per day, and every capture of the sample
+	// file falls in the first hour of it.
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	stats, err := session.CaptureTableStats("testcollector6", start, end)
+	if err != nil {
+		t.Fatalf("Error getting table stats: %s", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Expected one table, Got: %d", len(stats))
+	}
+
+	ts := stats[0]
+	if ts.Captures == 0 || ts.SizeBytes == 0 {
+		t.Errorf("Expected captures and a size, Got: %+v", ts)
+	}
+	if ts.Hours != 24 || ts.HoursWithData != 1 || !ts.HasGaps() {
+		t.Errorf("Expected 1 of 24 hours with data, Got: %d of %d", ts.HoursWithData, ts.Hours)
+	}
+
+	buckets, err := session.CaptureCoverage("testcollector6", RollupHour, start, end)
+	if err != nil {
+		t.Fatalf("Error getting coverage: %s", err)
+	}
+	if len(buckets) != 1 || !buckets[0].Start.Equal(start) || buckets[0].Captures != ts.Captures {
+		t.Errorf("Expected one bucket at %s with %d captures, Got: %v", start, ts.Captures, buckets)
+	}
+}
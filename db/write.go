@@ -21,14 +21,34 @@ type writeCapStream struct {
 	cancel chan bool
 
 	ex       util.AtomicSQLExecutor
-	buffers  map[string]util.SQLBuffer
+	buffers  map[string]*util.InsertBuffer
 	cache    tableCache
 	daemonWG sync.WaitGroup
+
+	// If dedup is set, captures are written with their content hash, and the
+	// ones already in their table are skipped.
+	dedup   bool
+	written int64
+	skipped int64
+
+	// pending holds the captures written since the stream opened, to be
+	// published on bus once they're committed. They're only kept while the
+	// bus has subscribers, and at most maxPendingPublish of them.
+	bus     *captureBus
+	pending []*Capture
+
+	// seg holds every capture written to the stream if the session has a
+	// spool. Once spooling is set, the database is unavailable and captures
+	// are only written to seg.
+	seg      *spoolSegment
+	spooling bool
 }
 
-// newWriteCapStream returns a newly allocated writeCapStream.
-func newWriteCapStream(baseStream *sessionStream, pCancel chan bool) (*writeCapStream, error) {
-	w := &writeCapStream{sessionStream: baseStream, daemonWG: sync.WaitGroup{}}
+// newWriteCapStream returns a newly allocated writeCapStream. If spool isn't
+// nil, the stream writes ahead to it, and can be opened while the database
+// is unavailable. Captures are published on bus once they're committed.
+func newWriteCapStream(baseStream *sessionStream, pCancel chan bool, spool *captureSpool, dedup bool, bus *captureBus) (*writeCapStream, error) {
+	w := &writeCapStream{sessionStream: baseStream, daemonWG: sync.WaitGroup{}, dedup: dedup, bus: bus}
 
 	parentCancel := pCancel
 	childCancel := make(chan bool)
@@ -50,16 +70,36 @@ func newWriteCapStream(baseStream *sessionStream, pCancel chan bool) (*writeCapS
 	// This needs to have a buffer of 1 so the daemon can send back a response
 	// when it's cancelled, and doesn't have to wait for the next request.
 	w.resp = make(chan CommonReply, 1)
-	w.buffers = make(map[string]util.SQLBuffer)
+	w.buffers = make(map[string]*util.InsertBuffer)
 	w.cache = newNestedTableCache(baseStream.schema)
 
 	ctxTx, err := newCtxExecutor(w.db)
-	if err != nil {
+	if err != nil && (spool == nil || pingDB(w.db.DB()) == nil) {
 		dbLogger.Errorf("Error opening ctxTx executor: %s", err)
 		close(w.cancel)
 		return nil, err
+	} else if err != nil {
+		dbLogger.Infof("Database unavailable, spooling stream: %s", err)
+		w.spooling = true
+	} else {
+		w.ex = ctxTx
+	}
+
+	if spool != nil {
+		seg, serr := spool.newSegment()
+		if serr != nil && w.spooling {
+			dbLogger.Errorf("Error creating spool segment: %s", serr)
+			close(w.cancel)
+			return nil, err
+		} else if serr != nil {
+			dbLogger.Errorf("Error creating spool segment, the stream won't be spooled: %s", serr)
+		} else {
+			w.seg = seg
+			if w.spooling {
+				seg.setSpooling()
+			}
+		}
 	}
-	w.ex = ctxTx
 
 	w.daemonWG.Add(1)
 	go w.listen(daemonCancel)
@@ -71,8 +111,50 @@ func newWriteCapStream(baseStream *sessionStream, pCancel chan bool) (*writeCapS
 func (w *writeCapStream) Write(arg interface{}) error {
 	cap := arg.(*Capture)
 
+	if w.seg != nil {
+		if err := w.seg.append(cap); err != nil && w.spooling {
+			return dbLogger.Errorf("failed to spool capture: %s", err)
+		} else if err != nil {
+			// Without every capture, the segment can't replace the transaction.
+			dbLogger.Errorf("Stopped spooling stream: %s", err)
+			w.seg.discard()
+			w.seg = nil
+		}
+	}
+
+	if w.spooling {
+		// The daemon only replies without a request if it was cancelled.
+		select {
+		case <-w.resp:
+			return fmt.Errorf("writeCapStream cancelled")
+		default:
+			w.written++
+			return nil
+		}
+	}
+
+	err := w.writeDB(cap)
+	if err == nil {
+		if w.bus != nil && w.bus.hasSubscribers() {
+			if len(w.pending) < maxPendingPublish {
+				w.pending = append(w.pending, cap)
+			} else {
+				w.bus.drop(cap)
+			}
+		}
+		w.written++
+		return nil
+	} else if w.startSpooling(err) {
+		w.written++
+		return nil
+	}
+	return err
+}
+
+// writeDB sends a capture to the daemon to be buffered for the database.
+func (w *writeCapStream) writeDB(cap *Capture) error {
 	// Check our local cache first, otherwise contact schemaMgr.
-	table, err := w.cache.LookupTable(cap.ColIP, cap.Timestamp)
+	table, _, err := w.cache.LookupTable(cap.ColIP, cap.Timestamp)
 	if err != nil {
 		return dbLogger.Errorf("failed to get table from cache: %s", err)
 	}
@@ -93,14 +175,78 @@ func (w *writeCapStream) Write(arg interface{}) error {
 // Flush is called when a stream finishes successfully.
 // It flushes all remaining buffers.
 func (w *writeCapStream) Flush() error {
+	if w.spooling {
+		return w.sealSpool()
+	}
+
 	dbLogger.Infof("Flushing stream")
+	w.skipped = 0
 	for key := range w.buffers {
 		err := w.buffers[key].Flush()
 		if err != nil {
 			dbLogger.Errorf("writeCapStream failed to flush buffer: %s", err)
 		}
+		w.skipped += w.buffers[key].Skipped()
+	}
+	if w.skipped != 0 {
+		dbLogger.Infof("Skipped %d duplicate captures", w.skipped)
 	}
-	return w.ex.Commit()
+
+	err := w.ex.Commit()
+	if err != nil && w.startSpooling(err) {
+		return w.sealSpool()
+	} else if err == nil && w.seg != nil {
+		w.seg.discard()
+		w.seg = nil
+	}
+
+	if err == nil && len(w.pending) != 0 {
+		w.bus.publish(w.pending)
+	}
+	w.pending = nil
+	return err
+}
+
+// Counts returns how many captures were written to the stream, and how many
+// of those were skipped as duplicates once it was flushed.
+func (w *writeCapStream) Counts() (int64, int64) {
+	return w.written, w.skipped
+}
+
+// startSpooling switches the stream to its spool segment if err was caused
+// by the database being unavailable, and returns true if it did. The open
+// transaction is lost, so it's rolled back and the whole stream is replayed
+// from the segment.
+func (w *writeCapStream) startSpooling(err error) bool {
+	if w.seg == nil || pingDB(w.db.DB()) == nil {
+		return false
+	}
+
+	dbLogger.Infof("Database unavailable, spooling stream: %s", err)
+	for key := range w.buffers {
+		w.buffers[key].Clear()
+	}
+	// The captures will be published when the spool is replayed.
+	w.pending = nil
+	if rerr := w.ex.Rollback(); rerr != nil {
+		dbLogger.Infof("Error rolling back spooled stream: %s", rerr)
+	}
+
+	w.spooling = true
+	w.seg.setSpooling()
+	return true
+}
+
+// sealSpool queues the captures of a spooling stream to be replayed.
+func (w *writeCapStream) sealSpool() error {
+	seg := w.seg
+	w.seg = nil
+	if err := seg.seal(); err != nil {
+		return dbLogger.Errorf("failed to seal spool segment: %s", err)
+	}
+
+	dbLogger.Infof("Spooled %d captures to be replayed", seg.captures)
+	return nil
 }
 
 //Cancel is used when there is an error on the client-side,
@@ -110,6 +256,15 @@ func (w *writeCapStream) Cancel() {
 	for key := range w.buffers {
 		w.buffers[key].Clear()
 	}
+	w.pending = nil
+
+	if w.seg != nil {
+		w.seg.discard()
+		w.seg = nil
+	}
+	if w.spooling {
+		return
+	}
 
 	if err := w.ex.Rollback(); err != nil {
 		dbLogger.Errorf("Error rolling back stream: %s", err)
@@ -122,6 +277,12 @@ func (w *writeCapStream) Cancel() {
 //This should be called by the same goroutine as the one calling Write.
 func (w *writeCapStream) Close() {
 	dbLogger.Infof("Closing session stream")
+	// A stream closed without a Flush never committed, so neither is its
+	// segment replayed.
+	if w.seg != nil {
+		w.seg.discard()
+		w.seg = nil
+	}
 	close(w.cancel)
 	close(w.req)
 	w.daemonWG.Wait()
@@ -171,12 +332,20 @@ func (w *writeCapStream) listen(cancel chan bool) {
 				tName := capMsg.getTableName()
 
 				buf, ok := w.buffers[tName]
-				if !ok {
+				if !ok && w.dedup {
+					buf = util.NewInsertBufferSuffix(w.ex, bufferSize, true, w.oper.getQuery(skipDuplicateCapturesOp))
+					w.buffers[tName] = buf
+				} else if !ok {
 					buf = util.NewInsertBuffer(w.ex, bufferSize, true)
 					w.buffers[tName] = buf
 				}
 
-				rep := insertCapture(newSessionExecutor(buf, w.oper), capMsg)
+				var rep CommonReply
+				if w.dedup {
+					rep = insertDedupCapture(newSessionExecutor(buf, w.oper), capMsg)
+				} else {
+					rep = insertCapture(newSessionExecutor(buf, w.oper), capMsg)
+				}
 				w.resp <- rep
 			} else {
 				return
@@ -257,3 +426,91 @@ func newWriteEntityStream(baseStream *sessionStream, pcancel chan bool) (*writeE
 
 	return es, nil
 }
+
+// writePeerEventStream is a Write Stream that writes PeerEvent structs into
+// the database.
+type writePeerEventStream struct {
+	*sessionStream
+
+	cancel chan bool
+	done   bool
+	ex     util.AtomicSQLExecutor
+
+	// Events already stored are skipped, so writing the same file twice
+	// doesn't store its events twice.
+	written int64
+	skipped int64
+}
+
+// Write will panic if ev is not a PeerEvent struct.
+func (ps *writePeerEventStream) Write(ev interface{}) error {
+	evMsg := newPeerEventMessage(ev.(*PeerEvent))
+	// Make sure this uses the same tables as the schema
+	ps.schema.setMessageTables(evMsg)
+
+	rep := insertPeerEvent(newSessionExecutor(ps.ex, ps.oper), evMsg)
+	if rep.Error() != nil {
+		return rep.Error()
+	}
+
+	ps.written++
+	if rep.(*insertReply).isSkipped() {
+		ps.skipped++
+	}
+	return nil
+}
+
+// Counts returns how many events were written to the stream, and how many
+// of those were skipped as duplicates.
+func (ps *writePeerEventStream) Counts() (int64, int64) {
+	return ps.written, ps.skipped
+}
+
+func (ps *writePeerEventStream) Flush() error {
+	if ps.done {
+		return nil
+	}
+
+	ps.done = true
+	return ps.ex.Commit()
+}
+
+func (ps *writePeerEventStream) Cancel() {
+	if ps.done {
+		return
+	}
+
+	if err := ps.ex.Rollback(); err != nil {
+		dbLogger.Errorf("Error rolling back writePeerEventStream write: %s", err)
+	}
+	ps.done = true
+}
+
+func (ps *writePeerEventStream) Close() {
+	close(ps.cancel)
+	ps.wp.Done()
+}
+
+// waitForCancel rolls back the stream if it wasn't flushed when either it or
+// its session is closed.
+func (ps *writePeerEventStream) waitForCancel(parent chan bool) {
+	select {
+	case <-parent:
+	case <-ps.cancel:
+	}
+	ps.Cancel()
+}
+
+func newWritePeerEventStream(baseStream *sessionStream, pcancel chan bool) (*writePeerEventStream, error) {
+	ps := &writePeerEventStream{sessionStream: baseStream}
+	ctxEx, err := newCtxExecutor(baseStream.db)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.ex = ctxEx
+	ps.cancel = make(chan bool)
+	go ps.waitForCancel(pcancel)
+
+	return ps, nil
+}
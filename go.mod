@@ -5,6 +5,7 @@ require (
 	github.com/CSUNetSec/netsec-protobufs v0.1.5
 	github.com/CSUNetSec/protoparse v0.1.3
 	github.com/araddon/dateparse v0.0.0-20181123171228-21df004e09ca
+	github.com/golang/protobuf v1.2.0
 	github.com/google/uuid v1.1.0
 	github.com/lib/pq v1.0.0
 	github.com/mitchellh/go-homedir v1.1.0
@@ -0,0 +1,113 @@
+package bgpmon
+
+import (
+	"time"
+)
+
+// leaseCheckInterval is how often the server looks for sessions whose lease
+// has expired.
+const leaseCheckInterval = 10 * time.Second
+
+// leaseExpired returns whether a leased session should be reaped: it has no
+// open streams, and has been idle for longer than its TTL.
+func leaseExpired(ttl time.Duration, open int, last, now time.Time) bool {
+	return ttl > 0 && open == 0 && now.Sub(last) > ttl
+}
+
+// LeaseSession gives the session with ID sID a lease held by owner. Once the
+// session has no open streams, and hasn't been active for longer than ttl,
+// it's closed by the server. A ttl of 0 removes the lease. A lease held by
+// another owner can't be changed, but one without an owner can be taken.
+// Leasing a session renews it.
+func (s *server) LeaseSession(sID, owner string, ttl time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	sh, ok := s.sessions[sID]
+	if !ok {
+		return coreLogger.Errorf("No session found with ID: %s", sID)
+	}
+	if sh.Owner != "" && sh.Owner != owner {
+		return coreLogger.Errorf("Session %s is leased by %s", sID, sh.Owner)
+	}
+	if ttl < 0 {
+		return coreLogger.Errorf("Invalid lease TTL: %s", ttl)
+	}
+
+	if ttl == 0 {
+		owner = ""
+	}
+	sh.Owner, sh.TTL = owner, ttl
+	s.sessions[sID] = sh
+	sh.Session.Touch()
+	s.saveState()
+
+	return nil
+}
+
+// RenewSession renews the lease of the session with ID sID, like any other
+// activity on it. It's an error if the session is leased by another owner.
+func (s *server) RenewSession(sID, owner string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	sh, ok := s.sessions[sID]
+	if !ok {
+		return coreLogger.Errorf("No session found with ID: %s", sID)
+	}
+	if sh.Owner != "" && sh.Owner != owner {
+		return coreLogger.Errorf("Session %s is leased by %s", sID, sh.Owner)
+	}
+
+	sh.Session.Touch()
+	return nil
+}
+
+// reapSessions closes the sessions whose lease expired, until stop is
+// closed. They are removed from the server first, and closed without
+// holding its mutex.
+func (s *server) reapSessions(stop chan struct{}) {
+	tick := time.NewTicker(leaseCheckInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+
+		s.mux.Lock()
+		if s.draining || s.closing {
+			s.mux.Unlock()
+			continue
+		}
+
+		now := time.Now()
+		var expired []SessionHandle
+		for id, sh := range s.sessions {
+			var idle time.Time
+			ok := sh.Session.Expire(func(open int, last time.Time) bool {
+				idle = last
+				return leaseExpired(sh.TTL, open, last, now)
+			})
+			if !ok {
+				continue
+			}
+
+			coreLogger.Infof("Lease of session %s expired, idle since %s, closing it", id, idle.Format(time.RFC3339))
+			delete(s.sessions, id)
+			expired = append(expired, sh)
+		}
+		if len(expired) != 0 {
+			s.saveState()
+		}
+		s.mux.Unlock()
+
+		for _, sh := range expired {
+			if err := sh.Session.Close(); err != nil {
+				coreLogger.Errorf("Error closing session %s: %s", sh.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package bgpmon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ttl     time.Duration
+		open    int
+		idle    time.Duration
+		expired bool
+	}{
+		{time.Minute, 0, 2 * time.Minute, true},
+		{time.Minute, 0, 30 * time.Second, false},
+		{time.Minute, 1, time.Hour, false},
+		{0, 0, time.Hour, false},
+	}
+
+	for _, v := range tests {
+		if leaseExpired(v.ttl, v.open, now.Add(-v.idle), now) != v.expired {
+			t.Errorf("TTL: %s, Open: %d, Idle: %s, Expected expired: %t", v.ttl, v.open, v.idle, v.expired)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package bgpmon
 
 import (
+	"context"
+
 	"github.com/CSUNetSec/bgpmon/util"
 )
 
@@ -42,6 +44,15 @@ type Module interface {
 	Stop() error
 }
 
+// Drainer is implemented by modules which serve streams, like the RPC
+// module, so the work they have in progress can finish before the server is
+// closed. Drain should stop accepting new work, and block until the work in
+// progress is done, or ctx is done and it was cancelled. The module is
+// still stopped with Stop afterwards.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
 // ModuleInfo is used to describe an available module. It should include the
 // type of the module, a description, and a description of the opts that the
 // module uses.
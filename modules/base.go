@@ -5,9 +5,12 @@ package modules
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/db"
 	"github.com/CSUNetSec/bgpmon/util"
 )
 
@@ -45,6 +48,49 @@ func (b *BaseTask) Stop() error {
 	return fmt.Errorf("tasks can't be stopped")
 }
 
+// getSession returns the session with ID sID open on the server, or an
+// error if there is none.
+func (b *BaseTask) getSession(sID string) (*db.Session, error) {
+	for _, sh := range b.server.ListSessions() {
+		if sh.Name == sID {
+			return sh.Session, nil
+		}
+	}
+
+	return nil, fmt.Errorf("session ID: %s not found", sID)
+}
+
+// parseDurationOpt returns the duration in the option key, or def if the
+// option isn't present. Negative durations are rejected, and so is zero,
+// unless it's the default.
+func parseDurationOpt(args map[string]string, key string, def time.Duration) (time.Duration, error) {
+	opt, ok := args[key]
+	if !ok {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(opt)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("error parsing %s: %s", key, opt)
+	}
+	return d, nil
+}
+
+// parseBoolOpt returns the boolean in the option key, or def if the option
+// isn't present.
+func parseBoolOpt(args map[string]string, key string, def bool) (bool, error) {
+	opt, ok := args[key]
+	if !ok {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(opt)
+	if err != nil {
+		return false, fmt.Errorf("error parsing %s: %s", key, opt)
+	}
+	return b, nil
+}
+
 // NewBaseTask creates a base task with the server, logger and name.
 func NewBaseTask(server core.BgpmondServer, logger util.Logger, name string) *BaseTask {
 	return &BaseTask{server: server, logger: logger, name: name}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+// fsckModule is a task which checks that the main table, the node table and
+// the capture tables of a session agree with each other. It reports every
+// problem found, and if repair is set, repairs the ones it can.
+type fsckModule struct {
+	*BaseTask
+}
+
+// fsckResult is a problem found in the catalog of a session. err is the
+// error repairing it, if a repair was attempted.
+type fsckResult struct {
+	problem  *db.CatalogProblem
+	repaired bool
+	err      error
+}
+
+// fsckSession checks the catalog of a session, and if repair is true,
+// repairs every problem that has a repair. A failed repair doesn't stop
+// the others.
+func fsckSession(sess *db.Session, repair bool) ([]fsckResult, error) {
+	problems, err := sess.CheckCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]fsckResult, 0, len(problems))
+	for _, p := range problems {
+		res := fsckResult{problem: p}
+		if repair && p.Repair != db.RepairNone {
+			res.err = sess.RepairCatalogProblem(p)
+			res.repaired = res.err == nil
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Run will check the catalog of a session. The required option key is
+// session, and repair is optional.
+func (f *fsckModule) Run(args map[string]string) {
+	if !util.CheckForKeys(args, "session") {
+		f.logger.Errorf("Expected option keys: session. Got %v", args)
+		return
+	}
+
+	repair, err := parseBoolOpt(args, "repair", false)
+	if err != nil {
+		f.logger.Errorf("%s", err)
+		return
+	}
+
+	sess, err := f.getSession(args["session"])
+	if err != nil {
+		f.logger.Errorf("Error finding session: %s", err)
+		return
+	}
+
+	results, err := fsckSession(sess, repair)
+	if err != nil {
+		f.logger.Errorf("Error checking catalog: %s", err)
+		return
+	}
+
+	repaired := 0
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			f.logger.Errorf("%s: repair failed: %s", res.problem, res.err)
+		case res.repaired:
+			f.logger.Infof("%s: repaired", res.problem)
+			repaired++
+		default:
+			f.logger.Infof("%s", res.problem)
+		}
+	}
+	f.logger.Infof("Found %d catalog problems, repaired %d", len(results), repaired)
+}
+
+func newFsckModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &fsckModule{NewBaseTask(s, l, "fsck")}
+}
+
+func init() {
+	opts := "session : the ID of the open session to check\n" +
+		"repair : if true, repair the problems found, defaults to false"
+
+	fsckHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "fsck",
+			Description: "Check, and optionally repair, the capture table catalog of a session",
+			Opts:        opts,
+		},
+		Maker: newFsckModule,
+	}
+	core.RegisterModule(fsckHandle)
+}
@@ -2,6 +2,8 @@ package modules
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	core "github.com/CSUNetSec/bgpmon"
 	"github.com/CSUNetSec/bgpmon/db"
@@ -67,7 +69,7 @@ func (h *hijackModule) Run(args map[string]string) {
 		msgCt++
 		cap := capStream.Data().(*db.Capture)
 
-		if h.isEvent(entity, cap) {
+		if isHijack(entity, cap) {
 			events++
 		}
 	}
@@ -80,11 +82,11 @@ func (h *hijackModule) Run(args map[string]string) {
 	h.logger.Infof("Scanned %d messages, detected %d events!", msgCt, events)
 }
 
-// isEvent determines whether or not a capture qualifies as a hijack.
+// isHijack determines whether or not a capture qualifies as a hijack.
 // Currently, a capture qualifies as a hijack if it contains a prefix owned
 // by the entity (as filtered above) but does not contain one of the entities
 // ownedOrigins in it's AS path
-func (h *hijackModule) isEvent(ent *db.Entity, cap *db.Capture) bool {
+func isHijack(ent *db.Entity, cap *db.Capture) bool {
 	for _, as := range ent.OwnedOrigins {
 		for _, asStep := range cap.ASPath {
 			if asStep == as {
@@ -98,9 +100,9 @@ func (h *hijackModule) isEvent(ent *db.Entity, cap *db.Capture) bool {
 
 // readEntity opens a read entity stream on the server, and returns an entity
 // with the provided name, or an error.
-func (h *hijackModule) readEntity(session, entName string) (*db.Entity, error) {
+func (b *BaseTask) readEntity(session, entName string) (*db.Entity, error) {
 	opts := db.NewEntityFilterOptions(entName)
-	entityStream, err := h.server.OpenReadStream(session, db.SessionReadEntity, opts)
+	entityStream, err := b.server.OpenReadStream(session, db.SessionReadEntity, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -125,6 +127,76 @@ func newHijackModule(s core.BgpmondServer, l util.Logger) core.Module {
 	return &hijackModule{NewBaseTask(s, l, "hijack")}
 }
 
+// hijackWatchModule is a daemon which checks the captures written to a
+// session for hijacks of an entity as they are committed.
+type hijackWatchModule struct {
+	*BaseDaemon
+
+	events  uint64
+	dropped uint64
+}
+
+// Run subscribes to the session, and logs every hijack until the module is
+// stopped or the session is closed.
+func (h *hijackWatchModule) Run(args map[string]string) {
+	defer h.wg.Done()
+
+	if !util.CheckForKeys(args, "entity", "session") {
+		h.logger.Errorf("Need entity and session keys")
+		return
+	}
+	entityName := args["entity"]
+
+	entity, err := h.readEntity(args["session"], entityName)
+	if err != nil {
+		h.logger.Errorf("Error reading entity name: %s %s", entityName, err)
+		return
+	}
+
+	// The time span of a subscription's filter is ignored if it's empty.
+	captureOptions := db.NewCaptureFilterOptions(db.AnyCollector, time.Time{}, time.Time{})
+	captureOptions.AllowSubnets(entity.OwnedPrefixes...)
+	sub, err := h.server.Subscribe(args["session"], db.SubscribeOptions{Filter: captureOptions, Policy: db.DropOldest})
+	if err != nil {
+		h.logger.Errorf("Error subscribing to session: %s", err)
+		return
+	}
+	defer sub.Close()
+
+	// Read blocks until a capture is published, so it's stopped by closing
+	// the subscription.
+	go func() {
+		<-h.ctx.Done()
+		sub.Close()
+	}()
+
+	for sub.Read() {
+		cap := sub.Data().(*db.Capture)
+		if isHijack(entity, cap) {
+			atomic.AddUint64(&h.events, 1)
+			h.logger.Infof("Possible hijack of %s: collector %s peer %s origin AS%d prefixes %v", entityName,
+				util.IPString(cap.ColIP), util.IPString(cap.PeerIP), cap.Origin, cap.Advertised)
+		}
+		atomic.StoreUint64(&h.dropped, sub.Dropped())
+	}
+
+	if err := sub.Err(); err != nil {
+		h.logger.Errorf("Subscription error: %s", err)
+	}
+	h.logger.Infof("Stopping hijack watch")
+}
+
+// GetInfo satisfies the module interface, and counts the hijacks found.
+func (h *hijackWatchModule) GetInfo() core.OpenModuleInfo {
+	status := fmt.Sprintf("Running: %d events, %d captures dropped", atomic.LoadUint64(&h.events), atomic.LoadUint64(&h.dropped))
+	return core.NewOpenModuleInfo(h.name, status)
+}
+
+// newHijackWatchModule is the module maker for the hijack watch module.
+func newHijackWatchModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &hijackWatchModule{BaseDaemon: NewBaseDaemon(s, l, "hijack_watch")}
+}
+
 func init() {
 	opts := "entity: the name of the entity to search for hijacks on\n" +
 		"session: the name of the session to read captures from.\n" +
@@ -140,4 +212,17 @@ func init() {
 		Maker: newHijackModule,
 	}
 	core.RegisterModule(hijackHandle)
+
+	watchOpts := "entity: the name of the entity to watch for hijacks on\n" +
+		"session: the name of the session whose captures are checked as they are written."
+
+	watchHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "hijack_watch",
+			Description: "Watch captures as they are written for BGP hijacks",
+			Opts:        watchOpts,
+		},
+		Maker: newHijackWatchModule,
+	}
+	core.RegisterModule(watchHandle)
 }
@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"time"
+
+	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+// indexTablesModule is a task which creates the configured indexes of a
+// session on its existing capture tables. It backfills indexes on tables
+// created before they were configured, and it can be run by the periodic
+// module to index the tables of a session that defers its indexes.
+type indexTablesModule struct {
+	*BaseTask
+}
+
+// Run will index the capture tables of a session. The required option key is
+// session. collector is optional, and if open is true, tables still being
+// written to are indexed as well.
+func (i *indexTablesModule) Run(args map[string]string) {
+	if !util.CheckForKeys(args, "session") {
+		i.logger.Errorf("Expected option keys: session. Got %v", args)
+		return
+	}
+
+	collector := db.AnyCollector
+	if col, ok := args["collector"]; ok {
+		collector = col
+	}
+
+	open, err := parseBoolOpt(args, "open", false)
+	if err != nil {
+		i.logger.Errorf("%s", err)
+		return
+	}
+
+	// Only tables whose period has closed are indexed by default.
+	before := time.Now().UTC()
+	if open {
+		// Past the end of any table, but still a valid database timestamp.
+		before = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	}
+
+	sess, err := i.getSession(args["session"])
+	if err != nil {
+		i.logger.Errorf("Error finding session: %s", err)
+		return
+	}
+
+	ct, err := sess.IndexCaptureTables(collector, before)
+	if err != nil {
+		i.logger.Errorf("Error indexing capture tables, %d indexed: %s", ct, err)
+		return
+	}
+	i.logger.Infof("Indexed %d capture tables", ct)
+}
+
+func newIndexTablesModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &indexTablesModule{NewBaseTask(s, l, "index_tables")}
+}
+
+func init() {
+	opts := "session : the ID of the open session whose capture tables are indexed\n" +
+		"collector : only index tables of this collector, defaults to all\n" +
+		"open : if true, also index tables that are still being written to, defaults to false"
+
+	indexHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "index_tables",
+			Description: "Create the configured indexes on existing capture tables",
+			Opts:        opts,
+		},
+		Maker: newIndexTablesModule,
+	}
+	core.RegisterModule(indexHandle)
+}
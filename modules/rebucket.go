@@ -0,0 +1,125 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+// rebucketModule is a task which moves the captures of a collector into
+// tables of its current dump duration. If a new duration is provided, the
+// node is updated first. Tables still being written to can't be moved, so
+// the task should be run again once they have ended, for example by the
+// periodic module. Nodes in the configuration file are preferred over the
+// stored ones when a session opens, so the duration should be changed there
+// as well.
+type rebucketModule struct {
+	*BaseTask
+}
+
+// Run will rebucket the tables of a collector. The required option keys are
+// session and collector, and duration is optional.
+func (r *rebucketModule) Run(args map[string]string) {
+	if !util.CheckForKeys(args, "session", "collector") {
+		r.logger.Errorf("Expected option keys: session, collector. Got %v", args)
+		return
+	}
+
+	sess, err := r.getSession(args["session"])
+	if err != nil {
+		r.logger.Errorf("Error finding session: %s", err)
+		return
+	}
+
+	nc, err := findNodeByName(sess, args["collector"])
+	if err != nil {
+		r.logger.Errorf("%s", err)
+		return
+	}
+
+	if durOpt, ok := args["duration"]; ok {
+		dur, err := strconv.Atoi(durOpt)
+		if err != nil || dur <= 0 {
+			r.logger.Errorf("Error parsing duration: %s", durOpt)
+			return
+		}
+
+		if nc.DumpDurationMinutes != dur {
+			r.logger.Infof("Changing the dump duration of %s from %d to %d minutes", nc.Name, nc.DumpDurationMinutes, dur)
+			nc.DumpDurationMinutes = dur
+			if err := sess.UpdateNode(nc); err != nil {
+				r.logger.Errorf("Error updating node %s: %s", nc.Name, err)
+				return
+			}
+		}
+	}
+
+	problems, err := sess.CheckCatalog()
+	if err != nil {
+		r.logger.Errorf("Error checking catalog: %s", err)
+		return
+	}
+
+	moved, open := 0, 0
+	for _, p := range problems {
+		if p.Kind != db.ProblemMisaligned || !strings.EqualFold(p.Collector, nc.Name) {
+			continue
+		}
+
+		if p.Repair != db.RepairRebucket {
+			open++
+			continue
+		}
+
+		if err := sess.RepairCatalogProblem(p); err != nil {
+			r.logger.Errorf("Error rebucketing table %s: %s", p.Table, err)
+			continue
+		}
+		moved++
+	}
+
+	r.logger.Infof("Rebucketed %d tables of %s to %d minutes", moved, nc.Name, nc.DumpDurationMinutes)
+	if open != 0 {
+		r.logger.Infof("%d tables of %s are still open, and will be rebucketed by a later run", open, nc.Name)
+	}
+}
+
+// findNodeByName returns the node of a session with the provided name.
+func findNodeByName(sess *db.Session, name string) (config.NodeConfig, error) {
+	nodes, err := sess.ListNodes()
+	if err != nil {
+		return config.NodeConfig{}, err
+	}
+
+	for _, nc := range nodes {
+		if strings.EqualFold(nc.Name, name) {
+			return nc, nil
+		}
+	}
+	return config.NodeConfig{}, fmt.Errorf("no node named %s", name)
+}
+
+func newRebucketModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &rebucketModule{NewBaseTask(s, l, "rebucket")}
+}
+
+func init() {
+	opts := "session : the ID of the open session holding the captures\n" +
+		"collector : the name of the collector whose tables are rebucketed\n" +
+		"duration : the new dump duration of the collector in minutes, defaults to its current one"
+
+	rebucketHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "rebucket",
+			Description: "Move the captures of a collector into tables of its dump duration",
+			Opts:        opts,
+		},
+		Maker: newRebucketModule,
+	}
+	core.RegisterModule(rebucketHandle)
+}
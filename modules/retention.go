@@ -0,0 +1,227 @@
+package modules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+const (
+	retentionDrop   = "drop"
+	retentionExport = "export"
+
+	defaultRetentionInterval = time.Hour
+)
+
+// retentionModule is a daemon which periodically drops the capture tables
+// of a session that are older than a configured age. Tables can be exported
+// to an archive first, which can be written back with bgpmon import. The
+// captures of an imported archive are as old as they were, so the module
+// drops their tables again on its next run unless it's stopped, or its age
+// is raised, first.
+type retentionModule struct {
+	*BaseDaemon
+
+	sessionID string
+	collector string
+	age       time.Duration
+	action    string
+	dir       string
+}
+
+// Run will launch the retention daemon. The required option keys are session
+// and age, and action must be export or drop. Exporting requires dir.
+// collector and interval are optional.
+func (r *retentionModule) Run(args map[string]string) {
+	defer r.wg.Done()
+
+	interval, err := r.parseArgs(args)
+	if err != nil {
+		r.logger.Errorf("%s", err)
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		r.expireTables()
+
+		select {
+		case <-r.ctx.Done():
+			r.logger.Infof("Stopping retention")
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// parseArgs checks the options of the module and stores them. It returns
+// the interval between runs.
+func (r *retentionModule) parseArgs(args map[string]string) (time.Duration, error) {
+	if !util.CheckForKeys(args, "session", "age", "action") {
+		return 0, fmt.Errorf("expected option keys: session, age, action. Got %v", args)
+	}
+
+	var err error
+	if r.age, err = parseDurationOpt(args, "age", 0); err != nil {
+		return 0, err
+	}
+
+	r.sessionID = args["session"]
+	r.action = args["action"]
+	r.dir = args["dir"]
+	r.collector = db.AnyCollector
+	if col, ok := args["collector"]; ok {
+		r.collector = col
+	}
+
+	switch r.action {
+	case retentionDrop:
+	case retentionExport:
+		if r.dir == "" {
+			return 0, fmt.Errorf("the export action requires a dir")
+		}
+		if err := os.MkdirAll(r.dir, 0755); err != nil {
+			return 0, fmt.Errorf("error creating export dir: %s", err)
+		}
+	default:
+		return 0, fmt.Errorf("unknown action: %s. Expected %s or %s", r.action, retentionDrop, retentionExport)
+	}
+
+	return parseDurationOpt(args, "interval", defaultRetentionInterval)
+}
+
+// expireTables exports, if configured, and drops every capture table which
+// only holds captures older than the configured age. Tables that fail to
+// export are kept, and retried on the next run.
+func (r *retentionModule) expireTables() {
+	sess, err := r.getSession(r.sessionID)
+	if err != nil {
+		r.logger.Errorf("Error finding session: %s", err)
+		return
+	}
+
+	before := time.Now().UTC().Add(-r.age)
+	tables, err := sess.ListCaptureTables(r.collector, before)
+	if err != nil {
+		r.logger.Errorf("Error listing capture tables: %s", err)
+		return
+	}
+
+	dropped := 0
+	for _, t := range tables {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		if r.action == retentionExport {
+			ct, err := r.exportTable(t)
+			if err != nil {
+				r.logger.Errorf("Error exporting table %s, not dropping it: %s", t.Name(), err)
+				continue
+			}
+			r.logger.Infof("Exported %d captures from table %s", ct, t.Name())
+		}
+
+		if err := sess.DropCaptureTable(t.Name()); err != nil {
+			r.logger.Errorf("Error dropping table %s: %s", t.Name(), err)
+			continue
+		}
+		dropped++
+	}
+
+	if len(tables) != 0 {
+		r.logger.Infof("Dropped %d of %d capture tables older than %s", dropped, len(tables), before)
+	}
+}
+
+// exportTable writes every capture of a table to an archive in the export
+// directory, named after the table. The archive is only moved into place
+// once it has been completely written.
+func (r *retentionModule) exportTable(t *db.CaptureTable) (int, error) {
+	path := filepath.Join(r.dir, t.Name()+util.ArchiveExtension)
+	tmpPath := path + ".tmp"
+
+	fd, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	ct, err := r.writeArchive(fd, t)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cErr := fd.Close(); err == nil {
+		err = cErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	return ct, os.Rename(tmpPath, path)
+}
+
+// writeArchive reads the captures of a table and writes them to fd. Only
+// the table itself is read, since other tables of its collector, like
+// those of a different dump duration, may overlap its span.
+func (r *retentionModule) writeArchive(fd *os.File, t *db.CaptureTable) (int, error) {
+	span := t.Span()
+	fo := db.NewCaptureFilterOptions(t.Collector(), span.Start, span.End)
+	fo.SetTable(t.Name())
+	// The table is dropped once it's exported, so a replica which is behind
+	// must not be read from.
+	fo.SetFromPrimary()
+	stream, err := r.server.OpenReadStream(r.sessionID, db.SessionReadCapture, fo)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	aw := util.NewCaptureArchiveWriter(fd)
+	for stream.Read() {
+		cap := stream.Data().(*db.Capture)
+		if err := aw.Write(cap.ToProtobuf()); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := aw.Close(); err != nil {
+		return 0, err
+	}
+	return aw.Count(), nil
+}
+
+func newRetentionModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &retentionModule{BaseDaemon: NewBaseDaemon(s, l, "retention")}
+}
+
+func init() {
+	opts := "session : the ID of the open session to expire capture tables from\n" +
+		"age : tables holding only captures older than this duration are expired, like 720h\n" +
+		"action : export to archive the tables before dropping them, or drop\n" +
+		"dir : the directory archives are exported to, required by export\n" +
+		"collector : only expire tables of this collector, defaults to all\n" +
+		"interval : how often to look for expired tables, defaults to 1h"
+
+	retentionHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "retention",
+			Description: "Drop or export and drop capture tables older than a given age",
+			Opts:        opts,
+		},
+		Maker: newRetentionModule,
+	}
+	core.RegisterModule(retentionHandle)
+}
@@ -0,0 +1,143 @@
+package modules
+
+import (
+	"fmt"
+	"time"
+
+	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+const (
+	defaultRollupInterval = time.Hour
+	defaultRollupLookback = 24 * time.Hour
+)
+
+// rollupModule is a daemon which periodically summarizes the captures of a
+// session into hourly or daily buckets. A bucket is only summarized once it
+// has ended, and delay has passed. When the module starts, the last lookback
+// of buckets is summarized again, so nothing is missed across restarts. To
+// keep the summaries complete, capture tables should be retained for longer
+// than lookback and delay combined.
+type rollupModule struct {
+	*BaseDaemon
+
+	sessionID   string
+	collector   string
+	granularity db.RollupGranularity
+	lookback    time.Duration
+	delay       time.Duration
+
+	// rolledUntil is the end of the last window that was summarized.
+	rolledUntil time.Time
+}
+
+// Run will launch the rollup daemon. The required option key is session.
+// granularity, collector, lookback, delay and interval are optional.
+func (r *rollupModule) Run(args map[string]string) {
+	defer r.wg.Done()
+
+	interval, err := r.parseArgs(args)
+	if err != nil {
+		r.logger.Errorf("%s", err)
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		r.rollup()
+
+		select {
+		case <-r.ctx.Done():
+			r.logger.Infof("Stopping rollup")
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// parseArgs checks the options of the module and stores them. It returns
+// the interval between rollups.
+func (r *rollupModule) parseArgs(args map[string]string) (time.Duration, error) {
+	if !util.CheckForKeys(args, "session") {
+		return 0, fmt.Errorf("expected option keys: session. Got %v", args)
+	}
+	r.sessionID = args["session"]
+
+	r.collector = db.AnyCollector
+	if col, ok := args["collector"]; ok {
+		r.collector = col
+	}
+
+	r.granularity = db.RollupHour
+	if gOpt, ok := args["granularity"]; ok {
+		g, err := db.ParseRollupGranularity(gOpt)
+		if err != nil {
+			return 0, err
+		}
+		r.granularity = g
+	}
+
+	var err error
+	if r.lookback, err = parseDurationOpt(args, "lookback", defaultRollupLookback); err != nil {
+		return 0, err
+	}
+
+	if r.delay, err = parseDurationOpt(args, "delay", 0); err != nil {
+		return 0, err
+	}
+
+	return parseDurationOpt(args, "interval", defaultRollupInterval)
+}
+
+// rollup summarizes every bucket that ended since the last run.
+func (r *rollupModule) rollup() {
+	end := time.Now().UTC().Add(-r.delay).Truncate(r.granularity.Duration())
+
+	start := r.rolledUntil
+	if start.IsZero() {
+		start = end.Add(-r.lookback)
+	}
+
+	if !start.Before(end) {
+		return
+	}
+
+	sess, err := r.getSession(r.sessionID)
+	if err != nil {
+		r.logger.Errorf("Error finding session: %s", err)
+		return
+	}
+
+	if err := sess.RollupCaptures(r.collector, r.granularity, start, end); err != nil {
+		r.logger.Errorf("Error rolling up captures from %s to %s: %s", start, end, err)
+		return
+	}
+	r.rolledUntil = end
+}
+
+func newRollupModule(s core.BgpmondServer, l util.Logger) core.Module {
+	return &rollupModule{BaseDaemon: NewBaseDaemon(s, l, "rollup")}
+}
+
+func init() {
+	opts := "session : the ID of the open session to summarize captures from\n" +
+		"granularity : the length of each bucket, hour or day, defaults to hour\n" +
+		"collector : only summarize captures of this collector, defaults to all\n" +
+		"lookback : how far back to summarize when the module starts, defaults to 24h\n" +
+		"delay : how long to wait for late captures after a bucket ends, defaults to 0\n" +
+		"interval : how often to look for ended buckets, defaults to 1h"
+
+	rollupHandle := core.ModuleHandler{
+		Info: core.ModuleInfo{
+			Type:        "rollup",
+			Description: "Summarize captures into hourly or daily rollups",
+			Opts:        opts,
+		},
+		Maker: newRollupModule,
+	}
+	core.RegisterModule(rollupHandle)
+}
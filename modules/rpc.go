@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strconv"
 	"time"
 
 	core "github.com/CSUNetSec/bgpmon"
+	"github.com/CSUNetSec/bgpmon/config"
 	"github.com/CSUNetSec/bgpmon/db"
+	"github.com/CSUNetSec/bgpmon/rpc"
 	"github.com/CSUNetSec/bgpmon/util"
 
 	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
@@ -22,9 +25,12 @@ type rpcServer struct {
 
 	grpcServer  *grpc.Server
 	timeoutSecs int
+	leaseSecs   int
 }
 
-// Run on the rpc server expects two options named "address" and "timeoutSecs"
+// Run on the rpc server expects two options named "address" and "timeoutSecs".
+// If "leaseSecs" is provided, sessions opened over RPC are leased for that
+// long, without an owner, so they're closed if their client goes away.
 func (r *rpcServer) Run(opts map[string]string) {
 	defer r.wg.Done()
 
@@ -41,6 +47,16 @@ func (r *rpcServer) Run(opts map[string]string) {
 	}
 
 	r.timeoutSecs = int(ts)
+
+	if lSecs, ok := opts["leaseSecs"]; ok {
+		ls, err := strconv.ParseInt(lSecs, 10, 32)
+		if err != nil || ls < 0 {
+			r.logger.Errorf("Error parsing leaseSecs :%s", lSecs)
+			return
+		}
+		r.leaseSecs = int(ls)
+	}
+
 	listen, err := net.Listen("tcp", addr)
 	if err != nil {
 		r.logger.Errorf("Error listening on address: %s", addr)
@@ -49,6 +65,7 @@ func (r *rpcServer) Run(opts map[string]string) {
 
 	r.grpcServer = grpc.NewServer()
 	pb.RegisterBgpmondServer(r.grpcServer, r)
+	rpc.RegisterBgpmondExtServer(r.grpcServer, r)
 
 	err = r.grpcServer.Serve(listen)
 	if err != nil {
@@ -66,6 +83,29 @@ func (r *rpcServer) Stop() error {
 	return nil
 }
 
+// Drain stops accepting new RPCs, and waits for the ones in progress, like
+// write streams, to finish. Once ctx is done, the ones left are cancelled.
+func (r *rpcServer) Drain(ctx context.Context) error {
+	if r.grpcServer == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		r.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		r.logger.Infof("Cancelling the RPCs still in progress")
+		r.grpcServer.Stop()
+		<-stopped
+	}
+	return nil
+}
+
 // GetTimeout implements the util.GetTimeouter interface for RPC requests
 func (r *rpcServer) GetTimeout() time.Duration {
 	return time.Duration(r.timeoutSecs) * time.Second
@@ -78,7 +118,8 @@ func newRPCServer(s core.BgpmondServer, l util.Logger) core.Module {
 
 func init() {
 	opts := "address : the address to start the RPC server\n" +
-		"timeoutsecs : timeout for some RPC requests"
+		"timeoutsecs : timeout for some RPC requests\n" +
+		"leaseSecs : (optional) lease sessions opened over RPC for this long, 0 for no lease"
 	rpcHandle := core.ModuleHandler{
 		Info: core.ModuleInfo{
 			Type:        "rpc",
@@ -177,9 +218,59 @@ func (r *rpcServer) OpenSession(ctx context.Context, request *pb.OpenSessionRequ
 	r.logger.Infof("Opening session named %s of config name:%s with %d workers", request.SessionId, request.SessionName, request.Workers)
 
 	err := r.server.OpenSession(request.SessionName, request.SessionId, int(request.Workers))
+	if err == nil && r.leaseSecs > 0 {
+		// A session the client was told failed to open mustn't be left open
+		// without its lease.
+		if err = r.server.LeaseSession(request.SessionId, "", time.Duration(r.leaseSecs)*time.Second); err != nil {
+			if cErr := r.server.CloseSession(request.SessionId); cErr != nil {
+				r.logger.Errorf("Error closing session %s: %s", request.SessionId, cErr)
+			}
+		}
+	}
 	return &pb.OpenSessionReply{SessionId: request.SessionId}, err
 }
 
+// LeaseSession is the RPC port to the servers LeaseSession function
+func (r *rpcServer) LeaseSession(ctx context.Context, request *rpc.LeaseSessionRequest) (*rpc.Empty, error) {
+	if request.TTLSecs < 0 {
+		return nil, fmt.Errorf("invalid lease TTL: %d", request.TTLSecs)
+	}
+
+	ttl := time.Duration(request.TTLSecs) * time.Second
+	if err := r.server.LeaseSession(request.SessionID, request.Owner, ttl); err != nil {
+		return nil, err
+	}
+	return &rpc.Empty{}, nil
+}
+
+// KeepAlive is the RPC port to the servers RenewSession function
+func (r *rpcServer) KeepAlive(ctx context.Context, request *rpc.KeepAliveRequest) (*rpc.Empty, error) {
+	if err := r.server.RenewSession(request.SessionID, request.Owner); err != nil {
+		return nil, err
+	}
+	return &rpc.Empty{}, nil
+}
+
+// ListSessions is the RPC port to the servers ListSessions function. Unlike
+// ListOpenSessions, it includes the lease and activity of each session.
+func (r *rpcServer) ListSessions(ctx context.Context, _ *rpc.Empty) (*rpc.ListSessionsReply, error) {
+	rep := &rpc.ListSessionsReply{}
+	for _, sh := range r.server.ListSessions() {
+		rep.Sessions = append(rep.Sessions, &rpc.SessionInfo{
+			SessionID:    sh.Name,
+			Type:         sh.SessType.Name,
+			Workers:      sh.Session.GetMaxWorkers(),
+			Owner:        sh.Owner,
+			TTLSecs:      int64(sh.TTL / time.Second),
+			OpenStreams:  sh.OpenStreams,
+			LastActivity: sh.LastActivity.Unix(),
+		})
+	}
+
+	sort.Slice(rep.Sessions, func(i, j int) bool { return rep.Sessions[i].SessionID < rep.Sessions[j].SessionID })
+	return rep, nil
+}
+
 func (r *rpcServer) GetSessionInfo(ctx context.Context, request *pb.SessionInfoRequest) (*pb.SessionInfoReply, error) {
 	r.logger.Infof("Returning info on session: %s", request.SessionId)
 	for _, sh := range r.server.ListSessions() {
@@ -221,11 +312,11 @@ func (r *rpcServer) Write(stream pb.Bgpmond_WriteServer) error {
 		return r.logger.Errorf("invalid write type")
 	}
 
-	err = r.WriteStream(timeoutCtx, stream, first, writeType, objectFunc)
+	stored, err := r.WriteStream(timeoutCtx, stream, first, writeType, objectFunc)
 	if err != nil {
 		return err
 	}
-	rep := &pb.WriteReply{}
+	rep := &pb.WriteReply{TotalMessages: stored}
 	err = stream.SendAndClose(rep)
 	if err != nil {
 		return err
@@ -235,25 +326,29 @@ func (r *rpcServer) Write(stream pb.Bgpmond_WriteServer) error {
 	return nil
 }
 
-// WriteStream is a general purpose write method.
+// WriteStream is a general purpose write method. It returns how many objects
+// were stored, which excludes the ones that failed to parse and, on sessions
+// which deduplicate captures, the duplicates.
 func (r *rpcServer) WriteStream(ctx context.Context,
 	writeSrv pb.Bgpmond_WriteServer,
 	firstMsg *pb.WriteRequest,
 	writeType db.SessionType,
-	getWriteObject func(*pb.WriteRequest) (interface{}, error)) error {
+	getWriteObject func(*pb.WriteRequest) (interface{}, error)) (uint64, error) {
 
 	stream, err := r.server.OpenWriteStream(firstMsg.SessionId, writeType)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer stream.Close()
 
+	var written int64
 	obj, err := getWriteObject(firstMsg)
 	if err == nil {
 		err = stream.Write(obj)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		written++
 	} else {
 		r.logger.Errorf("Error parsing object: %s", err)
 	}
@@ -261,7 +356,7 @@ func (r *rpcServer) WriteStream(ctx context.Context,
 	for {
 		if util.IsClosed(ctx) {
 			stream.Cancel()
-			return r.logger.Errorf("context closed")
+			return 0, r.logger.Errorf("context closed")
 		}
 
 		wr, err := writeSrv.Recv()
@@ -270,7 +365,7 @@ func (r *rpcServer) WriteStream(ctx context.Context,
 				break
 			} else {
 				stream.Cancel()
-				return err
+				return 0, err
 			}
 		}
 
@@ -282,15 +377,24 @@ func (r *rpcServer) WriteStream(ctx context.Context,
 
 		if err = stream.Write(obj); err != nil {
 			stream.Cancel()
-			return err
+			return 0, err
 		}
+		written++
 	}
 
 	if err = stream.Flush(); err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	if wc, ok := stream.(db.WriteCounter); ok {
+		var skipped int64
+		written, skipped = wc.Counts()
+		if skipped != 0 {
+			r.logger.Infof("Write stream skipped %d duplicates of %d objects", skipped, written)
+		}
+		written -= skipped
+	}
+	return uint64(written), nil
 }
 
 // RunModule is the RPC port to the servers RunModule function
@@ -347,3 +451,667 @@ func (r *rpcServer) ListOpenModules(ctx context.Context, _ *pb.Empty) (*pb.ListO
 
 	return &pb.ListOpenModulesReply{OpenModules: ret}, nil
 }
+
+// ListNodes is the RPC port to a sessions ListNodes function
+func (r *rpcServer) ListNodes(ctx context.Context, request *rpc.ListNodesRequest) (*rpc.ListNodesReply, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := sess.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*rpc.NodeInfo, 0, len(nodes))
+	for _, v := range nodes {
+		ret = append(ret, nodeInfoFromConfig(v))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return &rpc.ListNodesReply{Nodes: ret}, nil
+}
+
+// AddNode is the RPC port to a sessions AddNode function
+func (r *rpcServer) AddNode(ctx context.Context, request *rpc.NodeRequest) (*rpc.Empty, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Node == nil {
+		return nil, fmt.Errorf("no node provided")
+	}
+	r.logger.Infof("Adding node %s(%s) on session %s", request.Node.Name, request.Node.IP, request.SessionID)
+
+	return &rpc.Empty{}, sess.AddNode(nodeConfigFromInfo(request.Node))
+}
+
+// UpdateNode is the RPC port to a sessions UpdateNode function
+func (r *rpcServer) UpdateNode(ctx context.Context, request *rpc.NodeRequest) (*rpc.Empty, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Node == nil {
+		return nil, fmt.Errorf("no node provided")
+	}
+	r.logger.Infof("Updating node %s(%s) on session %s", request.Node.Name, request.Node.IP, request.SessionID)
+
+	return &rpc.Empty{}, sess.UpdateNode(nodeConfigFromInfo(request.Node))
+}
+
+// DeleteNode is the RPC port to a sessions DeleteNode function
+func (r *rpcServer) DeleteNode(ctx context.Context, request *rpc.DeleteNodeRequest) (*rpc.Empty, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Name == "" && request.IP == "" {
+		return nil, fmt.Errorf("a node name or IP is required")
+	}
+	r.logger.Infof("Deleting node %s(%s) on session %s", request.Name, request.IP, request.SessionID)
+
+	return &rpc.Empty{}, sess.DeleteNode(request.Name, request.IP)
+}
+
+func nodeInfoFromConfig(nc config.NodeConfig) *rpc.NodeInfo {
+	return &rpc.NodeInfo{
+		Name:                nc.Name,
+		IP:                  nc.IP,
+		IsCollector:         nc.IsCollector,
+		DumpDurationMinutes: nc.DumpDurationMinutes,
+		Description:         nc.Description,
+		Coords:              nc.Coords,
+		Address:             nc.Location,
+	}
+}
+
+func nodeConfigFromInfo(ni *rpc.NodeInfo) config.NodeConfig {
+	return config.NodeConfig{
+		Name:                ni.Name,
+		IP:                  ni.IP,
+		IsCollector:         ni.IsCollector,
+		DumpDurationMinutes: ni.DumpDurationMinutes,
+		Description:         ni.Description,
+		Coords:              ni.Coords,
+		Location:            ni.Address,
+	}
+}
+
+// entityFilterFromQuery builds the entity filter options described by an
+// EntityQuery.
+func entityFilterFromQuery(q *rpc.EntityQuery) (*db.EntityFilterOptions, error) {
+	fo := db.NewEntityFilterOptions(q.Name)
+	if q.OwnedOrigin != 0 {
+		fo.SetOwnedOrigin(q.OwnedOrigin)
+	}
+
+	for _, v := range q.Prefixes {
+		_, pref, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed prefix: %s", v)
+		}
+		fo.AllowOwnersOf(pref)
+	}
+
+	if q.EmailDomain != "" {
+		fo.SetEmailDomain(q.EmailDomain)
+	}
+
+	switch fam := db.AddressFamily(q.Family); fam {
+	case db.AnyFamily, db.IPv4Family, db.IPv6Family:
+		fo.SetFamily(fam)
+	default:
+		return nil, fmt.Errorf("unknown address family: %d", q.Family)
+	}
+	return fo, nil
+}
+
+func entityInfoFromEntity(e *db.Entity) *rpc.EntityInfo {
+	info := &rpc.EntityInfo{
+		Name:         e.Name,
+		Email:        e.Email,
+		OwnedOrigins: e.OwnedOrigins,
+	}
+
+	for _, v := range e.OwnedPrefixes {
+		info.OwnedPrefixes = append(info.OwnedPrefixes, util.IPNetString(v))
+	}
+	return info
+}
+
+// ListEntities is the RPC port to a session entity read stream
+func (r *rpcServer) ListEntities(ctx context.Context, request *rpc.EntityQuery) (*rpc.ListEntitiesReply, error) {
+	fo, err := entityFilterFromQuery(request)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := r.server.OpenReadStream(request.SessionID, db.SessionReadEntity, fo)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	ret := &rpc.ListEntitiesReply{}
+	for stream.Read() {
+		ret.Entities = append(ret.Entities, entityInfoFromEntity(stream.Data().(*db.Entity)))
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DeleteEntity is the RPC port to a sessions DeleteEntity function
+func (r *rpcServer) DeleteEntity(ctx context.Context, request *rpc.DeleteEntityRequest) (*rpc.Empty, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Name == "" {
+		return nil, fmt.Errorf("an entity name is required")
+	}
+	r.logger.Infof("Deleting entity %s on session %s", request.Name, request.SessionID)
+
+	return &rpc.Empty{}, sess.DeleteEntity(request.Name)
+}
+
+// EntityHistory is the RPC port to a session entity history read stream
+func (r *rpcServer) EntityHistory(ctx context.Context, request *rpc.EntityQuery) (*rpc.EntityHistoryReply, error) {
+	fo, err := entityFilterFromQuery(request)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := r.server.OpenReadStream(request.SessionID, db.SessionReadEntityHistory, fo)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	ret := &rpc.EntityHistoryReply{}
+	for stream.Read() {
+		change := stream.Data().(*db.EntityChange)
+		ret.Changes = append(ret.Changes, &rpc.EntityChangeInfo{
+			Entity:    entityInfoFromEntity(change.Entity),
+			Action:    change.Action,
+			Timestamp: change.Time.Unix(),
+		})
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// rollupFilterFromQuery builds the rollup filter options described by a
+// RollupQuery. Peer IPs and prefixes used as keys are written the way they
+// are stored.
+func rollupFilterFromQuery(q *rpc.RollupQuery) (*db.RollupFilterOptions, error) {
+	g, err := db.ParseRollupGranularity(q.Granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := q.Collector
+	if collector == "" {
+		collector = db.AnyCollector
+	}
+
+	fo := db.NewRollupFilterOptions(collector, g, time.Unix(q.Start, 0), time.Unix(q.End, 0))
+
+	kind := db.RollupKind(q.Kind)
+	switch kind {
+	case "", db.RollupTotal, db.RollupPeer, db.RollupOrigin, db.RollupPrefix:
+		fo.SetKind(kind)
+	default:
+		return nil, fmt.Errorf("unknown rollup kind: %s", q.Kind)
+	}
+
+	key := q.Key
+	if _, pref, err := net.ParseCIDR(key); err == nil {
+		key = util.IPNetString(pref)
+	} else if ip := util.ParseIP(key); ip != nil {
+		key = util.IPString(ip)
+	}
+	fo.SetKey(key)
+
+	return fo, nil
+}
+
+// ReadRollups is the RPC port to a session rollup read stream
+func (r *rpcServer) ReadRollups(ctx context.Context, request *rpc.RollupQuery) (*rpc.RollupsReply, error) {
+	fo, err := rollupFilterFromQuery(request)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := r.server.OpenReadStream(request.SessionID, db.SessionReadRollup, fo)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	ret := &rpc.RollupsReply{}
+	for stream.Read() {
+		roll := stream.Data().(*db.Rollup)
+		ret.Rollups = append(ret.Rollups, &rpc.RollupInfo{
+			Collector:         roll.Collector,
+			Granularity:       string(roll.Granularity),
+			Bucket:            roll.Bucket.Unix(),
+			Kind:              string(roll.Kind),
+			Key:               roll.Key,
+			Updates:           roll.Updates,
+			Announcements:     roll.Announcements,
+			Withdrawals:       roll.Withdrawals,
+			UniquePaths:       roll.UniquePaths,
+			AnnouncementRatio: roll.AnnouncementRatio(),
+		})
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListTables is the RPC port to a sessions CaptureTableStats function
+func (r *rpcServer) ListTables(ctx context.Context, request *rpc.TablesQuery) (*rpc.ListTablesReply, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := request.Collector
+	if collector == "" {
+		collector = db.AnyCollector
+	}
+
+	stats, err := sess.CaptureTableStats(collector, time.Unix(request.Start, 0), time.Unix(request.End, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &rpc.ListTablesReply{}
+	for _, ts := range stats {
+		span := ts.Span()
+		ret.Tables = append(ret.Tables, &rpc.CaptureTableInfo{
+			Name:          ts.Name(),
+			Collector:     ts.Collector(),
+			Start:         span.Start.Unix(),
+			End:           span.End.Unix(),
+			Captures:      ts.Captures,
+			SizeBytes:     ts.SizeBytes,
+			Hours:         ts.Hours,
+			HoursWithData: ts.HoursWithData,
+			HasGaps:       ts.HasGaps(),
+		})
+	}
+	return ret, nil
+}
+
+// GetCoverage is the RPC port to a sessions CaptureCoverage function
+func (r *rpcServer) GetCoverage(ctx context.Context, request *rpc.CoverageQuery) (*rpc.CoverageReply, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := db.ParseRollupGranularity(request.Granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := request.Collector
+	if collector == "" {
+		collector = db.AnyCollector
+	}
+
+	buckets, err := sess.CaptureCoverage(collector, g, time.Unix(request.Start, 0), time.Unix(request.End, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &rpc.CoverageReply{}
+	for _, b := range buckets {
+		ret.Buckets = append(ret.Buckets, &rpc.CoverageInfo{
+			Collector: b.Collector,
+			Bucket:    b.Start.Unix(),
+			Captures:  b.Captures,
+		})
+	}
+	return ret, nil
+}
+
+// Fsck is the RPC port to the fsck module's catalog check
+func (r *rpcServer) Fsck(ctx context.Context, request *rpc.FsckRequest) (*rpc.FsckReply, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Repair {
+		r.logger.Infof("Checking and repairing the catalog of session %s", request.SessionID)
+	}
+
+	results, err := fsckSession(sess, request.Repair)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &rpc.FsckReply{}
+	for _, res := range results {
+		p := res.problem
+		info := &rpc.CatalogProblemInfo{
+			Kind:      string(p.Kind),
+			Table:     p.Table,
+			Collector: p.Collector,
+			Detail:    p.Detail,
+			Repair:    string(p.Repair),
+			Repaired:  res.repaired,
+		}
+		if !p.Span.Start.IsZero() {
+			info.Start, info.End = p.Span.Start.Unix(), p.Span.End.Unix()
+		}
+		if res.err != nil {
+			info.Error = res.err.Error()
+		}
+		ret.Problems = append(ret.Problems, info)
+	}
+	return ret, nil
+}
+
+// SpoolStatus is the RPC port to a session's SpoolStatus function
+func (r *rpcServer) SpoolStatus(ctx context.Context, request *rpc.SpoolStatusRequest) (*rpc.SpoolStatusReply, error) {
+	sess, err := r.getSession(request.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	st := sess.SpoolStatus()
+	ret := &rpc.SpoolStatusReply{
+		Enabled:      st.Enabled,
+		Dir:          st.Dir,
+		Segments:     st.Segments,
+		Captures:     st.Captures,
+		Bytes:        st.Bytes,
+		MaxBytes:     st.MaxBytes,
+		OpenSegments: st.OpenSegments,
+		Spooling:     st.Spooling,
+		Failed:       st.Failed,
+		Replaying:    st.Replaying,
+		LastError:    st.LastError,
+	}
+	if !st.LastReplay.IsZero() {
+		ret.LastReplay = st.LastReplay.Unix()
+	}
+	return ret, nil
+}
+
+// peerEventFromInfo converts a peer event received over RPC to the one
+// written to the database.
+func peerEventFromInfo(info *rpc.PeerEventInfo) (*db.PeerEvent, error) {
+	evType, err := db.ParsePeerEventType(info.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	colIP := util.ParseIP(info.Collector)
+	if colIP == nil {
+		return nil, fmt.Errorf("invalid collector IP: %s", info.Collector)
+	}
+	peerIP := util.ParseIP(info.Peer)
+	if peerIP == nil {
+		return nil, fmt.Errorf("invalid peer IP: %s", info.Peer)
+	}
+
+	return &db.PeerEvent{
+		Timestamp:    time.Unix(0, info.Timestamp*int64(time.Microsecond)).UTC(),
+		Type:         evType,
+		ColIP:        colIP,
+		PeerIP:       peerIP,
+		PeerAS:       info.PeerAS,
+		OldState:     info.OldState,
+		NewState:     info.NewState,
+		ErrorCode:    info.ErrorCode,
+		ErrorSubcode: info.ErrorSubcode,
+		HoldTime:     time.Duration(info.HoldTime) * time.Second,
+		Gap:          time.Duration(info.Gap * float64(time.Second)),
+	}, nil
+}
+
+// WritePeerEvents is the RPC port to a session peer event write stream
+func (r *rpcServer) WritePeerEvents(ctx context.Context, request *rpc.WritePeerEventsRequest) (*rpc.WritePeerEventsReply, error) {
+	stream, err := r.server.OpenWriteStream(request.SessionID, db.SessionWritePeerEvent)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var written uint64
+	for _, info := range request.Events {
+		ev, err := peerEventFromInfo(info)
+		if err != nil {
+			r.logger.Errorf("Error parsing peer event: %s", err)
+			continue
+		}
+
+		if err := stream.Write(ev); err != nil {
+			stream.Cancel()
+			return nil, err
+		}
+		written++
+	}
+
+	if err := stream.Flush(); err != nil {
+		return nil, err
+	}
+
+	var skipped uint64
+	if wc, ok := stream.(db.WriteCounter); ok {
+		_, sk := wc.Counts()
+		skipped = uint64(sk)
+	}
+	return &rpc.WritePeerEventsReply{Written: written - skipped, Skipped: skipped}, nil
+}
+
+// peerEventFilterFromQuery builds the filter options of a peer event read
+// from the query received over RPC.
+func peerEventFilterFromQuery(q *rpc.PeerEventQuery) (*db.PeerEventFilterOptions, error) {
+	var collector net.IP
+	if q.Collector != "" {
+		if collector = util.ParseIP(q.Collector); collector == nil {
+			return nil, fmt.Errorf("invalid collector IP: %s", q.Collector)
+		}
+	}
+	fo := db.NewPeerEventFilterOptions(collector, time.Unix(q.Start, 0), time.Unix(q.End, 0))
+
+	if q.Peer != "" {
+		peer := util.ParseIP(q.Peer)
+		if peer == nil {
+			return nil, fmt.Errorf("invalid peer IP: %s", q.Peer)
+		}
+		fo.SetPeer(peer)
+	}
+
+	var types []db.PeerEventType
+	for _, t := range q.Types {
+		evType, err := db.ParsePeerEventType(t)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, evType)
+	}
+	fo.AllowTypes(types...)
+
+	return fo, nil
+}
+
+// ReadPeerEvents is the RPC port to a session peer event read stream. The
+// events are sent as they're read, so a long span isn't held in memory.
+func (r *rpcServer) ReadPeerEvents(request *rpc.PeerEventQuery, rpcStream rpc.BgpmondExt_ReadPeerEventsServer) error {
+	fo, err := peerEventFilterFromQuery(request)
+	if err != nil {
+		return err
+	}
+
+	stream, err := r.server.OpenReadStream(request.SessionID, db.SessionReadPeerEvent, fo)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for stream.Read() {
+		ev := stream.Data().(*db.PeerEvent)
+		info := &rpc.PeerEventInfo{
+			Timestamp:    ev.Timestamp.UnixNano() / int64(time.Microsecond),
+			Type:         string(ev.Type),
+			Collector:    util.IPString(ev.ColIP),
+			Peer:         util.IPString(ev.PeerIP),
+			PeerAS:       ev.PeerAS,
+			OldState:     ev.OldState,
+			NewState:     ev.NewState,
+			ErrorCode:    ev.ErrorCode,
+			ErrorSubcode: ev.ErrorSubcode,
+			HoldTime:     int64(ev.HoldTime / time.Second),
+			Gap:          ev.Gap.Seconds(),
+		}
+		if err := rpcStream.Send(info); err != nil {
+			return err
+		}
+	}
+
+	return stream.Err()
+}
+
+// subscribeOptionsFromTail builds the subscription described by a
+// TailRequest.
+func subscribeOptionsFromTail(q *rpc.TailRequest) (db.SubscribeOptions, error) {
+	collector := q.Collector
+	if collector == "" {
+		collector = db.AnyCollector
+	}
+
+	// Subscriptions ignore an empty time span.
+	fo := db.NewCaptureFilterOptions(collector, time.Time{}, time.Time{})
+	if q.Origin != 0 {
+		fo.SetOrigin(q.Origin)
+	}
+	if q.PeerAS != 0 {
+		fo.SetPeerAS(q.PeerAS)
+	}
+
+	switch fam := db.AddressFamily(q.Family); fam {
+	case db.AnyFamily, db.IPv4Family, db.IPv6Family:
+		fo.SetFamily(fam)
+	default:
+		return db.SubscribeOptions{}, fmt.Errorf("unknown address family: %d", q.Family)
+	}
+
+	for _, v := range q.Prefixes {
+		_, pref, err := net.ParseCIDR(v)
+		if err != nil {
+			return db.SubscribeOptions{}, fmt.Errorf("malformed prefix: %s", v)
+		}
+		fo.AllowAdvPrefixes(pref)
+	}
+	for _, v := range q.Subnets {
+		_, pref, err := net.ParseCIDR(v)
+		if err != nil {
+			return db.SubscribeOptions{}, fmt.Errorf("malformed prefix: %s", v)
+		}
+		fo.AllowSubnets(pref)
+	}
+
+	opts := db.SubscribeOptions{Filter: fo, Buffer: q.Buffer, Policy: db.DropOldest}
+	if q.DisconnectSlow {
+		opts.Policy = db.Disconnect
+	}
+	return opts, nil
+}
+
+func captureInfoFromCapture(cap *db.Capture) *rpc.CaptureInfo {
+	info := &rpc.CaptureInfo{
+		Timestamp: cap.Timestamp.UnixNano() / int64(time.Microsecond),
+		Collector: util.IPString(cap.ColIP),
+		Peer:      util.IPString(cap.PeerIP),
+		PeerAS:    cap.PeerAS,
+		Origin:    cap.Origin,
+		ASPath:    cap.ASPath,
+	}
+	if cap.NextHop != nil {
+		info.NextHop = util.IPString(cap.NextHop)
+	}
+
+	for _, v := range cap.Advertised {
+		info.Advertised = append(info.Advertised, util.IPNetString(v))
+	}
+	for _, v := range cap.Withdrawn {
+		info.Withdrawn = append(info.Withdrawn, util.IPNetString(v))
+	}
+	return info
+}
+
+// Tail is the RPC port to the servers Subscribe function. It streams the
+// captures committed to a session until the client goes away, the module
+// is stopped, or the session is closed.
+func (r *rpcServer) Tail(request *rpc.TailRequest, stream rpc.BgpmondExt_TailServer) error {
+	opts, err := subscribeOptionsFromTail(request)
+	if err != nil {
+		return err
+	}
+
+	sub, err := r.server.Subscribe(request.SessionID, opts)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	// Read blocks until a capture is published, so the subscription is
+	// closed from here once the stream is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stream.Context().Done():
+		case <-r.ctx.Done():
+		case <-done:
+		}
+		sub.Close()
+	}()
+
+	var limiter *util.RateLimiter
+	if request.MaxRate > 0 {
+		limiter = util.NewRateLimiter(request.MaxRate)
+	}
+
+	var limited uint64
+	for sub.Read() {
+		if limiter != nil && !limiter.Allow() {
+			limited++
+			continue
+		}
+
+		rep := &rpc.TailReply{
+			Capture: captureInfoFromCapture(sub.Data().(*db.Capture)),
+			Dropped: limited + sub.Dropped(),
+		}
+		if err := stream.Send(rep); err != nil {
+			return err
+		}
+	}
+
+	if err := sub.Err(); err != nil {
+		r.logger.Infof("Closing tail of session %s: %s", request.SessionID, err)
+		return err
+	}
+	return nil
+}
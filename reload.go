@@ -0,0 +1,218 @@
+package bgpmon
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/CSUNetSec/bgpmon/config"
+)
+
+// configDiff is what changed between two configurations. Each list holds
+// sorted session type names, node IPs or module IDs.
+type configDiff struct {
+	addedTypes, removedTypes, changedTypes       []string
+	addedNodes, removedNodes, changedNodes       []string
+	addedModules, removedModules, changedModules []string
+}
+
+// diffConfigs compares a new configuration against the old one.
+func diffConfigs(oldConf, newConf config.Configer) configDiff {
+	var d configDiff
+
+	oldTypes := make(map[string]config.SessionConfiger)
+	for _, sc := range oldConf.GetSessionConfigs() {
+		oldTypes[sc.GetName()] = sc
+	}
+	newTypes := make(map[string]bool)
+	for _, sc := range newConf.GetSessionConfigs() {
+		name := sc.GetName()
+		newTypes[name] = true
+		if osc, ok := oldTypes[name]; !ok {
+			d.addedTypes = append(d.addedTypes, name)
+		} else if !config.SameSessionConfig(osc, sc) {
+			d.changedTypes = append(d.changedTypes, name)
+		}
+	}
+	for name := range oldTypes {
+		if !newTypes[name] {
+			d.removedTypes = append(d.removedTypes, name)
+		}
+	}
+
+	oldNodes, newNodes := oldConf.GetConfiguredNodes(), newConf.GetConfiguredNodes()
+	for ip, nc := range newNodes {
+		if onc, ok := oldNodes[ip]; !ok {
+			d.addedNodes = append(d.addedNodes, ip)
+		} else if onc != nc {
+			d.changedNodes = append(d.changedNodes, ip)
+		}
+	}
+	for ip := range oldNodes {
+		if _, ok := newNodes[ip]; !ok {
+			d.removedNodes = append(d.removedNodes, ip)
+		}
+	}
+
+	oldMods := make(map[string]config.ModuleConfig)
+	for _, mc := range oldConf.GetModules() {
+		oldMods[mc.GetID()] = mc
+	}
+	newMods := make(map[string]bool)
+	for _, mc := range newConf.GetModules() {
+		id := mc.GetID()
+		newMods[id] = true
+		if omc, ok := oldMods[id]; !ok {
+			d.addedModules = append(d.addedModules, id)
+		} else if omc.GetType() != mc.GetType() || !reflect.DeepEqual(omc.GetArgs(), mc.GetArgs()) {
+			d.changedModules = append(d.changedModules, id)
+		}
+	}
+	for id := range oldMods {
+		if !newMods[id] {
+			d.removedModules = append(d.removedModules, id)
+		}
+	}
+
+	for _, l := range [][]string{d.addedTypes, d.removedTypes, d.changedTypes, d.addedNodes, d.removedNodes,
+		d.changedNodes, d.addedModules, d.removedModules, d.changedModules} {
+		sort.Strings(l)
+	}
+	return d
+}
+
+// log prints every change in the diff.
+func (d configDiff) log() {
+	changes := []struct {
+		what string
+		l    []string
+	}{
+		{"Added session types", d.addedTypes},
+		{"Removed session types", d.removedTypes},
+		{"Changed session types", d.changedTypes},
+		{"Added nodes", d.addedNodes},
+		{"Removed nodes", d.removedNodes},
+		{"Changed nodes", d.changedNodes},
+		{"Added modules", d.addedModules},
+		{"Removed modules", d.removedModules},
+		{"Changed modules", d.changedModules},
+	}
+
+	logged := false
+	for _, c := range changes {
+		if len(c.l) != 0 {
+			coreLogger.Infof("Config reload: %s: %v", c.what, c.l)
+			logged = true
+		}
+	}
+	if !logged {
+		coreLogger.Infof("Config reload: nothing changed")
+	}
+}
+
+// Reload replaces the configuration of the server. Session types are added
+// and removed, open sessions are synced with the configured nodes if they
+// changed, and modules of the old configuration which were removed or
+// changed are stopped, before the new and changed ones are run. Open
+// sessions keep the settings of their type until they are reopened, even if
+// it was changed or removed. The state file can't be changed, since it was
+// already restored from. Every step is attempted, and the first error is
+// returned.
+func (s *server) Reload(conf config.Configer) error {
+	s.mux.Lock()
+	if s.closing || s.draining {
+		s.mux.Unlock()
+		return coreLogger.Errorf("Can't reload the configuration of a closing server")
+	}
+
+	d := diffConfigs(s.conf, conf)
+	oldMods := make(map[string]bool)
+	for _, mc := range s.conf.GetModules() {
+		oldMods[mc.GetID()] = true
+	}
+	if conf.GetStateFile() != s.stateFile {
+		coreLogger.Infof("Config reload: the state file can't change while running, still using %q", s.stateFile)
+	}
+	s.conf = conf
+
+	var sessions []SessionHandle
+	for _, sh := range s.sessions {
+		sessions = append(sessions, sh)
+	}
+	s.mux.Unlock()
+
+	d.log()
+
+	var ret error
+	record := func(err error) {
+		if ret == nil {
+			ret = err
+		}
+	}
+
+	stale := make(map[string]string)
+	for _, name := range d.removedTypes {
+		stale[name] = "removed"
+	}
+	for _, name := range d.changedTypes {
+		stale[name] = "changed"
+	}
+	for _, sh := range sessions {
+		if why, ok := stale[sh.SessType.Name]; ok {
+			coreLogger.Infof("Session %s keeps the settings of its %s type %s until it's reopened", sh.Name, why, sh.SessType.Name)
+		}
+	}
+
+	if len(d.addedNodes) != 0 || len(d.changedNodes) != 0 {
+		for _, sh := range sessions {
+			if err := sh.Session.SyncNodes(conf.GetConfiguredNodes()); err != nil {
+				record(coreLogger.Errorf("Error syncing nodes of session %s: %s", sh.Name, err))
+			}
+		}
+	}
+	if len(d.removedNodes) != 0 {
+		coreLogger.Infof("Removed nodes are left in the node tables of open sessions")
+	}
+
+	for _, id := range append(d.removedModules, d.changedModules...) {
+		// A module of the old configuration may be done already, and one
+		// run since with the same ID isn't touched.
+		s.mux.Lock()
+		_, running := s.modules[id]
+		_, persisted := s.persisted[id]
+		s.mux.Unlock()
+		if !running || persisted || !oldMods[id] {
+			continue
+		}
+
+		if err := s.CloseModule(id); err != nil {
+			record(coreLogger.Errorf("Error stopping module %s: %s", id, err))
+		}
+	}
+
+	run := make(map[string]bool)
+	for _, id := range append(d.addedModules, d.changedModules...) {
+		run[id] = true
+	}
+	for _, mc := range conf.GetModules() {
+		if !run[mc.GetID()] {
+			continue
+		}
+		if err := s.runModule(mc.GetType(), mc.GetID(), mc.GetArgs(), false); err != nil {
+			record(err)
+		}
+	}
+
+	return ret
+}
+
+// ReloadFromFile does the same thing as Reload on the server, but loads the
+// configuration from a specified file name. Returns an error if the file
+// can't be parsed, in which case the server is left as it was.
+func ReloadFromFile(s BgpmondServer, fName string) error {
+	conf, err := loadConfigFile(fName)
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(conf)
+}
@@ -0,0 +1,247 @@
+package bgpmon
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CSUNetSec/bgpmon/config"
+	"github.com/CSUNetSec/bgpmon/util"
+)
+
+const reloadOldConf = `
+[Sessions.A]
+Type = "postgres"
+Hosts = ["localhost"]
+[Sessions.B]
+Type = "postgres"
+Hosts = ["localhost"]
+[Nodes]
+[Nodes."192.0.2.1"]
+Name = "one"
+[Nodes."192.0.2.2"]
+Name = "two"
+[Modules.same]
+Type = "pprof"
+Args = "-address localhost:6969"
+[Modules.changed]
+Type = "pprof"
+Args = "-address localhost:6970"
+[Modules.removed]
+Type = "pprof"
+Args = "-address localhost:6971"
+`
+
+const reloadNewConf = `
+[Sessions.A]
+Type = "postgres"
+Hosts = ["localhost"]
+[Sessions.B]
+Type = "postgres"
+Hosts = ["otherhost"]
+[Sessions.C]
+Type = "postgres"
+Hosts = ["localhost"]
+[Nodes]
+[Nodes."192.0.2.1"]
+Name = "one"
+[Nodes."192.0.2.2"]
+Name = "renamed"
+[Nodes."192.0.2.3"]
+Name = "three"
+[Modules.same]
+Type = "pprof"
+Args = "-address localhost:6969"
+[Modules.changed]
+Type = "pprof"
+Args = "-address localhost:6972"
+[Modules.added]
+Type = "pprof"
+Args = "-address localhost:6973"
+`
+
+func TestDiffConfigs(t *testing.T) {
+	oldConf, err := config.NewConfig(strings.NewReader(reloadOldConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf, err := config.NewConfig(strings.NewReader(reloadNewConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := diffConfigs(oldConf, newConf)
+	tests := []struct {
+		what     string
+		got      []string
+		expected []string
+	}{
+		{"added types", d.addedTypes, []string{"C"}},
+		{"removed types", d.removedTypes, nil},
+		{"changed types", d.changedTypes, []string{"B"}},
+		{"added nodes", d.addedNodes, []string{"192.0.2.3"}},
+		{"removed nodes", d.removedNodes, nil},
+		{"changed nodes", d.changedNodes, []string{"192.0.2.2"}},
+		{"added modules", d.addedModules, []string{"added"}},
+		{"removed modules", d.removedModules, []string{"removed"}},
+		{"changed modules", d.changedModules, []string{"changed"}},
+	}
+	for _, v := range tests {
+		if !reflect.DeepEqual(v.got, v.expected) {
+			t.Errorf("%s: Expected: %v, Got: %v", v.what, v.expected, v.got)
+		}
+	}
+
+	if d = diffConfigs(newConf, newConf); len(d.changedTypes)+len(d.changedNodes)+len(d.changedModules) != 0 {
+		t.Errorf("Expected no changes between a configuration and itself, Got: %+v", d)
+	}
+}
+
+func TestReloadListSessionTypes(t *testing.T) {
+	// Without modules, so nothing is run by the reloads.
+	withoutModules := func(conf string) string {
+		return conf[:strings.Index(conf, "[Modules")]
+	}
+	oldConf, err := config.NewConfig(strings.NewReader(withoutModules(reloadOldConf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf, err := config.NewConfig(strings.NewReader(withoutModules(reloadNewConf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := NewServer(oldConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+
+	// Session types are listed while the configuration is being replaced,
+	// which the race detector checks.
+	stop := make(chan struct{})
+	listed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if n := len(bs.ListSessionTypes()); n != 2 && n != 3 {
+				t.Errorf("Expected 2 or 3 session types, Got: %d", n)
+			}
+			if i == 0 {
+				close(listed)
+			}
+		}
+	}()
+
+	<-listed
+	for i := 0; i < 100; i++ {
+		conf := newConf
+		if i%2 == 1 {
+			conf = oldConf
+		}
+		if err := bs.Reload(conf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+// reloadTestModule is a daemon which keeps running for a while after Stop
+// returns, until release is closed, like a module cleaning up.
+type reloadTestModule struct {
+	stopped chan struct{}
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (m *reloadTestModule) Run(args map[string]string) {
+	defer close(m.done)
+	<-m.stopped
+	<-m.release
+}
+
+func (m *reloadTestModule) GetType() int {
+	return ModuleDaemon
+}
+
+func (m *reloadTestModule) GetName() string {
+	return "reloadtest"
+}
+
+func (m *reloadTestModule) GetInfo() OpenModuleInfo {
+	return NewOpenModuleInfo(m.GetName(), "running")
+}
+
+func (m *reloadTestModule) Stop() error {
+	close(m.stopped)
+	return nil
+}
+
+// reloadTestModules receives every reloadTestModule made.
+var reloadTestModules = make(chan *reloadTestModule, 2)
+
+func init() {
+	RegisterModule(ModuleHandler{
+		Info: ModuleInfo{Type: "reloadtest", Description: "test module for reloads"},
+		Maker: func(s BgpmondServer, l util.Logger) Module {
+			m := &reloadTestModule{stopped: make(chan struct{}), release: make(chan struct{}), done: make(chan struct{})}
+			reloadTestModules <- m
+			return m
+		},
+	})
+}
+
+func TestReloadRestartsModule(t *testing.T) {
+	const conf = `
+[Sessions.A]
+Type = "postgres"
+Hosts = ["localhost"]
+[Modules.mod]
+Type = "reloadtest"
+Args = "-n %d"
+`
+	oldConf, err := config.NewConfig(strings.NewReader(fmt.Sprintf(conf, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf, err := config.NewConfig(strings.NewReader(fmt.Sprintf(conf, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := NewServer(oldConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := bs.(*server)
+	defer s.Close()
+	old := <-reloadTestModules
+
+	if err := s.Reload(newConf); err != nil {
+		t.Fatal(err)
+	}
+	restarted := <-reloadTestModules
+	defer close(restarted.release)
+
+	// The old instance finishes after its replacement was run, and mustn't
+	// remove it.
+	close(old.release)
+	<-old.done
+	time.Sleep(50 * time.Millisecond)
+
+	s.mux.Lock()
+	mod := s.modules["mod"]
+	s.mux.Unlock()
+	if mod != restarted {
+		t.Errorf("Expected the restarted module to be running, Got: %v", mod)
+	}
+}
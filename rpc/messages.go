@@ -0,0 +1,357 @@
+package rpc
+
+// Empty messages are used as arguments and return types for calls that don't
+// need to carry any data.
+type Empty struct{}
+
+// NodeInfo describes a single row of a session's node table.
+type NodeInfo struct {
+	Name                string `json:"name"`
+	IP                  string `json:"ip"`
+	IsCollector         bool   `json:"is_collector"`
+	DumpDurationMinutes int    `json:"dump_duration_minutes"`
+	Description         string `json:"description"`
+	Coords              string `json:"coords"`
+	Address             string `json:"address"`
+}
+
+// ListNodesRequest messages request all the nodes known to the session
+// identified by SessionID.
+type ListNodesRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// ListNodesReply messages contain the nodes of a session.
+type ListNodesReply struct {
+	Nodes []*NodeInfo `json:"nodes"`
+}
+
+// NodeRequest messages carry a node to be added to, or updated on, the
+// session identified by SessionID.
+type NodeRequest struct {
+	SessionID string    `json:"session_id"`
+	Node      *NodeInfo `json:"node"`
+}
+
+// DeleteNodeRequest messages request the removal of a node from a session. The
+// node is matched by either its name or its IP.
+type DeleteNodeRequest struct {
+	SessionID string `json:"session_id"`
+	Name      string `json:"name"`
+	IP        string `json:"ip"`
+}
+
+// EntityInfo describes a single entity.
+type EntityInfo struct {
+	Name          string   `json:"name"`
+	Email         string   `json:"email"`
+	OwnedOrigins  []uint32 `json:"owned_origins"`
+	OwnedPrefixes []string `json:"owned_prefixes"`
+}
+
+// EntityQuery messages select entities, or their history, on the session
+// identified by SessionID. Every non-empty field must match. OwnedOrigin
+// is ignored if it is 0, and an entity matches Prefixes if it owns any of
+// them or a prefix covering them. Family may be 4 or 6 to only match entities
+// owning a prefix of that IP version.
+type EntityQuery struct {
+	SessionID   string   `json:"session_id"`
+	Name        string   `json:"name"`
+	OwnedOrigin uint32   `json:"owned_origin"`
+	Prefixes    []string `json:"prefixes"`
+	EmailDomain string   `json:"email_domain"`
+	Family      int      `json:"family"`
+}
+
+// ListEntitiesReply messages contain the entities matching an EntityQuery.
+type ListEntitiesReply struct {
+	Entities []*EntityInfo `json:"entities"`
+}
+
+// DeleteEntityRequest messages request the removal of the entity named
+// Name from the session identified by SessionID.
+type DeleteEntityRequest struct {
+	SessionID string `json:"session_id"`
+	Name      string `json:"name"`
+}
+
+// EntityChangeInfo describes a single change to an entity. Entity holds the
+// state after an upsert, or right before a delete. Timestamp is in unix
+// seconds.
+type EntityChangeInfo struct {
+	Entity    *EntityInfo `json:"entity"`
+	Action    string      `json:"action"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// EntityHistoryReply messages contain the changes made to the entities
+// matching an EntityQuery, oldest first.
+type EntityHistoryReply struct {
+	Changes []*EntityChangeInfo `json:"changes"`
+}
+
+// RollupQuery messages select the capture summaries of the session
+// identified by SessionID. Only rollups of one Granularity, "hour" or "day",
+// whose bucket starts in [Start, End) are returned. Start and End are in unix
+// seconds. Collector, Kind and Key are ignored if empty.
+type RollupQuery struct {
+	SessionID   string `json:"session_id"`
+	Collector   string `json:"collector"`
+	Granularity string `json:"granularity"`
+	Kind        string `json:"kind"`
+	Key         string `json:"key"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+}
+
+// RollupInfo summarizes the captures of a collector in a single bucket that
+// share a key. Bucket is in unix seconds. AnnouncementRatio is the fraction
+// of announced and withdrawn prefixes that were announced.
+type RollupInfo struct {
+	Collector         string  `json:"collector"`
+	Granularity       string  `json:"granularity"`
+	Bucket            int64   `json:"bucket"`
+	Kind              string  `json:"kind"`
+	Key               string  `json:"key"`
+	Updates           int64   `json:"updates"`
+	Announcements     int64   `json:"announcements"`
+	Withdrawals       int64   `json:"withdrawals"`
+	UniquePaths       int64   `json:"unique_paths"`
+	AnnouncementRatio float64 `json:"announcement_ratio"`
+}
+
+// RollupsReply messages contain the rollups matching a RollupQuery, ordered
+// by bucket.
+type RollupsReply struct {
+	Rollups []*RollupInfo `json:"rollups"`
+}
+
+// TablesQuery messages select the capture tables of the session identified
+// by SessionID holding captures in [Start, End). Start and End are in unix
+// seconds. Collector is ignored if empty.
+type TablesQuery struct {
+	SessionID string `json:"session_id"`
+	Collector string `json:"collector"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+}
+
+// CaptureTableInfo describes a single capture table. Start and End bound the
+// captures it may hold, in unix seconds. Hours counts the hours of that span
+// that have ended, and HasGaps is true if any of them holds no captures.
+type CaptureTableInfo struct {
+	Name          string `json:"name"`
+	Collector     string `json:"collector"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	Captures      int64  `json:"captures"`
+	SizeBytes     int64  `json:"size_bytes"`
+	Hours         int64  `json:"hours"`
+	HoursWithData int64  `json:"hours_with_data"`
+	HasGaps       bool   `json:"has_gaps"`
+}
+
+// ListTablesReply messages contain the capture tables matching a
+// TablesQuery, ordered by time.
+type ListTablesReply struct {
+	Tables []*CaptureTableInfo `json:"tables"`
+}
+
+// CoverageQuery messages select the capture counts of the session identified
+// by SessionID in every hour or day, depending on Granularity, of
+// [Start, End). Start and End are in unix seconds. Collector is ignored if
+// empty.
+type CoverageQuery struct {
+	SessionID   string `json:"session_id"`
+	Collector   string `json:"collector"`
+	Granularity string `json:"granularity"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+}
+
+// CoverageInfo counts the captures of a collector in the bucket starting at
+// Bucket, in unix seconds.
+type CoverageInfo struct {
+	Collector string `json:"collector"`
+	Bucket    int64  `json:"bucket"`
+	Captures  int64  `json:"captures"`
+}
+
+// CoverageReply messages contain the buckets matching a CoverageQuery which
+// hold captures, ordered by collector and time.
+type CoverageReply struct {
+	Buckets []*CoverageInfo `json:"buckets"`
+}
+
+// FsckRequest messages request a check of the capture table catalog of the
+// session identified by SessionID. If Repair is set, every problem found is
+// repaired if possible.
+type FsckRequest struct {
+	SessionID string `json:"session_id"`
+	Repair    bool   `json:"repair"`
+}
+
+// CatalogProblemInfo describes a single problem found in a catalog, and the
+// repair that fixes it. Start and End are the window the table is, or would
+// be, registered for, in unix seconds, and are 0 if it isn't known. Error
+// holds the reason a repair failed.
+type CatalogProblemInfo struct {
+	Kind      string `json:"kind"`
+	Table     string `json:"table"`
+	Collector string `json:"collector"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Detail    string `json:"detail"`
+	Repair    string `json:"repair"`
+	Repaired  bool   `json:"repaired"`
+	Error     string `json:"error"`
+}
+
+// FsckReply messages contain the problems found by a FsckRequest.
+type FsckReply struct {
+	Problems []*CatalogProblemInfo `json:"problems"`
+}
+
+// SpoolStatusRequest messages request the state of the capture spool of the
+// session identified by SessionID.
+type SpoolStatusRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// SpoolStatusReply messages describe a capture spool. Segments and Captures
+// count what is waiting to be replayed into the database, and Bytes is the
+// space used by every segment. LastReplay is in unix seconds, and is 0 if
+// nothing was replayed yet.
+type SpoolStatusReply struct {
+	Enabled      bool   `json:"enabled"`
+	Dir          string `json:"dir"`
+	Segments     int    `json:"segments"`
+	Captures     int64  `json:"captures"`
+	Bytes        int64  `json:"bytes"`
+	MaxBytes     int64  `json:"max_bytes"`
+	OpenSegments int    `json:"open_segments"`
+	Spooling     int    `json:"spooling"`
+	Failed       int    `json:"failed"`
+	Replaying    bool   `json:"replaying"`
+	LastReplay   int64  `json:"last_replay"`
+	LastError    string `json:"last_error"`
+}
+
+// PeerEventInfo describes an event of the BGP session between a collector
+// and a peer. Timestamp is in unix microseconds, and HoldTime and Gap are in
+// seconds. Only the fields of its Type are set.
+type PeerEventInfo struct {
+	Timestamp    int64   `json:"timestamp"`
+	Type         string  `json:"type"`
+	Collector    string  `json:"collector"`
+	Peer         string  `json:"peer"`
+	PeerAS       uint32  `json:"peer_as"`
+	OldState     string  `json:"old_state"`
+	NewState     string  `json:"new_state"`
+	ErrorCode    uint8   `json:"error_code"`
+	ErrorSubcode uint8   `json:"error_subcode"`
+	HoldTime     int64   `json:"hold_time"`
+	Gap          float64 `json:"gap"`
+}
+
+// WritePeerEventsRequest messages write a batch of peer events to the
+// session identified by SessionID.
+type WritePeerEventsRequest struct {
+	SessionID string           `json:"session_id"`
+	Events    []*PeerEventInfo `json:"events"`
+}
+
+// WritePeerEventsReply messages count the events stored by a
+// WritePeerEventsRequest, and the ones skipped because they were already
+// stored.
+type WritePeerEventsReply struct {
+	Written uint64 `json:"written"`
+	Skipped uint64 `json:"skipped"`
+}
+
+// PeerEventQuery messages select the peer events of the session identified
+// by SessionID in [Start, End). Start and End are in unix seconds. Collector,
+// Peer and Types are ignored if empty. The events are streamed back ordered
+// by time.
+type PeerEventQuery struct {
+	SessionID string   `json:"session_id"`
+	Collector string   `json:"collector"`
+	Peer      string   `json:"peer"`
+	Types     []string `json:"types"`
+	Start     int64    `json:"start"`
+	End       int64    `json:"end"`
+}
+
+// TailRequest messages subscribe to the captures committed to the session
+// identified by SessionID from now on. Collector, Origin, PeerAS, Family,
+// Prefixes and Subnets filter them like a read, and are ignored if empty.
+// If MaxRate isn't 0, at most MaxRate captures are sent each second, and the
+// rest are dropped. Buffer is how many captures the server holds while the
+// client catches up, and if DisconnectSlow is set, the stream is closed once
+// that is full instead of dropping the oldest captures.
+type TailRequest struct {
+	SessionID      string   `json:"session_id"`
+	Collector      string   `json:"collector"`
+	Origin         uint32   `json:"origin"`
+	PeerAS         uint32   `json:"peer_as"`
+	Family         int      `json:"family"`
+	Prefixes       []string `json:"prefixes"`
+	Subnets        []string `json:"subnets"`
+	MaxRate        float64  `json:"max_rate"`
+	Buffer         int      `json:"buffer"`
+	DisconnectSlow bool     `json:"disconnect_slow"`
+}
+
+// CaptureInfo describes a single capture. Timestamp is in unix microseconds.
+type CaptureInfo struct {
+	Timestamp  int64    `json:"timestamp"`
+	Collector  string   `json:"collector"`
+	Peer       string   `json:"peer"`
+	PeerAS     uint32   `json:"peer_as"`
+	Origin     uint32   `json:"origin"`
+	ASPath     []uint32 `json:"as_path"`
+	NextHop    string   `json:"next_hop"`
+	Advertised []string `json:"advertised"`
+	Withdrawn  []string `json:"withdrawn"`
+}
+
+// TailReply messages carry a capture matching a TailRequest. Dropped counts
+// the captures matching it which weren't sent so far, because of MaxRate or
+// a full buffer.
+type TailReply struct {
+	Capture *CaptureInfo `json:"capture"`
+	Dropped uint64       `json:"dropped"`
+}
+
+// LeaseSessionRequest messages lease the session identified by SessionID to
+// Owner, so it's closed once it has been idle for longer than TTLSecs. A
+// TTLSecs of 0 removes the lease.
+type LeaseSessionRequest struct {
+	SessionID string `json:"session_id"`
+	Owner     string `json:"owner"`
+	TTLSecs   int64  `json:"ttl_secs"`
+}
+
+// KeepAliveRequest messages renew the lease of the session identified by
+// SessionID, held by Owner.
+type KeepAliveRequest struct {
+	SessionID string `json:"session_id"`
+	Owner     string `json:"owner"`
+}
+
+// SessionInfo describes an open session. LastActivity is in unix seconds,
+// and TTLSecs is 0 if the session isn't leased.
+type SessionInfo struct {
+	SessionID    string `json:"session_id"`
+	Type         string `json:"type"`
+	Workers      int    `json:"workers"`
+	Owner        string `json:"owner"`
+	TTLSecs      int64  `json:"ttl_secs"`
+	OpenStreams  int    `json:"open_streams"`
+	LastActivity int64  `json:"last_activity"`
+}
+
+// ListSessionsReply messages contain every open session.
+type ListSessionsReply struct {
+	Sessions []*SessionInfo `json:"sessions"`
+}
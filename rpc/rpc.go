@@ -0,0 +1,35 @@
+// Package rpc defines the supplementary bgpmond RPC service. The core RPCs are
+// described by the protocol buffers in the netsec-protobufs repository. Calls
+// that haven't made it into that specification yet live here, in a second gRPC
+// service that is registered on the same server as the core one. Messages for
+// this service are plain go structs encoded as JSON, so they can be extended
+// without regenerating any code.
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the gRPC content-subtype used by every call on this service.
+const CodecName = "json"
+
+// jsonCodec implements the grpc encoding.Codec interface with encoding/json.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
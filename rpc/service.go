@@ -0,0 +1,436 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serviceName is the fully qualified name of the supplementary service.
+const serviceName = "bgpmonext.BgpmondExt"
+
+// BgpmondExtServer is the server API for the supplementary bgpmond service.
+type BgpmondExtServer interface {
+	ListNodes(context.Context, *ListNodesRequest) (*ListNodesReply, error)
+	AddNode(context.Context, *NodeRequest) (*Empty, error)
+	UpdateNode(context.Context, *NodeRequest) (*Empty, error)
+	DeleteNode(context.Context, *DeleteNodeRequest) (*Empty, error)
+	ListEntities(context.Context, *EntityQuery) (*ListEntitiesReply, error)
+	DeleteEntity(context.Context, *DeleteEntityRequest) (*Empty, error)
+	EntityHistory(context.Context, *EntityQuery) (*EntityHistoryReply, error)
+	ReadRollups(context.Context, *RollupQuery) (*RollupsReply, error)
+	ListTables(context.Context, *TablesQuery) (*ListTablesReply, error)
+	GetCoverage(context.Context, *CoverageQuery) (*CoverageReply, error)
+	Fsck(context.Context, *FsckRequest) (*FsckReply, error)
+	SpoolStatus(context.Context, *SpoolStatusRequest) (*SpoolStatusReply, error)
+	WritePeerEvents(context.Context, *WritePeerEventsRequest) (*WritePeerEventsReply, error)
+	ReadPeerEvents(*PeerEventQuery, BgpmondExt_ReadPeerEventsServer) error
+	Tail(*TailRequest, BgpmondExt_TailServer) error
+	LeaseSession(context.Context, *LeaseSessionRequest) (*Empty, error)
+	KeepAlive(context.Context, *KeepAliveRequest) (*Empty, error)
+	ListSessions(context.Context, *Empty) (*ListSessionsReply, error)
+}
+
+// BgpmondExt_TailServer is the server side of a Tail stream.
+type BgpmondExt_TailServer interface {
+	Send(*TailReply) error
+	grpc.ServerStream
+}
+
+type bgpmondExtTailServer struct {
+	grpc.ServerStream
+}
+
+func (x *bgpmondExtTailServer) Send(m *TailReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// tailHandler runs Tail on the registered server with the request sent by
+// the client.
+func tailHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := &TailRequest{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(BgpmondExtServer).Tail(in, &bgpmondExtTailServer{stream})
+}
+
+// BgpmondExt_ReadPeerEventsServer is the server side of a ReadPeerEvents
+// stream.
+type BgpmondExt_ReadPeerEventsServer interface {
+	Send(*PeerEventInfo) error
+	grpc.ServerStream
+}
+
+type bgpmondExtReadPeerEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *bgpmondExtReadPeerEventsServer) Send(m *PeerEventInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// readPeerEventsHandler runs ReadPeerEvents on the registered server with
+// the query sent by the client.
+func readPeerEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := &PeerEventQuery{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(BgpmondExtServer).ReadPeerEvents(in, &bgpmondExtReadPeerEventsServer{stream})
+}
+
+// RegisterBgpmondExtServer registers srv on the provided grpc server.
+func RegisterBgpmondExtServer(s *grpc.Server, srv BgpmondExtServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// unaryHandler builds a grpc method handler. newReq allocates the request
+// message the incoming call is decoded into, and call runs the method on
+// the registered server.
+func unaryHandler(method string, newReq func() interface{},
+	call func(BgpmondExtServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+
+	handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := newReq()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(BgpmondExtServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/" + serviceName + "/" + method,
+		}
+		wrapped := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(BgpmondExtServer), ctx, req)
+		}
+		return interceptor(ctx, in, info, wrapped)
+	}
+
+	return grpc.MethodDesc{MethodName: method, Handler: handler}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*BgpmondExtServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("ListNodes", func() interface{} { return &ListNodesRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ListNodes(ctx, req.(*ListNodesRequest))
+			}),
+		unaryHandler("AddNode", func() interface{} { return &NodeRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.AddNode(ctx, req.(*NodeRequest))
+			}),
+		unaryHandler("UpdateNode", func() interface{} { return &NodeRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.UpdateNode(ctx, req.(*NodeRequest))
+			}),
+		unaryHandler("DeleteNode", func() interface{} { return &DeleteNodeRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.DeleteNode(ctx, req.(*DeleteNodeRequest))
+			}),
+		unaryHandler("ListEntities", func() interface{} { return &EntityQuery{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ListEntities(ctx, req.(*EntityQuery))
+			}),
+		unaryHandler("DeleteEntity", func() interface{} { return &DeleteEntityRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.DeleteEntity(ctx, req.(*DeleteEntityRequest))
+			}),
+		unaryHandler("EntityHistory", func() interface{} { return &EntityQuery{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.EntityHistory(ctx, req.(*EntityQuery))
+			}),
+		unaryHandler("ReadRollups", func() interface{} { return &RollupQuery{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ReadRollups(ctx, req.(*RollupQuery))
+			}),
+		unaryHandler("ListTables", func() interface{} { return &TablesQuery{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ListTables(ctx, req.(*TablesQuery))
+			}),
+		unaryHandler("GetCoverage", func() interface{} { return &CoverageQuery{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.GetCoverage(ctx, req.(*CoverageQuery))
+			}),
+		unaryHandler("Fsck", func() interface{} { return &FsckRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Fsck(ctx, req.(*FsckRequest))
+			}),
+		unaryHandler("SpoolStatus", func() interface{} { return &SpoolStatusRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.SpoolStatus(ctx, req.(*SpoolStatusRequest))
+			}),
+		unaryHandler("WritePeerEvents", func() interface{} { return &WritePeerEventsRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.WritePeerEvents(ctx, req.(*WritePeerEventsRequest))
+			}),
+		unaryHandler("LeaseSession", func() interface{} { return &LeaseSessionRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.LeaseSession(ctx, req.(*LeaseSessionRequest))
+			}),
+		unaryHandler("KeepAlive", func() interface{} { return &KeepAliveRequest{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.KeepAlive(ctx, req.(*KeepAliveRequest))
+			}),
+		unaryHandler("ListSessions", func() interface{} { return &Empty{} },
+			func(s BgpmondExtServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ListSessions(ctx, req.(*Empty))
+			}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Tail",
+			Handler:       tailHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReadPeerEvents",
+			Handler:       readPeerEventsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "bgpmonext",
+}
+
+// BgpmondExtClient is the client API for the supplementary bgpmond service.
+type BgpmondExtClient interface {
+	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesReply, error)
+	AddNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	UpdateNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	DeleteNode(ctx context.Context, in *DeleteNodeRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListEntities(ctx context.Context, in *EntityQuery, opts ...grpc.CallOption) (*ListEntitiesReply, error)
+	DeleteEntity(ctx context.Context, in *DeleteEntityRequest, opts ...grpc.CallOption) (*Empty, error)
+	EntityHistory(ctx context.Context, in *EntityQuery, opts ...grpc.CallOption) (*EntityHistoryReply, error)
+	ReadRollups(ctx context.Context, in *RollupQuery, opts ...grpc.CallOption) (*RollupsReply, error)
+	ListTables(ctx context.Context, in *TablesQuery, opts ...grpc.CallOption) (*ListTablesReply, error)
+	GetCoverage(ctx context.Context, in *CoverageQuery, opts ...grpc.CallOption) (*CoverageReply, error)
+	Fsck(ctx context.Context, in *FsckRequest, opts ...grpc.CallOption) (*FsckReply, error)
+	SpoolStatus(ctx context.Context, in *SpoolStatusRequest, opts ...grpc.CallOption) (*SpoolStatusReply, error)
+	WritePeerEvents(ctx context.Context, in *WritePeerEventsRequest, opts ...grpc.CallOption) (*WritePeerEventsReply, error)
+	ReadPeerEvents(ctx context.Context, in *PeerEventQuery, opts ...grpc.CallOption) (BgpmondExt_ReadPeerEventsClient, error)
+	Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (BgpmondExt_TailClient, error)
+	LeaseSession(ctx context.Context, in *LeaseSessionRequest, opts ...grpc.CallOption) (*Empty, error)
+	KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListSessions(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListSessionsReply, error)
+}
+
+// BgpmondExt_TailClient is the client side of a Tail stream.
+type BgpmondExt_TailClient interface {
+	Recv() (*TailReply, error)
+	grpc.ClientStream
+}
+
+type bgpmondExtTailClient struct {
+	grpc.ClientStream
+}
+
+func (x *bgpmondExtTailClient) Recv() (*TailReply, error) {
+	m := &TailReply{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BgpmondExt_ReadPeerEventsClient is the client side of a ReadPeerEvents
+// stream.
+type BgpmondExt_ReadPeerEventsClient interface {
+	Recv() (*PeerEventInfo, error)
+	grpc.ClientStream
+}
+
+type bgpmondExtReadPeerEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *bgpmondExtReadPeerEventsClient) Recv() (*PeerEventInfo, error) {
+	m := &PeerEventInfo{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type bgpmondExtClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewBgpmondExtClient returns a client for the supplementary service on an
+// existing connection. The same connection can be shared with a client of
+// the core service.
+func NewBgpmondExtClient(cc *grpc.ClientConn) BgpmondExtClient {
+	return &bgpmondExtClient{cc: cc}
+}
+
+// invoke runs a unary method on the connection, making sure the call
+// is encoded with this package's codec.
+func (c *bgpmondExtClient) invoke(ctx context.Context, method string, in, out interface{}, opts ...grpc.CallOption) error {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	return c.cc.Invoke(ctx, "/"+serviceName+"/"+method, in, out, opts...)
+}
+
+func (c *bgpmondExtClient) ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesReply, error) {
+	out := &ListNodesReply{}
+	if err := c.invoke(ctx, "ListNodes", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) AddNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "AddNode", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) UpdateNode(ctx context.Context, in *NodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "UpdateNode", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) DeleteNode(ctx context.Context, in *DeleteNodeRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "DeleteNode", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) ListEntities(ctx context.Context, in *EntityQuery, opts ...grpc.CallOption) (*ListEntitiesReply, error) {
+	out := &ListEntitiesReply{}
+	if err := c.invoke(ctx, "ListEntities", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) DeleteEntity(ctx context.Context, in *DeleteEntityRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "DeleteEntity", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) EntityHistory(ctx context.Context, in *EntityQuery, opts ...grpc.CallOption) (*EntityHistoryReply, error) {
+	out := &EntityHistoryReply{}
+	if err := c.invoke(ctx, "EntityHistory", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) ReadRollups(ctx context.Context, in *RollupQuery, opts ...grpc.CallOption) (*RollupsReply, error) {
+	out := &RollupsReply{}
+	if err := c.invoke(ctx, "ReadRollups", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) ListTables(ctx context.Context, in *TablesQuery, opts ...grpc.CallOption) (*ListTablesReply, error) {
+	out := &ListTablesReply{}
+	if err := c.invoke(ctx, "ListTables", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) GetCoverage(ctx context.Context, in *CoverageQuery, opts ...grpc.CallOption) (*CoverageReply, error) {
+	out := &CoverageReply{}
+	if err := c.invoke(ctx, "GetCoverage", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) Fsck(ctx context.Context, in *FsckRequest, opts ...grpc.CallOption) (*FsckReply, error) {
+	out := &FsckReply{}
+	if err := c.invoke(ctx, "Fsck", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) SpoolStatus(ctx context.Context, in *SpoolStatusRequest, opts ...grpc.CallOption) (*SpoolStatusReply, error) {
+	out := &SpoolStatusReply{}
+	if err := c.invoke(ctx, "SpoolStatus", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) WritePeerEvents(ctx context.Context, in *WritePeerEventsRequest, opts ...grpc.CallOption) (*WritePeerEventsReply, error) {
+	out := &WritePeerEventsReply{}
+	if err := c.invoke(ctx, "WritePeerEvents", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// serverStream opens the server stream desc, making sure it's encoded with
+// this package's codec, and sends in as its only request.
+func (c *bgpmondExtClient) serverStream(ctx context.Context, desc *grpc.StreamDesc, in interface{}, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	stream, err := c.cc.NewStream(ctx, desc, "/"+serviceName+"/"+desc.StreamName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (c *bgpmondExtClient) ReadPeerEvents(ctx context.Context, in *PeerEventQuery, opts ...grpc.CallOption) (BgpmondExt_ReadPeerEventsClient, error) {
+	stream, err := c.serverStream(ctx, &serviceDesc.Streams[1], in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &bgpmondExtReadPeerEventsClient{stream}, nil
+}
+
+func (c *bgpmondExtClient) Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (BgpmondExt_TailClient, error) {
+	stream, err := c.serverStream(ctx, &serviceDesc.Streams[0], in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &bgpmondExtTailClient{stream}, nil
+}
+
+func (c *bgpmondExtClient) LeaseSession(ctx context.Context, in *LeaseSessionRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "LeaseSession", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "KeepAlive", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bgpmondExtClient) ListSessions(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListSessionsReply, error) {
+	out := &ListSessionsReply{}
+	if err := c.invoke(ctx, "ListSessions", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// testServer is a minimal BgpmondExtServer which keeps nodes in memory.
+// Calls to methods it doesn't override will panic.
+type testServer struct {
+	BgpmondExtServer
+
+	nodes map[string]*NodeInfo
+}
+
+func (ts *testServer) ListNodes(ctx context.Context, req *ListNodesRequest) (*ListNodesReply, error) {
+	var ret []*NodeInfo
+	for _, v := range ts.nodes {
+		ret = append(ret, v)
+	}
+	return &ListNodesReply{Nodes: ret}, nil
+}
+
+func (ts *testServer) AddNode(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	ts.nodes[req.Node.IP] = req.Node
+	return &Empty{}, nil
+}
+
+func (ts *testServer) UpdateNode(ctx context.Context, req *NodeRequest) (*Empty, error) {
+	if _, ok := ts.nodes[req.Node.IP]; !ok {
+		return nil, fmt.Errorf("no such node")
+	}
+	ts.nodes[req.Node.IP] = req.Node
+	return &Empty{}, nil
+}
+
+func (ts *testServer) DeleteNode(ctx context.Context, req *DeleteNodeRequest) (*Empty, error) {
+	delete(ts.nodes, req.IP)
+	return &Empty{}, nil
+}
+
+// Tail sends one capture for every prefix in the request.
+func (ts *testServer) Tail(req *TailRequest, stream BgpmondExt_TailServer) error {
+	for i, v := range req.Prefixes {
+		rep := &TailReply{Capture: &CaptureInfo{Collector: req.Collector, Advertised: []string{v}}, Dropped: uint64(i)}
+		if err := stream.Send(rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPeerEvents sends one event of every requested type.
+func (ts *testServer) ReadPeerEvents(req *PeerEventQuery, stream BgpmondExt_ReadPeerEventsServer) error {
+	for i, v := range req.Types {
+		ev := &PeerEventInfo{Timestamp: req.Start + int64(i), Type: v, Collector: req.Collector, Peer: req.Peer}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startTestServer launches a grpc server with the supplementary service on
+// a random local port, and returns a client connected to it.
+func startTestServer(t *testing.T, srv BgpmondExtServer) (BgpmondExtClient, func()) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs := grpc.NewServer()
+	RegisterBgpmondExtServer(gs, srv)
+	go gs.Serve(listen)
+
+	conn, err := grpc.Dial(listen.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		gs.Stop()
+		t.Fatal(err)
+	}
+
+	return NewBgpmondExtClient(conn), func() {
+		conn.Close()
+		gs.Stop()
+	}
+}
+
+func TestNodeRoundTrip(t *testing.T) {
+	cli, stop := startTestServer(t, &testServer{nodes: make(map[string]*NodeInfo)})
+	defer stop()
+
+	ctx := context.Background()
+	n := &NodeInfo{Name: "routeviews2", IP: "128.223.51.102", IsCollector: true, DumpDurationMinutes: 1440}
+	if _, err := cli.AddNode(ctx, &NodeRequest{SessionID: "s1", Node: n}); err != nil {
+		t.Fatal(err)
+	}
+
+	n.Description = "routeviews.org routeviews2 collector"
+	if _, err := cli.UpdateNode(ctx, &NodeRequest{SessionID: "s1", Node: n}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := cli.ListNodes(ctx, &ListNodesRequest{SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Nodes) != 1 || *rep.Nodes[0] != *n {
+		t.Fatalf("Expected: %+v, Got: %+v", n, rep.Nodes)
+	}
+
+	if _, err := cli.UpdateNode(ctx, &NodeRequest{SessionID: "s1", Node: &NodeInfo{IP: "1.1.1.1"}}); err == nil {
+		t.Fatalf("Expected error updating a missing node")
+	}
+}
+
+func TestTailStream(t *testing.T) {
+	cli, stop := startTestServer(t, &testServer{nodes: make(map[string]*NodeInfo)})
+	defer stop()
+
+	prefixes := []string{"10.0.0.0/8", "192.0.2.0/24", "2001:db8::/32"}
+	stream, err := cli.Tail(context.Background(), &TailRequest{SessionID: "s1", Collector: "rv2", Prefixes: prefixes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range prefixes {
+		rep, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Capture.Collector != "rv2" || rep.Capture.Advertised[0] != v || rep.Dropped != uint64(i) {
+			t.Errorf("Expected: %s from rv2 with %d dropped, Got: %+v, %d", v, i, rep.Capture, rep.Dropped)
+		}
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Expected: %s, Got: %v", io.EOF, err)
+	}
+}
+
+func TestReadPeerEventsStream(t *testing.T) {
+	cli, stop := startTestServer(t, &testServer{nodes: make(map[string]*NodeInfo)})
+	defer stop()
+
+	types := []string{"state_change", "open", "notification"}
+	q := &PeerEventQuery{SessionID: "s1", Collector: "rv2", Peer: "192.0.2.1", Types: types, Start: 100}
+	stream, err := cli.ReadPeerEvents(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range types {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != v || ev.Timestamp != 100+int64(i) || ev.Collector != "rv2" || ev.Peer != "192.0.2.1" {
+			t.Errorf("Expected: %s from 192.0.2.1 at %d, Got: %+v", v, 100+i, ev)
+		}
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Expected: %s, Got: %v", io.EOF, err)
+	}
+}
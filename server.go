@@ -3,9 +3,11 @@
 package bgpmon
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/CSUNetSec/bgpmon/config"
 	"github.com/CSUNetSec/bgpmon/db"
@@ -33,10 +35,18 @@ type BgpmondServer interface {
 	ListSessionTypes() []*pb.SessionType
 
 	// ListSessions returns a slice of currently active sesions on the server.
-	// Each session handle includes a name, a session type, and a pointer to the
-	// underlying session.
+	// Each session handle includes a name, a session type, a pointer to the
+	// underlying session, its lease and its activity.
 	ListSessions() []SessionHandle
 
+	// LeaseSession gives an open session a lease held by owner, so it's
+	// closed once it has been idle for longer than ttl. A ttl of 0 removes
+	// the lease.
+	LeaseSession(sID, owner string, ttl time.Duration) error
+
+	// RenewSession renews the lease of an open session, like a keepalive.
+	RenewSession(sID, owner string) error
+
 	// CloseSession attempts to close active session with the provided session ID.
 	// If that ID does not exist, or that session fails to close, this will return
 	// an error.
@@ -52,6 +62,13 @@ type BgpmondServer interface {
 	// fails to open, this will return an error.
 	OpenReadStream(string, db.SessionType, db.FilterOptions) (db.ReadStream, error)
 
+	// Subscribe returns a subscription to the captures committed to the session
+	// with the provided ID from now on, which is read like a ReadStream of
+	// *db.Capture. The options select the captures delivered, and what happens
+	// to them when the reader falls behind. The subscription must be closed
+	// once it's no longer read.
+	Subscribe(string, db.SubscribeOptions) (*db.Subscription, error)
+
 	// RunModule will launch the module specified with mType with the ID mID. opts will
 	// be passed to the modules Run function.
 	RunModule(mType string, mID string, opts map[string]string) error
@@ -68,15 +85,44 @@ type BgpmondServer interface {
 	// does not exist, or the module fails to close, this will return an error.
 	CloseModule(string) error
 
+	// Reload replaces the configuration of the server, adding and removing
+	// session types, syncing open sessions with the configured nodes, and
+	// restarting the configured modules which changed.
+	Reload(config.Configer) error
+
+	// Drain stops the server from opening new sessions, streams and modules,
+	// and waits for the open write streams to commit, for at most the
+	// configured drain timeout. It reports the write streams still open by
+	// then, which are cancelled when the server is closed. Close should be
+	// called after it.
+	Drain() DrainReport
+
 	// Close will close all active modules, then all active sessions.
 	Close() error
 }
 
-// SessionHandle is used to return information on an open session.
+// DrainReport describes what a drain of the server couldn't finish. Lost
+// holds, by session ID, the write streams which were still open, and how
+// many objects were written to them without being committed.
+type DrainReport struct {
+	Lost map[string]db.DrainReport
+}
+
+// SessionHandle is used to return information on an open session. A
+// session with a TTL is leased by Owner, and is closed once it has been
+// idle for longer than the TTL. OpenStreams and LastActivity are set by
+// ListSessions.
 type SessionHandle struct {
 	Name     string
 	SessType *pb.SessionType
 	Session  *db.Session
+
+	Owner        string
+	TTL          time.Duration
+	OpenStreams  int
+	LastActivity time.Time
+
+	workers int // the worker count the session was opened with
 }
 
 // server is the primary server object. It contains a map of sessions
@@ -89,21 +135,37 @@ type server struct {
 	modules  map[string]Module
 	conf     config.Configer
 	mux      *sync.Mutex
+
+	// The sessions and modules opened at runtime are recorded in stateFile,
+	// if it's set. persisted holds the modules which are recorded.
+	stateFile string
+	persisted map[string]moduleState
+	restoring bool
+	closing   bool
+	draining  bool
+
+	// stopReaper stops the routine closing sessions whose lease expired.
+	stopReaper chan struct{}
 }
 
 // NewServer creates a BgpmondServer instance from a configuration. It loads
 // session types from the configuration, and launches any modules specified.
-// It returns an error if a module type is specified that isn't registered
-// with the server.
+// If the configuration has a state file, the sessions and modules recorded
+// in it are restored. It returns an error if a module type is specified that
+// isn't registered with the server, or the state file can't be read.
 func NewServer(conf config.Configer) (BgpmondServer, error) {
 	s := &server{}
 	s.sessions = make(map[string]SessionHandle)
 	s.modules = make(map[string]Module)
 	s.mux = &sync.Mutex{}
 	s.conf = conf
+	s.stateFile = conf.GetStateFile()
+	s.persisted = make(map[string]moduleState)
+	s.stopReaper = make(chan struct{})
+	go s.reapSessions(s.stopReaper)
 
 	for _, mod := range conf.GetModules() {
-		err := s.RunModule(mod.GetType(), mod.GetID(), mod.GetArgs())
+		err := s.runModule(mod.GetType(), mod.GetID(), mod.GetArgs(), false)
 		if err != nil {
 			cErr := s.Close()
 			if cErr != nil {
@@ -112,6 +174,15 @@ func NewServer(conf config.Configer) (BgpmondServer, error) {
 			return nil, err
 		}
 	}
+
+	if s.stateFile != "" {
+		if err := s.restoreState(); err != nil {
+			if cErr := s.Close(); cErr != nil {
+				coreLogger.Infof("Error shutting down server: %s", cErr)
+			}
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
@@ -119,21 +190,26 @@ func NewServer(conf config.Configer) (BgpmondServer, error) {
 // configuration from a specified file name. Returns an error if the
 // file can't be parsed, or specifies an invalid module.
 func NewServerFromFile(fName string) (BgpmondServer, error) {
-	fd, err := os.Open(fName)
+	bc, err := loadConfigFile(fName)
 	if err != nil {
 		return nil, err
 	}
 
-	// fd.Close can return an error, but we aren't prepared
-	// to handle it in any way.
-	defer fd.Close()
+	return NewServer(bc)
+}
 
-	bc, err := config.NewConfig(fd)
+// loadConfigFile parses the configuration in the file fName.
+func loadConfigFile(fName string) (config.Configer, error) {
+	fd, err := os.Open(fName)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewServer(bc)
+	// fd.Close can return an error, but we aren't prepared
+	// to handle it in any way.
+	defer fd.Close()
+
+	return config.NewConfig(fd)
 }
 
 // OpenSession will look for a configured session with type sType. It will
@@ -144,6 +220,10 @@ func (s *server) OpenSession(sType, sID string, workers int) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	if s.draining {
+		return coreLogger.Errorf("Can't open session %s on a draining server", sID)
+	}
+
 	if _, ok := s.sessions[sID]; ok {
 		return coreLogger.Errorf("Session ID: %s already exists.", sID)
 	}
@@ -169,31 +249,30 @@ func (s *server) OpenSession(sType, sID string, workers int) error {
 		Name:     sID,
 		SessType: pbType,
 		Session:  session,
+		workers:  workers,
 	}
 	s.sessions[sID] = sh
+	s.saveState()
 
 	return nil
 }
 
 // CloseSession closes a single session with ID sID. It will return an error
 // if sID does not exist on the server or the session returns an error in
-// closing.
+// closing. The session is removed from the server either way, and is closed
+// without holding the server's mutex, since that can block on its streams.
 func (s *server) CloseSession(sID string) error {
 	s.mux.Lock()
-	defer s.mux.Unlock()
-
 	sh, ok := s.sessions[sID]
 	if !ok {
+		s.mux.Unlock()
 		return coreLogger.Errorf("No session found with ID: %s", sID)
 	}
-
-	if err := sh.Session.Close(); err != nil {
-		return err
-	}
-
 	delete(s.sessions, sID)
+	s.saveState()
+	s.mux.Unlock()
 
-	return nil
+	return sh.Session.Close()
 }
 
 // CloseAllSessions is a convenience method to shut down every open
@@ -215,10 +294,14 @@ func (s *server) CloseAllSessions() {
 // ListSessionTypes will return the configured types the server
 // is aware of.
 func (s *server) ListSessionTypes() []*pb.SessionType {
-	// This function doesn't need to lock the sessions map because
-	// it is only concerned with available sessions, not open ones.
+	// The configuration is replaced by Reload, so it's read under the lock,
+	// but it isn't changed once loaded.
+	s.mux.Lock()
+	conf := s.conf
+	s.mux.Unlock()
+
 	var availSessions []*pb.SessionType
-	for _, sc := range s.conf.GetSessionConfigs() {
+	for _, sc := range conf.GetSessionConfigs() {
 		availSess := &pb.SessionType{
 			Name: sc.GetName(),
 			Type: sc.GetTypeName(),
@@ -238,6 +321,7 @@ func (s *server) ListSessions() []SessionHandle {
 
 	var sList []SessionHandle
 	for _, sh := range s.sessions {
+		sh.OpenStreams, sh.LastActivity = sh.Session.Activity()
 		sList = append(sList, sh)
 	}
 	return sList
@@ -291,13 +375,43 @@ func (s *server) OpenReadStream(sID string, readType db.SessionType, fo db.Filte
 	return stream, nil
 }
 
+// Subscribe will look up the session with ID sID, and return a subscription
+// to the captures committed to it. Unlike streams, subscriptions don't use
+// the session's workers.
+func (s *server) Subscribe(sID string, opts db.SubscribeOptions) (*db.Subscription, error) {
+	s.mux.Lock()
+	sh, ok := s.sessions[sID]
+	s.mux.Unlock()
+
+	if !ok {
+		return nil, coreLogger.Errorf("Can't subscribe to nonexistant session: %s", sID)
+	}
+
+	sub, err := sh.Session.Subscribe(opts)
+	if err != nil {
+		return nil, coreLogger.Errorf("Failed to subscribe to session(%s): %s", sID, err)
+	}
+	return sub, nil
+}
+
 // RunModule will look up the modType in the registered modules, create the module,
-// add it to it's module map, and launch the module with opts.
+// add it to it's module map, and launch the module with opts. Daemon modules are
+// recorded in the state file, so they are run again when the server restarts.
 func (s *server) RunModule(modType, name string, opts map[string]string) error {
+	return s.runModule(modType, name, opts, true)
+}
+
+// runModule runs a module like RunModule. If persist is false, it isn't
+// recorded in the state file, like the modules of the configuration.
+func (s *server) runModule(modType, name string, opts map[string]string, persist bool) error {
 	coreLogger.Infof("Running module %s with ID %s", modType, name)
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	if s.draining {
+		return coreLogger.Errorf("Can't run module %s on a draining server", name)
+	}
+
 	if _, ok := s.modules[name]; ok {
 		return coreLogger.Errorf("Module with ID: %s is already running", name)
 	}
@@ -309,20 +423,38 @@ func (s *server) RunModule(modType, name string, opts map[string]string) error {
 
 	newMod := maker(s, getModuleLogger(modType, name))
 	s.modules[name] = newMod
+	if persist && newMod.GetType() == ModuleDaemon {
+		s.persisted[name] = moduleState{Type: modType, ID: name, Args: opts}
+		s.saveState()
+	}
 
 	go s.launchModule(name, newMod, opts)
 
 	return nil
 }
 
+// launchModule runs a module, and removes it once it's done. A module run
+// since with the same ID, like one restarted by a reload, isn't removed.
 func (s *server) launchModule(id string, mod Module, opts map[string]string) {
 	mod.Run(opts)
 
 	s.mux.Lock()
-	delete(s.modules, id)
+	s.removeModule(id, mod)
 	s.mux.Unlock()
 }
 
+// removeModule removes the module with ID id if it's still mod. The mux
+// must be held.
+func (s *server) removeModule(id string, mod Module) {
+	if s.modules[id] != mod {
+		return
+	}
+
+	delete(s.modules, id)
+	delete(s.persisted, id)
+	s.saveState()
+}
+
 // ListModuleTypes lists the modules available for the server to run.
 func (s *server) ListModuleTypes() []ModuleInfo {
 	return getModuleTypes()
@@ -363,7 +495,9 @@ func (s *server) CloseModule(name string) error {
 		return err
 	}
 
-	delete(s.modules, name)
+	s.mux.Lock()
+	s.removeModule(name, mod)
+	s.mux.Unlock()
 	return nil
 }
 
@@ -383,8 +517,65 @@ func (s *server) CloseAllModules() {
 	}
 }
 
-// Close completely shuts down the server.
+// Drain waits for the open write streams of every session to commit. Every
+// session refuses new streams at once, and modules which serve streams are
+// drained until every session is, so they finish the streams in progress
+// without accepting new ones.
+func (s *server) Drain() DrainReport {
+	s.mux.Lock()
+	s.draining = true
+	timeout := time.Duration(s.conf.GetDrainTimeoutSecs()) * time.Second
+	sessions := make(map[string]SessionHandle)
+	for id, sh := range s.sessions {
+		sessions[id] = sh
+	}
+	var drainers []Drainer
+	for _, mod := range s.modules {
+		if d, ok := mod.(Drainer); ok {
+			drainers = append(drainers, d)
+		}
+	}
+	s.mux.Unlock()
+
+	coreLogger.Infof("Draining %d sessions for at most %s", len(sessions), timeout)
+
+	modCtx, stopModules := context.WithCancel(context.Background())
+	modWG := &sync.WaitGroup{}
+	for _, d := range drainers {
+		modWG.Add(1)
+		go func(d Drainer) {
+			defer modWG.Done()
+			if err := d.Drain(modCtx); err != nil {
+				coreLogger.Errorf("Error draining module: %s", err)
+			}
+		}(d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rep := DrainReport{Lost: make(map[string]db.DrainReport)}
+	for id, sh := range sessions {
+		if lost := sh.Session.Drain(ctx); lost.Streams != 0 {
+			rep.Lost[id] = lost
+		}
+	}
+
+	stopModules()
+	modWG.Wait()
+	return rep
+}
+
+// Close completely shuts down the server. The state file is left as it was,
+// so everything open now is restored when the server starts again.
 func (s *server) Close() error {
+	s.mux.Lock()
+	if !s.closing {
+		close(s.stopReaper)
+	}
+	s.closing = true
+	s.mux.Unlock()
+
 	s.CloseAllModules()
 	s.CloseAllSessions()
 	return nil
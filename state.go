@@ -0,0 +1,155 @@
+package bgpmon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// serverState is what a server records in its state file: the sessions
+// opened, and the daemon modules run, since it started. Modules from the
+// configuration are run again from it, and tasks run until they are done, so
+// neither is recorded.
+type serverState struct {
+	Sessions []sessionState `json:"sessions"`
+	Modules  []moduleState  `json:"modules"`
+}
+
+// sessionState records an open session. Type is the name of its session
+// configuration, and Workers is the worker count it was opened with, 0 for
+// the configured one. A leased session has a TTL, in seconds, which starts
+// again once it's restored.
+type sessionState struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Workers int    `json:"workers"`
+	Owner   string `json:"owner,omitempty"`
+	TTLSecs int64  `json:"ttl_secs,omitempty"`
+}
+
+// moduleState records a running module.
+type moduleState struct {
+	Type string            `json:"type"`
+	ID   string            `json:"id"`
+	Args map[string]string `json:"args"`
+}
+
+// readServerState reads a state file. A missing file is an empty state.
+func readServerState(path string) (*serverState, error) {
+	st := &serverState{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// write replaces the state file at path. The new state is written next to
+// it and renamed over it, so a crash never leaves a partial file.
+func (st *serverState) write(path string) error {
+	sort.Slice(st.Sessions, func(i, j int) bool { return st.Sessions[i].ID < st.Sessions[j].ID })
+	sort.Slice(st.Modules, func(i, j int) bool { return st.Modules[i].ID < st.Modules[j].ID })
+
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveState records the open sessions and persistent modules of the server
+// in its state file, if it has one. It must be called with the server's
+// mutex held. Failing to save is only logged, since the change it records
+// has already happened.
+func (s *server) saveState() {
+	if s.stateFile == "" || s.closing || s.draining || s.restoring {
+		return
+	}
+
+	st := &serverState{}
+	for id, sh := range s.sessions {
+		st.Sessions = append(st.Sessions, sessionState{Type: sh.SessType.Name, ID: id, Workers: sh.workers,
+			Owner: sh.Owner, TTLSecs: int64(sh.TTL / time.Second)})
+	}
+	for id, ms := range s.persisted {
+		if _, ok := s.modules[id]; ok {
+			st.Modules = append(st.Modules, ms)
+		}
+	}
+
+	if err := st.write(s.stateFile); err != nil {
+		coreLogger.Errorf("Error saving server state to %s: %s", s.stateFile, err)
+	}
+}
+
+// restoreState opens the sessions and runs the modules recorded in the
+// server's state file. Sessions are opened first, since modules may use
+// them. Anything that fails to be restored is logged, and is dropped from
+// the state file the next time it's saved.
+func (s *server) restoreState() error {
+	st, err := readServerState(s.stateFile)
+	if err != nil {
+		return coreLogger.Errorf("Error reading server state from %s: %s", s.stateFile, err)
+	}
+
+	// Until everything is restored, saving would drop what isn't yet.
+	s.mux.Lock()
+	s.restoring = true
+	s.mux.Unlock()
+
+	for _, ss := range st.Sessions {
+		if err := s.OpenSession(ss.Type, ss.ID, ss.Workers); err != nil {
+			coreLogger.Errorf("Error restoring session %s: %s", ss.ID, err)
+			continue
+		}
+		if ss.TTLSecs > 0 {
+			if err := s.LeaseSession(ss.ID, ss.Owner, time.Duration(ss.TTLSecs)*time.Second); err != nil {
+				coreLogger.Errorf("Error restoring the lease of session %s: %s", ss.ID, err)
+			}
+		}
+		coreLogger.Infof("Restored session %s of type %s", ss.ID, ss.Type)
+	}
+
+	for _, ms := range st.Modules {
+		s.mux.Lock()
+		_, running := s.modules[ms.ID]
+		s.mux.Unlock()
+		if running {
+			coreLogger.Infof("Module %s is already running, not restoring it", ms.ID)
+			continue
+		}
+
+		if err := s.RunModule(ms.Type, ms.ID, ms.Args); err != nil {
+			coreLogger.Errorf("Error restoring module %s: %s", ms.ID, err)
+		}
+	}
+
+	s.mux.Lock()
+	s.restoring = false
+	s.saveState()
+	s.mux.Unlock()
+	return nil
+}
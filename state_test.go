@@ -0,0 +1,50 @@
+package bgpmon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestServerStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bgpmon-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	st, err := readServerState(path)
+	if err != nil {
+		t.Fatalf("Expected a missing state file to be empty, Got: %s", err)
+	}
+	if len(st.Sessions) != 0 || len(st.Modules) != 0 {
+		t.Fatalf("Expected an empty state, Got: %+v", st)
+	}
+
+	st = &serverState{
+		Sessions: []sessionState{{Type: "LocalPostgres", ID: "sess2"}, {Type: "LocalPostgres", ID: "sess1", Workers: 4, Owner: "collector1", TTLSecs: 600}},
+		Modules:  []moduleState{{Type: "periodic", ID: "scan", Args: map[string]string{"duration": "1h", "module": "hijack"}}},
+	}
+	if err := st.write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readServerState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, st) {
+		t.Errorf("Expected: %+v, Got: %+v", st, got)
+	}
+	if got.Sessions[0].ID != "sess1" {
+		t.Errorf("Expected sessions to be sorted by ID, Got: %+v", got.Sessions)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected only the state file to be left, Got %d files", len(files))
+	}
+}
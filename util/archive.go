@@ -0,0 +1,147 @@
+package util
+
+// archive.go contains the reader and writer for capture archives. An archive
+// is a gzip compressed sequence of protobuf BGPCaptures, each preceded by its
+// length as a uvarint.
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// ArchiveExtension is the file extension used for capture archives.
+const ArchiveExtension = ".pb.gz"
+
+// maxArchiveMsgLen bounds the length of a single archived capture, so a
+// corrupt length can't cause a huge allocation.
+const maxArchiveMsgLen = 1 << 20
+
+// ErrArchiveMsgLen is returned when an archive contains a message longer
+// than any capture bgpmon writes.
+var ErrArchiveMsgLen = errors.New("archived message is too long, the archive may be corrupt")
+
+// CaptureArchiveWriter writes captures to a compressed archive.
+type CaptureArchiveWriter struct {
+	gz     *gzip.Writer
+	lenBuf []byte
+	count  int
+}
+
+// NewCaptureArchiveWriter returns a CaptureArchiveWriter which writes to w.
+// Close must be called to flush the archive, but it doesn't close w.
+func NewCaptureArchiveWriter(w io.Writer) *CaptureArchiveWriter {
+	return &CaptureArchiveWriter{gz: gzip.NewWriter(w), lenBuf: make([]byte, binary.MaxVarintLen64)}
+}
+
+// Write adds a capture to the archive.
+func (a *CaptureArchiveWriter) Write(cap *pb.BGPCapture) error {
+	if cap == nil {
+		return ErrNilCap
+	}
+
+	data, err := proto.Marshal(cap)
+	if err != nil {
+		return err
+	}
+
+	n := binary.PutUvarint(a.lenBuf, uint64(len(data)))
+	if _, err := a.gz.Write(a.lenBuf[:n]); err != nil {
+		return err
+	}
+	if _, err := a.gz.Write(data); err != nil {
+		return err
+	}
+	a.count++
+
+	return nil
+}
+
+// Count returns the number of captures written to the archive.
+func (a *CaptureArchiveWriter) Count() int {
+	return a.count
+}
+
+// Close flushes the archive to the underlying writer.
+func (a *CaptureArchiveWriter) Close() error {
+	return a.gz.Close()
+}
+
+// CaptureArchiveReader reads captures from an archive written by a
+// CaptureArchiveWriter. It is used like a bufio.Scanner.
+type CaptureArchiveReader struct {
+	gz  *gzip.Reader
+	br  *bufio.Reader
+	cap *pb.BGPCapture
+	err error
+}
+
+// NewCaptureArchiveReader returns a CaptureArchiveReader reading from r, or
+// an error if r isn't gzip compressed.
+func NewCaptureArchiveReader(r io.Reader) (*CaptureArchiveReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptureArchiveReader{gz: gz, br: bufio.NewReader(gz)}, nil
+}
+
+// Scan advances the reader to the next capture. It returns false at the end
+// of the archive, or on an error, which can be checked with Err.
+func (a *CaptureArchiveReader) Scan() bool {
+	if a.err != nil {
+		return false
+	}
+
+	msgLen, err := binary.ReadUvarint(a.br)
+	if err == io.EOF {
+		return false
+	} else if err != nil {
+		a.err = err
+		return false
+	}
+
+	if msgLen > maxArchiveMsgLen {
+		a.err = ErrArchiveMsgLen
+		return false
+	}
+
+	data := make([]byte, msgLen)
+	if _, err := io.ReadFull(a.br, data); err != nil {
+		a.err = fmt.Errorf("truncated archive: %s", err)
+		return false
+	}
+
+	cap := &pb.BGPCapture{}
+	if err := proto.Unmarshal(data, cap); err != nil {
+		a.err = err
+		return false
+	}
+	a.cap = cap
+
+	return true
+}
+
+// GetCapture returns the capture read by the last call to Scan.
+func (a *CaptureArchiveReader) GetCapture() *pb.BGPCapture {
+	return a.cap
+}
+
+// Err returns the first error encountered while reading the archive, or nil
+// if it was read to the end.
+func (a *CaptureArchiveReader) Err() error {
+	return a.err
+}
+
+// Close releases the resources of the reader. It doesn't close the
+// underlying reader.
+func (a *CaptureArchiveReader) Close() error {
+	return a.gz.Close()
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	pb "github.com/CSUNetSec/netsec-protobufs/bgpmon/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCaptureArchiveRoundTrip(t *testing.T) {
+	caps := []*pb.BGPCapture{
+		{Timestamp: 1356998400, Peer_AS: 3356, Peer_IP: GetIPAsIPWrapper(ParseIP("192.0.2.1"))},
+		{Timestamp: 1356998401, Peer_AS: 4200000000, Peer_IP: GetIPAsIPWrapper(ParseIP("2001:db8::1"))},
+		{},
+	}
+
+	buf := &bytes.Buffer{}
+	aw := NewCaptureArchiveWriter(buf)
+	for _, v := range caps {
+		if err := aw.Write(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if aw.Count() != len(caps) {
+		t.Fatalf("Expected count: %d, Got: %d", len(caps), aw.Count())
+	}
+
+	ar, err := NewCaptureArchiveReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	readCt := 0
+	for ar.Scan() {
+		if readCt >= len(caps) {
+			t.Fatalf("Read more captures than were written")
+		}
+		if !proto.Equal(ar.GetCapture(), caps[readCt]) {
+			t.Fatalf("Expected: %v, Got: %v", caps[readCt], ar.GetCapture())
+		}
+		readCt++
+	}
+
+	if err := ar.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if readCt != len(caps) {
+		t.Fatalf("Expected %d captures, Got: %d", len(caps), readCt)
+	}
+}
+
+func TestCaptureArchiveTruncated(t *testing.T) {
+	// A message claiming 10 bytes, followed by only 5.
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte{10, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ar, err := NewCaptureArchiveReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	if ar.Scan() {
+		t.Fatalf("Expected no capture from a truncated archive")
+	}
+	if ar.Err() == nil {
+		t.Fatalf("Expected an error from a truncated archive")
+	}
+}
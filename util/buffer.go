@@ -32,6 +32,8 @@ type InsertBuffer struct {
 	batchSize  int             // Number of arguments expected of an add
 	values     []interface{}   // Buffered values
 	usePosArgs bool            // Use $1 style args in the statement instead of ?
+	suffix     string          // Appended after the VALUES clauses, like an ON CONFLICT clause
+	skipped    int64           // Flushed values which didn't affect a row
 	first      bool
 }
 
@@ -50,6 +52,16 @@ func NewInsertBuffer(ex SQLExecutor, max int, usePositional bool) *InsertBuffer
 	}
 }
 
+// NewInsertBufferSuffix returns a SQLBuffer like NewInsertBuffer, which appends
+// suffix to the statement after the VALUES() clauses. With a suffix like ON
+// CONFLICT DO NOTHING, some values may not be inserted, which is reported by
+// Skipped.
+func NewInsertBufferSuffix(ex SQLExecutor, max int, usePositional bool, suffix string) *InsertBuffer {
+	ib := NewInsertBuffer(ex, max, usePositional)
+	ib.suffix = suffix
+	return ib
+}
+
 // Flush Satisfies the SQLBuffer interface. It will execute the INSERT statement
 // on the provided executor.
 func (ib *InsertBuffer) Flush() error {
@@ -62,14 +74,23 @@ func (ib *InsertBuffer) Flush() error {
 		return fmt.Errorf("improperly terminated statement: %s", addedStmt)
 	}
 
-	addedStmt = addedStmt[:len(addedStmt)-1] + ";"
+	addedStmt = addedStmt[:len(addedStmt)-1]
+	if ib.suffix != "" {
+		addedStmt += " " + ib.suffix
+	}
+	addedStmt += ";"
 
 	combinedStmt := fmt.Sprintf("%s %s", ib.stmt, addedStmt)
 
-	_, err := ib.ex.Exec(combinedStmt, ib.values...)
+	res, err := ib.ex.Exec(combinedStmt, ib.values...)
 	if err != nil {
 		return err
 	}
+	if res != nil {
+		if affected, err := res.RowsAffected(); err == nil {
+			ib.skipped += int64(ib.ct) - affected
+		}
+	}
 	ib.Clear()
 	return nil
 }
@@ -82,6 +103,11 @@ func (ib *InsertBuffer) Clear() {
 	ib.stmtBldr.Reset()
 }
 
+// Skipped returns how many of the flushed values were not inserted.
+func (ib *InsertBuffer) Skipped() int64 {
+	return ib.skipped
+}
+
 // Exec allows the insert buffer to adhere to the SQLExecutor interface.
 func (ib *InsertBuffer) Exec(query string, arg ...interface{}) (sql.Result, error) {
 	if ib.first {
@@ -93,6 +93,26 @@ func TestInsertBuffer(t *testing.T) {
 	}
 }
 
+// TestInsertBufferSuffix tests whether the suffix follows the values.
+func TestInsertBufferSuffix(t *testing.T) {
+	base := "INSERT INTO testTable VALUES"
+	testEx := &TestExecutor{t: t}
+	buf := NewInsertBufferSuffix(testEx, 2, true, "ON CONFLICT DO NOTHING")
+
+	if _, err := buf.Exec(base, 1, 2); err != nil {
+		t.Fatalf("Error adding (1,2) to buffer: %s", err)
+	}
+	if _, err := buf.Exec(base, 3, 4); err != nil {
+		t.Fatalf("Error adding (3,4) to buffer: %s", err)
+	}
+
+	expected := base + " ($1,$2),($3,$4) ON CONFLICT DO NOTHING;"
+	if !testEx.checkLast(expected, 1, 2, 3, 4) {
+		t.Logf("Expected: %s %v", expected, []int{1, 2, 3, 4})
+		t.Fatalf("Received: %s %v", testEx.lastStmt, testEx.lastVals)
+	}
+}
+
 // TestTimedBuffer will check if the TimedBuffer flushes with the timeout.
 func TestTimedBuffer(t *testing.T) {
 	base := "INSERT INTO timed VALUES"
@@ -52,7 +52,7 @@ func PrefixesToPQArray(n []*net.IPNet) interface {
 
 	ret := make([]string, len(n))
 	for i := range n {
-		ret[i] = n[i].String()
+		ret[i] = IPNetString(n[i])
 		if ret[i] == "" || ret[i] == "<nil>" {
 			// This is a sane default value for an IPNet that also shows there was a parse error.
 			ret[i] = "0.0.0.0/0"
@@ -0,0 +1,75 @@
+package util
+
+// iputil.go contains functions to convert IP addresses and prefixes to and
+// from strings without losing their address family. The net package treats
+// an IPv4-mapped IPv6 address (::ffff:a.b.c.d) as IPv4 when printing it, so
+// bgpmon keeps IPv4 addresses in their 4 byte form, and treats any 16 byte
+// address as IPv6.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseIP parses s as an IP address. Unlike net.ParseIP, IPv4 addresses are
+// returned in their 4 byte form, so an IPv4-mapped IPv6 address can be told
+// apart from the IPv4 address it maps. It returns nil if s is not a valid
+// address.
+func ParseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+
+	if !strings.Contains(s, ":") {
+		return ip.To4()
+	}
+	return ip
+}
+
+// IsIPv4 returns true if ip is a 4 byte IPv4 address.
+func IsIPv4(ip net.IP) bool {
+	return len(ip) == net.IPv4len
+}
+
+// IPString returns the string form of ip. IPv4-mapped IPv6 addresses are
+// returned as "::ffff:a.b.c.d" instead of as a plain IPv4 address.
+func IPString(ip net.IP) string {
+	if len(ip) == net.IPv6len && ip.To4() != nil {
+		return "::ffff:" + ip.To4().String()
+	}
+	return ip.String()
+}
+
+// IsIPv4Net returns true if n is an IPv4 prefix. The address family of a
+// prefix is decided by the length of its mask.
+func IsIPv4Net(n *net.IPNet) bool {
+	return len(n.Mask) == net.IPv4len
+}
+
+// IPNetString returns the CIDR string form of n, keeping the address family
+// of its mask. It returns "<nil>" for a nil or malformed prefix, like
+// net.IPNet.String.
+func IPNetString(n *net.IPNet) string {
+	if n == nil {
+		return "<nil>"
+	}
+
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return "<nil>"
+	}
+
+	var ip net.IP
+	if bits == 8*net.IPv4len {
+		ip = n.IP.To4()
+	} else {
+		ip = n.IP.To16()
+	}
+	if ip == nil {
+		return "<nil>"
+	}
+
+	return fmt.Sprintf("%s/%d", IPString(ip), ones)
+}
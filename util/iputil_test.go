@@ -0,0 +1,81 @@
+package util
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPStringKeepsFamily(t *testing.T) {
+	tests := []struct {
+		in     string
+		isIPv4 bool
+		out    string
+	}{
+		{"192.0.2.1", true, "192.0.2.1"},
+		{"2001:db8::1", false, "2001:db8::1"},
+		{"2001:DB8:0::1", false, "2001:db8::1"},
+		{"::ffff:192.0.2.1", false, "::ffff:192.0.2.1"},
+		{"::", false, "::"},
+	}
+
+	for _, v := range tests {
+		ip := ParseIP(v.in)
+		if ip == nil {
+			t.Fatalf("Failed to parse %s", v.in)
+		}
+
+		if IsIPv4(ip) != v.isIPv4 {
+			t.Errorf("Input: %s, Expected IPv4: %t, Got: %t", v.in, v.isIPv4, IsIPv4(ip))
+		}
+
+		if str := IPString(ip); str != v.out {
+			t.Errorf("Input: %s, Expected: %s, Got: %s", v.in, v.out, str)
+		}
+
+		wrapped, err := GetIPWrapper(GetIPAsIPWrapper(ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !wrapped.Equal(ip) || len(wrapped) != len(ip) {
+			t.Errorf("Input: %s, Expected wrapper round trip: %v, Got: %v", v.in, []byte(ip), []byte(wrapped))
+		}
+	}
+
+	if ParseIP("not an ip") != nil {
+		t.Errorf("Expected nil parsing a malformed address")
+	}
+}
+
+func TestPrefixListKeepsFamily(t *testing.T) {
+	prefs := []string{"10.1.0.0/16", "2001:db8:100::/48", "::ffff:192.0.2.0/120", "::/0", "0.0.0.0/0"}
+
+	var nets []*net.IPNet
+	for _, v := range prefs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if str := IPNetString(n); str != v {
+			t.Errorf("Expected: %s, Got: %s", v, str)
+		}
+		nets = append(nets, n)
+	}
+
+	parsed, err := GetPrefixListAsIPNet(GetIPNetsAsPrefixList(nets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != len(prefs) {
+		t.Fatalf("Expected %d prefixes, Got: %d", len(prefs), len(parsed))
+	}
+	for i, v := range parsed {
+		if str := IPNetString(v); str != prefs[i] {
+			t.Errorf("Expected: %s, Got: %s", prefs[i], str)
+		}
+		if IsIPv4Net(v) != IsIPv4Net(nets[i]) {
+			t.Errorf("Prefix %s changed address family", prefs[i])
+		}
+	}
+}
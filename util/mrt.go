@@ -0,0 +1,281 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// MRT record types and BGP4MP subtypes, from RFC 6396 and RFC 8050.
+const (
+	mrtHeaderLen = 12
+	mrtBGP4MP    = 16
+	mrtBGP4MPET  = 17
+
+	bgp4mpStateChange       = 0
+	bgp4mpMessage           = 1
+	bgp4mpMessageAS4        = 4
+	bgp4mpStateChangeAS4    = 5
+	bgp4mpMessageAddPath    = 8
+	bgp4mpMessageAS4AddPath = 9
+
+	bgpHeaderLen = 19
+	bgpOpen      = 1
+	bgpUpdate    = 2
+	bgpNotify    = 3
+	bgpKeepalive = 4
+
+	afiIPv4 = 1
+	afiIPv6 = 2
+)
+
+// BGPEstablished is the BGP state of a session which is up.
+const BGPEstablished = 6
+
+// bgpStateNames are the names of the BGP finite state machine states, by the
+// number MRT state changes record them with.
+var bgpStateNames = map[uint16]string{
+	1:              "Idle",
+	2:              "Connect",
+	3:              "Active",
+	4:              "OpenSent",
+	5:              "OpenConfirm",
+	BGPEstablished: "Established",
+}
+
+// BGPStateName returns the name of a BGP state, like Established.
+func BGPStateName(state uint16) string {
+	if name, ok := bgpStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("State%d", state)
+}
+
+// MRTRecordKind describes what a BGP4MP record holds.
+type MRTRecordKind int
+
+// These are the kinds of BGP4MP records ParseMRTPeerRecord returns.
+const (
+	MRTStateChange MRTRecordKind = iota
+	MRTOpen
+	MRTUpdate
+	MRTNotification
+	MRTKeepalive
+	MRTOtherMessage
+)
+
+// ErrNotBGP4MP is returned by ParseMRTPeerRecord for MRT records which are
+// not BGP4MP records, like table dumps.
+var ErrNotBGP4MP = errors.New("not a BGP4MP record")
+
+// MRTPeerRecord is a BGP4MP record of an MRT file, which is either a state
+// change of the session with a peer, or a message received from it. Only the
+// fields of its kind are set.
+type MRTPeerRecord struct {
+	Timestamp time.Time
+	Kind      MRTRecordKind
+	PeerAS    uint32
+	LocalAS   uint32
+	PeerIP    net.IP
+	LocalIP   net.IP
+
+	OldState uint16
+	NewState uint16
+	// HoldTime is the hold time proposed by an open.
+	HoldTime time.Duration
+	// ErrorCode and ErrorSubcode are the error of a notification.
+	ErrorCode    uint8
+	ErrorSubcode uint8
+}
+
+// ParseMRTPeerRecord parses the headers of a BGP4MP record, and the body of
+// state changes, opens and notifications. Messages sent by the collector,
+// which are recorded with the LOCAL subtypes, return ErrNotBGP4MP like other
+// records.
+func ParseMRTPeerRecord(rec []byte) (*MRTPeerRecord, error) {
+	if len(rec) < mrtHeaderLen {
+		return nil, fmt.Errorf("MRT record too short: %d bytes", len(rec))
+	}
+
+	ts := time.Unix(int64(binary.BigEndian.Uint32(rec[:4])), 0).UTC()
+	mrtType := binary.BigEndian.Uint16(rec[4:6])
+	subtype := binary.BigEndian.Uint16(rec[6:8])
+	body := rec[mrtHeaderLen:]
+	if uint32(len(body)) < binary.BigEndian.Uint32(rec[8:12]) {
+		return nil, fmt.Errorf("MRT record truncated")
+	}
+	body = body[:binary.BigEndian.Uint32(rec[8:12])]
+
+	switch mrtType {
+	case mrtBGP4MP:
+	case mrtBGP4MPET:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("BGP4MP_ET record too short")
+		}
+		ts = ts.Add(time.Duration(binary.BigEndian.Uint32(body[:4])) * time.Microsecond)
+		body = body[4:]
+	default:
+		return nil, ErrNotBGP4MP
+	}
+
+	var as4, message bool
+	switch subtype {
+	case bgp4mpStateChange:
+	case bgp4mpStateChangeAS4:
+		as4 = true
+	case bgp4mpMessage, bgp4mpMessageAddPath:
+		message = true
+	case bgp4mpMessageAS4, bgp4mpMessageAS4AddPath:
+		as4, message = true, true
+	default:
+		return nil, ErrNotBGP4MP
+	}
+
+	pr := &MRTPeerRecord{Timestamp: ts}
+	body, err := pr.parsePeers(body, as4)
+	if err != nil {
+		return nil, err
+	}
+
+	if !message {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("BGP4MP state change too short")
+		}
+		pr.Kind = MRTStateChange
+		pr.OldState = binary.BigEndian.Uint16(body[:2])
+		pr.NewState = binary.BigEndian.Uint16(body[2:4])
+		return pr, nil
+	}
+
+	if len(body) < bgpHeaderLen {
+		return nil, fmt.Errorf("BGP message too short")
+	}
+	msg := body[bgpHeaderLen:]
+	switch body[18] {
+	case bgpOpen:
+		// The version, AS and hold time.
+		if len(msg) < 5 {
+			return nil, fmt.Errorf("BGP open too short")
+		}
+		pr.Kind = MRTOpen
+		pr.HoldTime = time.Duration(binary.BigEndian.Uint16(msg[3:5])) * time.Second
+	case bgpUpdate:
+		pr.Kind = MRTUpdate
+	case bgpNotify:
+		if len(msg) < 2 {
+			return nil, fmt.Errorf("BGP notification too short")
+		}
+		pr.Kind = MRTNotification
+		pr.ErrorCode, pr.ErrorSubcode = msg[0], msg[1]
+	case bgpKeepalive:
+		pr.Kind = MRTKeepalive
+	default:
+		pr.Kind = MRTOtherMessage
+	}
+	return pr, nil
+}
+
+// parsePeers parses the ASes and addresses at the start of every BGP4MP
+// record, and returns the rest of it.
+func (pr *MRTPeerRecord) parsePeers(body []byte, as4 bool) ([]byte, error) {
+	asLen := 2
+	if as4 {
+		asLen = 4
+	}
+	if len(body) < 2*asLen+4 {
+		return nil, fmt.Errorf("BGP4MP header too short")
+	}
+
+	if as4 {
+		pr.PeerAS = binary.BigEndian.Uint32(body[:4])
+		pr.LocalAS = binary.BigEndian.Uint32(body[4:8])
+	} else {
+		pr.PeerAS = uint32(binary.BigEndian.Uint16(body[:2]))
+		pr.LocalAS = uint32(binary.BigEndian.Uint16(body[2:4]))
+	}
+	// Skip the interface index.
+	body = body[2*asLen+2:]
+
+	ipLen := net.IPv4len
+	switch afi := binary.BigEndian.Uint16(body[:2]); afi {
+	case afiIPv4:
+	case afiIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unsupported BGP4MP address family: %d", afi)
+	}
+	body = body[2:]
+	if len(body) < 2*ipLen {
+		return nil, fmt.Errorf("BGP4MP header too short")
+	}
+
+	pr.PeerIP = net.IP(append([]byte(nil), body[:ipLen]...))
+	pr.LocalIP = net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	return body[2*ipLen:], nil
+}
+
+// holdState is what a HoldTimer knows of the session with one peer.
+type holdState struct {
+	last      time.Time
+	hold      time.Duration
+	keepalive bool
+}
+
+// DefaultHoldTime is the hold time of a peer whose open wasn't seen.
+const DefaultHoldTime = 180 * time.Second
+
+// HoldTimer follows the messages received from each peer to find the gaps
+// between them longer than the peer's hold time, which should have closed
+// the session. Many collectors don't record keepalives, and the updates of a
+// quiet peer can be hours apart, so only peers that were seen sending a
+// keepalive are checked.
+type HoldTimer struct {
+	peers map[string]*holdState
+}
+
+// NewHoldTimer returns a HoldTimer which hasn't seen any peer.
+func NewHoldTimer() *HoldTimer {
+	return &HoldTimer{peers: make(map[string]*holdState)}
+}
+
+// Observe records a record of a peer. If it ended a gap longer than the
+// hold time, it returns the gap and the hold time it exceeded.
+func (h *HoldTimer) Observe(pr *MRTPeerRecord) (gap time.Duration, hold time.Duration, ok bool) {
+	key := IPString(pr.LocalIP) + " " + IPString(pr.PeerIP)
+	st := h.peers[key]
+
+	switch pr.Kind {
+	case MRTStateChange:
+		if pr.OldState == BGPEstablished {
+			// The session went down, and the next one will send its own open.
+			delete(h.peers, key)
+			return 0, 0, false
+		} else if pr.NewState != BGPEstablished {
+			return 0, 0, false
+		}
+	case MRTNotification:
+		delete(h.peers, key)
+		return 0, 0, false
+	}
+
+	if st == nil {
+		st = &holdState{hold: DefaultHoldTime}
+		h.peers[key] = st
+	}
+
+	if pr.Kind == MRTOpen {
+		st.hold = pr.HoldTime
+	}
+
+	// A hold time of 0 means the peer doesn't send keepalives.
+	if st.keepalive && st.hold != 0 && !st.last.IsZero() && pr.Timestamp.Sub(st.last) > st.hold {
+		gap, hold, ok = pr.Timestamp.Sub(st.last), st.hold, true
+	}
+	if pr.Kind == MRTKeepalive {
+		st.keepalive = true
+	}
+	st.last = pr.Timestamp
+	return gap, hold, ok
+}
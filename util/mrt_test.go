@@ -0,0 +1,137 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// bgp4mpRecord builds an MRT BGP4MP_AS4 record between IPv4 peers with the
+// provided subtype and body.
+func bgp4mpRecord(ts uint32, subtype uint16, body []byte) []byte {
+	peers := []byte{
+		0, 0, 0xfd, 0xe8, // peer AS 65000
+		0, 0, 0xfd, 0xe9, // local AS 65001
+		0, 0, // interface index
+		0, afiIPv4,
+		192, 0, 2, 1, // peer IP
+		192, 0, 2, 254, // local IP
+	}
+	body = append(peers, body...)
+
+	rec := make([]byte, mrtHeaderLen, mrtHeaderLen+len(body))
+	binary.BigEndian.PutUint32(rec[0:4], ts)
+	binary.BigEndian.PutUint16(rec[4:6], mrtBGP4MP)
+	binary.BigEndian.PutUint16(rec[6:8], subtype)
+	binary.BigEndian.PutUint32(rec[8:12], uint32(len(body)))
+	return append(rec, body...)
+}
+
+// bgpMessage builds a BGP message of the provided type.
+func bgpMessage(msgType byte, body []byte) []byte {
+	msg := make([]byte, bgpHeaderLen)
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:18], uint16(bgpHeaderLen+len(body)))
+	msg[18] = msgType
+	return append(msg, body...)
+}
+
+func TestParseMRTPeerRecord(t *testing.T) {
+	pr, err := ParseMRTPeerRecord(bgp4mpRecord(1000, bgp4mpStateChangeAS4, []byte{0, 6, 0, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Kind != MRTStateChange || pr.OldState != BGPEstablished || pr.NewState != 1 {
+		t.Errorf("Expected a change from Established to Idle, Got: %+v", pr)
+	}
+	if pr.PeerAS != 65000 || pr.LocalAS != 65001 {
+		t.Errorf("Expected: AS65000 and AS65001, Got: AS%d and AS%d", pr.PeerAS, pr.LocalAS)
+	}
+	if IPString(pr.PeerIP) != "192.0.2.1" || IPString(pr.LocalIP) != "192.0.2.254" {
+		t.Errorf("Expected: 192.0.2.1 and 192.0.2.254, Got: %s and %s", IPString(pr.PeerIP), IPString(pr.LocalIP))
+	}
+	if !pr.Timestamp.Equal(time.Unix(1000, 0)) {
+		t.Errorf("Expected: %s, Got: %s", time.Unix(1000, 0), pr.Timestamp)
+	}
+
+	// Version 4, AS 65000, hold time 90.
+	open := bgpMessage(bgpOpen, []byte{4, 0xfd, 0xe8, 0, 90, 0, 0, 0, 0, 0})
+	pr, err = ParseMRTPeerRecord(bgp4mpRecord(1000, bgp4mpMessageAS4, open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Kind != MRTOpen || pr.HoldTime != 90*time.Second {
+		t.Errorf("Expected an open with a 90s hold time, Got: %+v", pr)
+	}
+
+	notify := bgpMessage(bgpNotify, []byte{6, 2})
+	pr, err = ParseMRTPeerRecord(bgp4mpRecord(1000, bgp4mpMessageAS4, notify))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Kind != MRTNotification || pr.ErrorCode != 6 || pr.ErrorSubcode != 2 {
+		t.Errorf("Expected a cease notification, Got: %+v", pr)
+	}
+
+	pr, err = ParseMRTPeerRecord(bgp4mpRecord(1000, bgp4mpMessageAS4, bgpMessage(bgpUpdate, nil)))
+	if err != nil || pr.Kind != MRTUpdate {
+		t.Errorf("Expected an update, Got: %+v, %v", pr, err)
+	}
+
+	// Messages sent by the collector aren't parsed.
+	if _, err = ParseMRTPeerRecord(bgp4mpRecord(1000, 7, bgpMessage(bgpKeepalive, nil))); err != ErrNotBGP4MP {
+		t.Errorf("Expected: %s, Got: %v", ErrNotBGP4MP, err)
+	}
+
+	rec := bgp4mpRecord(1000, bgp4mpMessageAS4, notify)
+	if _, err = ParseMRTPeerRecord(rec[:len(rec)-1]); err == nil {
+		t.Errorf("Expected an error parsing a truncated record")
+	}
+}
+
+func TestHoldTimer(t *testing.T) {
+	base := time.Unix(1000, 0)
+	record := func(secs int, kind MRTRecordKind) *MRTPeerRecord {
+		return &MRTPeerRecord{
+			Timestamp: base.Add(time.Duration(secs) * time.Second),
+			Kind:      kind,
+			PeerIP:    ParseIP("192.0.2.1"),
+			LocalIP:   ParseIP("192.0.2.254"),
+			HoldTime:  90 * time.Second,
+		}
+	}
+
+	ht := NewHoldTimer()
+	// Updates hours apart aren't gaps until a keepalive is seen.
+	for _, secs := range []int{0, 3600, 7200} {
+		if _, _, ok := ht.Observe(record(secs, MRTUpdate)); ok {
+			t.Errorf("Expected no gap before a keepalive at %ds", secs)
+		}
+	}
+	if _, _, ok := ht.Observe(record(10000, MRTKeepalive)); ok {
+		t.Errorf("Expected no gap on the first keepalive")
+	}
+	if _, _, ok := ht.Observe(record(10030, MRTKeepalive)); ok {
+		t.Errorf("Expected no gap shorter than the hold time")
+	}
+
+	gap, hold, ok := ht.Observe(record(10300, MRTKeepalive))
+	if !ok || gap != 270*time.Second || hold != DefaultHoldTime {
+		t.Errorf("Expected a 270s gap over %s, Got: %s over %s, %t", DefaultHoldTime, gap, hold, ok)
+	}
+
+	// An open sets the hold time of the peer.
+	ht.Observe(record(10310, MRTOpen))
+	gap, hold, ok = ht.Observe(record(10410, MRTKeepalive))
+	if !ok || gap != 100*time.Second || hold != 90*time.Second {
+		t.Errorf("Expected a 100s gap over 90s, Got: %s over %s, %t", gap, hold, ok)
+	}
+
+	// A notification closes the session, forgetting the peer.
+	ht.Observe(record(10420, MRTNotification))
+	if _, _, ok = ht.Observe(record(20000, MRTKeepalive)); ok {
+		t.Errorf("Expected no gap after a notification")
+	}
+}
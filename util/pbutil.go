@@ -6,6 +6,7 @@ package util
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -34,6 +35,8 @@ var (
 )
 
 // GetIPWrapper returns a net.IP and possibly an error  from the protobuf IP address wrapper.
+// The address family is taken from the field of the wrapper that is set, so IPv4
+// addresses are returned in their 4 byte form and IPv6 addresses in their 16 byte form.
 func GetIPWrapper(pIP *pbcomm.IPAddressWrapper) (net.IP, error) {
 	if pIP == nil || (pIP.IPv4 == nil && pIP.IPv6 == nil) {
 		return nil, ErrNoIP
@@ -41,21 +44,38 @@ func GetIPWrapper(pIP *pbcomm.IPAddressWrapper) (net.IP, error) {
 
 	var ret net.IP
 	if pIP.IPv4 != nil {
-		ret = net.IP(pIP.IPv4)
-	} else if pIP.IPv6 != nil {
+		ret = net.IP(pIP.IPv4).To4()
+	} else if len(pIP.IPv6) == net.IPv6len {
 		ret = net.IP(pIP.IPv6)
 	}
 
+	if ret == nil {
+		return nil, ErrNoIP
+	}
 	return ret, nil
 }
 
-// GetTimeColIP returns the time of the capture, the collector IP and possibly an error
+// GetIPAsIPWrapper returns a protobuf IP address wrapper from a net.IP,
+// or nil if ip is nil. Only 4 byte addresses are wrapped as IPv4, so an
+// IPv4-mapped IPv6 address stays IPv6.
+func GetIPAsIPWrapper(ip net.IP) *pbcomm.IPAddressWrapper {
+	if ip == nil {
+		return nil
+	}
+
+	if IsIPv4(ip) {
+		return &pbcomm.IPAddressWrapper{IPv4: ip}
+	}
+	return &pbcomm.IPAddressWrapper{IPv6: ip.To16()}
+}
+
+// GetTimeColIP returns the time of the capture in UTC, the collector IP and possibly an error
 // from a protobuf BGP capture.
 func GetTimeColIP(cap *pb.BGPCapture) (time.Time, net.IP, error) {
 	if cap == nil {
 		return time.Now(), nil, ErrNilCap
 	}
-	secs := time.Unix(int64(cap.GetTimestamp()), 0)
+	secs := time.Unix(int64(cap.GetTimestamp()), 0).UTC()
 
 	locIP := cap.GetLocal_IP()
 	colIP, err := GetIPWrapper(locIP)
@@ -73,9 +93,19 @@ func GetPeerIP(cap *pb.BGPCapture) (net.IP, error) {
 	return pIP, err
 }
 
-// GetASPath returns an Autonomous System path as an array of integers
+// GetPeerAS returns the AS number of the peer a capture was received from.
+// It is 0 if the source of the capture didn't provide one.
+func GetPeerAS(cap *pb.BGPCapture) (uint32, error) {
+	if cap == nil {
+		return 0, ErrNilCap
+	}
+
+	return cap.GetPeer_AS(), nil
+}
+
+// GetASPath returns an Autonomous System path as an array of AS numbers
 // from a protobuf capture.
-func GetASPath(cap *pb.BGPCapture) ([]int, error) {
+func GetASPath(cap *pb.BGPCapture) ([]uint32, error) {
 	if cap == nil {
 		return nil, ErrNilCap
 	}
@@ -86,17 +116,13 @@ func GetASPath(cap *pb.BGPCapture) ([]int, error) {
 		return nil, ErrNoASPath
 	}
 
-	var path []int
+	var path []uint32
 	for _, s := range segments {
 		if s.ASSet != nil {
-			for _, AS := range s.ASSet {
-				path = append(path, int(AS))
-			}
+			path = append(path, s.ASSet...)
 		}
 		if s.ASSeq != nil {
-			for _, AS := range s.ASSeq {
-				path = append(path, int(AS))
-			}
+			path = append(path, s.ASSeq...)
 		}
 	}
 
@@ -117,7 +143,7 @@ func GetNextHop(cap *pb.BGPCapture) (net.IP, error) {
 
 // GetOriginAS returns the origin AS as an integer (the AS at the last index of the AS-Path)
 // of the ASPath from a capture or possibly an error.
-func GetOriginAS(cap *pb.BGPCapture) (int, error) {
+func GetOriginAS(cap *pb.BGPCapture) (uint32, error) {
 	path, err := GetASPath(cap)
 	if err != nil {
 		return 0, err
@@ -177,12 +203,18 @@ func GetPrefixListAsIPNet(prefs []*pbcomm.PrefixWrapper) ([]*net.IPNet, error) {
 	return ret, nil
 }
 
-// GetIPNetsAsPrefixList returns []*PrefixWrapper from a []*net.IPNet
+// GetIPNetsAsPrefixList returns []*PrefixWrapper from a []*net.IPNet. The
+// address family of each prefix is decided by the length of its mask.
 func GetIPNetsAsPrefixList(nets []*net.IPNet) []*pbcomm.PrefixWrapper {
 	ret := make([]*pbcomm.PrefixWrapper, len(nets))
 	for i, v := range nets {
 		mask, _ := v.Mask.Size()
-		ipWrapper := &pbcomm.IPAddressWrapper{IPv4: v.IP.To4()}
+		ipWrapper := &pbcomm.IPAddressWrapper{}
+		if IsIPv4Net(v) {
+			ipWrapper.IPv4 = v.IP.To4()
+		} else {
+			ipWrapper.IPv6 = v.IP.To16()
+		}
 		ret[i] = &pbcomm.PrefixWrapper{Prefix: ipWrapper, Mask: uint32(mask)}
 	}
 	return ret
@@ -191,19 +223,22 @@ func GetIPNetsAsPrefixList(nets []*net.IPNet) []*pbcomm.PrefixWrapper {
 // getPrefixAsIPNet returns the protobuf prefixwrapper to a native net.IPNet
 // type and possibly returns an error.
 func getPrefixAsIPNet(pw *pbcomm.PrefixWrapper) (*net.IPNet, error) {
+	if pw == nil {
+		return nil, ErrNilPrefWrap
+	}
+
 	ip, err := GetIPWrapper(pw.Prefix)
 	if err != nil {
 		return nil, err
 	}
-	var mask net.IPMask
-	// This is true if it's an IPv4 or a special IPv6 address, might need to be fixed
-	if ip.To4() != nil {
-		mask = net.CIDRMask(int(pw.Mask), 32)
-	} else {
-		mask = net.CIDRMask(int(pw.Mask), 128)
+
+	bits := 8 * len(ip)
+	if int(pw.Mask) > bits {
+		return nil, fmt.Errorf("prefix mask /%d is too long for a %d bit address", pw.Mask, bits)
 	}
+	mask := net.CIDRMask(int(pw.Mask), bits)
 
-	return &net.IPNet{IP: ip, Mask: mask}, nil
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
 }
 
 // GetProtoMsg returns a byte array representing the capture from a protobuf capture.
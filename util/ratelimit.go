@@ -0,0 +1,47 @@
+package util
+
+import (
+	"time"
+)
+
+// RateLimiter is a token bucket which allows a number of events each second,
+// in bursts of up to a second's worth. It isn't safe for concurrent use.
+type RateLimiter struct {
+	rate   float64 // tokens added each second
+	burst  float64 // most tokens the bucket holds
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing perSec events each second,
+// which starts with a full bucket.
+func NewRateLimiter(perSec float64) *RateLimiter {
+	burst := perSec
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: perSec, burst: burst, tokens: burst}
+}
+
+// Allow returns whether an event may happen now, and takes a token if so.
+func (rl *RateLimiter) Allow() bool {
+	return rl.AllowAt(time.Now())
+}
+
+// AllowAt returns whether an event may happen at t, and takes a token if
+// so. Times must not go backwards.
+func (rl *RateLimiter) AllowAt(t time.Time) bool {
+	if !rl.last.IsZero() {
+		rl.tokens += t.Sub(rl.last).Seconds() * rl.rate
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+	}
+	rl.last = t
+
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
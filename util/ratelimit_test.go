@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(2)
+	now := time.Unix(1000, 0)
+
+	// The bucket starts full, with a second's worth of events.
+	for i := 0; i < 2; i++ {
+		if !rl.AllowAt(now) {
+			t.Fatalf("Expected event %d of the burst to be allowed", i)
+		}
+	}
+	if rl.AllowAt(now) {
+		t.Errorf("Expected an event over the burst to be refused")
+	}
+
+	if !rl.AllowAt(now.Add(500 * time.Millisecond)) {
+		t.Errorf("Expected an event to be allowed after half a second")
+	}
+	if rl.AllowAt(now.Add(600 * time.Millisecond)) {
+		t.Errorf("Expected an event to be refused before the next token")
+	}
+
+	// Idle time doesn't add more than the burst.
+	later := now.Add(time.Hour)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if rl.AllowAt(later) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("Expected: 2 events after an idle hour, Got: %d", allowed)
+	}
+}